@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+
+	"circuit-breaker-demo/pkg/grpcserver"
+	"circuit-breaker-demo/pkg/marketdata"
+	"circuit-breaker-demo/pkg/models"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PriceServer serves the gRPC price API from the same prices and failure
+// simulation as the HTTP API
+type PriceServer struct {
+	service *MarketDataService
+}
+
+// NewGRPCServer creates a gRPC server offering the price API of service
+func NewGRPCServer(service *MarketDataService, logger *zap.Logger) *grpc.Server {
+	server := grpc.NewServer(
+		marketdata.ServerCodec(),
+		grpc.UnaryInterceptor(grpcserver.UnaryServerInterceptor(logger)),
+		grpc.StreamInterceptor(grpcserver.StreamServerInterceptor(logger)),
+	)
+	marketdata.RegisterPriceServer(server, &PriceServer{service: service})
+	return server
+}
+
+// checkAvailable simulates latency and failures like the HTTP handlers do,
+// answering UNAVAILABLE while the service is unhealthy or a request is made
+// to fail, and DEADLINE_EXCEEDED when the latency does not fit the deadline
+func (p *PriceServer) checkAvailable(ctx context.Context) error {
+	if err := p.service.simulateLatency(ctx); err != nil {
+		return err
+	}
+
+	p.service.mutex.RLock()
+	healthy := p.service.isHealthy
+	p.service.mutex.RUnlock()
+
+	if !healthy {
+		return status.Error(codes.Unavailable, "market data service unavailable")
+	}
+	if p.service.simulateFailure() {
+		return status.Error(codes.Unavailable, "market data service temporarily unavailable")
+	}
+	return nil
+}
+
+// GetPrice implements marketdata.PriceServer
+func (p *PriceServer) GetPrice(ctx context.Context, req *marketdata.PriceRequest) (*models.MarketData, error) {
+	if req.Symbol == "" {
+		return nil, status.Error(codes.InvalidArgument, "symbol is required")
+	}
+	if err := p.checkAvailable(ctx); err != nil {
+		return nil, err
+	}
+
+	data, exists := p.service.quote(req.Symbol)
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "symbol %q not found", req.Symbol)
+	}
+
+	result := models.MarketData(data)
+	return &result, nil
+}
+
+// StreamPrices implements marketdata.PriceServer
+func (p *PriceServer) StreamPrices(req *marketdata.PricesRequest, stream marketdata.PriceStreamServer) error {
+	if len(req.Symbols) == 0 || len(req.Symbols) > models.MaxBatchSymbols {
+		return status.Errorf(codes.InvalidArgument, "symbols must list between 1 and %d symbols", models.MaxBatchSymbols)
+	}
+	if err := p.checkAvailable(stream.Context()); err != nil {
+		return err
+	}
+
+	for _, symbol := range req.Symbols {
+		data, exists := p.service.quote(symbol)
+		if !exists {
+			return status.Errorf(codes.NotFound, "symbol %q not found", symbol)
+		}
+
+		result := models.MarketData(data)
+		if err := stream.Send(&result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
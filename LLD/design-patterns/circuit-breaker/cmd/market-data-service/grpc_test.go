@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+	"circuit-breaker-demo/pkg/grpcclient"
+	"circuit-breaker-demo/pkg/marketdata"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// grpcFixture is a market data service served over an in-memory listener,
+// with a client whose calls go through a circuit breaker that opens on the
+// second status held against the server
+type grpcFixture struct {
+	service *MarketDataService
+	breaker *circuitbreaker.CircuitBreaker
+	client  *marketdata.PriceClient
+
+	mutex   sync.Mutex
+	counted []codes.Code // Status codes the interceptor counted as failures
+}
+
+func newGRPCFixture(t *testing.T) *grpcFixture {
+	t.Helper()
+
+	service := NewMarketDataService()
+	service.responseTime = 0
+
+	listener := bufconn.Listen(1 << 20)
+	server := NewGRPCServer(service, zap.NewNop())
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	config := circuitbreaker.DefaultConfig("market-data-grpc")
+	config.FailureThreshold = 2
+	config.Timeout = time.Minute
+	f := &grpcFixture{
+		service: service,
+		breaker: circuitbreaker.NewCircuitBreaker(config, zap.NewNop()),
+	}
+
+	interceptor := grpcclient.NewInterceptor(grpcclient.SingleBreaker(f.breaker), zap.NewNop())
+	interceptor.SetClassifier(func(err error) bool {
+		failed := grpcclient.DefaultClassifier(err)
+		if failed {
+			f.mutex.Lock()
+			f.counted = append(f.counted, status.Code(err))
+			f.mutex.Unlock()
+		}
+		return failed
+	})
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(interceptor.Unary()),
+		grpc.WithStreamInterceptor(interceptor.Stream()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	f.client = marketdata.NewPriceClient(conn)
+	return f
+}
+
+func (f *grpcFixture) setHealthy(healthy bool) {
+	f.service.mutex.Lock()
+	f.service.isHealthy = healthy
+	f.service.mutex.Unlock()
+}
+
+// checkCounted fails the test unless the interceptor counted exactly the
+// status codes want against the server
+func (f *grpcFixture) checkCounted(t *testing.T, want ...codes.Code) {
+	t.Helper()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !reflect.DeepEqual(append([]codes.Code{}, f.counted...), append([]codes.Code{}, want...)) {
+		t.Fatalf("counted failures = %v, want %v", f.counted, want)
+	}
+}
+
+func TestGRPCGetPrice(t *testing.T) {
+	f := newGRPCFixture(t)
+
+	data, err := f.client.GetPrice(context.Background(), "AAPL")
+	if err != nil {
+		t.Fatalf("GetPrice failed: %v", err)
+	}
+	if data.Symbol != "AAPL" || data.Price <= 0 {
+		t.Fatalf("unexpected market data: %+v", data)
+	}
+	f.checkCounted(t)
+}
+
+func TestGRPCClientErrorsDoNotOpenCircuit(t *testing.T) {
+	f := newGRPCFixture(t)
+
+	for i := 0; i < 3; i++ {
+		_, err := f.client.GetPrice(context.Background(), "")
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("empty symbol: got %v, want INVALID_ARGUMENT", err)
+		}
+		_, err = f.client.GetPrice(context.Background(), "UNKNOWN")
+		if status.Code(err) != codes.NotFound {
+			t.Fatalf("unknown symbol: got %v, want NOT_FOUND", err)
+		}
+	}
+
+	f.checkCounted(t)
+	if state := f.breaker.GetState(); state != circuitbreaker.StateClosed {
+		t.Fatalf("state = %s, want CLOSED", state)
+	}
+}
+
+func TestGRPCUnavailableOpensCircuit(t *testing.T) {
+	f := newGRPCFixture(t)
+	f.setHealthy(false)
+
+	for i := 0; i < 2; i++ {
+		_, err := f.client.GetPrice(context.Background(), "AAPL")
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("call %d: got %v, want UNAVAILABLE", i, err)
+		}
+	}
+	f.checkCounted(t, codes.Unavailable, codes.Unavailable)
+	if state := f.breaker.GetState(); state != circuitbreaker.StateOpen {
+		t.Fatalf("state = %s, want OPEN", state)
+	}
+
+	// Rejected without reaching the server, which is healthy again
+	f.setHealthy(true)
+	_, err := f.client.GetPrice(context.Background(), "AAPL")
+	if !errors.Is(err, circuitbreaker.ErrOpenState) {
+		t.Fatalf("got %v, want ErrOpenState", err)
+	}
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("rejected call has code %s, want UNAVAILABLE", status.Code(err))
+	}
+}
+
+func TestGRPCDeadlineExceededCountsAsFailure(t *testing.T) {
+	f := newGRPCFixture(t)
+	f.service.responseTime = 200 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := f.client.GetPrice(ctx, "AAPL")
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("got %v, want DEADLINE_EXCEEDED", err)
+	}
+	f.checkCounted(t, codes.DeadlineExceeded)
+}
+
+func TestGRPCCanceledCallIsNotRecorded(t *testing.T) {
+	f := newGRPCFixture(t)
+	f.service.responseTime = time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	_, err := f.client.GetPrice(ctx, "AAPL")
+	if status.Code(err) != codes.Canceled {
+		t.Fatalf("got %v, want CANCELED", err)
+	}
+	f.checkCounted(t)
+}
+
+func TestGRPCStreamPrices(t *testing.T) {
+	f := newGRPCFixture(t)
+	symbols := []string{"AAPL", "MSFT", "TSLA"}
+
+	stream, err := f.client.StreamPrices(context.Background(), symbols)
+	if err != nil {
+		t.Fatalf("StreamPrices failed: %v", err)
+	}
+	for _, symbol := range symbols {
+		data, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if data.Symbol != symbol {
+			t.Fatalf("got %s, want %s", data.Symbol, symbol)
+		}
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+	f.checkCounted(t)
+}
+
+func TestGRPCStreamFailuresOpenCircuit(t *testing.T) {
+	f := newGRPCFixture(t)
+	f.setHealthy(false)
+
+	for i := 0; i < 2; i++ {
+		stream, err := f.client.StreamPrices(context.Background(), []string{"AAPL"})
+		if err != nil {
+			t.Fatalf("StreamPrices failed: %v", err)
+		}
+		if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+			t.Fatalf("stream %d: got %v, want UNAVAILABLE", i, err)
+		}
+	}
+	f.checkCounted(t, codes.Unavailable, codes.Unavailable)
+	if state := f.breaker.GetState(); state != circuitbreaker.StateOpen {
+		t.Fatalf("state = %s, want OPEN", state)
+	}
+
+	_, err := f.client.StreamPrices(context.Background(), []string{"AAPL"})
+	if !errors.Is(err, circuitbreaker.ErrOpenState) {
+		t.Fatalf("got %v, want ErrOpenState", err)
+	}
+}
+
+func TestGRPCStreamAbandonedByCallerIsNotRecorded(t *testing.T) {
+	f := newGRPCFixture(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := f.client.StreamPrices(ctx, []string{"AAPL", "MSFT"})
+	if err != nil {
+		t.Fatalf("StreamPrices failed: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	cancel()
+
+	// The outcome is reported once the context ends
+	time.Sleep(50 * time.Millisecond)
+	f.checkCounted(t)
+	if state := f.breaker.GetState(); state != circuitbreaker.StateClosed {
+		t.Fatalf("state = %s, want CLOSED", state)
+	}
+}
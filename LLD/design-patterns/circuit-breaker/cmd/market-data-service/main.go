@@ -1,14 +1,38 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 
+	"circuit-breaker-demo/pkg/auth"
+	"circuit-breaker-demo/pkg/bootstrap"
+	"circuit-breaker-demo/pkg/config"
+	"circuit-breaker-demo/pkg/loadshed"
+	"circuit-breaker-demo/pkg/marketdata"
+
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// defaultRetryAfter is sent with 503 responses while the service is
+// simulated unhealthy
+const defaultRetryAfter = 30 * time.Second
+
+// Ports used unless the configuration sets server.port, server.grpc_port
+// and metrics.port
+const (
+	defaultPort        = 8082
+	defaultGRPCPort    = 50052
+	defaultMetricsPort = 9092
 )
 
 // MarketData represents market data for a symbol
@@ -37,20 +61,24 @@ type BatchMarketDataResponse struct {
 
 // HealthResponse represents health check response
 type HealthResponse struct {
-	Status    string            `json:"status"`
-	Service   string            `json:"service"`
-	Version   string            `json:"version"`
-	Timestamp time.Time         `json:"timestamp"`
-	Checks    map[string]string `json:"checks,omitempty"`
+	Status        string            `json:"status"`
+	Service       string            `json:"service"`
+	Version       string            `json:"version"`
+	ConfigVersion uint64            `json:"configVersion,omitempty"`
+	ConfigHash    string            `json:"configHash,omitempty"`
+	Timestamp     time.Time         `json:"timestamp"`
+	Checks        map[string]string `json:"checks,omitempty"`
 }
 
 // MarketDataService simulates an external market data provider
 type MarketDataService struct {
-	prices       map[string]float64
-	mutex        sync.RWMutex
-	isHealthy    bool
-	failureRate  float64
-	responseTime time.Duration
+	prices        map[string]float64
+	mutex         sync.RWMutex
+	isHealthy     bool
+	failureRate   float64
+	retryAfter    time.Duration
+	responseTime  time.Duration
+	configWatcher *config.Watcher
 }
 
 // NewMarketDataService creates a new market data service
@@ -59,6 +87,7 @@ func NewMarketDataService() *MarketDataService {
 		prices:       make(map[string]float64),
 		isHealthy:    true,
 		failureRate:  0.0,
+		retryAfter:   defaultRetryAfter,
 		responseTime: 100 * time.Millisecond,
 	}
 
@@ -93,58 +122,71 @@ func (s *MarketDataService) updatePrices() {
 	}
 }
 
-// simulateLatency adds artificial latency to simulate network delays
-func (s *MarketDataService) simulateLatency() {
+// simulateLatency adds artificial latency to simulate network delays. Work
+// that cannot finish before the request deadline is given up at once, and
+// waiting stops when the request ends.
+func (s *MarketDataService) simulateLatency(ctx context.Context) error {
 	s.mutex.RLock()
 	latency := s.responseTime
 	s.mutex.RUnlock()
 
 	// Add random jitter
 	jitter := time.Duration(rand.Float64() * float64(latency) * 0.5)
-	time.Sleep(latency + jitter)
+	delay := latency + jitter
+
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return context.DeadlineExceeded
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // simulateFailure randomly fails requests based on failure rate
 func (s *MarketDataService) simulateFailure() bool {
 	s.mutex.RLock()
 	rate := s.failureRate
-	healthy := s.isHealthy
 	s.mutex.RUnlock()
 
-	if !healthy {
-		return true
-	}
-
 	return rand.Float64() < rate
 }
 
-// GetPrice handles individual price requests
-func (s *MarketDataService) GetPrice(c *gin.Context) {
-	symbol := c.Param("symbol")
-
-	// Simulate latency
-	s.simulateLatency()
+// rejectIfUnhealthy answers 503 with Retry-After while the service is
+// simulated unhealthy, telling clients when to come back, and reports
+// whether it did
+func (s *MarketDataService) rejectIfUnhealthy(c *gin.Context) bool {
+	s.mutex.RLock()
+	healthy := s.isHealthy
+	retryAfter := s.retryAfter
+	s.mutex.RUnlock()
 
-	// Simulate failures
-	if s.simulateFailure() {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":     "Market data service temporarily unavailable",
-			"timestamp": time.Now(),
-		})
-		return
+	if healthy {
+		return false
 	}
 
+	c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"error":     "Market data service unavailable",
+		"timestamp": time.Now(),
+	})
+	return true
+}
+
+// quote returns the current market data for a symbol
+func (s *MarketDataService) quote(symbol string) (MarketData, bool) {
 	s.mutex.RLock()
 	currentPrice, exists := s.prices[symbol]
 	s.mutex.RUnlock()
 
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error":     "Symbol not found",
-			"symbol":    symbol,
-			"timestamp": time.Now(),
-		})
-		return
+		return MarketData{}, false
 	}
 
 	// Calculate some mock data
@@ -154,7 +196,7 @@ func (s *MarketDataService) GetPrice(c *gin.Context) {
 	change := (rand.Float64() - 0.5) * 10
 	changePercent := change / currentPrice * 100
 
-	marketData := MarketData{
+	return MarketData{
 		Symbol:        symbol,
 		Price:         currentPrice,
 		High:          high,
@@ -163,6 +205,40 @@ func (s *MarketDataService) GetPrice(c *gin.Context) {
 		Change:        change,
 		ChangePercent: changePercent,
 		Timestamp:     time.Now(),
+	}, true
+}
+
+// GetPrice handles individual price requests
+func (s *MarketDataService) GetPrice(c *gin.Context) {
+	symbol := c.Param("symbol")
+
+	// Simulate latency
+	if err := s.simulateLatency(c.Request.Context()); err != nil {
+		bootstrap.AbandonRequest(c, err)
+		return
+	}
+
+	if s.rejectIfUnhealthy(c) {
+		return
+	}
+
+	// Simulate failures
+	if s.simulateFailure() {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":     "Market data service temporarily unavailable",
+			"timestamp": time.Now(),
+		})
+		return
+	}
+
+	marketData, exists := s.quote(symbol)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":     "Symbol not found",
+			"symbol":    symbol,
+			"timestamp": time.Now(),
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, marketData)
@@ -181,7 +257,14 @@ func (s *MarketDataService) GetBatchPrices(c *gin.Context) {
 	}
 
 	// Simulate latency
-	s.simulateLatency()
+	if err := s.simulateLatency(c.Request.Context()); err != nil {
+		bootstrap.AbandonRequest(c, err)
+		return
+	}
+
+	if s.rejectIfUnhealthy(c) {
+		return
+	}
 
 	// Simulate failures
 	if s.simulateFailure() {
@@ -198,30 +281,13 @@ func (s *MarketDataService) GetBatchPrices(c *gin.Context) {
 		Timestamp: time.Now(),
 	}
 
-	s.mutex.RLock()
 	for _, symbol := range request.Symbols {
-		if currentPrice, exists := s.prices[symbol]; exists {
-			high := currentPrice * (1 + rand.Float64()*0.05)
-			low := currentPrice * (1 - rand.Float64()*0.05)
-			volume := int64(rand.Intn(1000000) + 100000)
-			change := (rand.Float64() - 0.5) * 10
-			changePercent := change / currentPrice * 100
-
-			response.Data[symbol] = MarketData{
-				Symbol:        symbol,
-				Price:         currentPrice,
-				High:          high,
-				Low:           low,
-				Volume:        volume,
-				Change:        change,
-				ChangePercent: changePercent,
-				Timestamp:     time.Now(),
-			}
+		if marketData, exists := s.quote(symbol); exists {
+			response.Data[symbol] = marketData
 		} else {
 			response.Errors[symbol] = "Symbol not found"
 		}
 	}
-	s.mutex.RUnlock()
 
 	c.JSON(http.StatusOK, response)
 }
@@ -230,6 +296,7 @@ func (s *MarketDataService) GetBatchPrices(c *gin.Context) {
 func (s *MarketDataService) Health(c *gin.Context) {
 	s.mutex.RLock()
 	healthy := s.isHealthy
+	retryAfter := s.retryAfter
 	s.mutex.RUnlock()
 
 	status := "healthy"
@@ -238,6 +305,7 @@ func (s *MarketDataService) Health(c *gin.Context) {
 	if !healthy {
 		status = "unhealthy"
 		statusCode = http.StatusServiceUnavailable
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
 	}
 
 	response := HealthResponse{
@@ -252,6 +320,10 @@ func (s *MarketDataService) Health(c *gin.Context) {
 		},
 	}
 
+	if s.configWatcher != nil {
+		response.ConfigVersion, response.ConfigHash = s.configWatcher.Version()
+	}
+
 	c.JSON(statusCode, response)
 }
 
@@ -261,6 +333,7 @@ func (s *MarketDataService) SimulateFailure(c *gin.Context) {
 		FailureRate  float64 `json:"failure_rate"`
 		IsHealthy    bool    `json:"is_healthy"`
 		ResponseTime int     `json:"response_time_ms"`
+		RetryAfter   int     `json:"retry_after_seconds"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -277,6 +350,10 @@ func (s *MarketDataService) SimulateFailure(c *gin.Context) {
 	if request.ResponseTime > 0 {
 		s.responseTime = time.Duration(request.ResponseTime) * time.Millisecond
 	}
+	if request.RetryAfter > 0 {
+		s.retryAfter = time.Duration(request.RetryAfter) * time.Second
+	}
+	retryAfter := s.retryAfter
 	s.mutex.Unlock()
 
 	c.JSON(http.StatusOK, gin.H{
@@ -284,6 +361,7 @@ func (s *MarketDataService) SimulateFailure(c *gin.Context) {
 		"failure_rate":  request.FailureRate,
 		"is_healthy":    request.IsHealthy,
 		"response_time": request.ResponseTime,
+		"retry_after":   int(retryAfter.Seconds()),
 		"timestamp":     time.Now(),
 	})
 }
@@ -297,6 +375,7 @@ func (s *MarketDataService) GetStatus(c *gin.Context) {
 		"is_healthy":    s.isHealthy,
 		"failure_rate":  s.failureRate,
 		"response_time": s.responseTime.Milliseconds(),
+		"retry_after":   int(s.retryAfter.Seconds()),
 		"symbols_count": len(s.prices),
 		"timestamp":     time.Now(),
 	}
@@ -306,28 +385,69 @@ func (s *MarketDataService) GetStatus(c *gin.Context) {
 }
 
 func main() {
+	defaults := config.DefaultConfig()
+	defaults.Server.Port = defaultPort
+	defaults.Server.GRPCPort = defaultGRPCPort
+	defaults.Metrics.Port = defaultMetricsPort
+
+	loader := config.NewLoader(defaults)
+	loader.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatal("Failed to load configuration: ", err)
+	}
+	if loader.PrintRequested() {
+		loader.Print(os.Stdout)
+		return
+	}
+
+	logger, logLevel, err := bootstrap.NewLogger(cfg.Logging)
+	if err != nil {
+		log.Fatal("Failed to initialize logger: ", err)
+	}
+	defer logger.Sync()
+
+	// Reload configuration on file change or SIGHUP
+	configWatcher := config.NewWatcher(loader, cfg, 5*time.Second, logger)
+	bootstrap.FollowLogLevel(configWatcher, logLevel, logger)
+	go configWatcher.Start(context.Background())
+
 	// Seed random number generator
 	rand.Seed(time.Now().UnixNano())
 
 	service := NewMarketDataService()
+	service.configWatcher = configWatcher
 
 	// Configure Gin
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 
-	// Add CORS middleware
-	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	// Admin routes need an admin API key or bearer token
+	authenticator, err := auth.NewAuthenticator(cfg.Auth.Policy(), logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize authentication", zap.Error(err))
+	}
+	bootstrap.FollowAuth(configWatcher, authenticator, logger)
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
-			return
-		}
+	// Allow cross-origin calls; OPTIONS preflights are answered before any shedding
+	router.Use(bootstrap.CORS(http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete))
 
-		c.Next()
-	})
+	// Correlate requests across services with X-Request-ID
+	router.Use(bootstrap.RequestID())
+
+	// Bound each request by the caller's X-Request-Deadline and the configured budget
+	router.Use(bootstrap.Deadline(cfg.Server.RequestBudget))
+
+	// Shed excess load by route priority before it reaches the handlers
+	shedder := loadshed.NewShedder(cfg.LoadShedding.Policy(), logger)
+	bootstrap.FollowLoadShedding(configWatcher, shedder, logger)
+	go shedder.Start(context.Background())
+	router.Use(shedder.Middleware())
+
+	// Metrics endpoint, on its own listener when metrics.port is set
+	metricsPort := bootstrap.ServeMetrics(router, cfg, logger)
 
 	// API routes
 	v1 := router.Group("/api/v1")
@@ -335,12 +455,16 @@ func main() {
 		v1.GET("/prices/:symbol", service.GetPrice)
 		v1.POST("/prices/batch", service.GetBatchPrices)
 		v1.GET("/health", service.Health)
-		v1.POST("/simulate/failure", service.SimulateFailure)
 		v1.GET("/status", service.GetStatus)
 	}
+	admin := v1.Group("", authenticator.RequireRole(auth.RoleAdmin))
+	{
+		admin.POST("/simulate/failure", service.SimulateFailure)
+		bootstrap.RegisterLogLevel(admin, logLevel)
+	}
 
 	// Start server
-	port := 8082
+	port := cfg.Server.Port
 	fmt.Printf("🚀 Market Data Service starting on port %d\n", port)
 	fmt.Printf("📊 Available endpoints:\n")
 	fmt.Printf("   GET  /api/v1/prices/{symbol}     - Get price for symbol\n")
@@ -348,10 +472,40 @@ func main() {
 	fmt.Printf("   GET  /api/v1/health              - Health check\n")
 	fmt.Printf("   POST /api/v1/simulate/failure    - Simulate failures\n")
 	fmt.Printf("   GET  /api/v1/status              - Service status\n")
+	fmt.Printf("   GET|PUT /api/v1/admin/log-level  - Get or change log level\n")
+	if metricsPort != 0 {
+		fmt.Printf("   GET  :%d%s - Prometheus metrics\n", metricsPort, cfg.Metrics.Path)
+	}
+	if cfg.Server.GRPCPort != 0 {
+		fmt.Printf("   gRPC :%d %s - GetPrice, StreamPrices\n", cfg.Server.GRPCPort, marketdata.PriceServiceName)
+	}
 	fmt.Printf("\n💡 Example: curl http://localhost:%d/api/v1/prices/AAPL\n", port)
 	fmt.Printf("💡 Simulate failure: curl -X POST http://localhost:%d/api/v1/simulate/failure -H 'Content-Type: application/json' -d '{\"failure_rate\": 0.5, \"is_healthy\": true}'\n", port)
 
-	if err := router.Run(fmt.Sprintf(":%d", port)); err != nil {
-		log.Fatal("Failed to start server:", err)
+	logger.Info("Market Data Service starting", zap.Int("port", port))
+
+	// gRPC price API on its own listener
+	if cfg.Server.GRPCPort != 0 {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Server.GRPCPort))
+		if err != nil {
+			logger.Fatal("Failed to listen for gRPC", zap.Int("port", cfg.Server.GRPCPort), zap.Error(err))
+		}
+		grpcServer := NewGRPCServer(service, logger)
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				logger.Error("gRPC server stopped", zap.Error(err))
+			}
+		}()
+	}
+
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      router,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}
+
+	if err := server.ListenAndServe(); err != nil {
+		logger.Fatal("Failed to start server", zap.Error(err))
 	}
 }
@@ -1,14 +1,35 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 
+	"circuit-breaker-demo/pkg/auth"
+	"circuit-breaker-demo/pkg/bootstrap"
+	"circuit-breaker-demo/pkg/config"
+	"circuit-breaker-demo/pkg/idempotency"
+	"circuit-breaker-demo/pkg/loadshed"
+
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// defaultRetryAfter is sent with 503 responses while the service is
+// simulated unhealthy
+const defaultRetryAfter = 30 * time.Second
+
+// Ports used unless the configuration sets server.port and metrics.port
+const (
+	defaultPort        = 8081
+	defaultMetricsPort = 9091
 )
 
 // Position represents a position in a user's portfolio
@@ -42,19 +63,23 @@ type UpdatePositionRequest struct {
 
 // HealthResponse represents health check response
 type HealthResponse struct {
-	Status    string            `json:"status"`
-	Service   string            `json:"service"`
-	Version   string            `json:"version"`
-	Timestamp time.Time         `json:"timestamp"`
-	Checks    map[string]string `json:"checks,omitempty"`
+	Status        string            `json:"status"`
+	Service       string            `json:"service"`
+	Version       string            `json:"version"`
+	ConfigVersion uint64            `json:"configVersion,omitempty"`
+	ConfigHash    string            `json:"configHash,omitempty"`
+	Timestamp     time.Time         `json:"timestamp"`
+	Checks        map[string]string `json:"checks,omitempty"`
 }
 
 // PortfolioService manages user portfolios
 type PortfolioService struct {
-	portfolios  map[string]*Portfolio
-	mutex       sync.RWMutex
-	isHealthy   bool
-	failureRate float64
+	portfolios    map[string]*Portfolio
+	mutex         sync.RWMutex
+	isHealthy     bool
+	failureRate   float64
+	retryAfter    time.Duration
+	configWatcher *config.Watcher
 }
 
 // NewPortfolioService creates a new portfolio service
@@ -63,6 +88,7 @@ func NewPortfolioService() *PortfolioService {
 		portfolios:  make(map[string]*Portfolio),
 		isHealthy:   true,
 		failureRate: 0.0,
+		retryAfter:  defaultRetryAfter,
 	}
 
 	// Initialize with some sample portfolios
@@ -120,20 +146,45 @@ func (s *PortfolioService) initializeSamplePortfolios() {
 func (s *PortfolioService) simulateFailure() bool {
 	s.mutex.RLock()
 	rate := s.failureRate
+	s.mutex.RUnlock()
+
+	return rand.Float64() < rate
+}
+
+// rejectIfUnhealthy answers 503 with Retry-After while the service is
+// simulated unhealthy, telling clients when to come back, and reports
+// whether it did
+func (s *PortfolioService) rejectIfUnhealthy(c *gin.Context) bool {
+	s.mutex.RLock()
 	healthy := s.isHealthy
+	retryAfter := s.retryAfter
 	s.mutex.RUnlock()
 
-	if !healthy {
-		return true
+	if healthy {
+		return false
 	}
 
-	return rand.Float64() < rate
+	c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"error":     "Portfolio service unavailable",
+		"timestamp": time.Now(),
+	})
+	return true
 }
 
 // GetPortfolio returns a user's portfolio
 func (s *PortfolioService) GetPortfolio(c *gin.Context) {
 	userID := c.Param("userId")
 
+	if s.rejectIfUnhealthy(c) {
+		return
+	}
+
+	if err := c.Request.Context().Err(); err != nil {
+		bootstrap.AbandonRequest(c, err)
+		return
+	}
+
 	// Simulate failures
 	if s.simulateFailure() {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -177,6 +228,10 @@ func (s *PortfolioService) UpdatePosition(c *gin.Context) {
 		return
 	}
 
+	if s.rejectIfUnhealthy(c) {
+		return
+	}
+
 	// Simulate failures
 	if s.simulateFailure() {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -189,6 +244,12 @@ func (s *PortfolioService) UpdatePosition(c *gin.Context) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	// Do not apply an update the caller has stopped waiting for
+	if err := c.Request.Context().Err(); err != nil {
+		bootstrap.AbandonRequest(c, err)
+		return
+	}
+
 	portfolio, exists := s.portfolios[userID]
 	if !exists {
 		portfolio = &Portfolio{
@@ -310,6 +371,7 @@ func (s *PortfolioService) UpdatePosition(c *gin.Context) {
 func (s *PortfolioService) Health(c *gin.Context) {
 	s.mutex.RLock()
 	healthy := s.isHealthy
+	retryAfter := s.retryAfter
 	s.mutex.RUnlock()
 
 	status := "healthy"
@@ -318,6 +380,7 @@ func (s *PortfolioService) Health(c *gin.Context) {
 	if !healthy {
 		status = "unhealthy"
 		statusCode = http.StatusServiceUnavailable
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
 	}
 
 	response := HealthResponse{
@@ -331,6 +394,10 @@ func (s *PortfolioService) Health(c *gin.Context) {
 		},
 	}
 
+	if s.configWatcher != nil {
+		response.ConfigVersion, response.ConfigHash = s.configWatcher.Version()
+	}
+
 	c.JSON(statusCode, response)
 }
 
@@ -339,6 +406,7 @@ func (s *PortfolioService) SimulateFailure(c *gin.Context) {
 	var request struct {
 		FailureRate float64 `json:"failure_rate"`
 		IsHealthy   bool    `json:"is_healthy"`
+		RetryAfter  int     `json:"retry_after_seconds"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -352,60 +420,128 @@ func (s *PortfolioService) SimulateFailure(c *gin.Context) {
 	s.mutex.Lock()
 	s.failureRate = request.FailureRate
 	s.isHealthy = request.IsHealthy
+	if request.RetryAfter > 0 {
+		s.retryAfter = time.Duration(request.RetryAfter) * time.Second
+	}
+	retryAfter := s.retryAfter
 	s.mutex.Unlock()
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":      "Failure simulation updated",
 		"failure_rate": request.FailureRate,
 		"is_healthy":   request.IsHealthy,
+		"retry_after":  int(retryAfter.Seconds()),
 		"timestamp":    time.Now(),
 	})
 }
 
 func main() {
+	defaults := config.DefaultConfig()
+	defaults.Server.Port = defaultPort
+	defaults.Metrics.Port = defaultMetricsPort
+
+	loader := config.NewLoader(defaults)
+	loader.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatal("Failed to load configuration: ", err)
+	}
+	if loader.PrintRequested() {
+		loader.Print(os.Stdout)
+		return
+	}
+
+	logger, logLevel, err := bootstrap.NewLogger(cfg.Logging)
+	if err != nil {
+		log.Fatal("Failed to initialize logger: ", err)
+	}
+	defer logger.Sync()
+
+	// Reload configuration on file change or SIGHUP
+	configWatcher := config.NewWatcher(loader, cfg, 5*time.Second, logger)
+	bootstrap.FollowLogLevel(configWatcher, logLevel, logger)
+	go configWatcher.Start(context.Background())
+
 	// Seed random number generator
 	rand.Seed(time.Now().UnixNano())
 
 	service := NewPortfolioService()
+	service.configWatcher = configWatcher
 
 	// Configure Gin
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 
-	// Add CORS middleware
-	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	// Admin routes need an admin API key or bearer token
+	authenticator, err := auth.NewAuthenticator(cfg.Auth.Policy(), logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize authentication", zap.Error(err))
+	}
+	bootstrap.FollowAuth(configWatcher, authenticator, logger)
+
+	// Allow cross-origin calls; OPTIONS preflights are answered before any shedding
+	router.Use(bootstrap.CORS(http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete))
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
-			return
-		}
+	// Correlate requests across services with X-Request-ID
+	router.Use(bootstrap.RequestID())
 
-		c.Next()
-	})
+	// Bound each request by the caller's X-Request-Deadline and the configured budget
+	router.Use(bootstrap.Deadline(cfg.Server.RequestBudget))
+
+	// Shed excess load by route priority before it reaches the handlers
+	shedder := loadshed.NewShedder(cfg.LoadShedding.Policy(), logger)
+	bootstrap.FollowLoadShedding(configWatcher, shedder, logger)
+	go shedder.Start(context.Background())
+	router.Use(shedder.Middleware())
+
+	// Apply each trade's position update at most once, however often the
+	// gateway sends it with the trade's Idempotency-Key
+	guard := idempotency.NewGuard(idempotency.NewMemoryStore(), cfg.Idempotency.Policy(), logger)
+	bootstrap.FollowIdempotency(configWatcher, guard, logger)
+
+	// Metrics endpoint, on its own listener when metrics.port is set
+	metricsPort := bootstrap.ServeMetrics(router, cfg, logger)
 
 	// API routes
 	v1 := router.Group("/api/v1")
 	{
 		v1.GET("/portfolio/:userId", service.GetPortfolio)
-		v1.POST("/portfolio/:userId/positions", service.UpdatePosition)
+		// A retried trade may carry a newer price; the key alone identifies it
+		v1.POST("/portfolio/:userId/positions", guard.Middleware(false), service.UpdatePosition)
 		v1.GET("/health", service.Health)
-		v1.POST("/simulate/failure", service.SimulateFailure)
+	}
+	admin := v1.Group("", authenticator.RequireRole(auth.RoleAdmin))
+	{
+		admin.POST("/simulate/failure", service.SimulateFailure)
+		bootstrap.RegisterLogLevel(admin, logLevel)
 	}
 
 	// Start server
-	port := 8081
+	port := cfg.Server.Port
 	fmt.Printf("🚀 Portfolio Service starting on port %d\n", port)
 	fmt.Printf("📊 Available endpoints:\n")
 	fmt.Printf("   GET  /api/v1/portfolio/{userId}           - Get user portfolio\n")
 	fmt.Printf("   POST /api/v1/portfolio/{userId}/positions - Update position\n")
 	fmt.Printf("   GET  /api/v1/health                       - Health check\n")
 	fmt.Printf("   POST /api/v1/simulate/failure             - Simulate failures\n")
+	fmt.Printf("   GET|PUT /api/v1/admin/log-level  - Get or change log level\n")
+	if metricsPort != 0 {
+		fmt.Printf("   GET  :%d%s - Prometheus metrics\n", metricsPort, cfg.Metrics.Path)
+	}
 	fmt.Printf("\n💡 Example: curl http://localhost:%d/api/v1/portfolio/user123\n", port)
 
-	if err := router.Run(fmt.Sprintf(":%d", port)); err != nil {
-		log.Fatal("Failed to start server:", err)
+	logger.Info("Portfolio Service starting", zap.Int("port", port))
+
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      router,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}
+
+	if err := server.ListenAndServe(); err != nil {
+		logger.Fatal("Failed to start server", zap.Error(err))
 	}
 }
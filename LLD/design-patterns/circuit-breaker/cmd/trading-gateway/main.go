@@ -2,17 +2,31 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
 	"time"
 
+	"circuit-breaker-demo/pkg/auth"
+	"circuit-breaker-demo/pkg/bootstrap"
 	"circuit-breaker-demo/pkg/circuitbreaker"
+	"circuit-breaker-demo/pkg/config"
 	"circuit-breaker-demo/pkg/httpclient"
+	"circuit-breaker-demo/pkg/idempotency"
+	"circuit-breaker-demo/pkg/loadshed"
 	"circuit-breaker-demo/pkg/models"
+	"circuit-breaker-demo/pkg/ratelimit"
 
 	"github.com/gin-gonic/gin"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 )
 
@@ -24,91 +38,173 @@ type TradingGateway struct {
 	riskManagementClient *httpclient.HTTPClient
 	notificationClient   *httpclient.HTTPClient
 	auditClient          *httpclient.HTTPClient
+	circuitBreakers      map[string]*circuitbreaker.CircuitBreaker
+	breakerLayers        *circuitbreaker.Layers
+	clients              map[string]*httpclient.HTTPClient
+	healthCheckers       []*httpclient.HealthChecker
+	quoteBatcher         *httpclient.Batcher
+	configWatcher        *config.Watcher
+	authenticator        *auth.Authenticator
+	idempotencyGuard     *idempotency.Guard
 }
 
+// Override layers of the circuit breakers, lowest precedence first. A
+// breaker's configuration comes from its service's circuit_breaker block in
+// the main configuration, then the overrides file, then the admin API; a
+// configuration reload replaces only the first, keeping both kinds of
+// override.
+const (
+	fileLayer  = "file"
+	adminLayer = "admin"
+)
+
 // NewTradingGateway creates a new trading gateway instance
-func NewTradingGateway(logger *zap.Logger) *TradingGateway {
-	// Create circuit breakers for each service
-	marketDataCB := circuitbreaker.NewCircuitBreaker(
-		circuitbreaker.Config{
-			Name:                 "market-data-service",
-			MaxRequests:          3,
-			Interval:             time.Minute,
-			Timeout:              30 * time.Second,
-			FailureThreshold:     5,
-			SuccessThreshold:     2,
-			FailureRateThreshold: 0.5,
-			MinimumRequests:      3,
-		},
-		logger,
-	)
+func NewTradingGateway(cfg *config.Config, logger *zap.Logger) *TradingGateway {
+	tg := &TradingGateway{
+		logger:          logger,
+		circuitBreakers: make(map[string]*circuitbreaker.CircuitBreaker),
+		clients:         make(map[string]*httpclient.HTTPClient),
+	}
 
-	portfolioCB := circuitbreaker.NewCircuitBreaker(
-		circuitbreaker.Config{
-			Name:                 "portfolio-service",
-			MaxRequests:          5,
-			Interval:             time.Minute,
-			Timeout:              20 * time.Second,
-			FailureThreshold:     3,
-			SuccessThreshold:     2,
-			FailureRateThreshold: 0.6,
-			MinimumRequests:      2,
-		},
-		logger,
-	)
+	// Create a circuit breaker protected client for each service
+	marketData := cfg.Resolve("market-data-service", cfg.Services.MarketData)
+	tg.marketDataClient = tg.newServiceClient(marketData)
+	tg.portfolioClient = tg.newServiceClient(cfg.Resolve("portfolio-service", cfg.Services.Portfolio))
+	// Position updates carry the trade's Idempotency-Key, so they apply at most once
+	tg.portfolioClient.Use(httpclient.IdempotencyKey())
+	tg.riskManagementClient = tg.newServiceClient(cfg.Resolve("risk-management-service", cfg.Services.RiskManagement))
+	tg.notificationClient = tg.newServiceClient(cfg.Resolve("notification-service", cfg.Services.Notification))
+	tg.auditClient = tg.newServiceClient(cfg.Resolve("audit-service", cfg.Services.Audit))
+
+	// Quotes for several symbols are collected into batch requests
+	tg.quoteBatcher = httpclient.NewBatcher("market-data-quotes", tg.loadQuotes, marketData.Batch, marketData.Timeout, logger)
+
+	breakers := make([]*circuitbreaker.CircuitBreaker, 0, len(tg.circuitBreakers))
+	for _, cb := range tg.circuitBreakers {
+		breakers = append(breakers, cb)
+	}
+	tg.breakerLayers = circuitbreaker.NewLayers([]string{fileLayer, adminLayer}, breakers...)
 
-	riskMgmtCB := circuitbreaker.NewCircuitBreaker(
-		circuitbreaker.Config{
-			Name:                 "risk-management-service",
-			MaxRequests:          2,
-			Interval:             time.Minute,
-			Timeout:              15 * time.Second,
-			FailureThreshold:     2,
-			SuccessThreshold:     1,
-			FailureRateThreshold: 0.3,
-			MinimumRequests:      2,
-		},
-		logger,
-	)
+	return tg
+}
 
-	notificationCB := circuitbreaker.NewCircuitBreaker(
-		circuitbreaker.Config{
-			Name:                 "notification-service",
-			MaxRequests:          10,
-			Interval:             time.Minute,
-			Timeout:              10 * time.Second,
-			FailureThreshold:     10,
-			SuccessThreshold:     3,
-			FailureRateThreshold: 0.8,
-			MinimumRequests:      5,
-		},
-		logger,
-	)
+// newServiceClient creates the circuit breakers and HTTP client for an
+// upstream service. A service with several endpoints gets one breaker per
+// endpoint, named after the service and the endpoint host.
+func (tg *TradingGateway) newServiceClient(service config.ResolvedService) *httpclient.HTTPClient {
+	endpoints := make([]*httpclient.Endpoint, len(service.Endpoints))
+	for i, endpoint := range service.Endpoints {
+		breakerConfig := service.CircuitBreaker
+		if len(service.Endpoints) > 1 {
+			breakerConfig.Name = endpointBreakerName(service.Name, endpoint.URL)
+		}
 
-	auditCB := circuitbreaker.NewCircuitBreaker(
-		circuitbreaker.Config{
-			Name:                 "audit-service",
-			MaxRequests:          15,
-			Interval:             time.Minute,
-			Timeout:              5 * time.Second,
-			FailureThreshold:     15,
-			SuccessThreshold:     5,
-			FailureRateThreshold: 0.9,
-			MinimumRequests:      10,
-		},
-		logger,
+		cb := circuitbreaker.NewCircuitBreaker(breakerConfig, tg.logger)
+		tg.circuitBreakers[breakerConfig.Name] = cb
+		endpoints[i] = httpclient.NewEndpoint(endpoint.URL, endpoint.Weight, cb)
+	}
+
+	// The balancer name was validated with the rest of the configuration
+	balancer, _ := httpclient.NewBalancer(service.Balancer)
+
+	client := httpclient.NewBalancedHTTPClient(endpoints, balancer, service.Timeout, tg.logger)
+	client.SetRetryPolicy(service.Retry)
+	client.SetHedgePolicy(service.Hedge)
+	client.SetCachePolicy(service.Cache)
+	client.SetOutlierDetection(service.OutlierDetection)
+	client.Use(
+		httpclient.UserAgent(service.UserAgent),
+		httpclient.RequestID(),
+		httpclient.PropagateDeadline(),
+		httpclient.Metrics(service.Name),
+		httpclient.Logging(tg.logger),
 	)
+	if service.AuthToken != "" {
+		client.Use(httpclient.AuthToken(httpclient.StaticToken(service.AuthToken)))
+	}
+	tg.clients[service.Name] = client
+
+	tg.healthCheckers = append(tg.healthCheckers, httpclient.NewHealthChecker(endpoints, service.HealthCheck, tg.logger))
+
+	return client
+}
 
-	return &TradingGateway{
-		logger:               logger,
-		marketDataClient:     httpclient.NewHTTPClient("http://localhost:8082", 5*time.Second, marketDataCB, logger),
-		portfolioClient:      httpclient.NewHTTPClient("http://localhost:8081", 5*time.Second, portfolioCB, logger),
-		riskManagementClient: httpclient.NewHTTPClient("http://localhost:8083", 3*time.Second, riskMgmtCB, logger),
-		notificationClient:   httpclient.NewHTTPClient("http://localhost:8084", 2*time.Second, notificationCB, logger),
-		auditClient:          httpclient.NewHTTPClient("http://localhost:8085", 3*time.Second, auditCB, logger),
+// UseUpstreamTransport sends every upstream request through base instead of
+// the network, e.g. a Recorder, Replayer or FakeTransport, so the gateway can
+// run in-process against recorded or scripted upstreams. Health checks are
+// not affected. Call it before serving requests.
+func (tg *TradingGateway) UseUpstreamTransport(base http.RoundTripper) {
+	for _, client := range tg.clients {
+		client.Transport().SetBase(base)
 	}
 }
 
+// UseAuthenticator protects the routes registered from now on: trades need
+// the trader role, portfolios an authenticated caller and admin routes the
+// admin role. Without an authenticator every route is open.
+func (tg *TradingGateway) UseAuthenticator(authenticator *auth.Authenticator) {
+	tg.authenticator = authenticator
+}
+
+// UseIdempotency lets clients retry trades registered from now on with an
+// Idempotency-Key header without executing them twice
+func (tg *TradingGateway) UseIdempotency(guard *idempotency.Guard) {
+	tg.idempotencyGuard = guard
+}
+
+// RegisterRoutes adds the gateway API to the group, normally /api/v1
+func (tg *TradingGateway) RegisterRoutes(v1 *gin.RouterGroup) {
+	trader, user, admin := v1, v1, v1
+	if tg.authenticator != nil {
+		trader = v1.Group("", tg.authenticator.RequireRole(auth.RoleTrader))
+		user = v1.Group("", tg.authenticator.Require())
+		admin = v1.Group("", tg.authenticator.RequireRole(auth.RoleAdmin))
+	}
+
+	trades := []gin.HandlerFunc{tg.ExecuteTrade}
+	if tg.idempotencyGuard != nil {
+		// A retried trade must repeat the original request exactly
+		trades = append([]gin.HandlerFunc{tg.idempotencyGuard.Middleware(true)}, trades...)
+	}
+	trader.POST("/trades", trades...)
+	user.GET("/portfolio/:userId", tg.GetPortfolio)
+	v1.GET("/market-data", tg.GetQuotes)
+	v1.GET("/market-data/:symbol", tg.GetMarketData)
+	v1.GET("/circuit-breaker/status", tg.GetCircuitBreakerStatus)
+	v1.GET("/health", tg.Health)
+	admin.PATCH("/admin/circuit-breakers/:name/config", tg.UpdateCircuitBreakerConfig)
+}
+
+// actsFor reports whether the caller may act for userID and answers 403 if
+// not. Callers act for their own user; with allowAdmin, admins act for any.
+// Without a principal authentication is disabled and anyone may.
+func actsFor(c *gin.Context, userID string, allowAdmin bool) bool {
+	principal, ok := auth.PrincipalFromContext(c.Request.Context())
+	if !ok || principal.Subject == userID || (allowAdmin && principal.HasRole(auth.RoleAdmin)) {
+		return true
+	}
+
+	auth.Forbidden(c, fmt.Sprintf("%s may not act for user %s", principal.Subject, userID))
+	return false
+}
+
+// StartHealthChecks polls the health route of every upstream endpoint until
+// the context is cancelled
+func (tg *TradingGateway) StartHealthChecks(ctx context.Context) {
+	for _, checker := range tg.healthCheckers {
+		go checker.Start(ctx)
+	}
+}
+
+// endpointBreakerName names the breaker of one endpoint of a service,
+// e.g. "market-data-service@localhost:8086"
+func endpointBreakerName(service, endpointURL string) string {
+	if parsed, err := url.Parse(endpointURL); err == nil {
+		return service + "@" + parsed.Host
+	}
+	return service + "@" + endpointURL
+}
+
 // ExecuteTrade handles trade execution requests
 func (tg *TradingGateway) ExecuteTrade(c *gin.Context) {
 	var request models.TradeRequest
@@ -123,8 +219,14 @@ func (tg *TradingGateway) ExecuteTrade(c *gin.Context) {
 		return
 	}
 
+	if !actsFor(c, request.UserID, false) {
+		return
+	}
+
 	request.Timestamp = time.Now()
-	ctx := context.Background()
+
+	// Upstream calls carry the request ID and share the trade's deadline
+	ctx := c.Request.Context()
 
 	tg.logger.Info("Processing trade request",
 		zap.String("userId", request.UserID),
@@ -138,6 +240,10 @@ func (tg *TradingGateway) ExecuteTrade(c *gin.Context) {
 	var marketData models.MarketData
 	err := tg.marketDataClient.GetJSON(ctx, fmt.Sprintf("/api/v1/prices/%s", request.Symbol), &marketData)
 	if err != nil {
+		// An upstream that gave up on the budget still leaves time for the fallback
+		if tg.tradeOutOfTime(ctx, c, "market data", nil) {
+			return
+		}
 		tg.logger.Error("Failed to get market data", zap.Error(err))
 
 		// Fallback: use the price from the request
@@ -163,6 +269,10 @@ func (tg *TradingGateway) ExecuteTrade(c *gin.Context) {
 	var riskResponse models.RiskCheckResponse
 	err = tg.riskManagementClient.PostJSON(ctx, "/api/v1/risk/check", riskRequest, &riskResponse)
 	if err != nil {
+		// An upstream that gave up on the budget still leaves time for the fallback
+		if tg.tradeOutOfTime(ctx, c, "risk check", nil) {
+			return
+		}
 		tg.logger.Error("Risk management check failed", zap.Error(err))
 
 		// Fallback: apply basic risk rules
@@ -187,7 +297,10 @@ func (tg *TradingGateway) ExecuteTrade(c *gin.Context) {
 		return
 	}
 
-	// Step 3: Update portfolio
+	// Step 3: Update portfolio, unless there is no time left to do so
+	if tg.tradeOutOfTime(ctx, c, "portfolio update", nil) {
+		return
+	}
 	portfolioUpdateRequest := map[string]interface{}{
 		"symbol":   request.Symbol,
 		"quantity": request.Quantity,
@@ -198,6 +311,9 @@ func (tg *TradingGateway) ExecuteTrade(c *gin.Context) {
 	var portfolioResponse map[string]interface{}
 	err = tg.portfolioClient.PostJSON(ctx, fmt.Sprintf("/api/v1/portfolio/%s/positions", request.UserID), portfolioUpdateRequest, &portfolioResponse)
 	if err != nil {
+		if tg.tradeOutOfTime(ctx, c, "portfolio update", err) {
+			return
+		}
 		tg.logger.Error("Failed to update portfolio", zap.Error(err))
 
 		c.JSON(http.StatusInternalServerError, models.TradeResponse{
@@ -228,6 +344,11 @@ func (tg *TradingGateway) ExecuteTrade(c *gin.Context) {
 		TotalValue: marketData.Price * float64(request.Quantity),
 	}
 
+	// Notification and audit outlive the request and its deadline, and the
+	// gin context, which is reused once the handler returns
+	asyncCtx := context.WithoutCancel(ctx)
+	clientIP := c.ClientIP()
+
 	// Step 5: Send notification (async, non-blocking)
 	go func() {
 		notificationRequest := models.NotificationRequest{
@@ -245,7 +366,7 @@ func (tg *TradingGateway) ExecuteTrade(c *gin.Context) {
 		}
 
 		var notificationResponse models.NotificationResponse
-		if err := tg.notificationClient.PostJSON(context.Background(), "/api/v1/notifications", notificationRequest, &notificationResponse); err != nil {
+		if err := tg.notificationClient.PostJSON(asyncCtx, "/api/v1/notifications", notificationRequest, &notificationResponse); err != nil {
 			tg.logger.Error("Failed to send notification", zap.Error(err))
 		}
 	}()
@@ -267,10 +388,10 @@ func (tg *TradingGateway) ExecuteTrade(c *gin.Context) {
 				"riskScore":  riskResponse.RiskScore,
 			},
 			Timestamp: time.Now(),
-			IPAddress: c.ClientIP(),
+			IPAddress: clientIP,
 		}
 
-		if err := tg.auditClient.PostJSON(context.Background(), "/api/v1/audit", auditEvent, nil); err != nil {
+		if err := tg.auditClient.PostJSON(asyncCtx, "/api/v1/audit", auditEvent, nil); err != nil {
 			tg.logger.Error("Failed to log audit event", zap.Error(err))
 		}
 	}()
@@ -285,6 +406,32 @@ func (tg *TradingGateway) ExecuteTrade(c *gin.Context) {
 	c.JSON(http.StatusOK, tradeResponse)
 }
 
+// outOfTime reports whether a request failed because its deadline passed,
+// either here or at the upstream it was propagated to
+func outOfTime(ctx context.Context, err error) bool {
+	var httpErr *httpclient.HTTPError
+	return errors.Is(ctx.Err(), context.DeadlineExceeded) ||
+		(errors.As(err, &httpErr) && httpErr.DeadlineExceeded())
+}
+
+// tradeOutOfTime reports whether the trade ran out of time before or during
+// step, given the error of the step's upstream call if any. A trade past its
+// deadline is answered 504; a trade the client gave up on gets no response.
+// Either way no fallback is attempted.
+func (tg *TradingGateway) tradeOutOfTime(ctx context.Context, c *gin.Context, step string, err error) bool {
+	switch {
+	case outOfTime(ctx, err):
+		tg.logger.Warn("Trade deadline exceeded", zap.String("step", step))
+		bootstrap.DeadlineExceeded(c, fmt.Sprintf("Trade could not complete the %s in time", step))
+		return true
+	case ctx.Err() != nil:
+		tg.logger.Info("Trade canceled by client", zap.String("step", step))
+		c.Abort()
+		return true
+	}
+	return false
+}
+
 // fallbackRiskCheck provides basic risk checking when the risk service is unavailable
 func (tg *TradingGateway) fallbackRiskCheck(request models.RiskCheckRequest) models.RiskCheckResponse {
 	// Basic risk rules
@@ -308,11 +455,18 @@ func (tg *TradingGateway) fallbackRiskCheck(request models.RiskCheckRequest) mod
 // GetPortfolio returns a user's portfolio
 func (tg *TradingGateway) GetPortfolio(c *gin.Context) {
 	userID := c.Param("userId")
-	ctx := context.Background()
+	ctx := c.Request.Context()
+	if !actsFor(c, userID, true) {
+		return
+	}
 
 	var portfolio models.Portfolio
 	err := tg.portfolioClient.GetJSON(ctx, fmt.Sprintf("/api/v1/portfolio/%s", userID), &portfolio)
 	if err != nil {
+		if outOfTime(ctx, err) {
+			bootstrap.DeadlineExceeded(c, "Portfolio could not be retrieved in time")
+			return
+		}
 		tg.logger.Error("Failed to get portfolio", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
 			Error:     "Failed to retrieve portfolio",
@@ -329,11 +483,26 @@ func (tg *TradingGateway) GetPortfolio(c *gin.Context) {
 // GetMarketData returns market data for a symbol
 func (tg *TradingGateway) GetMarketData(c *gin.Context) {
 	symbol := c.Param("symbol")
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
 	var marketData models.MarketData
 	err := tg.marketDataClient.GetJSON(ctx, fmt.Sprintf("/api/v1/prices/%s", symbol), &marketData)
 	if err != nil {
+		var httpErr *httpclient.HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+			c.JSON(http.StatusNotFound, models.ErrorResponse{
+				Error:     "Symbol not found",
+				Message:   fmt.Sprintf("no market data for %q", symbol),
+				Code:      "SYMBOL_NOT_FOUND",
+				Timestamp: time.Now(),
+			})
+			return
+		}
+		if outOfTime(ctx, err) {
+			bootstrap.DeadlineExceeded(c, "Market data could not be retrieved in time")
+			return
+		}
+
 		tg.logger.Error("Failed to get market data", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
 			Error:     "Failed to retrieve market data",
@@ -347,6 +516,118 @@ func (tg *TradingGateway) GetMarketData(c *gin.Context) {
 	c.JSON(http.StatusOK, marketData)
 }
 
+// symbolError is a per-symbol error reported inside a batch response
+type symbolError string
+
+func (e symbolError) Error() string {
+	return string(e)
+}
+
+// loadQuotes fetches market data for several symbols with one batch
+// request. The response is decoded as it streams in, and a body that stalls
+// or is cut off counts against the market data breaker.
+func (tg *TradingGateway) loadQuotes(ctx context.Context, symbols []string) (map[string]interface{}, map[string]error, error) {
+	request := models.BatchMarketDataRequest{Symbols: symbols}
+	resp, err := tg.marketDataClient.Stream(ctx, http.MethodPost, "/api/v1/prices/batch", request, map[string]string{
+		"Content-Type": "application/json",
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	var response models.BatchMarketDataResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode batch response: %w", err)
+	}
+
+	results := make(map[string]interface{}, len(response.Data))
+	for symbol, marketData := range response.Data {
+		results[symbol] = marketData
+	}
+
+	symbolErrors := make(map[string]error, len(response.Errors))
+	for symbol, message := range response.Errors {
+		symbolErrors[symbol] = symbolError(message)
+	}
+
+	return results, symbolErrors, nil
+}
+
+// GetQuotes returns market data for a comma-separated list of symbols. The
+// lookups of concurrent callers are merged into batch requests upstream.
+func (tg *TradingGateway) GetQuotes(c *gin.Context) {
+	var symbols []string
+	seen := make(map[string]bool)
+	for _, symbol := range strings.Split(c.Query("symbols"), ",") {
+		symbol = strings.TrimSpace(symbol)
+		if symbol != "" && !seen[symbol] {
+			seen[symbol] = true
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	if len(symbols) == 0 || len(symbols) > models.MaxBatchSymbols {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid request format",
+			Message:   fmt.Sprintf("symbols must list between 1 and %d comma-separated symbols", models.MaxBatchSymbols),
+			Code:      "INVALID_REQUEST",
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	type quote struct {
+		symbol string
+		value  interface{}
+		err    error
+	}
+	quotes := make(chan quote, len(symbols))
+	for _, symbol := range symbols {
+		go func(symbol string) {
+			value, err := tg.quoteBatcher.Load(c.Request.Context(), symbol)
+			quotes <- quote{symbol: symbol, value: value, err: err}
+		}(symbol)
+	}
+
+	response := models.BatchMarketDataResponse{
+		Data:      make(map[string]models.MarketData, len(symbols)),
+		Errors:    make(map[string]string),
+		Timestamp: time.Now(),
+	}
+	var upstreamErr error
+	for range symbols {
+		q := <-quotes
+		var symbolErr symbolError
+		switch {
+		case q.err == nil:
+			response.Data[q.symbol] = q.value.(models.MarketData)
+		case errors.As(q.err, &symbolErr):
+			response.Errors[q.symbol] = symbolErr.Error()
+		default:
+			upstreamErr = q.err
+			response.Errors[q.symbol] = "Market data unavailable"
+		}
+	}
+
+	if len(response.Data) == 0 && outOfTime(c.Request.Context(), upstreamErr) {
+		bootstrap.DeadlineExceeded(c, "Market data could not be retrieved in time")
+		return
+	}
+	if len(response.Data) == 0 && upstreamErr != nil {
+		tg.logger.Error("Failed to get quotes", zap.Strings("symbols", symbols), zap.Error(upstreamErr))
+		c.JSON(http.StatusServiceUnavailable, models.ErrorResponse{
+			Error:     "Failed to retrieve market data",
+			Message:   upstreamErr.Error(),
+			Code:      "MARKET_DATA_SERVICE_ERROR",
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
 // GetCircuitBreakerStatus returns the status of all circuit breakers
 func (tg *TradingGateway) GetCircuitBreakerStatus(c *gin.Context) {
 	status := map[string]interface{}{
@@ -361,10 +642,185 @@ func (tg *TradingGateway) GetCircuitBreakerStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, status)
 }
 
-// Health returns the health status of the gateway
+// UpdateCircuitBreakerConfig applies a partial configuration update to a running circuit breaker
+func (tg *TradingGateway) UpdateCircuitBreakerConfig(c *gin.Context) {
+	name := c.Param("name")
+
+	cb, exists := tg.circuitBreakers[name]
+	if !exists {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:     "Circuit breaker not found",
+			Message:   fmt.Sprintf("no circuit breaker named %q", name),
+			Code:      "CIRCUIT_BREAKER_NOT_FOUND",
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	var patch models.CircuitBreakerConfigPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid request format",
+			Message:   err.Error(),
+			Code:      "INVALID_REQUEST",
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	// Patches stay in force across reloads and overrides file changes
+	_, err := tg.breakerLayers.Add(adminLayer, name, func(config *circuitbreaker.Config) error {
+		patched, err := applyConfigPatch(*config, patch)
+		*config = patched
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid circuit breaker configuration",
+			Message:   err.Error(),
+			Code:      "INVALID_CONFIGURATION",
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	tg.logger.Info("Circuit breaker configuration patched via admin API",
+		zap.String("name", name),
+		zap.String("clientIp", c.ClientIP()),
+	)
+
+	c.JSON(http.StatusOK, cb.GetStats())
+}
+
+// applyConfigPatch returns config with the fields set in patch overwritten
+func applyConfigPatch(config circuitbreaker.Config, patch models.CircuitBreakerConfigPatch) (circuitbreaker.Config, error) {
+	if patch.MaxRequests != nil {
+		config.MaxRequests = *patch.MaxRequests
+	}
+	if patch.Interval != nil {
+		interval, err := time.ParseDuration(*patch.Interval)
+		if err != nil {
+			return config, fmt.Errorf("interval: %w", err)
+		}
+		config.Interval = interval
+	}
+	if patch.Timeout != nil {
+		timeout, err := time.ParseDuration(*patch.Timeout)
+		if err != nil {
+			return config, fmt.Errorf("timeout: %w", err)
+		}
+		config.Timeout = timeout
+	}
+	if patch.FailureThreshold != nil {
+		config.FailureThreshold = *patch.FailureThreshold
+	}
+	if patch.SuccessThreshold != nil {
+		config.SuccessThreshold = *patch.SuccessThreshold
+	}
+	if patch.FailureRateThreshold != nil {
+		config.FailureRateThreshold = *patch.FailureRateThreshold
+	}
+	if patch.MinimumRequests != nil {
+		config.MinimumRequests = *patch.MinimumRequests
+	}
+
+	return config, nil
+}
+
+// ApplyConfigChange updates breakers and clients in place after a
+// configuration reload. A new circuit_breaker block replaces the base of the
+// service's breakers; overrides from the file and the admin API are applied
+// on top again. Services without a client are only created at startup.
+func (tg *TradingGateway) ApplyConfigChange(change config.Change) {
+	for _, service := range change.Services {
+		name := service.New.Name
+
+		client, exists := tg.clients[name]
+		if !exists {
+			tg.logger.Warn("Configuration changed for an unknown service; restart the gateway to apply it",
+				zap.String("name", name),
+			)
+			continue
+		}
+		if service.New.CircuitBreaker != service.Old.CircuitBreaker {
+			for _, endpoint := range client.Endpoints() {
+				breakerName := endpoint.Breaker().GetConfig().Name
+				if err := tg.breakerLayers.SetBase(breakerName, service.New.CircuitBreaker); err != nil {
+					tg.logger.Error("Failed to update circuit breaker", zap.String("name", breakerName), zap.Error(err))
+				}
+			}
+		}
+
+		if service.New.Timeout != service.Old.Timeout {
+			client.SetTimeout(service.New.Timeout)
+		}
+		if service.New.Retry != service.Old.Retry {
+			client.SetRetryPolicy(service.New.Retry)
+		}
+		if service.New.Hedge != service.Old.Hedge {
+			client.SetHedgePolicy(service.New.Hedge)
+		}
+		if service.New.Cache != service.Old.Cache {
+			client.SetCachePolicy(service.New.Cache)
+		}
+		if service.New.Batch != service.Old.Batch && client == tg.marketDataClient {
+			tg.quoteBatcher.SetPolicy(service.New.Batch)
+		}
+		if service.New.OutlierDetection != service.Old.OutlierDetection {
+			client.SetOutlierDetection(service.New.OutlierDetection)
+		}
+		if service.New.UserAgent != service.Old.UserAgent || service.New.AuthToken != service.Old.AuthToken {
+			tg.logger.Warn("Request headers changed; restart the gateway to apply them",
+				zap.String("name", name),
+			)
+		}
+		if service.New.HealthCheck != service.Old.HealthCheck {
+			tg.logger.Warn("Health check settings changed; restart the gateway to apply them",
+				zap.String("name", name),
+			)
+		}
+		if !reflect.DeepEqual(service.New.Endpoints, service.Old.Endpoints) || service.New.Balancer != service.Old.Balancer {
+			tg.logger.Warn("Service endpoints changed; restart the gateway to apply them",
+				zap.String("name", name),
+				zap.Any("endpoints", service.New.Endpoints),
+				zap.String("balancer", service.New.Balancer),
+			)
+		}
+	}
+}
+
+// Health returns the health status of the gateway. The gateway reports
+// "degraded" while any upstream has no healthy endpoint; it keeps serving
+// with fallbacks, so the status code stays 200.
 func (tg *TradingGateway) Health(c *gin.Context) {
+	status := "healthy"
+	upstreams := make(map[string][]models.EndpointHealth, len(tg.clients))
+	for name, client := range tg.clients {
+		healthy := 0
+		for _, endpoint := range client.EndpointStatuses() {
+			health := models.EndpointHealth{
+				URL:          endpoint.URL,
+				Healthy:      endpoint.Healthy,
+				Ejected:      endpoint.Ejected,
+				CircuitState: endpoint.BreakerState,
+				Outstanding:  endpoint.Outstanding,
+			}
+			if endpoint.Ejected {
+				ejectedUntil := endpoint.EjectedUntil
+				health.EjectedUntil = &ejectedUntil
+			}
+			if endpoint.Healthy && !endpoint.Ejected {
+				healthy++
+			}
+			upstreams[name] = append(upstreams[name], health)
+		}
+		if healthy == 0 {
+			status = "degraded"
+		}
+	}
+
 	response := models.HealthResponse{
-		Status:    "healthy",
+		Status:    status,
 		Service:   "trading-gateway",
 		Version:   "1.0.0",
 		Timestamp: time.Now(),
@@ -375,65 +831,191 @@ func (tg *TradingGateway) Health(c *gin.Context) {
 			"notification_circuit_breaker":    tg.notificationClient.GetCircuitBreakerState().String(),
 			"audit_circuit_breaker":           tg.auditClient.GetCircuitBreakerState().String(),
 		},
+		Upstreams: upstreams,
+	}
+
+	if tg.configWatcher != nil {
+		response.ConfigVersion, response.ConfigHash = tg.configWatcher.Version()
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
+// saveOnShutdown writes the recorded upstream traffic to path when the
+// gateway is interrupted, then exits
+func saveOnShutdown(recorder *httpclient.Recorder, path string, logger *zap.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	if err := recorder.Save(path); err != nil {
+		logger.Error("Failed to save fixture", zap.String("fixture", path), zap.Error(err))
+		os.Exit(1)
+	}
+	logger.Info("Saved recorded upstream traffic",
+		zap.String("fixture", path),
+		zap.Int("interactions", len(recorder.Fixture().Interactions)),
+	)
+	os.Exit(0)
+}
+
 func main() {
+	loader := config.NewLoader(config.DefaultConfig())
+	loader.RegisterFlags(flag.CommandLine)
+	breakerConfigPath := flag.String("breaker-config", "", "YAML file with circuit breaker overrides, watched for changes")
+	recordPath := flag.String("record", "", "Record upstream traffic and write it to this fixture file on shutdown")
+	replayPath := flag.String("replay", "", "Answer upstream requests from this fixture file instead of the network")
+	issueAPIKey := flag.String("issue-api-key", "", "Print the API key for this key ID, signed with auth.api_key_secret, and exit")
+	flag.Parse()
+
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatal("Failed to load configuration: ", err)
+	}
+	if loader.PrintRequested() {
+		loader.Print(os.Stdout)
+		return
+	}
+	if *issueAPIKey != "" {
+		// List the ID under auth.api_keys with its subject and roles to activate the key
+		fmt.Println(auth.IssueAPIKey([]byte(cfg.Auth.APIKeySecret), *issueAPIKey))
+		return
+	}
+
 	// Initialize logger
-	logger, _ := zap.NewProduction()
+	logger, logLevel, err := bootstrap.NewLogger(cfg.Logging)
+	if err != nil {
+		log.Fatal("Failed to initialize logger: ", err)
+	}
 	defer logger.Sync()
 
 	// Create trading gateway
-	gateway := NewTradingGateway(logger)
+	gateway := NewTradingGateway(cfg, logger)
+
+	// Reload configuration on file change or SIGHUP
+	gateway.configWatcher = config.NewWatcher(loader, cfg, 5*time.Second, logger)
+	gateway.configWatcher.Subscribe(gateway.ApplyConfigChange)
+	bootstrap.FollowLogLevel(gateway.configWatcher, logLevel, logger)
+	go gateway.configWatcher.Start(context.Background())
+
+	// Record or replay upstream traffic instead of only talking to the network
+	switch {
+	case *replayPath != "":
+		fixture, err := httpclient.LoadFixture(*replayPath)
+		if err != nil {
+			logger.Fatal("Failed to load fixture", zap.Error(err))
+		}
+		replayer, err := httpclient.NewReplayer(fixture)
+		if err != nil {
+			logger.Fatal("Failed to load fixture", zap.Error(err))
+		}
+		gateway.UseUpstreamTransport(replayer)
+		logger.Info("Replaying upstream traffic",
+			zap.String("fixture", *replayPath),
+			zap.Int("interactions", len(fixture.Interactions)),
+		)
+	case *recordPath != "":
+		recorder := httpclient.NewRecorder(http.DefaultTransport)
+		gateway.UseUpstreamTransport(recorder)
+		go saveOnShutdown(recorder, *recordPath, logger)
+		logger.Info("Recording upstream traffic", zap.String("fixture", *recordPath))
+	}
+
+	// Poll the health route of every upstream endpoint. Replayed upstreams
+	// are not on the network, so they are not checked.
+	if *replayPath == "" {
+		gateway.StartHealthChecks(context.Background())
+	}
+
+	// Apply circuit breaker overrides from file and keep watching it
+	if *breakerConfigPath != "" {
+		breakers := make([]*circuitbreaker.CircuitBreaker, 0, len(gateway.circuitBreakers))
+		for _, cb := range gateway.circuitBreakers {
+			breakers = append(breakers, cb)
+		}
+		watcher := circuitbreaker.NewFileWatcher(*breakerConfigPath, 5*time.Second, logger, breakers...)
+		watcher.UseLayers(gateway.breakerLayers, fileLayer)
+		go watcher.Start(context.Background())
+	}
 
 	// Configure Gin
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 
-	// Add CORS middleware
-	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	// Allow cross-origin calls; OPTIONS preflights are answered before any shedding
+	router.Use(bootstrap.CORS(http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete))
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
-			return
-		}
+	// Correlate requests across services with X-Request-ID
+	router.Use(bootstrap.RequestID())
 
-		c.Next()
-	})
+	// Bound each request by the caller's X-Request-Deadline and the configured budget
+	router.Use(bootstrap.Deadline(cfg.Server.RequestBudget))
+
+	// Identify callers by API key or bearer token; routes decide who may call them
+	authenticator, err := auth.NewAuthenticator(cfg.Auth.Policy(), logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize authentication", zap.Error(err))
+	}
+	bootstrap.FollowAuth(gateway.configWatcher, authenticator, logger)
+	gateway.UseAuthenticator(authenticator)
+	router.Use(authenticator.Authenticate())
+
+	// Replay trades retried with an Idempotency-Key instead of executing them again
+	guard := idempotency.NewGuard(idempotency.NewMemoryStore(), cfg.Idempotency.Policy(), logger)
+	bootstrap.FollowIdempotency(gateway.configWatcher, guard, logger)
+	gateway.UseIdempotency(guard)
 
-	// Metrics endpoint
-	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	// Limit each client per route before its requests take up capacity
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), cfg.RateLimiting.Policy(), logger)
+	bootstrap.FollowRateLimiting(gateway.configWatcher, limiter, logger)
+	router.Use(limiter.Middleware())
+
+	// Shed excess load by route priority before it reaches the handlers
+	shedder := loadshed.NewShedder(cfg.LoadShedding.Policy(), logger)
+	bootstrap.FollowLoadShedding(gateway.configWatcher, shedder, logger)
+	go shedder.Start(context.Background())
+	router.Use(shedder.Middleware())
+
+	// Metrics endpoint, on its own listener when metrics.port is set
+	metricsPort := bootstrap.ServeMetrics(router, cfg, logger)
 
 	// API routes
 	v1 := router.Group("/api/v1")
 	{
-		v1.POST("/trades", gateway.ExecuteTrade)
-		v1.GET("/portfolio/:userId", gateway.GetPortfolio)
-		v1.GET("/market-data/:symbol", gateway.GetMarketData)
-		v1.GET("/circuit-breaker/status", gateway.GetCircuitBreakerStatus)
-		v1.GET("/health", gateway.Health)
+		gateway.RegisterRoutes(v1)
+		bootstrap.RegisterLogLevel(v1.Group("", authenticator.RequireRole(auth.RoleAdmin)), logLevel)
 	}
 
 	// Start server
-	port := 8080
+	port := cfg.Server.Port
 	fmt.Printf("🚀 Trading Gateway starting on port %d\n", port)
 	fmt.Printf("📊 Available endpoints:\n")
 	fmt.Printf("   POST /api/v1/trades                    - Execute trade\n")
 	fmt.Printf("   GET  /api/v1/portfolio/{userId}        - Get portfolio\n")
 	fmt.Printf("   GET  /api/v1/market-data/{symbol}      - Get market data\n")
+	fmt.Printf("   GET  /api/v1/market-data?symbols=A,B   - Get quotes for several symbols\n")
 	fmt.Printf("   GET  /api/v1/circuit-breaker/status    - Circuit breaker status\n")
 	fmt.Printf("   GET  /api/v1/health                     - Health check\n")
-	fmt.Printf("   GET  /metrics                           - Prometheus metrics\n")
+	fmt.Printf("   PATCH /api/v1/admin/circuit-breakers/{name}/config - Update circuit breaker config\n")
+	fmt.Printf("   GET|PUT /api/v1/admin/log-level         - Get or change log level\n")
+	if metricsPort != 0 {
+		fmt.Printf("   GET  :%d%s - Prometheus metrics\n", metricsPort, cfg.Metrics.Path)
+	}
 	fmt.Printf("\n💡 Example trade: curl -X POST http://localhost:%d/api/v1/trades \\\n", port)
-	fmt.Printf("   -H 'Content-Type: application/json' \\\n")
+	if cfg.Auth.Enabled {
+		fmt.Printf("   -H \"X-API-Key: $(trading-gateway -config <file> -issue-api-key user123-key)\" \\\n")
+	}
+	fmt.Printf("   -H 'Content-Type: application/json' -H 'Idempotency-Key: <unique id>' \\\n")
 	fmt.Printf("   -d '{\"userId\":\"user123\",\"symbol\":\"AAPL\",\"quantity\":10,\"orderType\":\"BUY\",\"price\":150.00}'\n")
 
-	if err := router.Run(fmt.Sprintf(":%d", port)); err != nil {
-		log.Fatal("Failed to start server:", err)
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      router,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}
+
+	if err := server.ListenAndServe(); err != nil {
+		logger.Fatal("Failed to start server", zap.Error(err))
 	}
 }
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"circuit-breaker-demo/pkg/config"
+	"circuit-breaker-demo/pkg/httpclient"
+	"circuit-breaker-demo/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+const tradeBody = `{"userId":"user123","symbol":"AAPL","quantity":10,"orderType":"BUY","price":150.00}`
+
+// Upstream paths of a trade
+const (
+	pricePath         = "/api/v1/prices/AAPL"
+	quotesPath        = "/api/v1/prices/batch"
+	riskPath          = "/api/v1/risk/check"
+	positionsPath     = "/api/v1/portfolio/user123/positions"
+	notificationsPath = "/api/v1/notifications"
+	auditPath         = "/api/v1/audit"
+)
+
+// newTestGateway returns a gateway with the default configuration whose
+// upstream calls all go to base, and a router serving its API
+func newTestGateway(t *testing.T, base http.RoundTripper) (*TradingGateway, *gin.Engine) {
+	t.Helper()
+	return newConfiguredTestGateway(t, config.DefaultConfig(), base)
+}
+
+// newConfiguredTestGateway is newTestGateway with the given configuration
+func newConfiguredTestGateway(t *testing.T, cfg *config.Config, base http.RoundTripper) (*TradingGateway, *gin.Engine) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	gateway := NewTradingGateway(cfg, zap.NewNop())
+	gateway.UseUpstreamTransport(base)
+
+	router := gin.New()
+	gateway.RegisterRoutes(router.Group("/api/v1"))
+	return gateway, router
+}
+
+// postTrade sends tradeBody and decodes the trade response
+func postTrade(t *testing.T, router *gin.Engine) (int, models.TradeResponse) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/trades", strings.NewReader(tradeBody))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	var response models.TradeResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response %q: %v", recorder.Body.String(), err)
+	}
+	return recorder.Code, response
+}
+
+func TestExecuteTrade(t *testing.T) {
+	price := httpclient.Respond(http.StatusOK, models.MarketData{Symbol: "AAPL", Price: 151.5})
+	approved := httpclient.Respond(http.StatusOK, models.RiskCheckResponse{Approved: true, RiskScore: 0.2})
+	updated := httpclient.Respond(http.StatusOK, map[string]string{"message": "Position updated successfully"})
+
+	tests := []struct {
+		name      string
+		price     httpclient.Step
+		risk      httpclient.Step
+		positions httpclient.Step
+		status    int
+		want      models.OrderStatus
+		wantPrice float64 // Price of the response
+		updates   int     // Position updates sent to the portfolio service
+	}{
+		{
+			name:      "executes at the market price",
+			price:     price,
+			risk:      approved,
+			positions: updated,
+			status:    http.StatusOK,
+			want:      models.OrderStatusExecuted,
+			wantPrice: 151.5,
+			updates:   1,
+		},
+		{
+			name:      "falls back to the requested price without market data",
+			price:     httpclient.Refuse(),
+			risk:      approved,
+			positions: updated,
+			status:    http.StatusOK,
+			want:      models.OrderStatusExecuted,
+			wantPrice: 150,
+			updates:   1,
+		},
+		{
+			name:      "falls back to the requested price for an unknown symbol",
+			price:     httpclient.Respond(http.StatusNotFound, map[string]string{"error": "Symbol not found"}),
+			risk:      approved,
+			positions: updated,
+			status:    http.StatusOK,
+			want:      models.OrderStatusExecuted,
+			wantPrice: 150,
+			updates:   1,
+		},
+		{
+			name:      "rejected by risk management",
+			price:     price,
+			risk:      httpclient.Respond(http.StatusOK, models.RiskCheckResponse{Approved: false, Reason: "Position limit", RiskScore: 0.95}),
+			positions: updated,
+			status:    http.StatusBadRequest,
+			want:      models.OrderStatusRejected,
+			wantPrice: 150,
+			updates:   0,
+		},
+		{
+			name:      "portfolio failure",
+			price:     price,
+			risk:      approved,
+			positions: httpclient.Respond(http.StatusInternalServerError, map[string]string{"error": "Portfolio service temporarily unavailable"}),
+			status:    http.StatusInternalServerError,
+			want:      models.OrderStatusRejected,
+			wantPrice: 150,
+			updates:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := httpclient.NewFakeTransport().
+				On(http.MethodGet, pricePath, tt.price).
+				On(http.MethodPost, riskPath, tt.risk).
+				On(http.MethodPost, positionsPath, tt.positions).
+				On(http.MethodPost, notificationsPath, httpclient.Respond(http.StatusOK, models.NotificationResponse{})).
+				On(http.MethodPost, auditPath, httpclient.Respond(http.StatusOK, nil))
+			_, router := newTestGateway(t, fake)
+
+			status, response := postTrade(t, router)
+			if status != tt.status {
+				t.Fatalf("Status = %d, want %d", status, tt.status)
+			}
+			if response.Status != tt.want {
+				t.Errorf("Trade status = %s, want %s", response.Status, tt.want)
+			}
+			if response.Price != tt.wantPrice {
+				t.Errorf("Price = %v, want %v", response.Price, tt.wantPrice)
+			}
+			if calls := fake.Calls(http.MethodPost, positionsPath); calls != tt.updates {
+				t.Errorf("Position updates = %d, want %d", calls, tt.updates)
+			}
+		})
+	}
+}
+
+func TestExecuteTradeReplaysFixture(t *testing.T) {
+	fixture, err := httpclient.LoadFixture("testdata/trade.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayer, err := httpclient.NewReplayer(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, router := newTestGateway(t, replayer)
+
+	status, response := postTrade(t, router)
+	if status != http.StatusOK || response.Status != models.OrderStatusExecuted {
+		t.Fatalf("Trade = %d %s, want 200 %s", status, response.Status, models.OrderStatusExecuted)
+	}
+	if response.Price != 152.25 || response.TotalValue != 1522.5 {
+		t.Errorf("Trade executed at %v for %v, want the recorded 152.25 for 1522.5", response.Price, response.TotalValue)
+	}
+}
+
+// tradeUpstreams returns a fake answering every upstream call of a trade,
+// with the given steps for the price lookup
+func tradeUpstreams(price ...httpclient.Step) *httpclient.FakeTransport {
+	return httpclient.NewFakeTransport().
+		On(http.MethodGet, pricePath, price...).
+		On(http.MethodPost, riskPath, httpclient.Respond(http.StatusOK, models.RiskCheckResponse{Approved: true, RiskScore: 0.2})).
+		On(http.MethodPost, positionsPath, httpclient.Respond(http.StatusOK, map[string]string{"message": "Position updated successfully"})).
+		On(http.MethodPost, notificationsPath, httpclient.Respond(http.StatusOK, models.NotificationResponse{})).
+		On(http.MethodPost, auditPath, httpclient.Respond(http.StatusOK, nil))
+}
+
+// metricValue returns the value of a series as the metrics endpoint shows
+// it, e.g. `http_client_cache_requests_total{result="miss"}`, or 0
+func metricValue(t *testing.T, series string) float64 {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if value, found := strings.CutPrefix(line, series+" "); found {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("Metric %s = %q: %v", series, value, err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestExecuteTradeFinishesOnHedge(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Services.MarketData.Endpoints = []config.EndpointConfig{
+		{URL: "http://md-1:8082", Weight: 1},
+		{URL: "http://md-2:8082", Weight: 1},
+	}
+	hedge := cfg.Services.MarketData.Hedge
+
+	// Nine fast lookups save up the budget for one hedge (0.1 per request);
+	// the tenth stalls on its endpoint and the hedge answers
+	fast := httpclient.Respond(http.StatusOK, models.MarketData{Symbol: "AAPL", Price: 151.5})
+	steps := make([]httpclient.Step, 0, 11)
+	for i := 0; i < 9; i++ {
+		steps = append(steps, fast)
+	}
+	steps = append(steps,
+		httpclient.Respond(http.StatusOK, models.MarketData{Symbol: "AAPL", Price: 140}).After(time.Minute),
+		httpclient.Respond(http.StatusOK, models.MarketData{Symbol: "AAPL", Price: 152.25}))
+	fake := tradeUpstreams(steps...)
+	_, router := newConfiguredTestGateway(t, cfg, fake)
+
+	for i := 0; i < 9; i++ {
+		if status, _ := postTrade(t, router); status != http.StatusOK {
+			t.Fatalf("Trade %d status = %d, want 200", i+1, status)
+		}
+	}
+
+	// Either endpoint may be the one that stalls
+	won := func() float64 {
+		return metricValue(t, `http_client_hedges_total{endpoint="http://md-1:8082",result="won"}`) +
+			metricValue(t, `http_client_hedges_total{endpoint="http://md-2:8082",result="won"}`)
+	}
+	before := won()
+	start := time.Now()
+	status, response := postTrade(t, router)
+	elapsed := time.Since(start)
+
+	if status != http.StatusOK || response.Price != 152.25 {
+		t.Fatalf("Trade = %d at %v, want 200 at the hedge's 152.25", status, response.Price)
+	}
+	if elapsed < hedge.Delay || elapsed > 10*hedge.Delay {
+		t.Errorf("Trade took %v, want about the hedge delay %v", elapsed, hedge.Delay)
+	}
+	if calls := fake.Calls(http.MethodGet, pricePath); calls != 11 {
+		t.Errorf("Price lookups = %d, want 11", calls)
+	}
+	if got := won() - before; got != 1 {
+		t.Errorf("Hedges won = %v, want 1", got)
+	}
+}
+
+func TestConcurrentTradesShareOnePriceLookup(t *testing.T) {
+	const trades = 5
+	fake := tradeUpstreams(httpclient.Respond(http.StatusOK, models.MarketData{Symbol: "AAPL", Price: 151.5}).After(100 * time.Millisecond))
+	_, router := newTestGateway(t, fake)
+
+	coalesced := `http_client_cache_requests_total{result="coalesced"}`
+	before := metricValue(t, coalesced)
+
+	var wg sync.WaitGroup
+	prices := make(chan float64, trades)
+	for i := 0; i < trades; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, response := postTrade(t, router)
+			if status != http.StatusOK {
+				t.Errorf("Status = %d, want 200", status)
+			}
+			prices <- response.Price
+		}()
+	}
+	wg.Wait()
+	close(prices)
+
+	for price := range prices {
+		if price != 151.5 {
+			t.Errorf("Price = %v, want the shared 151.5", price)
+		}
+	}
+	if calls := fake.Calls(http.MethodGet, pricePath); calls != 1 {
+		t.Errorf("Price lookups = %d, want 1", calls)
+	}
+	if got := metricValue(t, coalesced) - before; got != trades-1 {
+		t.Errorf("Coalesced lookups = %v, want %d", got, trades-1)
+	}
+}
+
+func TestGetMarketData(t *testing.T) {
+	tests := []struct {
+		name   string
+		price  httpclient.Step
+		status int
+	}{
+		{"known symbol", httpclient.Respond(http.StatusOK, models.MarketData{Symbol: "AAPL", Price: 151.5}), http.StatusOK},
+		{"unknown symbol", httpclient.Respond(http.StatusNotFound, map[string]string{"error": "Symbol not found"}), http.StatusNotFound},
+		{"market data down", httpclient.Respond(http.StatusInternalServerError, nil), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		fake := httpclient.NewFakeTransport().On(http.MethodGet, pricePath, tt.price)
+		_, router := newTestGateway(t, fake)
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/market-data/AAPL", nil))
+		if recorder.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, recorder.Code, tt.status)
+		}
+		if calls := fake.Calls(http.MethodPost, quotesPath); calls != 0 {
+			t.Errorf("%s: batch requests = %d, want the single lookup path", tt.name, calls)
+		}
+	}
+}
+
+func TestGetQuotes(t *testing.T) {
+	fake := httpclient.NewFakeTransport().On(http.MethodPost, quotesPath, httpclient.Respond(http.StatusOK, models.BatchMarketDataResponse{
+		Data:   map[string]models.MarketData{"AAPL": {Symbol: "AAPL", Price: 151.5}},
+		Errors: map[string]string{"NOPE": "Symbol not found"},
+	}))
+	_, router := newTestGateway(t, fake)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/market-data?symbols=AAPL,NOPE", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200", recorder.Code)
+	}
+
+	var response models.BatchMarketDataResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Data["AAPL"].Price != 151.5 || response.Errors["NOPE"] == "" {
+		t.Errorf("Quotes = %+v, want AAPL and an error for NOPE", response)
+	}
+	if calls := fake.Calls(http.MethodPost, quotesPath); calls != 1 {
+		t.Errorf("Batch requests = %d, want 1", calls)
+	}
+	if calls := fake.Calls(http.MethodGet, pricePath); calls != 0 {
+		t.Errorf("Single lookups = %d, want 0", calls)
+	}
+}
+
+// patchBreaker sends body to the admin API that patches a breaker's config
+func patchBreaker(router *gin.Engine, name, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/admin/circuit-breakers/"+name+"/config", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestUpdateCircuitBreakerConfig(t *testing.T) {
+	const breaker = "risk-management-service"
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"valid", `{"failureThreshold":4,"timeout":"20s"}`, http.StatusOK},
+		{"invalid value", `{"failureThreshold":4,"timeout":"0s"}`, http.StatusBadRequest},
+		{"invalid duration", `{"failureThreshold":4,"interval":"soon"}`, http.StatusBadRequest},
+		{"not JSON", `failureThreshold=4`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway, router := newTestGateway(t, httpclient.NewFakeTransport())
+			before := gateway.circuitBreakers[breaker].GetConfig()
+
+			recorder := patchBreaker(router, breaker, tt.body)
+			if recorder.Code != tt.status {
+				t.Fatalf("PATCH = %d %s, want %d", recorder.Code, recorder.Body.String(), tt.status)
+			}
+
+			after := gateway.circuitBreakers[breaker].GetConfig()
+			if tt.status != http.StatusOK && after != before {
+				t.Errorf("Config = %+v, want it unchanged %+v", after, before)
+			}
+			if tt.status == http.StatusOK && (after.FailureThreshold != 4 || after.Timeout != 20*time.Second) {
+				t.Errorf("Config = %+v, want the patched threshold and timeout", after)
+			}
+		})
+	}
+
+	_, router := newTestGateway(t, httpclient.NewFakeTransport())
+	if recorder := patchBreaker(router, "billing-service", `{"failureThreshold":4}`); recorder.Code != http.StatusNotFound {
+		t.Errorf("PATCH of an unknown breaker = %d, want 404", recorder.Code)
+	}
+}
+
+// serviceChange describes the change of one service between two configurations
+func serviceChange(key string, old, next *config.Config, oldService, nextService config.ServiceConfig) config.ServiceChange {
+	name := config.ServiceName(key)
+	return config.ServiceChange{Key: key, Old: old.Resolve(name, oldService), New: next.Resolve(name, nextService)}
+}
+
+func TestApplyConfigChangeKeepsAdminOverrides(t *testing.T) {
+	gateway, router := newTestGateway(t, httpclient.NewFakeTransport())
+	const breaker = "risk-management-service"
+
+	if recorder := patchBreaker(router, breaker, `{"failureThreshold":2}`); recorder.Code != http.StatusOK {
+		t.Fatalf("PATCH = %d %s, want 200", recorder.Code, recorder.Body.String())
+	}
+
+	old := config.DefaultConfig()
+	next := config.DefaultConfig()
+	next.Services.RiskManagement.CircuitBreaker.FailureThreshold = 8
+	next.Services.RiskManagement.CircuitBreaker.Timeout = 45 * time.Second
+	gateway.ApplyConfigChange(config.Change{
+		Old:      old,
+		New:      next,
+		Services: []config.ServiceChange{serviceChange("risk_management", old, next, old.Services.RiskManagement, next.Services.RiskManagement)},
+	})
+
+	got := gateway.circuitBreakers[breaker].GetConfig()
+	if got.Timeout != 45*time.Second {
+		t.Errorf("Timeout = %v, want 45s from the reload", got.Timeout)
+	}
+	if got.FailureThreshold != 2 {
+		t.Errorf("FailureThreshold = %d, want the admin override 2", got.FailureThreshold)
+	}
+}
+
+func TestApplyConfigChangeSkipsUnknownServices(t *testing.T) {
+	gateway, _ := newTestGateway(t, httpclient.NewFakeTransport())
+
+	old := config.DefaultConfig()
+	next := config.DefaultConfig()
+	next.Services.Audit.Timeout = 4 * time.Second
+	unknown := serviceChange("billing", old, next, old.Services.Audit, next.Services.Audit)
+
+	gateway.ApplyConfigChange(config.Change{
+		Old:      old,
+		New:      next,
+		Services: []config.ServiceChange{unknown, serviceChange("audit", old, next, old.Services.Audit, next.Services.Audit)},
+	})
+
+	if timeout := gateway.clients["audit-service"].GetTimeout(); timeout != 4*time.Second {
+		t.Errorf("Audit timeout = %v, want the services after the unknown one updated", timeout)
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+var testAPIKeySecret = []byte("auth-test-secret-0123456789abcdef0123")
+
+// testAPIKeys are the keys listed in the test policies; revoked-key was
+// issued once but is no longer listed
+var testAPIKeys = map[string]APIKey{
+	"trader-key": {ID: "trader-key", Subject: "user123", Roles: []string{RoleTrader}},
+	"admin-key":  {ID: "admin-key", Subject: "ops", Roles: []string{RoleTrader, RoleAdmin}},
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	valid := IssueAPIKey(testAPIKeySecret, "trader-key")
+
+	tests := []struct {
+		name    string
+		secret  []byte
+		key     string
+		subject string // Empty when the key must be rejected
+	}{
+		{"issued key", testAPIKeySecret, valid, "user123"},
+		{"other secret", testAPIKeySecret, IssueAPIKey([]byte("another-secret-0123456789abcdef012345"), "trader-key"), ""},
+		{"id swapped under the signature", testAPIKeySecret, "admin-key" + valid[len("trader-key"):], ""},
+		{"bad signature", testAPIKeySecret, valid[:len(valid)-2] + "xx", ""},
+		{"revoked id", testAPIKeySecret, IssueAPIKey(testAPIKeySecret, "revoked-key"), ""},
+		{"no signature", testAPIKeySecret, "trader-key", ""},
+		{"no id", testAPIKeySecret, valid[len("trader-key"):], ""},
+		{"no secret configured", nil, IssueAPIKey(nil, "trader-key"), ""},
+	}
+
+	for _, tt := range tests {
+		issued, err := verifyAPIKey(tt.secret, testAPIKeys, tt.key)
+		if tt.subject == "" {
+			if !errors.Is(err, errInvalidAPIKey) {
+				t.Errorf("%s: verifyAPIKey() = %+v, %v, want errInvalidAPIKey", tt.name, issued, err)
+			}
+			continue
+		}
+		if err != nil || issued.Subject != tt.subject {
+			t.Errorf("%s: verifyAPIKey() = %+v, %v, want the key of %s", tt.name, issued, err, tt.subject)
+		}
+	}
+}
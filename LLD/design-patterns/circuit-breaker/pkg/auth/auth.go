@@ -0,0 +1,281 @@
+// Package auth authenticates API callers with HMAC-signed API keys or JWT
+// bearer tokens verified against a local JWKS file, binds the caller to the
+// request context and checks roles.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"circuit-breaker-demo/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Roles known to the services
+const (
+	RoleTrader = "trader"
+	RoleAdmin  = "admin"
+)
+
+// Authentication methods, as reported in Principal.Method
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// Principal is an authenticated caller
+type Principal struct {
+	Subject string // The user the caller acts as
+	Roles   []string
+	Method  string // MethodAPIKey or MethodJWT
+	KeyID   string // The API key ID, for API keys
+}
+
+// HasRole reports whether the principal has the role
+func (p *Principal) HasRole(role string) bool {
+	for _, candidate := range p.Roles {
+		if candidate == role {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal bound to the context, if any
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// Policy configures authentication. A disabled policy lets every request
+// through without a principal.
+type Policy struct {
+	Enabled      bool
+	APIKeySecret []byte
+	APIKeys      map[string]APIKey // By key ID
+	JWKSFile     string            // Empty disables bearer tokens
+	Issuer       string            // Required iss claim; empty skips the check
+	Audience     string            // Required aud claim; empty skips the check
+	ClockSkew    time.Duration
+}
+
+// Authenticator verifies credentials according to its policy
+type Authenticator struct {
+	logger  *zap.Logger
+	metrics *authMetrics
+
+	mutex  sync.RWMutex
+	policy Policy
+	keySet *KeySet
+}
+
+// authMetrics holds Prometheus metrics for authentication
+type authMetrics struct {
+	requestsTotal *prometheus.CounterVec
+}
+
+var (
+	authMetricsOnce   sync.Once
+	globalAuthMetrics *authMetrics
+)
+
+func getAuthMetrics() *authMetrics {
+	authMetricsOnce.Do(func() {
+		globalAuthMetrics = &authMetrics{
+			requestsTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "auth_requests_total",
+					Help: "Total number of authentication and authorization decisions by method and result",
+				},
+				[]string{"method", "result"},
+			),
+		}
+	})
+	return globalAuthMetrics
+}
+
+// NewAuthenticator creates an authenticator, loading the JWKS file when
+// the policy names one
+func NewAuthenticator(policy Policy, logger *zap.Logger) (*Authenticator, error) {
+	a := &Authenticator{
+		logger:  logger,
+		metrics: getAuthMetrics(),
+	}
+	if err := a.SetPolicy(policy); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// SetPolicy changes the policy, reloading the JWKS file. On error the
+// previous policy stays active.
+func (a *Authenticator) SetPolicy(policy Policy) error {
+	var keySet *KeySet
+	if policy.Enabled && policy.JWKSFile != "" {
+		var err error
+		if keySet, err = LoadKeySet(policy.JWKSFile); err != nil {
+			return err
+		}
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.policy = policy
+	a.keySet = keySet
+	return nil
+}
+
+// Enabled reports whether requests are authenticated
+func (a *Authenticator) Enabled() bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.policy.Enabled
+}
+
+// errNoCredentials is returned for a request without credentials
+var errNoCredentials = errors.New("no credentials")
+
+// authenticate verifies the API key or bearer token of a request
+func (a *Authenticator) authenticate(req *http.Request) (*Principal, string, error) {
+	a.mutex.RLock()
+	policy, keySet := a.policy, a.keySet
+	a.mutex.RUnlock()
+
+	if key := req.Header.Get(APIKeyHeader); key != "" {
+		issued, err := verifyAPIKey(policy.APIKeySecret, policy.APIKeys, key)
+		if err != nil {
+			return nil, MethodAPIKey, err
+		}
+		return &Principal{Subject: issued.Subject, Roles: issued.Roles, Method: MethodAPIKey, KeyID: issued.ID}, MethodAPIKey, nil
+	}
+
+	scheme, token, found := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return nil, "", errNoCredentials
+	}
+	if keySet == nil {
+		return nil, MethodJWT, errInvalidToken
+	}
+	claims, err := keySet.Verify(strings.TrimSpace(token), policy.Issuer, policy.Audience, policy.ClockSkew, time.Now())
+	if err != nil {
+		return nil, MethodJWT, err
+	}
+	return &Principal{Subject: claims.Subject, Roles: claims.Roles, Method: MethodJWT}, MethodJWT, nil
+}
+
+// Authenticate is middleware that verifies the credentials a request
+// carries and binds the principal to its context. Requests with invalid
+// credentials are answered 401; requests without any pass on anonymously,
+// for Require to reject where authentication is needed.
+func (a *Authenticator) Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Next()
+			return
+		}
+		if _, ok := a.bind(c); !ok && c.IsAborted() {
+			return
+		}
+		c.Next()
+	}
+}
+
+// bind returns the principal of the request, authenticating it unless an
+// earlier middleware already did. It answers 401 and aborts for invalid
+// credentials.
+func (a *Authenticator) bind(c *gin.Context) (*Principal, bool) {
+	if principal, ok := PrincipalFromContext(c.Request.Context()); ok {
+		return principal, true
+	}
+
+	principal, method, err := a.authenticate(c.Request)
+	switch {
+	case errors.Is(err, errNoCredentials):
+		return nil, false
+	case err != nil:
+		a.metrics.requestsTotal.WithLabelValues(method, "invalid").Inc()
+		a.logger.Warn("Rejected invalid credentials",
+			zap.String("method", method),
+			zap.String("path", c.Request.URL.Path),
+			zap.Error(err),
+		)
+		Unauthorized(c, "Invalid credentials")
+		return nil, false
+	}
+
+	a.metrics.requestsTotal.WithLabelValues(method, "authenticated").Inc()
+	c.Request = c.Request.WithContext(WithPrincipal(c.Request.Context(), principal))
+	return principal, true
+}
+
+// Require is middleware that answers 401 unless the request is
+// authenticated. It lets every request through while authentication is
+// disabled.
+func (a *Authenticator) Require() gin.HandlerFunc {
+	return a.RequireRole("")
+}
+
+// RequireRole is Require for callers with the role, answering 403 to
+// authenticated callers without it. An empty role only requires
+// authentication.
+func (a *Authenticator) RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Next()
+			return
+		}
+
+		principal, ok := a.bind(c)
+		if !ok {
+			if !c.IsAborted() {
+				a.metrics.requestsTotal.WithLabelValues("none", "missing").Inc()
+				Unauthorized(c, "Authentication required")
+			}
+			return
+		}
+		if role != "" && !principal.HasRole(role) {
+			a.metrics.requestsTotal.WithLabelValues(principal.Method, "forbidden").Inc()
+			Forbidden(c, "Requires the "+role+" role")
+			return
+		}
+		c.Next()
+	}
+}
+
+// Unauthorized answers 401 with the accepted schemes
+func Unauthorized(c *gin.Context, message string) {
+	c.Header("WWW-Authenticate", `Bearer, ApiKey header="`+APIKeyHeader+`"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, models.ErrorResponse{
+		Error:     "Unauthorized",
+		Message:   message,
+		Code:      "UNAUTHORIZED",
+		Timestamp: time.Now(),
+	})
+}
+
+// Forbidden answers 403 to an authenticated caller that may not do what it asked
+func Forbidden(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, models.ErrorResponse{
+		Error:     "Forbidden",
+		Message:   message,
+		Code:      "FORBIDDEN",
+		Timestamp: time.Now(),
+	})
+}
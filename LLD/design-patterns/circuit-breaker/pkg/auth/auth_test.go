@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// newTestRouter returns a router that authenticates the test API keys and
+// RSA-signed bearer tokens, with a route for traders and one for admins
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	_, path := newTestKeySet(t)
+	authenticator, err := NewAuthenticator(Policy{
+		Enabled:      true,
+		APIKeySecret: testAPIKeySecret,
+		APIKeys:      testAPIKeys,
+		JWKSFile:     path,
+		Issuer:       testIssuer,
+		Audience:     testAudience,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(authenticator.Authenticate())
+	whoami := func(c *gin.Context) {
+		principal, _ := PrincipalFromContext(c.Request.Context())
+		c.String(http.StatusOK, principal.Method+":"+principal.Subject)
+	}
+	router.POST("/trades", authenticator.RequireRole(RoleTrader), whoami)
+	router.PUT("/circuit-breakers/:name", authenticator.RequireRole(RoleAdmin), whoami)
+	router.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router
+}
+
+func TestMiddlewareRoles(t *testing.T) {
+	router := newTestRouter(t)
+	rsaKey, _ := testSigningKeys(t)
+	token := func(roles ...string) string {
+		return sign(t, map[string]string{"alg": AlgRS256, "kid": "rsa-1"}, map[string]interface{}{
+			"sub":   "user456",
+			"iss":   testIssuer,
+			"aud":   testAudience,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"roles": roles,
+		}, rsaKey)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		value  string
+		status int
+		body   string
+	}{
+		{"trader key trades", http.MethodPost, "/trades", APIKeyHeader, IssueAPIKey(testAPIKeySecret, "trader-key"), http.StatusOK, "api_key:user123"},
+		{"trader key is no admin", http.MethodPut, "/circuit-breakers/risk", APIKeyHeader, IssueAPIKey(testAPIKeySecret, "trader-key"), http.StatusForbidden, ""},
+		{"admin key", http.MethodPut, "/circuit-breakers/risk", APIKeyHeader, IssueAPIKey(testAPIKeySecret, "admin-key"), http.StatusOK, "api_key:ops"},
+		{"revoked key", http.MethodPost, "/trades", APIKeyHeader, IssueAPIKey(testAPIKeySecret, "revoked-key"), http.StatusUnauthorized, ""},
+		{"trader token", http.MethodPost, "/trades", "Authorization", "Bearer " + token(RoleTrader), http.StatusOK, "jwt:user456"},
+		{"token without roles", http.MethodPost, "/trades", "Authorization", "Bearer " + token(), http.StatusForbidden, ""},
+		{"invalid token", http.MethodPost, "/trades", "Authorization", "Bearer not-a-token", http.StatusUnauthorized, ""},
+		{"no credentials", http.MethodPost, "/trades", "", "", http.StatusUnauthorized, ""},
+		{"invalid key on a public route", http.MethodGet, "/health", APIKeyHeader, "forged.key", http.StatusUnauthorized, ""},
+		{"anonymous public route", http.MethodGet, "/health", "", "", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		if recorder.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, recorder.Code, tt.status)
+			continue
+		}
+		if tt.body != "" && recorder.Body.String() != tt.body {
+			t.Errorf("%s: principal = %q, want %q", tt.name, recorder.Body.String(), tt.body)
+		}
+		if tt.status == http.StatusUnauthorized && recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: 401 without WWW-Authenticate", tt.name)
+		}
+	}
+}
+
+func TestDisabledPolicyLetsEveryoneThrough(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	authenticator, err := NewAuthenticator(Policy{}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(authenticator.Authenticate())
+	router.PUT("/circuit-breakers/:name", authenticator.RequireRole(RoleAdmin), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPut, "/circuit-breakers/risk", nil)
+	req.Header.Set(APIKeyHeader, "forged.key")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200 while authentication is disabled", recorder.Code)
+	}
+}
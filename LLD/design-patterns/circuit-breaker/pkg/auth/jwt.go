@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported JWT signing algorithms. Symmetric and unsigned tokens are
+// rejected: the gateway only holds public keys.
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+)
+
+// jwksCheckInterval is how often a key set looks for a changed file when a
+// token names a key it does not know, e.g. after the issuer rotated keys
+const jwksCheckInterval = 5 * time.Second
+
+// errInvalidToken is returned for every token that does not verify
+var errInvalidToken = errors.New("invalid bearer token")
+
+// Claims are the JWT claims the gateway uses
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	Roles     []string `json:"roles"`
+}
+
+// audience is the aud claim, which may be a string or a list of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// contains reports whether the audience includes value
+func (a audience) contains(value string) bool {
+	for _, candidate := range a {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// jwk is one key of a JSON Web Key Set (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`   // RSA modulus
+	E   string `json:"e"`   // RSA exponent
+	Crv string `json:"crv"` // EC curve
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey is a verification key with the algorithm it is used with
+type publicKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// KeySet holds the public keys of a local JWKS file. The file is read again
+// when a token names an unknown key and the file has changed since.
+type KeySet struct {
+	path string
+
+	mutex   sync.Mutex
+	keys    map[string]publicKey // By kid
+	modTime time.Time
+	checked time.Time
+}
+
+// LoadKeySet reads a JWKS file
+func LoadKeySet(path string) (*KeySet, error) {
+	keySet := &KeySet{path: path}
+	if err := keySet.reload(); err != nil {
+		return nil, err
+	}
+	return keySet, nil
+}
+
+// reload reads the file and replaces the keys
+func (k *KeySet) reload() error {
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+
+	var file struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("%s: %w", k.path, err)
+	}
+
+	keys := make(map[string]publicKey, len(file.Keys))
+	for i, key := range file.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		parsed, err := parseJWK(key)
+		if err != nil {
+			return fmt.Errorf("%s: keys[%d]: %w", k.path, i, err)
+		}
+		keys[key.Kid] = parsed
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.keys = keys
+	k.modTime = info.ModTime()
+	k.checked = time.Now()
+	return nil
+}
+
+// Len returns the number of signing keys
+func (k *KeySet) Len() int {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return len(k.keys)
+}
+
+// key returns the key with the given kid. A token without kid may use the
+// only key of the set.
+func (k *KeySet) key(kid string) (publicKey, bool) {
+	k.mutex.Lock()
+	key, exists := k.lookupLocked(kid)
+	stale := !exists && time.Since(k.checked) >= jwksCheckInterval
+	if stale {
+		k.checked = time.Now()
+	}
+	modTime := k.modTime
+	k.mutex.Unlock()
+
+	if !stale {
+		return key, exists
+	}
+	if info, err := os.Stat(k.path); err != nil || info.ModTime().Equal(modTime) || k.reload() != nil {
+		return key, exists
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return k.lookupLocked(kid)
+}
+
+func (k *KeySet) lookupLocked(kid string) (publicKey, bool) {
+	if key, exists := k.keys[kid]; exists {
+		return key, true
+	}
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	return publicKey{}, false
+}
+
+// parseJWK converts an RSA or P-256 EC key
+func parseJWK(key jwk) (publicKey, error) {
+	switch key.Kty {
+	case "RSA":
+		if key.Alg != "" && key.Alg != AlgRS256 {
+			return publicKey{}, fmt.Errorf("unsupported algorithm %q", key.Alg)
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(key.N)
+		e, errE := base64.RawURLEncoding.DecodeString(key.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return publicKey{}, errors.New("invalid RSA key")
+		}
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		return publicKey{alg: AlgRS256, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, nil
+	case "EC":
+		if key.Crv != "P-256" || (key.Alg != "" && key.Alg != AlgES256) {
+			return publicKey{}, fmt.Errorf("unsupported curve %q or algorithm %q", key.Crv, key.Alg)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(key.X)
+		y, errY := base64.RawURLEncoding.DecodeString(key.Y)
+		if errX != nil || errY != nil {
+			return publicKey{}, errors.New("invalid EC key")
+		}
+		ecKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !ecKey.Curve.IsOnCurve(ecKey.X, ecKey.Y) {
+			return publicKey{}, errors.New("invalid EC key")
+		}
+		return publicKey{alg: AlgES256, key: ecKey}, nil
+	}
+	return publicKey{}, fmt.Errorf("unsupported key type %q", key.Kty)
+}
+
+// Verify checks the signature and the time, issuer and audience claims of
+// a compact JWT. Empty issuer or audience skip their check; skew allows
+// for clock differences with the issuer.
+func (k *KeySet) Verify(token, issuer, audience string, skew time.Duration, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errInvalidToken
+	}
+	key, exists := k.key(header.Kid)
+	if !exists || header.Alg != key.alg {
+		return nil, errInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.Strict().DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !verifySignature(key, digest[:], signature) {
+		return nil, errInvalidToken
+	}
+
+	claims := new(Claims)
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, errInvalidToken
+	}
+
+	switch {
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", errInvalidToken)
+	case claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(skew)):
+		return nil, fmt.Errorf("%w: expired", errInvalidToken)
+	case claims.NotBefore != 0 && now.Add(skew).Before(time.Unix(claims.NotBefore, 0)):
+		return nil, fmt.Errorf("%w: not valid yet", errInvalidToken)
+	case issuer != "" && claims.Issuer != issuer:
+		return nil, fmt.Errorf("%w: wrong issuer", errInvalidToken)
+	case audience != "" && !claims.Audience.contains(audience):
+		return nil, fmt.Errorf("%w: wrong audience", errInvalidToken)
+	}
+	return claims, nil
+}
+
+// verifySignature checks a signature over a SHA-256 digest
+func verifySignature(key publicKey, digest, signature []byte) bool {
+	switch public := key.key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(public, crypto.SHA256, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		// JWS carries r and s as two fixed-size big-endian integers
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(public, digest, r, s)
+	}
+	return false
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Issuer and audience the test tokens are minted for
+const (
+	testIssuer   = "https://auth.example.com"
+	testAudience = "trading-gateway"
+)
+
+var (
+	testKeysOnce sync.Once
+	testRSAKey   *rsa.PrivateKey
+	testECKey    *ecdsa.PrivateKey
+)
+
+// testSigningKeys returns an RSA and a P-256 key, generated once per run
+func testSigningKeys(t *testing.T) (*rsa.PrivateKey, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	testKeysOnce.Do(func() {
+		var err error
+		if testRSAKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			panic(err)
+		}
+		if testECKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			panic(err)
+		}
+	})
+	return testRSAKey, testECKey
+}
+
+func encodeSegment(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// rsaJWK returns the public JWK of an RSA key
+func rsaJWK(kid string, key *rsa.PrivateKey) jwk {
+	return jwk{
+		Kty: "RSA",
+		Kid: kid,
+		Alg: AlgRS256,
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// ecJWK returns the public JWK of a P-256 key
+func ecJWK(kid string, key *ecdsa.PrivateKey) jwk {
+	return jwk{
+		Kty: "EC",
+		Kid: kid,
+		Alg: AlgES256,
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// writeJWKS writes a key set to path
+func writeJWKS(t *testing.T, path string, keys ...jwk) {
+	t.Helper()
+
+	data, err := json.Marshal(map[string][]jwk{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sign returns a compact JWT with the header and claims, signed with an
+// RSA or EC key; a nil key leaves the signature empty
+func sign(t *testing.T, header map[string]string, claims interface{}, key crypto.Signer) string {
+	t.Helper()
+
+	signed := encodeSegment(header) + "." + encodeSegment(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// newTestKeySet loads a key set holding the RSA key as rsa-1 and the EC
+// key as ec-1
+func newTestKeySet(t *testing.T) (*KeySet, string) {
+	t.Helper()
+
+	rsaKey, ecKey := testSigningKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey))
+
+	keySet, err := LoadKeySet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keySet, path
+}
+
+func TestKeySetVerify(t *testing.T) {
+	keySet, _ := newTestKeySet(t)
+	rsaKey, ecKey := testSigningKeys(t)
+	now := time.Unix(1_700_000_000, 0)
+	skew := 30 * time.Second
+
+	claims := func(change func(c map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":   "user123",
+			"iss":   testIssuer,
+			"aud":   testAudience,
+			"exp":   now.Add(time.Hour).Unix(),
+			"roles": []string{RoleTrader},
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	rs256 := map[string]string{"alg": AlgRS256, "kid": "rsa-1"}
+	valid := sign(t, rs256, claims(nil), rsaKey)
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"RS256", valid, true},
+		{"ES256", sign(t, map[string]string{"alg": AlgES256, "kid": "ec-1"}, claims(nil), ecKey), true},
+		{"audience list", sign(t, rs256, claims(func(c map[string]interface{}) {
+			c["aud"] = []string{"other", testAudience}
+		}), rsaKey), true},
+
+		{"alg of another key", sign(t, map[string]string{"alg": AlgES256, "kid": "rsa-1"}, claims(nil), ecKey), false},
+		{"alg none", sign(t, map[string]string{"alg": "none", "kid": "rsa-1"}, claims(nil), nil), false},
+		{"alg HS256", sign(t, map[string]string{"alg": "HS256", "kid": "rsa-1"}, claims(nil), nil), false},
+		{"signed by another key", sign(t, map[string]string{"alg": AlgES256, "kid": "ec-1"}, claims(nil), mustECKey(t)), false},
+		{"tampered claims", withClaims(valid, claims(func(c map[string]interface{}) {
+			c["roles"] = []string{RoleAdmin}
+		})), false},
+		{"truncated signature", valid[:len(valid)-4], false},
+		{"unknown kid", sign(t, map[string]string{"alg": AlgRS256, "kid": "rsa-2"}, claims(nil), rsaKey), false},
+		{"malformed", "not-a-token", false},
+
+		{"expired within skew", sign(t, rs256, claims(func(c map[string]interface{}) {
+			c["exp"] = now.Add(-skew / 2).Unix()
+		}), rsaKey), true},
+		{"expired beyond skew", sign(t, rs256, claims(func(c map[string]interface{}) {
+			c["exp"] = now.Add(-2 * skew).Unix()
+		}), rsaKey), false},
+		{"no exp", sign(t, rs256, claims(func(c map[string]interface{}) {
+			delete(c, "exp")
+		}), rsaKey), false},
+		{"not before within skew", sign(t, rs256, claims(func(c map[string]interface{}) {
+			c["nbf"] = now.Add(skew / 2).Unix()
+		}), rsaKey), true},
+		{"not before beyond skew", sign(t, rs256, claims(func(c map[string]interface{}) {
+			c["nbf"] = now.Add(2 * skew).Unix()
+		}), rsaKey), false},
+
+		{"wrong issuer", sign(t, rs256, claims(func(c map[string]interface{}) {
+			c["iss"] = "https://evil.example.com"
+		}), rsaKey), false},
+		{"wrong audience", sign(t, rs256, claims(func(c map[string]interface{}) {
+			c["aud"] = "portfolio-service"
+		}), rsaKey), false},
+		{"no subject", sign(t, rs256, claims(func(c map[string]interface{}) {
+			delete(c, "sub")
+		}), rsaKey), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := keySet.Verify(tt.token, testIssuer, testAudience, skew, now)
+			if !tt.ok {
+				if !errors.Is(err, errInvalidToken) {
+					t.Errorf("Verify() = %+v, %v, want errInvalidToken", got, err)
+				}
+				return
+			}
+			if err != nil || got.Subject != "user123" || !(&Principal{Roles: got.Roles}).HasRole(RoleTrader) {
+				t.Errorf("Verify() = %+v, %v, want the claims of user123", got, err)
+			}
+		})
+	}
+}
+
+func mustECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// withClaims returns token with its claims replaced and the signature kept
+func withClaims(token string, claims interface{}) string {
+	parts := strings.Split(token, ".")
+	parts[1] = encodeSegment(claims)
+	return strings.Join(parts, ".")
+}
+
+func TestKeySetReloadsForUnknownKid(t *testing.T) {
+	keySet, path := newTestKeySet(t)
+	rsaKey, ecKey := testSigningKeys(t)
+	now := time.Now()
+	claims := map[string]interface{}{"sub": "user123", "exp": now.Add(time.Hour).Unix()}
+	rotated := sign(t, map[string]string{"alg": AlgRS256, "kid": "rsa-2"}, claims, rsaKey)
+
+	// The issuer rotates to a new kid
+	writeJWKS(t, path, rsaJWK("rsa-2", rsaKey), ecJWK("ec-1", ecKey))
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	// Right after a read the file is not checked again
+	if _, err := keySet.Verify(rotated, "", "", 0, now); !errors.Is(err, errInvalidToken) {
+		t.Fatalf("Verify() within the check interval = %v, want errInvalidToken", err)
+	}
+
+	keySet.mutex.Lock()
+	keySet.checked = time.Now().Add(-jwksCheckInterval)
+	keySet.mutex.Unlock()
+
+	if _, err := keySet.Verify(rotated, "", "", 0, now); err != nil {
+		t.Fatalf("Verify() after the check interval = %v, want the rotated key", err)
+	}
+	if n := keySet.Len(); n != 2 {
+		t.Errorf("Len() = %d, want 2", n)
+	}
+	old := sign(t, map[string]string{"alg": AlgRS256, "kid": "rsa-1"}, claims, rsaKey)
+	if _, err := keySet.Verify(old, "", "", 0, now); !errors.Is(err, errInvalidToken) {
+		t.Errorf("Verify() with the retired kid = %v, want errInvalidToken", err)
+	}
+}
+
+func TestLoadKeySetRejectsBadKeys(t *testing.T) {
+	rsaKey, _ := testSigningKeys(t)
+	hs := rsaJWK("rsa-1", rsaKey)
+	hs.Alg = "HS256"
+
+	tests := []struct {
+		name string
+		key  jwk
+	}{
+		{"symmetric algorithm", hs},
+		{"unknown key type", jwk{Kty: "oct", Kid: "secret"}},
+		{"other curve", jwk{Kty: "EC", Kid: "ec-384", Crv: "P-384"}},
+		{"point off the curve", jwk{Kty: "EC", Kid: "ec-bad", Crv: "P-256", X: "AQ", Y: "AQ"}},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		writeJWKS(t, path, tt.key)
+		if _, err := LoadKeySet(path); err == nil {
+			t.Errorf("LoadKeySet() with %s succeeded, want an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"circuit-breaker-demo/pkg/auth"
+	"circuit-breaker-demo/pkg/config"
+	"circuit-breaker-demo/pkg/httpclient"
+	"circuit-breaker-demo/pkg/idempotency"
+	"circuit-breaker-demo/pkg/loadshed"
+	"circuit-breaker-demo/pkg/models"
+	"circuit-breaker-demo/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewLogger builds a zap logger from the logging configuration. The returned
+// level can be changed at runtime without rebuilding the logger.
+func NewLogger(cfg config.LoggingConfig) (*zap.Logger, zap.AtomicLevel, error) {
+	level, err := zap.ParseAtomicLevel(cfg.Level)
+	if err != nil {
+		return nil, level, err
+	}
+
+	zapConfig := zap.NewProductionConfig()
+	if cfg.Format == "console" {
+		zapConfig.Encoding = "console"
+		zapConfig.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+		zapConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	}
+	zapConfig.Level = level
+
+	zapConfig.Sampling = nil
+	if cfg.Sampling.Initial > 0 {
+		zapConfig.Sampling = &zap.SamplingConfig{
+			Initial:    cfg.Sampling.Initial,
+			Thereafter: cfg.Sampling.Thereafter,
+		}
+	}
+
+	logger, err := zapConfig.Build()
+	return logger, level, err
+}
+
+// FollowLogLevel applies logging.level changes from configuration reloads
+func FollowLogLevel(watcher *config.Watcher, level zap.AtomicLevel, logger *zap.Logger) {
+	watcher.Subscribe(func(change config.Change) {
+		if change.Logging == nil {
+			return
+		}
+		if err := level.UnmarshalText([]byte(change.Logging.Level)); err != nil {
+			logger.Error("Failed to apply log level", zap.Error(err))
+			return
+		}
+		logger.Info("Log level changed", zap.String("level", level.String()))
+	})
+}
+
+// FollowLoadShedding applies load_shedding changes from configuration reloads
+func FollowLoadShedding(watcher *config.Watcher, shedder *loadshed.Shedder, logger *zap.Logger) {
+	watcher.Subscribe(func(change config.Change) {
+		if change.LoadShedding == nil {
+			return
+		}
+		shedder.SetPolicy(change.LoadShedding.Policy())
+		logger.Info("Load shedding policy changed",
+			zap.Bool("enabled", change.LoadShedding.Enabled),
+			zap.Int("maxInFlight", change.LoadShedding.MaxInFlight),
+			zap.Duration("maxQueueTime", change.LoadShedding.MaxQueueTime),
+			zap.Float64("maxCPU", change.LoadShedding.MaxCPU),
+		)
+	})
+}
+
+// FollowRateLimiting applies rate_limiting changes from configuration reloads
+func FollowRateLimiting(watcher *config.Watcher, limiter *ratelimit.Limiter, logger *zap.Logger) {
+	watcher.Subscribe(func(change config.Change) {
+		if change.RateLimiting == nil {
+			return
+		}
+		limiter.SetPolicy(change.RateLimiting.Policy())
+		logger.Info("Rate limits changed",
+			zap.Bool("enabled", change.RateLimiting.Enabled),
+			zap.Int("rules", len(change.RateLimiting.Rules)),
+		)
+	})
+}
+
+// FollowAuth applies auth changes from configuration reloads, e.g. revoked
+// API keys. A JWKS file that fails to load keeps the previous policy.
+func FollowAuth(watcher *config.Watcher, authenticator *auth.Authenticator, logger *zap.Logger) {
+	watcher.Subscribe(func(change config.Change) {
+		if change.Auth == nil {
+			return
+		}
+		if err := authenticator.SetPolicy(change.Auth.Policy()); err != nil {
+			logger.Error("Failed to apply auth policy", zap.Error(err))
+			return
+		}
+		logger.Info("Auth policy changed",
+			zap.Bool("enabled", change.Auth.Enabled),
+			zap.Int("apiKeys", len(change.Auth.APIKeys)),
+		)
+	})
+}
+
+// FollowIdempotency applies idempotency changes from configuration reloads
+func FollowIdempotency(watcher *config.Watcher, guard *idempotency.Guard, logger *zap.Logger) {
+	watcher.Subscribe(func(change config.Change) {
+		if change.Idempotency == nil {
+			return
+		}
+		guard.SetPolicy(change.Idempotency.Policy())
+		logger.Info("Idempotency policy changed",
+			zap.Bool("enabled", change.Idempotency.Enabled),
+			zap.Duration("ttl", change.Idempotency.TTL),
+		)
+	})
+}
+
+// RegisterLogLevel adds GET and PUT /admin/log-level to the group. PUT takes
+// {"level":"debug"} and changes the level until the next restart or reload.
+func RegisterLogLevel(group *gin.RouterGroup, level zap.AtomicLevel) {
+	handler := gin.WrapH(level)
+	group.GET("/admin/log-level", handler)
+	group.PUT("/admin/log-level", handler)
+}
+
+// CORS is middleware that allows cross-origin calls with the given methods
+// from any origin and answers preflight requests itself
+func CORS(methods ...string) gin.HandlerFunc {
+	allowMethods := strings.Join(append(methods, http.MethodOptions), ", ")
+
+	return func(c *gin.Context) {
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.Header("Access-Control-Allow-Methods", allowMethods)
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, Idempotency-Key")
+
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequestID is middleware that takes the caller's X-Request-ID, or assigns a
+// new one, and echoes it in the response. The ID is stored in the request
+// context, where the httpclient.RequestID interceptor forwards it upstream.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(httpclient.RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = httpclient.NewRequestID()
+		}
+
+		c.Header(httpclient.RequestIDHeader, id)
+		c.Request = c.Request.WithContext(httpclient.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// Deadline is middleware that bounds the request context by the caller's
+// X-Request-Deadline or by budget, whichever is shorter; a budget of 0 only
+// applies the caller's deadline. A request that arrives with no time left
+// is answered 504 right away. Handlers pass c.Request.Context() to upstream
+// calls, where the httpclient.PropagateDeadline interceptor forwards what
+// is left.
+func Deadline(budget time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		budget := budget
+		if header := c.GetHeader(httpclient.DeadlineHeader); header != "" {
+			if remaining, ok := httpclient.ParseBudget(header); ok && (budget <= 0 || remaining < budget) {
+				if remaining <= 0 {
+					DeadlineExceeded(c, "Request deadline expired before it was handled")
+					c.Abort()
+					return
+				}
+				budget = remaining
+			}
+		}
+		if budget <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), budget)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// DeadlineExceeded responds 504 for a request that ran out of time. The
+// X-Request-Deadline: 0 header tells callers the budget ran out, so their
+// breakers do not hold it against this service.
+func DeadlineExceeded(c *gin.Context, message string) {
+	c.Header(httpclient.DeadlineHeader, "0")
+	c.JSON(http.StatusGatewayTimeout, models.ErrorResponse{
+		Error:     "Deadline exceeded",
+		Message:   message,
+		Code:      "DEADLINE_EXCEEDED",
+		Timestamp: time.Now(),
+	})
+}
+
+// AbandonRequest answers a request whose context ended before its work was
+// done: 504 when the deadline passed, nothing when the caller went away
+func AbandonRequest(c *gin.Context, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		DeadlineExceeded(c, "Request could not be completed before its deadline")
+	}
+	c.Abort()
+}
+
+// ServeMetrics exposes Prometheus metrics as configured. When metrics.port is
+// set and differs from the API port, metrics get their own listener;
+// otherwise metrics.path is mounted on the API router. It returns the port
+// metrics are served on, or 0 when metrics are disabled.
+func ServeMetrics(router *gin.Engine, cfg *config.Config, logger *zap.Logger) int {
+	if !cfg.Metrics.Enabled {
+		return 0
+	}
+
+	if cfg.Metrics.Port == 0 || cfg.Metrics.Port == cfg.Server.Port {
+		router.GET(cfg.Metrics.Path, gin.WrapH(promhttp.Handler()))
+		return cfg.Server.Port
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Metrics.Path, promhttp.Handler())
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Metrics.Port),
+		Handler: mux,
+	}
+
+	go func() {
+		logger.Info("Metrics server starting",
+			zap.Int("port", cfg.Metrics.Port),
+			zap.String("path", cfg.Metrics.Path),
+		)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Metrics server stopped", zap.Error(err))
+		}
+	}()
+
+	return cfg.Metrics.Port
+}
@@ -0,0 +1,198 @@
+package bootstrap
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"circuit-breaker-demo/pkg/config"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logLines builds a logger from cfg, runs log with it and returns the lines
+// it wrote to stderr
+func logLines(t *testing.T, cfg config.LoggingConfig, log func(logger *zap.Logger)) []string {
+	t.Helper()
+
+	stderr, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+
+	// The logger opens stderr when it is built
+	saved := os.Stderr
+	os.Stderr = stderr
+	logger, _, err := NewLogger(cfg)
+	os.Stderr = saved
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log(logger)
+	logger.Sync()
+
+	data, err := os.ReadFile(stderr.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.LoggingConfig
+		prefix string // Of the first line
+		lines  int    // Of five infos and a warning
+	}{
+		{"json", config.LoggingConfig{Level: "info", Format: "json"}, `{"level":"info"`, 6},
+		{"console", config.LoggingConfig{Level: "info", Format: "console"}, time.Now().Format("2006-01-02T"), 6},
+		{"sampled", config.LoggingConfig{Level: "info", Format: "json", Sampling: config.SamplingConfig{Initial: 2, Thereafter: 3}}, `{"level":"info"`, 4},
+		{"warn", config.LoggingConfig{Level: "warn", Format: "json"}, `{"level":"warn"`, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := logLines(t, tt.cfg, func(logger *zap.Logger) {
+				logger.Debug("Not logged at any of these levels")
+				for i := 0; i < 5; i++ {
+					logger.Info("Quote served")
+				}
+				logger.Warn("Quote stale")
+			})
+
+			if len(lines) != tt.lines {
+				t.Fatalf("Lines = %d, want %d:\n%s", len(lines), tt.lines, strings.Join(lines, "\n"))
+			}
+			if !strings.HasPrefix(lines[0], tt.prefix) {
+				t.Errorf("First line = %q, want the prefix %q", lines[0], tt.prefix)
+			}
+		})
+	}
+}
+
+func TestNewLoggerRejectsBadLevel(t *testing.T) {
+	if _, _, err := NewLogger(config.LoggingConfig{Level: "loud", Format: "json"}); err == nil {
+		t.Error("NewLogger() with level loud succeeded, want an error")
+	}
+}
+
+func TestRegisterLogLevel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	router := gin.New()
+	RegisterLogLevel(router.Group("/api/v1"), level)
+
+	tests := []struct {
+		method string
+		body   string
+		status int
+		want   zapcore.Level
+	}{
+		{http.MethodGet, "", http.StatusOK, zapcore.InfoLevel},
+		{http.MethodPut, `{"level":"debug"}`, http.StatusOK, zapcore.DebugLevel},
+		{http.MethodPut, `{"level":"loud"}`, http.StatusBadRequest, zapcore.DebugLevel},
+		{http.MethodGet, "", http.StatusOK, zapcore.DebugLevel},
+	}
+	for i, tt := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(tt.method, "/api/v1/admin/log-level", strings.NewReader(tt.body)))
+
+		if recorder.Code != tt.status {
+			t.Errorf("%d. %s = %d %s, want %d", i+1, tt.method, recorder.Code, recorder.Body.String(), tt.status)
+		}
+		if got := level.Level(); got != tt.want {
+			t.Errorf("%d. Level after %s = %v, want %v", i+1, tt.method, got, tt.want)
+		}
+		if tt.status == http.StatusOK && !strings.Contains(recorder.Body.String(), `"level":"`+tt.want.String()+`"`) {
+			t.Errorf("%d. %s body = %s, want the level %v", i+1, tt.method, recorder.Body.String(), tt.want)
+		}
+	}
+}
+
+// freePort returns a port nothing listens on
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestServeMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	get := func(router *gin.Engine, path string) int {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.Metrics.Enabled = false
+		router := gin.New()
+
+		if port := ServeMetrics(router, cfg, zap.NewNop()); port != 0 {
+			t.Errorf("Port = %d, want 0", port)
+		}
+		if status := get(router, cfg.Metrics.Path); status != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", cfg.Metrics.Path, status)
+		}
+	})
+
+	t.Run("on the router", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.Metrics = config.MetricsConfig{Enabled: true, Port: cfg.Server.Port, Path: "/internal/metrics"}
+		router := gin.New()
+
+		if port := ServeMetrics(router, cfg, zap.NewNop()); port != cfg.Server.Port {
+			t.Errorf("Port = %d, want the server port %d", port, cfg.Server.Port)
+		}
+		if status := get(router, "/internal/metrics"); status != http.StatusOK {
+			t.Errorf("GET /internal/metrics = %d, want 200", status)
+		}
+	})
+
+	t.Run("own listener", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.Metrics = config.MetricsConfig{Enabled: true, Port: freePort(t), Path: "/internal/metrics"}
+		router := gin.New()
+
+		if port := ServeMetrics(router, cfg, zap.NewNop()); port != cfg.Metrics.Port {
+			t.Errorf("Port = %d, want the metrics port %d", port, cfg.Metrics.Port)
+		}
+		if status := get(router, "/internal/metrics"); status != http.StatusNotFound {
+			t.Errorf("GET /internal/metrics on the router = %d, want 404", status)
+		}
+
+		url := "http://127.0.0.1:" + strconv.Itoa(cfg.Metrics.Port) + "/internal/metrics"
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			if resp, err = http.Get(url); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("GET %s = %v, want the metrics listener", url, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s = %d, want 200", url, resp.StatusCode)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package circuitbreaker
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -34,6 +35,9 @@ func (s State) String() string {
 	}
 }
 
+// ErrOpenState is returned when a request is rejected because the circuit is open
+var ErrOpenState = errors.New("circuit breaker is open")
+
 // Config holds circuit breaker configuration
 type Config struct {
 	Name                 string        `yaml:"name"`
@@ -62,6 +66,7 @@ func DefaultConfig(name string) Config {
 
 // CircuitBreaker implements the circuit breaker pattern
 type CircuitBreaker struct {
+	name   string // immutable copy of config.Name, safe to read without the mutex
 	config Config
 	state  int32
 	mutex  sync.RWMutex
@@ -75,6 +80,7 @@ type CircuitBreaker struct {
 	// Timing
 	lastFailureTime time.Time
 	lastStateChange time.Time
+	openUntil       time.Time // Set by OpenUntil; the circuit stays open at least this long
 
 	// Half-open state tracking
 	halfOpenRequests uint32
@@ -100,6 +106,7 @@ var (
 // NewCircuitBreaker creates a new circuit breaker instance
 func NewCircuitBreaker(config Config, logger *zap.Logger) *CircuitBreaker {
 	cb := &CircuitBreaker{
+		name:            config.Name,
 		config:          config,
 		state:           int32(StateClosed),
 		logger:          logger,
@@ -107,7 +114,7 @@ func NewCircuitBreaker(config Config, logger *zap.Logger) *CircuitBreaker {
 		metrics:         getGlobalMetrics(),
 	}
 
-	cb.metrics.currentState.WithLabelValues(config.Name).Set(float64(StateClosed))
+	cb.metrics.currentState.WithLabelValues(cb.name).Set(float64(StateClosed))
 
 	return cb
 }
@@ -155,31 +162,60 @@ func getGlobalMetrics() *Metrics {
 	return globalMetrics
 }
 
-// Execute runs the given function with circuit breaker protection
+// Execute runs the given function with circuit breaker protection. A call
+// that fails because ctx was canceled is not recorded: the caller gave up
+// (e.g. a hedged request lost the race), which says nothing about the health
+// of the protected service.
 func (cb *CircuitBreaker) Execute(ctx context.Context, fn func() (interface{}, error)) (interface{}, error) {
 	start := time.Now()
 	// allowRequest determines if a request should be allowed through
 	if !cb.allowRequest() {
-		cb.metrics.requestsTotal.WithLabelValues(cb.config.Name, "rejected").Inc()
-		return nil, errors.New("circuit breaker is open")
+		cb.metrics.requestsTotal.WithLabelValues(cb.name, "rejected").Inc()
+		return nil, ErrOpenState
 	}
 
 	// Execute the function
 	result, err := fn()
+	cb.record(start, err, err != nil && errors.Is(ctx.Err(), context.Canceled))
+
+	return result, err
+}
+
+// Allow is the two-step form of Execute for work whose outcome is known
+// only later, such as a response body that is still streaming. It returns
+// ErrOpenState when the request is rejected; otherwise the caller must call
+// done exactly once with the outcome. An error matching context.Canceled is
+// not recorded, just as in Execute.
+func (cb *CircuitBreaker) Allow() (done func(err error), err error) {
+	start := time.Now()
+	if !cb.allowRequest() {
+		cb.metrics.requestsTotal.WithLabelValues(cb.name, "rejected").Inc()
+		return nil, ErrOpenState
+	}
+
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			cb.record(start, err, errors.Is(err, context.Canceled))
+		})
+	}, nil
+}
+
+// record updates the breaker and metrics with the outcome of a request
+func (cb *CircuitBreaker) record(start time.Time, err error, canceled bool) {
 	duration := time.Since(start)
-	// Record the result
-	if err != nil {
+	if canceled {
+		cb.metrics.requestsTotal.WithLabelValues(cb.name, "canceled").Inc()
+	} else if err != nil {
 		cb.onFailure()
-		cb.metrics.requestsTotal.WithLabelValues(cb.config.Name, "failure").Inc()
-		cb.metrics.failuresTotal.WithLabelValues(cb.config.Name).Inc()
-		cb.metrics.requestDuration.WithLabelValues(cb.config.Name, "failure").Observe(duration.Seconds())
+		cb.metrics.requestsTotal.WithLabelValues(cb.name, "failure").Inc()
+		cb.metrics.failuresTotal.WithLabelValues(cb.name).Inc()
+		cb.metrics.requestDuration.WithLabelValues(cb.name, "failure").Observe(duration.Seconds())
 	} else {
 		cb.onSuccess()
-		cb.metrics.requestsTotal.WithLabelValues(cb.config.Name, "success").Inc()
-		cb.metrics.requestDuration.WithLabelValues(cb.config.Name, "success").Observe(duration.Seconds())
+		cb.metrics.requestsTotal.WithLabelValues(cb.name, "success").Inc()
+		cb.metrics.requestDuration.WithLabelValues(cb.name, "success").Observe(duration.Seconds())
 	}
-
-	return result, err
 }
 
 // allowRequest determines if a request should be allowed through
@@ -203,7 +239,7 @@ func (cb *CircuitBreaker) shouldAttemptReset() bool {
 	cb.mutex.RLock()
 	defer cb.mutex.RUnlock()
 
-	return time.Since(cb.lastFailureTime) >= cb.config.Timeout
+	return time.Since(cb.lastFailureTime) >= cb.config.Timeout && !time.Now().Before(cb.openUntil)
 }
 
 // allowHalfOpenRequest checks if request is allowed in half-open state
@@ -287,30 +323,74 @@ func (cb *CircuitBreaker) setState(newState State) {
 
 	// Log state change
 	cb.logger.Info("Circuit breaker state changed",
-		zap.String("name", cb.config.Name),
+		zap.String("name", cb.name),
 		zap.String("from", oldState.String()),
 		zap.String("to", newState.String()),
 	)
 	// Update metrics
-	cb.metrics.stateChanges.WithLabelValues(cb.config.Name, oldState.String(), newState.String()).Inc()
-	cb.metrics.currentState.WithLabelValues(cb.config.Name).Set(float64(newState))
+	cb.metrics.stateChanges.WithLabelValues(cb.name, oldState.String(), newState.String()).Inc()
+	cb.metrics.currentState.WithLabelValues(cb.name).Set(float64(newState))
 
 	// Attempt transition to half-open if we're opening the circuit
 	if newState == StateOpen {
-		go cb.scheduleReset()
+		go cb.scheduleReset(cb.config.Timeout)
 	}
 }
 
-// scheduleReset schedules a transition to half-open state
-func (cb *CircuitBreaker) scheduleReset() {
-	time.Sleep(cb.config.Timeout)
+// scheduleReset schedules a transition to half-open state. If the circuit
+// was asked to stay open longer in the meantime, it waits again.
+func (cb *CircuitBreaker) scheduleReset(timeout time.Duration) {
+	for {
+		time.Sleep(timeout)
+
+		cb.mutex.Lock()
+		currentState := State(atomic.LoadInt32(&cb.state))
+		if currentState != StateOpen {
+			cb.mutex.Unlock()
+			return
+		}
 
+		timeout = time.Until(cb.resetAt())
+		if timeout <= 0 {
+			cb.setState(StateHalfOpen)
+			cb.mutex.Unlock()
+			return
+		}
+		cb.mutex.Unlock()
+	}
+}
+
+// resetAt returns when an open circuit may move to half-open. The caller
+// must hold the mutex.
+func (cb *CircuitBreaker) resetAt() time.Time {
+	resetAt := cb.lastStateChange.Add(cb.config.Timeout)
+	if cb.openUntil.After(resetAt) {
+		return cb.openUntil
+	}
+	return resetAt
+}
+
+// OpenUntil opens the circuit and keeps it open at least until the given
+// time, e.g. when the protected service answered 503 with Retry-After. A
+// hint never shortens the configured timeout of a circuit that opened on
+// its own, and an earlier hint than the current one is ignored.
+func (cb *CircuitBreaker) OpenUntil(until time.Time) {
 	cb.mutex.Lock()
 	defer cb.mutex.Unlock()
 
-	currentState := State(atomic.LoadInt32(&cb.state))
-	if currentState == StateOpen {
-		cb.setState(StateHalfOpen)
+	if !until.After(time.Now()) || !until.After(cb.openUntil) {
+		return
+	}
+	cb.openUntil = until
+
+	cb.logger.Info("Circuit breaker held open by upstream hint",
+		zap.String("name", cb.name),
+		zap.Time("until", until),
+	)
+
+	if State(atomic.LoadInt32(&cb.state)) != StateOpen {
+		cb.lastFailureTime = time.Now()
+		cb.setState(StateOpen)
 	}
 }
 
@@ -322,6 +402,48 @@ func (cb *CircuitBreaker) resetCounters() {
 	cb.halfOpenRequests = 0
 }
 
+// GetConfig returns a copy of the active configuration
+func (cb *CircuitBreaker) GetConfig() Config {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+
+	return cb.config
+}
+
+// UpdateConfig replaces the active configuration of a running breaker.
+// The current state and window counters are kept, so the new thresholds
+// apply to the statistics already collected. The name cannot be changed
+// because it is used as the metrics label.
+func (cb *CircuitBreaker) UpdateConfig(config Config) error {
+	if config.Name == "" {
+		config.Name = cb.name
+	}
+	if config.Name != cb.name {
+		return fmt.Errorf("circuit breaker name cannot be changed from %q to %q", cb.name, config.Name)
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.config = config
+
+	cb.logger.Info("Circuit breaker configuration updated",
+		zap.String("name", cb.name),
+		zap.Uint32("maxRequests", config.MaxRequests),
+		zap.Duration("interval", config.Interval),
+		zap.Duration("timeout", config.Timeout),
+		zap.Uint32("failureThreshold", config.FailureThreshold),
+		zap.Uint32("successThreshold", config.SuccessThreshold),
+		zap.Float64("failureRateThreshold", config.FailureRateThreshold),
+		zap.Uint32("minimumRequests", config.MinimumRequests),
+	)
+
+	return nil
+}
+
 // GetState returns the current state of the circuit breaker
 func (cb *CircuitBreaker) GetState() State {
 	return State(atomic.LoadInt32(&cb.state))
@@ -333,7 +455,7 @@ func (cb *CircuitBreaker) GetStats() map[string]interface{} {
 	defer cb.mutex.RUnlock()
 
 	return map[string]interface{}{
-		"name":             cb.config.Name,
+		"name":             cb.name,
 		"state":            cb.GetState().String(),
 		"failures":         cb.failures,
 		"requests":         cb.requests,
@@ -341,5 +463,6 @@ func (cb *CircuitBreaker) GetStats() map[string]interface{} {
 		"halfOpenRequests": cb.halfOpenRequests,
 		"lastStateChange":  cb.lastStateChange,
 		"lastFailureTime":  cb.lastFailureTime,
+		"openUntil":        cb.openUntil,
 	}
 }
@@ -0,0 +1,131 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestBreaker returns a breaker that stays open for timeout
+func newTestBreaker(timeout time.Duration) *CircuitBreaker {
+	config := DefaultConfig("test")
+	config.Timeout = timeout
+	return NewCircuitBreaker(config, zap.NewNop())
+}
+
+// allowed reports whether the breaker lets a request through, recording it
+// as a success
+func allowed(cb *CircuitBreaker) bool {
+	_, err := cb.Execute(context.Background(), func() (interface{}, error) {
+		return nil, nil
+	})
+	return err == nil
+}
+
+func TestOpenUntilHoldsCircuitOpen(t *testing.T) {
+	cb := newTestBreaker(20 * time.Millisecond)
+
+	cb.OpenUntil(time.Now().Add(80 * time.Millisecond))
+	if state := cb.GetState(); state != StateOpen {
+		t.Fatalf("State = %v, want open", state)
+	}
+
+	// Past the timeout but not the hint
+	time.Sleep(40 * time.Millisecond)
+	if allowed(cb) {
+		t.Error("Request allowed before the hint expired")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if !allowed(cb) {
+		t.Error("Request rejected after the hint expired")
+	}
+	if state := cb.GetState(); state != StateHalfOpen {
+		t.Errorf("State = %v, want half-open", state)
+	}
+}
+
+func TestOpenUntilNeverShortensTimeout(t *testing.T) {
+	cb := newTestBreaker(80 * time.Millisecond)
+
+	cb.OpenUntil(time.Now().Add(10 * time.Millisecond))
+	time.Sleep(30 * time.Millisecond)
+	if allowed(cb) {
+		t.Error("Request allowed before the breaker timeout")
+	}
+}
+
+func TestOpenUntilIgnoresEarlierHints(t *testing.T) {
+	cb := newTestBreaker(10 * time.Millisecond)
+
+	until := time.Now().Add(time.Minute)
+	cb.OpenUntil(until)
+	cb.OpenUntil(time.Now().Add(time.Second))
+	cb.OpenUntil(time.Now().Add(-time.Second))
+
+	if got := cb.GetStats()["openUntil"]; got != until {
+		t.Errorf("openUntil = %v, want the latest hint %v", got, until)
+	}
+
+	closed := newTestBreaker(10 * time.Millisecond)
+	closed.OpenUntil(time.Now().Add(-time.Second))
+	if state := closed.GetState(); state != StateClosed {
+		t.Errorf("State after a hint in the past = %v, want closed", state)
+	}
+}
+
+// fail records a failed request
+func fail(cb *CircuitBreaker) {
+	cb.Execute(context.Background(), func() (interface{}, error) {
+		return nil, errors.New("upstream down")
+	})
+}
+
+func TestUpdateConfigKeepsWindow(t *testing.T) {
+	config := DefaultConfig("test")
+	config.MinimumRequests = 100
+	cb := NewCircuitBreaker(config, zap.NewNop())
+
+	for i := 0; i < 3; i++ {
+		fail(cb)
+	}
+
+	config.FailureThreshold = 4
+	if err := cb.UpdateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if stats := cb.GetStats(); stats["failures"] != uint32(3) || stats["requests"] != uint32(3) {
+		t.Errorf("Stats after UpdateConfig = %v, want the 3 failures kept", stats)
+	}
+	if state := cb.GetState(); state != StateClosed {
+		t.Fatalf("State = %v, want closed below the new threshold", state)
+	}
+
+	// The fourth failure of the window reaches the new threshold
+	fail(cb)
+	if state := cb.GetState(); state != StateOpen {
+		t.Errorf("State = %v, want open", state)
+	}
+}
+
+func TestUpdateConfigRejectsInvalidConfig(t *testing.T) {
+	cb := NewCircuitBreaker(DefaultConfig("test"), zap.NewNop())
+	before := cb.GetConfig()
+
+	invalid := before
+	invalid.Timeout = 0
+	if err := cb.UpdateConfig(invalid); err == nil {
+		t.Error("UpdateConfig() with a zero timeout succeeded, want an error")
+	}
+	renamed := before
+	renamed.Name = "other"
+	if err := cb.UpdateConfig(renamed); err == nil {
+		t.Error("UpdateConfig() with a new name succeeded, want an error")
+	}
+	if after := cb.GetConfig(); after != before {
+		t.Errorf("Config = %+v, want it unchanged %+v", after, before)
+	}
+}
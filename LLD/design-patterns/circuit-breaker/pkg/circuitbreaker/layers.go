@@ -0,0 +1,173 @@
+package circuitbreaker
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Override sets some fields of a configuration and leaves the others as
+// they are
+type Override func(config *Config) error
+
+// Layers keeps the configuration of running breakers as a base with layers
+// of overrides on top, applied in a fixed order so later layers win.
+// Changing the base or one layer reapplies every layer above it: reloading
+// the base keeps the overrides, and an override stays in force until its
+// own layer changes.
+type Layers struct {
+	mutex     sync.Mutex
+	order     []string // Layer names, lowest precedence first
+	breakers  map[string]*CircuitBreaker
+	base      map[string]Config
+	overrides map[string]map[string][]Override // By layer, then breaker name
+}
+
+// NewLayers creates layers named in order of precedence, lowest first, for
+// the breakers. Their current configurations are the base.
+func NewLayers(order []string, breakers ...*CircuitBreaker) *Layers {
+	l := &Layers{
+		order:     order,
+		breakers:  make(map[string]*CircuitBreaker, len(breakers)),
+		base:      make(map[string]Config, len(breakers)),
+		overrides: make(map[string]map[string][]Override, len(order)),
+	}
+
+	for _, cb := range breakers {
+		l.breakers[cb.name] = cb
+		l.base[cb.name] = cb.GetConfig()
+	}
+	for _, layer := range order {
+		l.overrides[layer] = make(map[string][]Override)
+	}
+
+	return l
+}
+
+// SetBase replaces the base configuration of a breaker and applies the
+// overrides on top of it
+func (l *Layers) SetBase(name string, config Config) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	cb, exists := l.breakers[name]
+	if !exists {
+		return fmt.Errorf("unknown circuit breaker %q", name)
+	}
+
+	config.Name = name
+	resolved, err := l.resolveLocked(name, config, l.overrides)
+	if err != nil {
+		return err
+	}
+	if err := cb.UpdateConfig(resolved); err != nil {
+		return err
+	}
+	l.base[name] = config
+	return nil
+}
+
+// SetLayer replaces every override of a layer, e.g. after its file was
+// read again. Breakers left out of overrides lose the layer's override. All
+// breakers are validated before any is updated, so an invalid override
+// changes nothing.
+func (l *Layers) SetLayer(layer string, overrides map[string]Override) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, exists := l.overrides[layer]; !exists {
+		return fmt.Errorf("unknown override layer %q", layer)
+	}
+	for name := range overrides {
+		if _, exists := l.breakers[name]; !exists {
+			return fmt.Errorf("unknown circuit breaker %q", name)
+		}
+	}
+
+	replaced := l.withLayerLocked(layer, make(map[string][]Override, len(overrides)))
+	for name, override := range overrides {
+		replaced[layer][name] = []Override{override}
+	}
+
+	updates := make(map[*CircuitBreaker]Config, len(l.breakers))
+	for name, cb := range l.breakers {
+		resolved, err := l.resolveLocked(name, l.base[name], replaced)
+		if err != nil {
+			return err
+		}
+		if resolved != cb.GetConfig() {
+			updates[cb] = resolved
+		}
+	}
+	for cb, config := range updates {
+		if err := cb.UpdateConfig(config); err != nil {
+			return err
+		}
+	}
+
+	l.overrides = replaced
+	return nil
+}
+
+// Add puts an override on top of those a breaker already has in a layer,
+// e.g. for a partial update through an API, and returns the resulting
+// configuration
+func (l *Layers) Add(layer, name string, override Override) (Config, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	current, exists := l.overrides[layer]
+	if !exists {
+		return Config{}, fmt.Errorf("unknown override layer %q", layer)
+	}
+	cb, exists := l.breakers[name]
+	if !exists {
+		return Config{}, fmt.Errorf("unknown circuit breaker %q", name)
+	}
+
+	added := make(map[string][]Override, len(current))
+	for breaker, list := range current {
+		added[breaker] = list
+	}
+	added[name] = append(append([]Override{}, current[name]...), override)
+	extended := l.withLayerLocked(layer, added)
+
+	resolved, err := l.resolveLocked(name, l.base[name], extended)
+	if err != nil {
+		return Config{}, err
+	}
+	if err := cb.UpdateConfig(resolved); err != nil {
+		return Config{}, err
+	}
+
+	l.overrides = extended
+	return resolved, nil
+}
+
+// withLayerLocked returns a copy of the overrides with one layer replaced
+func (l *Layers) withLayerLocked(layer string, replacement map[string][]Override) map[string]map[string][]Override {
+	overrides := make(map[string]map[string][]Override, len(l.overrides))
+	for name, byBreaker := range l.overrides {
+		overrides[name] = byBreaker
+	}
+	overrides[layer] = replacement
+	return overrides
+}
+
+// resolveLocked applies the overrides of every layer for a breaker to its
+// base, in order, and validates the result
+func (l *Layers) resolveLocked(name string, base Config, overrides map[string]map[string][]Override) (Config, error) {
+	config := base
+	for _, layer := range l.order {
+		for _, override := range overrides[layer][name] {
+			if err := override(&config); err != nil {
+				return Config{}, fmt.Errorf("circuit breaker %q: %s override: %w", name, layer, err)
+			}
+		}
+	}
+
+	config.Name = name
+	if err := config.Validate(); err != nil {
+		return Config{}, fmt.Errorf("circuit breaker %q: %w", name, err)
+	}
+	return config, nil
+}
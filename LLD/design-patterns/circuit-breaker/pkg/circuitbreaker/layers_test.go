@@ -0,0 +1,152 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// setFailureThreshold returns an override of the failure threshold
+func setFailureThreshold(threshold uint32) Override {
+	return func(config *Config) error {
+		config.FailureThreshold = threshold
+		return nil
+	}
+}
+
+// setTimeout returns an override of the open timeout
+func setTimeout(timeout time.Duration) Override {
+	return func(config *Config) error {
+		config.Timeout = timeout
+		return nil
+	}
+}
+
+func newTestLayers() (*Layers, *CircuitBreaker, *CircuitBreaker) {
+	risk := NewCircuitBreaker(DefaultConfig("risk"), zap.NewNop())
+	audit := NewCircuitBreaker(DefaultConfig("audit"), zap.NewNop())
+	return NewLayers([]string{"file", "admin"}, risk, audit), risk, audit
+}
+
+func TestLayersBaseReloadKeepsOverrides(t *testing.T) {
+	layers, risk, _ := newTestLayers()
+
+	if err := layers.SetLayer("file", map[string]Override{"risk": setFailureThreshold(3)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := layers.Add("admin", "risk", setTimeout(5*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	base := DefaultConfig("ignored")
+	base.FailureThreshold = 20
+	base.MaxRequests = 8
+	if err := layers.SetBase("risk", base); err != nil {
+		t.Fatal(err)
+	}
+
+	got := risk.GetConfig()
+	if got.Name != "risk" {
+		t.Errorf("Name = %q, want the breaker's own", got.Name)
+	}
+	if got.MaxRequests != 8 {
+		t.Errorf("MaxRequests = %d, want 8 from the new base", got.MaxRequests)
+	}
+	if got.FailureThreshold != 3 {
+		t.Errorf("FailureThreshold = %d, want the file override 3", got.FailureThreshold)
+	}
+	if got.Timeout != 5*time.Second {
+		t.Errorf("Timeout = %v, want the admin override 5s", got.Timeout)
+	}
+}
+
+func TestLayersPrecedence(t *testing.T) {
+	layers, risk, _ := newTestLayers()
+
+	if _, err := layers.Add("admin", "risk", setFailureThreshold(7)); err != nil {
+		t.Fatal(err)
+	}
+	if err := layers.SetLayer("file", map[string]Override{"risk": setFailureThreshold(3)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := risk.GetConfig().FailureThreshold; got != 7 {
+		t.Errorf("FailureThreshold = %d, want the admin override 7 over the file's 3", got)
+	}
+
+	// Later admin overrides stack on earlier ones
+	if _, err := layers.Add("admin", "risk", setTimeout(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	got := risk.GetConfig()
+	if got.FailureThreshold != 7 || got.Timeout != time.Second {
+		t.Errorf("Config = %+v, want both admin overrides", got)
+	}
+}
+
+func TestLayersReplacingALayerDropsOldOverrides(t *testing.T) {
+	layers, risk, audit := newTestLayers()
+
+	if err := layers.SetLayer("file", map[string]Override{
+		"risk":  setFailureThreshold(3),
+		"audit": setFailureThreshold(4),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := layers.SetLayer("file", map[string]Override{"audit": setFailureThreshold(6)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := risk.GetConfig().FailureThreshold; got != DefaultConfig("risk").FailureThreshold {
+		t.Errorf("Risk FailureThreshold = %d, want the base again", got)
+	}
+	if got := audit.GetConfig().FailureThreshold; got != 6 {
+		t.Errorf("Audit FailureThreshold = %d, want 6", got)
+	}
+}
+
+func TestLayersRejectInvalidChanges(t *testing.T) {
+	layers, risk, audit := newTestLayers()
+	invalid := setFailureThreshold(0)
+
+	tests := []struct {
+		name   string
+		change func() error
+	}{
+		{"invalid layer entry", func() error {
+			return layers.SetLayer("file", map[string]Override{"audit": setFailureThreshold(2), "risk": invalid})
+		}},
+		{"invalid admin override", func() error {
+			_, err := layers.Add("admin", "risk", invalid)
+			return err
+		}},
+		{"invalid base", func() error {
+			base := DefaultConfig("risk")
+			base.Timeout = 0
+			return layers.SetBase("risk", base)
+		}},
+		{"unknown breaker", func() error {
+			return layers.SetLayer("file", map[string]Override{"billing": setFailureThreshold(2)})
+		}},
+		{"unknown layer", func() error {
+			_, err := layers.Add("env", "risk", setFailureThreshold(2))
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.change(); err == nil {
+				t.Fatal("Change succeeded, want an error")
+			}
+			if risk.GetConfig() != DefaultConfig("risk") || audit.GetConfig() != DefaultConfig("audit") {
+				t.Errorf("Rejected change updated a breaker: %+v, %+v", risk.GetConfig(), audit.GetConfig())
+			}
+		})
+	}
+
+	// A rejected override is not kept for later changes either
+	if err := layers.SetBase("risk", DefaultConfig("risk")); err != nil {
+		t.Errorf("SetBase() after rejected overrides = %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package circuitbreaker
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Registry creates breakers on first use and keeps them by name. Every
+// breaker starts from the template configuration with its own name.
+type Registry struct {
+	template Config
+	logger   *zap.Logger
+
+	mutex    sync.RWMutex
+	breakers map[string]*CircuitBreaker
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry(template Config, logger *zap.Logger) *Registry {
+	return &Registry{
+		template: template,
+		logger:   logger,
+		breakers: make(map[string]*CircuitBreaker),
+	}
+}
+
+// Get returns the breaker with the given name, creating it if needed
+func (r *Registry) Get(name string) *CircuitBreaker {
+	r.mutex.RLock()
+	cb, exists := r.breakers[name]
+	r.mutex.RUnlock()
+	if exists {
+		return cb
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if cb, exists := r.breakers[name]; exists {
+		return cb
+	}
+
+	config := r.template
+	config.Name = name
+	cb = NewCircuitBreaker(config, r.logger)
+	r.breakers[name] = cb
+
+	return cb
+}
+
+// All returns a snapshot of the registered breakers
+func (r *Registry) All() map[string]*CircuitBreaker {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	breakers := make(map[string]*CircuitBreaker, len(r.breakers))
+	for name, cb := range r.breakers {
+		breakers[name] = cb
+	}
+	return breakers
+}
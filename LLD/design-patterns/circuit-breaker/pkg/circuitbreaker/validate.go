@@ -0,0 +1,86 @@
+package circuitbreaker
+
+import (
+	"strings"
+)
+
+// FieldError describes a single invalid configuration field
+type FieldError struct {
+	Field   string // Dotted yaml path, e.g. "circuit_breaker.timeout"
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// ValidationErrors aggregates every problem found in a configuration
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Error()
+	}
+	return "invalid configuration: " + strings.Join(messages, "; ")
+}
+
+// Add records a problem with the given field
+func (e *ValidationErrors) Add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// Merge appends the errors of a nested configuration under the given prefix
+func (e *ValidationErrors) Merge(prefix string, err error) {
+	nested, ok := err.(ValidationErrors)
+	if !ok {
+		if err != nil {
+			e.Add(prefix, err.Error())
+		}
+		return
+	}
+
+	for _, fieldErr := range nested {
+		e.Add(prefix+"."+fieldErr.Field, fieldErr.Message)
+	}
+}
+
+// Err returns nil when no errors were recorded, so callers can return it directly
+func (e ValidationErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks that the configuration describes a breaker that can both
+// open and recover. All problems are reported at once.
+func (c Config) Validate() error {
+	var errs ValidationErrors
+
+	if c.Name == "" {
+		errs.Add("name", "is required")
+	}
+	if c.MaxRequests == 0 {
+		errs.Add("max_requests", "must be greater than 0")
+	}
+	if c.Interval < 0 {
+		errs.Add("interval", "must not be negative")
+	}
+	if c.Timeout <= 0 {
+		errs.Add("timeout", "must be greater than 0")
+	}
+	if c.FailureThreshold == 0 {
+		errs.Add("failure_threshold", "must be greater than 0")
+	}
+	if c.SuccessThreshold == 0 {
+		errs.Add("success_threshold", "must be greater than 0")
+	} else if c.MaxRequests > 0 && c.SuccessThreshold > c.MaxRequests {
+		errs.Add("success_threshold", "must not exceed max_requests")
+	}
+	if c.FailureRateThreshold <= 0 || c.FailureRateThreshold > 1 {
+		errs.Add("failure_rate_threshold", "must be in (0,1]")
+	}
+
+	return errs.Err()
+}
@@ -0,0 +1,88 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// invalidFields returns the fields err reports, or nil for no error
+func invalidFields(t *testing.T, err error) []string {
+	t.Helper()
+
+	if err == nil {
+		return nil
+	}
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Error = %v, want ValidationErrors", err)
+	}
+	fields := make([]string, len(errs))
+	for i, fieldErr := range errs {
+		fields[i] = fieldErr.Field
+	}
+	return fields
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{"default", func(c *Config) {}, nil},
+		{"no name", func(c *Config) { c.Name = "" }, []string{"name"}},
+		{"no half-open requests", func(c *Config) { c.MaxRequests = 0 }, []string{"max_requests"}},
+		{"negative interval", func(c *Config) { c.Interval = -time.Second }, []string{"interval"}},
+		{"zero interval", func(c *Config) { c.Interval = 0 }, nil},
+		{"zero timeout", func(c *Config) { c.Timeout = 0 }, []string{"timeout"}},
+		{"zero failure threshold", func(c *Config) { c.FailureThreshold = 0 }, []string{"failure_threshold"}},
+		{"zero success threshold", func(c *Config) { c.SuccessThreshold = 0 }, []string{"success_threshold"}},
+		{"success threshold above max requests", func(c *Config) {
+			c.MaxRequests = 2
+			c.SuccessThreshold = 3
+		}, []string{"success_threshold"}},
+		{"success threshold of max requests", func(c *Config) {
+			c.MaxRequests = 3
+			c.SuccessThreshold = 3
+		}, nil},
+		{"zero failure rate", func(c *Config) { c.FailureRateThreshold = 0 }, []string{"failure_rate_threshold"}},
+		{"failure rate above 1", func(c *Config) { c.FailureRateThreshold = 1.5 }, []string{"failure_rate_threshold"}},
+		{"failure rate of 1", func(c *Config) { c.FailureRateThreshold = 1 }, nil},
+		{"every problem at once", func(c *Config) {
+			c.MaxRequests = 0
+			c.Timeout = -time.Second
+			c.FailureRateThreshold = 2
+		}, []string{"max_requests", "timeout", "failure_rate_threshold"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig("test")
+			tt.change(&config)
+
+			got := invalidFields(t, config.Validate())
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Invalid fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationErrorsMerge(t *testing.T) {
+	var errs ValidationErrors
+	errs.Merge("services.risk.circuit_breaker", ValidationErrors{
+		{Field: "timeout", Message: "must be greater than 0"},
+	})
+	errs.Merge("services.audit", errors.New("unreachable"))
+	errs.Merge("services.portfolio", nil)
+
+	want := "invalid configuration: services.risk.circuit_breaker.timeout must be greater than 0; services.audit unreachable"
+	if err := errs.Err(); err == nil || err.Error() != want {
+		t.Errorf("Err() = %v, want %q", err, want)
+	}
+	if err := (ValidationErrors{}).Err(); err != nil {
+		t.Errorf("Err() without errors = %v, want nil", err)
+	}
+}
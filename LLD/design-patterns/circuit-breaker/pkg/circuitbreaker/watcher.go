@@ -0,0 +1,178 @@
+package circuitbreaker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// overridesFile is the on-disk format read by FileWatcher. Each entry only
+// needs the fields being changed; omitted fields keep their current value.
+//
+//	circuit_breakers:
+//	  risk-management-service:
+//	    failure_rate_threshold: 0.25
+type overridesFile struct {
+	CircuitBreakers map[string]yaml.Node `yaml:"circuit_breakers"`
+}
+
+// FileWatcher polls a YAML file and applies breaker overrides to running
+// breakers when the file changes
+type FileWatcher struct {
+	path     string
+	interval time.Duration
+	logger   *zap.Logger
+
+	mutex    sync.Mutex
+	breakers map[string]*CircuitBreaker
+	layers   *Layers
+	layer    string
+	modTime  time.Time
+	size     int64
+}
+
+// NewFileWatcher creates a watcher for the given breakers
+func NewFileWatcher(path string, interval time.Duration, logger *zap.Logger, breakers ...*CircuitBreaker) *FileWatcher {
+	w := &FileWatcher{
+		path:     path,
+		interval: interval,
+		logger:   logger,
+		breakers: make(map[string]*CircuitBreaker, len(breakers)),
+	}
+
+	for _, cb := range breakers {
+		w.breakers[cb.name] = cb
+	}
+
+	return w
+}
+
+// UseLayers makes the file one layer of layers: each reload replaces that
+// layer instead of updating the breakers directly, so the file's overrides
+// keep their precedence over the base configuration and below later
+// layers. Call it before Start.
+func (w *FileWatcher) UseLayers(layers *Layers, layer string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.layers = layers
+	w.layer = layer
+}
+
+// Start polls the file until the context is cancelled
+func (w *FileWatcher) Start(ctx context.Context) {
+	if err := w.Reload(); err != nil {
+		w.logger.Error("Failed to apply circuit breaker overrides",
+			zap.String("path", w.path),
+			zap.Error(err),
+		)
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := w.changed()
+			if err != nil {
+				w.logger.Warn("Failed to stat circuit breaker overrides",
+					zap.String("path", w.path),
+					zap.Error(err),
+				)
+				continue
+			}
+			if !changed {
+				continue
+			}
+
+			if err := w.Reload(); err != nil {
+				w.logger.Error("Failed to apply circuit breaker overrides",
+					zap.String("path", w.path),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+// changed reports whether the file was modified since the last reload
+func (w *FileWatcher) changed() (bool, error) {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return false, err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return !info.ModTime().Equal(w.modTime) || info.Size() != w.size, nil
+}
+
+// Reload reads the file and applies every override in it. All entries are
+// validated before any breaker is updated, so a bad file changes nothing.
+func (w *FileWatcher) Reload() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return err
+	}
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return err
+	}
+
+	var file overridesFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	overrides := make(map[string]Override, len(file.CircuitBreakers))
+	for name, node := range file.CircuitBreakers {
+		if _, exists := w.breakers[name]; !exists {
+			return fmt.Errorf("unknown circuit breaker %q", name)
+		}
+		node := node
+		overrides[name] = func(config *Config) error {
+			return node.Decode(config)
+		}
+	}
+	if w.layers != nil {
+		return w.layers.SetLayer(w.layer, overrides)
+	}
+
+	updates := make(map[*CircuitBreaker]Config, len(overrides))
+	for name, override := range overrides {
+		cb := w.breakers[name]
+		config := cb.GetConfig()
+		if err := override(&config); err != nil {
+			return fmt.Errorf("circuit breaker %q: %w", name, err)
+		}
+		config.Name = name
+
+		if err := config.Validate(); err != nil {
+			return fmt.Errorf("circuit breaker %q: %w", name, err)
+		}
+		updates[cb] = config
+	}
+
+	for cb, config := range updates {
+		if err := cb.UpdateConfig(config); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package circuitbreaker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// writeOverrides writes an overrides file into a temporary directory and
+// returns its path
+func writeOverrides(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "circuit-breakers.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileWatcherAppliesPartialOverrides(t *testing.T) {
+	risk := NewCircuitBreaker(DefaultConfig("risk"), zap.NewNop())
+	audit := NewCircuitBreaker(DefaultConfig("audit"), zap.NewNop())
+	path := writeOverrides(t, `
+circuit_breakers:
+  risk:
+    failure_rate_threshold: 0.25
+    timeout: 5s
+`)
+
+	if err := NewFileWatcher(path, time.Second, zap.NewNop(), risk, audit).Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := DefaultConfig("risk")
+	want.FailureRateThreshold = 0.25
+	want.Timeout = 5 * time.Second
+	if got := risk.GetConfig(); got != want {
+		t.Errorf("Risk config = %+v, want %+v", got, want)
+	}
+	if got := audit.GetConfig(); got != DefaultConfig("audit") {
+		t.Errorf("Audit config = %+v, want it untouched", got)
+	}
+}
+
+func TestFileWatcherRejectsBadFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"unknown breaker", "circuit_breakers:\n  billing:\n    timeout: 5s\n"},
+		{"invalid value", "circuit_breakers:\n  audit:\n    timeout: 5s\n  risk:\n    failure_rate_threshold: 2\n"},
+		{"malformed yaml", "circuit_breakers: [\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			risk := NewCircuitBreaker(DefaultConfig("risk"), zap.NewNop())
+			audit := NewCircuitBreaker(DefaultConfig("audit"), zap.NewNop())
+
+			watcher := NewFileWatcher(writeOverrides(t, tt.content), time.Second, zap.NewNop(), risk, audit)
+			if err := watcher.Reload(); err == nil {
+				t.Fatal("Reload() succeeded, want an error")
+			}
+			if risk.GetConfig() != DefaultConfig("risk") || audit.GetConfig() != DefaultConfig("audit") {
+				t.Error("Rejected file updated a breaker")
+			}
+		})
+	}
+}
+
+func TestFileWatcherUsesLayers(t *testing.T) {
+	risk := NewCircuitBreaker(DefaultConfig("risk"), zap.NewNop())
+	layers := NewLayers([]string{"file", "admin"}, risk)
+	if _, err := layers.Add("admin", "risk", setTimeout(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeOverrides(t, "circuit_breakers:\n  risk:\n    timeout: 5s\n    failure_threshold: 3\n")
+	watcher := NewFileWatcher(path, time.Second, zap.NewNop(), risk)
+	watcher.UseLayers(layers, "file")
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := risk.GetConfig()
+	if got.FailureThreshold != 3 || got.Timeout != time.Second {
+		t.Errorf("Config = %+v, want the file's threshold under the admin timeout", got)
+	}
+
+	// Emptying the file drops its overrides but keeps the admin's
+	if err := os.WriteFile(path, []byte("circuit_breakers: {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	got = risk.GetConfig()
+	if got.FailureThreshold != DefaultConfig("risk").FailureThreshold || got.Timeout != time.Second {
+		t.Errorf("Config after emptying the file = %+v, want the base with the admin timeout", got)
+	}
+}
+
+func TestFileWatcherChanged(t *testing.T) {
+	risk := NewCircuitBreaker(DefaultConfig("risk"), zap.NewNop())
+	path := writeOverrides(t, "circuit_breakers:\n  risk:\n    timeout: 5s\n")
+	watcher := NewFileWatcher(path, time.Second, zap.NewNop(), risk)
+
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := watcher.changed(); err != nil || changed {
+		t.Errorf("changed() right after Reload = %v, %v, want false", changed, err)
+	}
+
+	if err := os.WriteFile(path, []byte("circuit_breakers:\n  risk:\n    timeout: 10s\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := watcher.changed(); err != nil || !changed {
+		t.Errorf("changed() after a write = %v, %v, want true", changed, err)
+	}
+}
@@ -1,100 +1,565 @@
 package config
 
 import (
-	"os"
+	"fmt"
+	"strings"
 	"time"
 
-	"gopkg.in/yaml.v3"
+	"circuit-breaker-demo/pkg/auth"
+	"circuit-breaker-demo/pkg/circuitbreaker"
+	"circuit-breaker-demo/pkg/httpclient"
+	"circuit-breaker-demo/pkg/idempotency"
+	"circuit-breaker-demo/pkg/loadshed"
+	"circuit-breaker-demo/pkg/ratelimit"
 )
 
 // Config holds the application configuration
 type Config struct {
-	Server struct {
-		Port         int           `yaml:"port"`
-		ReadTimeout  time.Duration `yaml:"read_timeout"`
-		WriteTimeout time.Duration `yaml:"write_timeout"`
-	} `yaml:"server"`
-
-	CircuitBreaker struct {
-		MaxRequests          uint32        `yaml:"max_requests"`
-		Interval             time.Duration `yaml:"interval"`
-		Timeout              time.Duration `yaml:"timeout"`
-		FailureThreshold     uint32        `yaml:"failure_threshold"`
-		SuccessThreshold     uint32        `yaml:"success_threshold"`
-		FailureRateThreshold float64       `yaml:"failure_rate_threshold"`
-		MinimumRequests      uint32        `yaml:"minimum_requests"`
-	} `yaml:"circuit_breaker"`
-
-	Services struct {
-		MarketData struct {
-			URL     string        `yaml:"url"`
-			Timeout time.Duration `yaml:"timeout"`
-		} `yaml:"market_data"`
-
-		RiskManagement struct {
-			URL     string        `yaml:"url"`
-			Timeout time.Duration `yaml:"timeout"`
-		} `yaml:"risk_management"`
-
-		Notification struct {
-			URL     string        `yaml:"url"`
-			Timeout time.Duration `yaml:"timeout"`
-		} `yaml:"notification"`
-
-		Audit struct {
-			URL     string        `yaml:"url"`
-			Timeout time.Duration `yaml:"timeout"`
-		} `yaml:"audit"`
-	} `yaml:"services"`
-
-	Logging struct {
-		Level  string `yaml:"level"`
-		Format string `yaml:"format"`
-	} `yaml:"logging"`
-
-	Metrics struct {
-		Enabled bool   `yaml:"enabled"`
-		Port    int    `yaml:"port"`
-		Path    string `yaml:"path"`
-	} `yaml:"metrics"`
-}
-
-// LoadConfig loads configuration from file
-func LoadConfig(filename string) (*Config, error) {
-	data, err := os.ReadFile(filename)
+	Server         ServerConfig       `yaml:"server"`
+	LoadShedding   LoadSheddingConfig `yaml:"load_shedding"`
+	RateLimiting   RateLimitingConfig `yaml:"rate_limiting"`
+	Auth           AuthConfig         `yaml:"auth"`
+	Idempotency    IdempotencyConfig  `yaml:"idempotency"`
+	Client         ClientConfig       `yaml:"client"`          // Defaults for every entry under services
+	CircuitBreaker BreakerConfig      `yaml:"circuit_breaker"` // Default breaker for every entry under services
+	Services       ServicesConfig     `yaml:"services"`
+	Logging        LoggingConfig      `yaml:"logging"`
+	Metrics        MetricsConfig      `yaml:"metrics"`
+}
+
+// ServerConfig holds the HTTP server settings
+type ServerConfig struct {
+	Port         int           `yaml:"port"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+
+	// RequestBudget bounds the handling of one request, e.g. a trade with all
+	// its upstream calls. A shorter X-Request-Deadline from the caller wins;
+	// 0 leaves requests unbounded unless the caller sets one.
+	RequestBudget time.Duration `yaml:"request_budget"`
+
+	// GRPCPort is the port of the gRPC listener of services that offer one;
+	// 0 disables it
+	GRPCPort int `yaml:"grpc_port"`
+}
+
+// LoadSheddingConfig holds the load shedding settings of the HTTP server.
+// Low priority requests are shed at half of each limit, normal ones at
+// 80% and critical ones at the full limit.
+type LoadSheddingConfig struct {
+	Enabled         bool                  `yaml:"enabled"`
+	MaxInFlight     int                   `yaml:"max_in_flight"`  // Requests handled at once; 0 disables the limit
+	MaxQueueTime    time.Duration         `yaml:"max_queue_time"` // Wait for a slot before shedding; 0 sheds at once
+	MaxCPU          float64               `yaml:"max_cpu"`        // Process CPU utilization in (0,1]; 0 disables CPU shedding
+	RetryAfter      time.Duration         `yaml:"retry_after"`
+	DefaultPriority string                `yaml:"default_priority"` // low, normal or critical
+	Routes          []RoutePriorityConfig `yaml:"routes,omitempty"`
+}
+
+// RoutePriorityConfig sets the priority of one route
+type RoutePriorityConfig struct {
+	Route    string `yaml:"route"` // Method and path pattern, e.g. "POST /api/v1/trades"
+	Priority string `yaml:"priority"`
+}
+
+// RateLimitingConfig holds the per-client rate limits of the HTTP server
+type RateLimitingConfig struct {
+	Enabled bool                  `yaml:"enabled"`
+	Rules   []RateLimitRuleConfig `yaml:"rules,omitempty"`
+}
+
+// RateLimitRuleConfig limits one route
+type RateLimitRuleConfig struct {
+	Route     string        `yaml:"route"`     // Method and path pattern, e.g. "POST /api/v1/trades"
+	Algorithm string        `yaml:"algorithm"` // token_bucket or sliding_window
+	Rate      int           `yaml:"rate"`      // Requests per period
+	Period    time.Duration `yaml:"period"`
+	Burst     int           `yaml:"burst,omitempty"` // Token bucket capacity; defaults to rate
+	Keys      []string      `yaml:"keys"`            // api_key, user or ip, tried in order; only verified credentials count
+}
+
+// AuthConfig holds the authentication settings of the HTTP server
+type AuthConfig struct {
+	Enabled      bool           `yaml:"enabled"`
+	APIKeySecret string         `yaml:"api_key_secret"` // Signs API keys; set from TRADING_AUTH_API_KEY_SECRET, never committed
+	APIKeys      []APIKeyConfig `yaml:"api_keys,omitempty"`
+	JWKSFile     string         `yaml:"jwks_file"` // Public keys for bearer tokens; empty disables them
+	Issuer       string         `yaml:"issuer"`    // Required iss claim of bearer tokens
+	Audience     string         `yaml:"audience"`  // Required aud claim of bearer tokens
+	ClockSkew    time.Duration  `yaml:"clock_skew"`
+}
+
+// APIKeyConfig lists an issued API key; the key itself is derived from the
+// ID and api_key_secret
+type APIKeyConfig struct {
+	ID      string   `yaml:"id"`
+	Subject string   `yaml:"subject"` // The user the key acts for
+	Roles   []string `yaml:"roles"`
+}
+
+// IdempotencyConfig holds the Idempotency-Key settings of the HTTP server
+type IdempotencyConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"` // How long a key is held and its response replayed
+}
+
+// ClientConfig holds the default policy for calls to upstream services
+type ClientConfig struct {
+	Timeout          time.Duration     `yaml:"timeout"`
+	UserAgent        string            `yaml:"user_agent"`
+	Retry            RetryConfig       `yaml:"retry"`
+	HealthCheck      HealthCheckConfig `yaml:"health_check"`      // Applies to every service
+	OutlierDetection OutlierConfig     `yaml:"outlier_detection"` // Applies to every service
+}
+
+// HealthCheckConfig holds the active health check settings
+type HealthCheckConfig struct {
+	Path               string        `yaml:"path"`
+	Interval           time.Duration `yaml:"interval"` // 0 disables health checks
+	Timeout            time.Duration `yaml:"timeout"`
+	UnhealthyThreshold int           `yaml:"unhealthy_threshold"`
+	HealthyThreshold   int           `yaml:"healthy_threshold"`
+}
+
+// OutlierConfig holds the outlier detection settings. The n-th ejection of
+// an endpoint lasts n times base_ejection_time, capped at max_ejection_time.
+type OutlierConfig struct {
+	ConsecutiveErrors int           `yaml:"consecutive_errors"` // 0 disables outlier detection
+	BaseEjectionTime  time.Duration `yaml:"base_ejection_time"`
+	MaxEjectionTime   time.Duration `yaml:"max_ejection_time"`
+}
+
+// BreakerConfig holds circuit breaker settings. Inside a service entry a
+// zero field means "inherit the global circuit_breaker value".
+type BreakerConfig struct {
+	MaxRequests          uint32        `yaml:"max_requests,omitempty"`
+	Interval             time.Duration `yaml:"interval,omitempty"`
+	Timeout              time.Duration `yaml:"timeout,omitempty"`
+	FailureThreshold     uint32        `yaml:"failure_threshold,omitempty"`
+	SuccessThreshold     uint32        `yaml:"success_threshold,omitempty"`
+	FailureRateThreshold float64       `yaml:"failure_rate_threshold,omitempty"`
+	MinimumRequests      uint32        `yaml:"minimum_requests,omitempty"`
+}
+
+// RetryConfig holds the retry policy for idempotent requests. Inside a
+// service entry a zero field means "inherit the client.retry value".
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts,omitempty"` // 1 disables retries
+	InitialBackoff time.Duration `yaml:"initial_backoff,omitempty"`
+	MaxBackoff     time.Duration `yaml:"max_backoff,omitempty"`
+	Multiplier     float64       `yaml:"multiplier,omitempty"`
+}
+
+// ServiceConfig describes one upstream service. Timeout, CircuitBreaker and
+// Retry are optional and fall back to the global defaults. Endpoints, when
+// set, takes precedence over URL and lists several instances that each get
+// their own breaker.
+type ServiceConfig struct {
+	URL            string           `yaml:"url,omitempty"`
+	Endpoints      []EndpointConfig `yaml:"endpoints,omitempty"`
+	Balancer       string           `yaml:"balancer,omitempty"` // round_robin (default), weighted, least_outstanding or p2c
+	Timeout        time.Duration    `yaml:"timeout,omitempty"`
+	CircuitBreaker BreakerConfig    `yaml:"circuit_breaker,omitempty"`
+	Retry          RetryConfig      `yaml:"retry,omitempty"`
+	Hedge          HedgeConfig      `yaml:"hedge,omitempty"`
+	Cache          CacheConfig      `yaml:"cache,omitempty"`
+	Batch          BatchConfig      `yaml:"batch,omitempty"`
+	AuthToken      string           `yaml:"auth_token,omitempty"` // Sent as a bearer token; prefer the environment variable
+}
+
+// BatchConfig holds the batching policy for lookups that the gateway
+// collects into one batch request (market data quotes)
+type BatchConfig struct {
+	MaxWait time.Duration `yaml:"max_wait,omitempty"` // Send a batch this long after its first lookup
+	MaxSize int           `yaml:"max_size,omitempty"` // Send a batch once it holds this many keys
+}
+
+// CacheConfig holds the coalescing and caching policy for GET requests
+type CacheConfig struct {
+	Coalesce   bool          `yaml:"coalesce,omitempty"`    // Concurrent GETs for the same path share one upstream call
+	TTL        time.Duration `yaml:"ttl,omitempty"`         // Micro-cache lifetime of 200 responses; 0 disables caching
+	MaxEntries int           `yaml:"max_entries,omitempty"` // Defaults to 1000
+}
+
+// HedgeConfig holds the hedging policy for GET requests to a service with
+// several endpoints
+type HedgeConfig struct {
+	Delay  time.Duration `yaml:"delay,omitempty"`  // Send a second request after this long; 0 disables hedging
+	Budget float64       `yaml:"budget,omitempty"` // Hedges allowed per request, e.g. 0.1
+}
+
+// EndpointConfig describes one instance of a service
+type EndpointConfig struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight,omitempty"` // Used by the weighted balancer; defaults to 1
+}
+
+// ServicesConfig lists the upstream services called by the gateway
+type ServicesConfig struct {
+	MarketData     ServiceConfig `yaml:"market_data"`
+	Portfolio      ServiceConfig `yaml:"portfolio"`
+	RiskManagement ServiceConfig `yaml:"risk_management"`
+	Notification   ServiceConfig `yaml:"notification"`
+	Audit          ServiceConfig `yaml:"audit"`
+}
+
+// LoggingConfig holds the logger settings
+type LoggingConfig struct {
+	Level    string         `yaml:"level"`  // debug, info, warn or error
+	Format   string         `yaml:"format"` // json or console
+	Sampling SamplingConfig `yaml:"sampling"`
+}
+
+// SamplingConfig limits repeated log entries per second: the first Initial
+// entries with the same message are logged, then every Thereafter-th one.
+// Initial 0 disables sampling.
+type SamplingConfig struct {
+	Initial    int `yaml:"initial"`
+	Thereafter int `yaml:"thereafter"`
+}
+
+// MetricsConfig holds the Prometheus endpoint settings
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Port    int    `yaml:"port"` // Separate listener; 0 or the server port mounts metrics on the API router
+	Path    string `yaml:"path"`
+}
+
+// ResolvedService is a service entry with every default applied
+type ResolvedService struct {
+	Name             string // Breaker and metrics name, e.g. "market-data-service"
+	URL              string
+	Endpoints        []EndpointConfig // Always at least one; a lone url becomes a single endpoint
+	Balancer         string
+	Timeout          time.Duration
+	CircuitBreaker   circuitbreaker.Config
+	Retry            httpclient.RetryPolicy
+	Hedge            httpclient.HedgePolicy
+	Cache            httpclient.CachePolicy
+	Batch            httpclient.BatchPolicy
+	HealthCheck      httpclient.HealthCheck
+	OutlierDetection httpclient.OutlierDetection
+	UserAgent        string
+	AuthToken        string
+}
+
+// serviceEntry pairs a service with its yaml key
+type serviceEntry struct {
+	key     string
+	service *ServiceConfig
+}
+
+// entries returns every service with its yaml key, in declaration order
+func (s *ServicesConfig) entries() []serviceEntry {
+	return []serviceEntry{
+		{"market_data", &s.MarketData},
+		{"portfolio", &s.Portfolio},
+		{"risk_management", &s.RiskManagement},
+		{"notification", &s.Notification},
+		{"audit", &s.Audit},
+	}
+}
+
+// inherit returns b with its zero fields taken from defaults
+func (b BreakerConfig) inherit(defaults BreakerConfig) BreakerConfig {
+	if b.MaxRequests == 0 {
+		b.MaxRequests = defaults.MaxRequests
+	}
+	if b.Interval == 0 {
+		b.Interval = defaults.Interval
+	}
+	if b.Timeout == 0 {
+		b.Timeout = defaults.Timeout
+	}
+	if b.FailureThreshold == 0 {
+		b.FailureThreshold = defaults.FailureThreshold
+	}
+	if b.SuccessThreshold == 0 {
+		b.SuccessThreshold = defaults.SuccessThreshold
+	}
+	if b.FailureRateThreshold == 0 {
+		b.FailureRateThreshold = defaults.FailureRateThreshold
+	}
+	if b.MinimumRequests == 0 {
+		b.MinimumRequests = defaults.MinimumRequests
+	}
+	return b
+}
+
+// Breaker converts the settings into a circuitbreaker.Config with the given name
+func (b BreakerConfig) Breaker(name string) circuitbreaker.Config {
+	return circuitbreaker.Config{
+		Name:                 name,
+		MaxRequests:          b.MaxRequests,
+		Interval:             b.Interval,
+		Timeout:              b.Timeout,
+		FailureThreshold:     b.FailureThreshold,
+		SuccessThreshold:     b.SuccessThreshold,
+		FailureRateThreshold: b.FailureRateThreshold,
+		MinimumRequests:      b.MinimumRequests,
+	}
+}
+
+// inherit returns r with its zero fields taken from defaults
+func (r RetryConfig) inherit(defaults RetryConfig) RetryConfig {
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = defaults.MaxAttempts
+	}
+	if r.InitialBackoff == 0 {
+		r.InitialBackoff = defaults.InitialBackoff
+	}
+	if r.MaxBackoff == 0 {
+		r.MaxBackoff = defaults.MaxBackoff
+	}
+	if r.Multiplier == 0 {
+		r.Multiplier = defaults.Multiplier
+	}
+	return r
+}
+
+// Policy converts the settings into an httpclient.RetryPolicy
+func (r RetryConfig) Policy() httpclient.RetryPolicy {
+	return httpclient.RetryPolicy{
+		MaxAttempts:    r.MaxAttempts,
+		InitialBackoff: r.InitialBackoff,
+		MaxBackoff:     r.MaxBackoff,
+		Multiplier:     r.Multiplier,
+	}
+}
+
+// Policy converts the settings into an httpclient.HedgePolicy
+func (h HedgeConfig) Policy() httpclient.HedgePolicy {
+	return httpclient.HedgePolicy{
+		Delay:  h.Delay,
+		Budget: h.Budget,
+	}
+}
+
+// Policy converts the settings into an httpclient.CachePolicy
+func (c CacheConfig) Policy() httpclient.CachePolicy {
+	return httpclient.CachePolicy{
+		Coalesce:   c.Coalesce,
+		TTL:        c.TTL,
+		MaxEntries: c.MaxEntries,
+	}
+}
+
+// Policy converts the settings into an httpclient.BatchPolicy
+func (b BatchConfig) Policy() httpclient.BatchPolicy {
+	return httpclient.BatchPolicy{
+		MaxWait: b.MaxWait,
+		MaxSize: b.MaxSize,
+	}
+}
+
+// Policy converts the settings into a loadshed.Policy. A disabled or
+// invalid configuration sheds nothing; Validate reports the latter.
+func (l LoadSheddingConfig) Policy() loadshed.Policy {
+	if !l.Enabled {
+		return loadshed.Policy{}
+	}
+
+	defaultPriority, err := loadshed.ParsePriority(l.DefaultPriority)
 	if err != nil {
-		return nil, err
+		return loadshed.Policy{}
+	}
+	routes := make(map[string]loadshed.Priority, len(l.Routes))
+	for _, route := range l.Routes {
+		priority, err := loadshed.ParsePriority(route.Priority)
+		if err != nil {
+			return loadshed.Policy{}
+		}
+		method, path, _ := splitRoute(route.Route)
+		routes[loadshed.RouteKey(method, path)] = priority
 	}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	return loadshed.Policy{
+		MaxInFlight:     l.MaxInFlight,
+		MaxQueueTime:    l.MaxQueueTime,
+		MaxCPU:          l.MaxCPU,
+		RetryAfter:      l.RetryAfter,
+		DefaultPriority: defaultPriority,
+		Routes:          routes,
+	}
+}
+
+// Policy converts the settings into a ratelimit.Policy. A disabled
+// configuration limits nothing.
+func (r RateLimitingConfig) Policy() ratelimit.Policy {
+	policy := ratelimit.Policy{Rules: make(map[string]ratelimit.Rule)}
+	if !r.Enabled {
+		return policy
+	}
+
+	for _, rule := range r.Rules {
+		method, path, _ := splitRoute(rule.Route)
+		policy.Rules[ratelimit.RouteKey(method, path)] = ratelimit.Rule{
+			Limit: ratelimit.Limit{
+				Algorithm: rule.Algorithm,
+				Rate:      rule.Rate,
+				Period:    rule.Period,
+				Burst:     rule.Burst,
+			},
+			Keys: rule.Keys,
+		}
+	}
+	return policy
+}
+
+// Policy converts the settings into an auth.Policy
+func (a AuthConfig) Policy() auth.Policy {
+	keys := make(map[string]auth.APIKey, len(a.APIKeys))
+	for _, key := range a.APIKeys {
+		keys[key.ID] = auth.APIKey{ID: key.ID, Subject: key.Subject, Roles: key.Roles}
+	}
+
+	return auth.Policy{
+		Enabled:      a.Enabled,
+		APIKeySecret: []byte(a.APIKeySecret),
+		APIKeys:      keys,
+		JWKSFile:     a.JWKSFile,
+		Issuer:       a.Issuer,
+		Audience:     a.Audience,
+		ClockSkew:    a.ClockSkew,
+	}
+}
+
+// Policy converts the settings into an idempotency.Policy
+func (i IdempotencyConfig) Policy() idempotency.Policy {
+	return idempotency.Policy{Enabled: i.Enabled, TTL: i.TTL}
+}
+
+// splitRoute splits a route such as "POST /api/v1/trades" into its method
+// and path pattern
+func splitRoute(route string) (method, path string, ok bool) {
+	method, path, found := strings.Cut(strings.TrimSpace(route), " ")
+	path = strings.TrimSpace(path)
+	return method, path, found && method != "" && strings.HasPrefix(path, "/")
+}
+
+// Check converts the settings into an httpclient.HealthCheck
+func (h HealthCheckConfig) Check() httpclient.HealthCheck {
+	return httpclient.HealthCheck{
+		Path:               h.Path,
+		Interval:           h.Interval,
+		Timeout:            h.Timeout,
+		UnhealthyThreshold: h.UnhealthyThreshold,
+		HealthyThreshold:   h.HealthyThreshold,
+	}
+}
+
+// Detection converts the settings into an httpclient.OutlierDetection
+func (o OutlierConfig) Detection() httpclient.OutlierDetection {
+	return httpclient.OutlierDetection{
+		ConsecutiveErrors: o.ConsecutiveErrors,
+		BaseEjectionTime:  o.BaseEjectionTime,
+		MaxEjectionTime:   o.MaxEjectionTime,
+	}
+}
+
+// Resolve applies the global client and circuit breaker defaults to a
+// service entry. name is used for the breaker and its metrics.
+func (c *Config) Resolve(name string, service ServiceConfig) ResolvedService {
+	timeout := service.Timeout
+	if timeout == 0 {
+		timeout = c.Client.Timeout
+	}
+
+	endpoints := service.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = []EndpointConfig{{URL: service.URL, Weight: 1}}
+	}
+
+	balancer := service.Balancer
+	if balancer == "" {
+		balancer = httpclient.StrategyRoundRobin
+	}
+
+	return ResolvedService{
+		Name:             name,
+		URL:              service.URL,
+		Endpoints:        endpoints,
+		Balancer:         balancer,
+		Timeout:          timeout,
+		CircuitBreaker:   service.CircuitBreaker.inherit(c.CircuitBreaker).Breaker(name),
+		Retry:            service.Retry.inherit(c.Client.Retry).Policy(),
+		Hedge:            service.Hedge.Policy(),
+		Cache:            service.Cache.Policy(),
+		Batch:            service.Batch.Policy(),
+		HealthCheck:      c.Client.HealthCheck.Check(),
+		OutlierDetection: c.Client.OutlierDetection.Detection(),
+		UserAgent:        c.Client.UserAgent,
+		AuthToken:        service.AuthToken,
+	}
+}
+
+// ServiceName returns the conventional service name for a services key,
+// e.g. "market_data" becomes "market-data-service"
+func ServiceName(key string) string {
+	return strings.ReplaceAll(key, "_", "-") + "-service"
+}
+
+// LoadConfig loads configuration from file. Values missing from the file
+// keep their defaults; unknown keys and invalid values are rejected.
+func LoadConfig(filename string) (*Config, error) {
+	config := DefaultConfig()
+	if _, err := decodeFile(filename, config); err != nil {
 		return nil, err
 	}
 
-	return &config, nil
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return config, nil
 }
 
 // DefaultConfig returns default configuration
 func DefaultConfig() *Config {
 	return &Config{
-		Server: struct {
-			Port         int           `yaml:"port"`
-			ReadTimeout  time.Duration `yaml:"read_timeout"`
-			WriteTimeout time.Duration `yaml:"write_timeout"`
-		}{
+		Server: ServerConfig{
 			Port:         8080,
 			ReadTimeout:  10 * time.Second,
 			WriteTimeout: 10 * time.Second,
 		},
-		CircuitBreaker: struct {
-			MaxRequests          uint32        `yaml:"max_requests"`
-			Interval             time.Duration `yaml:"interval"`
-			Timeout              time.Duration `yaml:"timeout"`
-			FailureThreshold     uint32        `yaml:"failure_threshold"`
-			SuccessThreshold     uint32        `yaml:"success_threshold"`
-			FailureRateThreshold float64       `yaml:"failure_rate_threshold"`
-			MinimumRequests      uint32        `yaml:"minimum_requests"`
-		}{
+		LoadShedding: LoadSheddingConfig{
+			Enabled:         true,
+			MaxInFlight:     100,
+			MaxQueueTime:    100 * time.Millisecond,
+			MaxCPU:          0.9,
+			RetryAfter:      time.Second,
+			DefaultPriority: "normal",
+		},
+		Auth: AuthConfig{
+			ClockSkew: 30 * time.Second,
+		},
+		Idempotency: IdempotencyConfig{
+			Enabled: true,
+			TTL:     24 * time.Hour,
+		},
+		Client: ClientConfig{
+			Timeout:   5 * time.Second,
+			UserAgent: "trading-gateway/1.0",
+			Retry: RetryConfig{
+				MaxAttempts:    1,
+				InitialBackoff: 100 * time.Millisecond,
+				MaxBackoff:     time.Second,
+				Multiplier:     2,
+			},
+			HealthCheck: HealthCheckConfig{
+				Path:               "/api/v1/health",
+				Interval:           5 * time.Second,
+				Timeout:            time.Second,
+				UnhealthyThreshold: 2,
+				HealthyThreshold:   1,
+			},
+			OutlierDetection: OutlierConfig{
+				ConsecutiveErrors: 5,
+				BaseEjectionTime:  30 * time.Second,
+				MaxEjectionTime:   5 * time.Minute,
+			},
+		},
+		CircuitBreaker: BreakerConfig{
 			MaxRequests:          5,
 			Interval:             time.Minute,
 			Timeout:              30 * time.Second,
@@ -103,65 +568,82 @@ func DefaultConfig() *Config {
 			FailureRateThreshold: 0.6,
 			MinimumRequests:      5,
 		},
-		Services: struct {
-			MarketData struct {
-				URL     string        `yaml:"url"`
-				Timeout time.Duration `yaml:"timeout"`
-			} `yaml:"market_data"`
-			RiskManagement struct {
-				URL     string        `yaml:"url"`
-				Timeout time.Duration `yaml:"timeout"`
-			} `yaml:"risk_management"`
-			Notification struct {
-				URL     string        `yaml:"url"`
-				Timeout time.Duration `yaml:"timeout"`
-			} `yaml:"notification"`
-			Audit struct {
-				URL     string        `yaml:"url"`
-				Timeout time.Duration `yaml:"timeout"`
-			} `yaml:"audit"`
-		}{
-			MarketData: struct {
-				URL     string        `yaml:"url"`
-				Timeout time.Duration `yaml:"timeout"`
-			}{
+		Services: ServicesConfig{
+			MarketData: ServiceConfig{
 				URL:     "http://localhost:8082",
 				Timeout: 5 * time.Second,
+				CircuitBreaker: BreakerConfig{
+					MaxRequests:          3,
+					FailureThreshold:     5,
+					SuccessThreshold:     2,
+					FailureRateThreshold: 0.5,
+					MinimumRequests:      3,
+				},
+				Hedge: HedgeConfig{
+					Delay:  140 * time.Millisecond,
+					Budget: 0.1,
+				},
+				Cache: CacheConfig{
+					Coalesce: true,
+				},
+				Batch: BatchConfig{
+					MaxWait: 5 * time.Millisecond,
+					MaxSize: 50,
+				},
+			},
+			Portfolio: ServiceConfig{
+				URL:     "http://localhost:8081",
+				Timeout: 5 * time.Second,
+				CircuitBreaker: BreakerConfig{
+					Timeout:          20 * time.Second,
+					FailureThreshold: 3,
+					SuccessThreshold: 2,
+					MinimumRequests:  2,
+				},
 			},
-			RiskManagement: struct {
-				URL     string        `yaml:"url"`
-				Timeout time.Duration `yaml:"timeout"`
-			}{
+			RiskManagement: ServiceConfig{
 				URL:     "http://localhost:8083",
 				Timeout: 3 * time.Second,
+				CircuitBreaker: BreakerConfig{
+					MaxRequests:          2,
+					Timeout:              15 * time.Second,
+					FailureThreshold:     2,
+					SuccessThreshold:     1,
+					FailureRateThreshold: 0.3,
+					MinimumRequests:      2,
+				},
 			},
-			Notification: struct {
-				URL     string        `yaml:"url"`
-				Timeout time.Duration `yaml:"timeout"`
-			}{
+			Notification: ServiceConfig{
 				URL:     "http://localhost:8084",
 				Timeout: 2 * time.Second,
+				CircuitBreaker: BreakerConfig{
+					MaxRequests:          10,
+					Timeout:              10 * time.Second,
+					FailureRateThreshold: 0.8,
+				},
 			},
-			Audit: struct {
-				URL     string        `yaml:"url"`
-				Timeout time.Duration `yaml:"timeout"`
-			}{
+			Audit: ServiceConfig{
 				URL:     "http://localhost:8085",
 				Timeout: 3 * time.Second,
+				CircuitBreaker: BreakerConfig{
+					MaxRequests:          15,
+					Timeout:              5 * time.Second,
+					FailureThreshold:     15,
+					SuccessThreshold:     5,
+					FailureRateThreshold: 0.9,
+					MinimumRequests:      10,
+				},
 			},
 		},
-		Logging: struct {
-			Level  string `yaml:"level"`
-			Format string `yaml:"format"`
-		}{
+		Logging: LoggingConfig{
 			Level:  "info",
 			Format: "json",
+			Sampling: SamplingConfig{
+				Initial:    100,
+				Thereafter: 100,
+			},
 		},
-		Metrics: struct {
-			Enabled bool   `yaml:"enabled"`
-			Port    int    `yaml:"port"`
-			Path    string `yaml:"path"`
-		}{
+		Metrics: MetricsConfig{
 			Enabled: true,
 			Port:    9090,
 			Path:    "/metrics",
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+	"circuit-breaker-demo/pkg/httpclient"
+)
+
+func TestResolveInheritsDefaults(t *testing.T) {
+	c := DefaultConfig()
+	c.Client.Timeout = 4 * time.Second
+	c.Client.Retry = RetryConfig{MaxAttempts: 3, InitialBackoff: 50 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+
+	resolved := c.Resolve("billing-service", ServiceConfig{
+		URL:            "http://billing:8086",
+		CircuitBreaker: BreakerConfig{FailureThreshold: 4, Timeout: 10 * time.Second},
+		Retry:          RetryConfig{MaxAttempts: 5},
+	})
+
+	wantBreaker := circuitbreaker.Config{
+		Name:                 "billing-service",
+		MaxRequests:          c.CircuitBreaker.MaxRequests,
+		Interval:             c.CircuitBreaker.Interval,
+		Timeout:              10 * time.Second,
+		FailureThreshold:     4,
+		SuccessThreshold:     c.CircuitBreaker.SuccessThreshold,
+		FailureRateThreshold: c.CircuitBreaker.FailureRateThreshold,
+		MinimumRequests:      c.CircuitBreaker.MinimumRequests,
+	}
+	if resolved.CircuitBreaker != wantBreaker {
+		t.Errorf("CircuitBreaker = %+v, want %+v", resolved.CircuitBreaker, wantBreaker)
+	}
+
+	wantRetry := httpclient.RetryPolicy{MaxAttempts: 5, InitialBackoff: 50 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	if resolved.Retry != wantRetry {
+		t.Errorf("Retry = %+v, want %+v", resolved.Retry, wantRetry)
+	}
+	if resolved.Timeout != 4*time.Second {
+		t.Errorf("Timeout = %v, want the client default 4s", resolved.Timeout)
+	}
+	if want := []EndpointConfig{{URL: "http://billing:8086", Weight: 1}}; !reflect.DeepEqual(resolved.Endpoints, want) {
+		t.Errorf("Endpoints = %+v, want the url as a single endpoint", resolved.Endpoints)
+	}
+	if resolved.Balancer != httpclient.StrategyRoundRobin {
+		t.Errorf("Balancer = %q, want %q", resolved.Balancer, httpclient.StrategyRoundRobin)
+	}
+	if resolved.UserAgent != c.Client.UserAgent || resolved.HealthCheck != c.Client.HealthCheck.Check() {
+		t.Errorf("Client settings = %q %+v, want the client defaults", resolved.UserAgent, resolved.HealthCheck)
+	}
+}
+
+func TestResolveKeepsServiceSettings(t *testing.T) {
+	c := DefaultConfig()
+	endpoints := []EndpointConfig{{URL: "http://md-1:8082", Weight: 3}, {URL: "http://md-2:8082", Weight: 1}}
+
+	resolved := c.Resolve("market-data-service", ServiceConfig{
+		URL:       "http://ignored:8082",
+		Endpoints: endpoints,
+		Balancer:  httpclient.StrategyWeighted,
+		Timeout:   time.Second,
+		Hedge:     HedgeConfig{Delay: 100 * time.Millisecond, Budget: 0.2},
+		AuthToken: "token",
+	})
+
+	if !reflect.DeepEqual(resolved.Endpoints, endpoints) {
+		t.Errorf("Endpoints = %+v, want %+v", resolved.Endpoints, endpoints)
+	}
+	if resolved.Balancer != httpclient.StrategyWeighted || resolved.Timeout != time.Second || resolved.AuthToken != "token" {
+		t.Errorf("Resolved = %s %v %q, want the service's own settings", resolved.Balancer, resolved.Timeout, resolved.AuthToken)
+	}
+	if want := (httpclient.HedgePolicy{Delay: 100 * time.Millisecond, Budget: 0.2}); resolved.Hedge != want {
+		t.Errorf("Hedge = %+v, want %+v", resolved.Hedge, want)
+	}
+}
+
+func TestLoadConfigOverridesOneService(t *testing.T) {
+	path := writeConfig(t, `
+circuit_breaker:
+  interval: 2m
+services:
+  portfolio:
+    timeout: 1s
+    circuit_breaker:
+      failure_threshold: 7
+`)
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	portfolio := c.Resolve(ServiceName("portfolio"), c.Services.Portfolio)
+	if portfolio.Name != "portfolio-service" {
+		t.Errorf("Name = %q, want portfolio-service", portfolio.Name)
+	}
+	if portfolio.Timeout != time.Second || portfolio.CircuitBreaker.FailureThreshold != 7 {
+		t.Errorf("Portfolio = %v %d, want the file's timeout and threshold", portfolio.Timeout, portfolio.CircuitBreaker.FailureThreshold)
+	}
+	// Fields of the entry the file left out keep their defaults
+	if portfolio.CircuitBreaker.Timeout != DefaultConfig().Services.Portfolio.CircuitBreaker.Timeout {
+		t.Errorf("Breaker timeout = %v, want the portfolio default", portfolio.CircuitBreaker.Timeout)
+	}
+
+	for _, entry := range c.Services.entries() {
+		resolved := c.Resolve(ServiceName(entry.key), *entry.service)
+		if resolved.CircuitBreaker.Interval != 2*time.Minute {
+			t.Errorf("%s interval = %v, want the new default 2m", entry.key, resolved.CircuitBreaker.Interval)
+		}
+	}
+	if audit := c.Resolve(ServiceName("audit"), c.Services.Audit); audit.CircuitBreaker.FailureThreshold != 15 {
+		t.Errorf("Audit failure threshold = %d, want its default 15", audit.CircuitBreaker.FailureThreshold)
+	}
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// secretKeywords mark fields whose values must never be printed
+var secretKeywords = []string{"password", "secret", "token", "api_key", "private_key"}
+
+// field is a single leaf value of the configuration, addressed by its yaml path
+type field struct {
+	path  string // e.g. "services.market_data.url"
+	value reflect.Value
+}
+
+// fields returns every scalar leaf of the configuration in declaration
+// order. Lists such as services.*.endpoints can only be set in the file.
+func (c *Config) fields() []field {
+	var result []field
+	collectFields(reflect.ValueOf(c).Elem(), "", false, &result)
+	return result
+}
+
+// printedFields returns the fields along with the entries of every list,
+// addressed by index (e.g. "auth.api_keys[0].id"). An empty list or a list
+// of scalars is a single field.
+func (c *Config) printedFields() []field {
+	var result []field
+	collectFields(reflect.ValueOf(c).Elem(), "", true, &result)
+	return result
+}
+
+func collectFields(v reflect.Value, prefix string, lists bool, result *[]field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		name := strings.Split(structField.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		fieldValue := v.Field(i)
+		switch fieldValue.Kind() {
+		case reflect.Struct:
+			collectFields(fieldValue, path, lists, result)
+			continue
+		case reflect.Slice:
+			if lists {
+				collectList(fieldValue, path, result)
+			}
+			continue
+		}
+		*result = append(*result, field{path: path, value: fieldValue})
+	}
+}
+
+func collectList(v reflect.Value, path string, result *[]field) {
+	if v.Len() == 0 || v.Type().Elem().Kind() != reflect.Struct {
+		*result = append(*result, field{path: path, value: v})
+		return
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		collectFields(v.Index(i), fmt.Sprintf("%s[%d]", path, i), true, result)
+	}
+}
+
+// envName returns the environment variable for a field,
+// e.g. "services.market_data.url" becomes "TRADING_SERVICES_MARKET_DATA_URL"
+func envName(prefix, path string) string {
+	return prefix + "_" + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// set parses raw and stores it in the field
+func (f field) set(raw string) error {
+	switch {
+	case f.value.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(d))
+	case f.value.Kind() == reflect.String:
+		f.value.SetString(raw)
+	case f.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(b)
+	case f.value.Kind() == reflect.Int:
+		n, err := strconv.ParseInt(raw, 10, 0)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(n)
+	case f.value.Kind() == reflect.Uint32:
+		n, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return err
+		}
+		f.value.SetUint(n)
+	case f.value.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		f.value.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", f.value.Type())
+	}
+	return nil
+}
+
+// String formats the field value the way it would be written in yaml
+func (f field) String() string {
+	if f.value.Type() == durationType {
+		return time.Duration(f.value.Int()).String()
+	}
+	if f.value.Kind() == reflect.Slice {
+		items := make([]string, f.value.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(f.value.Index(i).Interface())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(f.value.Interface())
+}
+
+// redacted formats the field value with secrets hidden
+func (f field) redacted() string {
+	value := f.String()
+	if value == "" || (f.value.Kind() == reflect.Slice && f.value.Len() == 0) {
+		return value
+	}
+
+	last := f.path[strings.LastIndex(f.path, ".")+1:]
+	for _, keyword := range secretKeywords {
+		if strings.Contains(last, keyword) {
+			return "<redacted>"
+		}
+	}
+
+	if parsed, err := url.Parse(value); err == nil && parsed.User != nil {
+		return parsed.Redacted()
+	}
+	return value
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is prepended to the environment variable of every field
+const EnvPrefix = "TRADING"
+
+// Loader builds the effective configuration in layers, each overriding the
+// previous one: defaults, the -config file, TRADING_* environment variables
+// and finally command-line flags named after the yaml path of each field
+// (e.g. -services.market_data.url).
+type Loader struct {
+	defaults    *Config
+	configPath  *string
+	printConfig *bool
+	flagSet     *flag.FlagSet
+	lookupEnv   func(string) (string, bool)
+
+	config  *Config
+	sources map[string]string
+}
+
+// flagValue records the raw value of an override flag
+type flagValue struct {
+	raw string
+}
+
+func (v *flagValue) String() string { return v.raw }
+
+func (v *flagValue) Set(raw string) error {
+	v.raw = raw
+	return nil
+}
+
+// NewLoader creates a loader that starts from the given defaults
+func NewLoader(defaults *Config) *Loader {
+	return &Loader{
+		defaults:  defaults,
+		lookupEnv: os.LookupEnv,
+	}
+}
+
+// RegisterFlags adds -config, -print-config and one override flag per field to fs
+func (l *Loader) RegisterFlags(fs *flag.FlagSet) {
+	l.flagSet = fs
+	l.configPath = fs.String("config", "", "YAML configuration file (defaults are used when empty)")
+	l.printConfig = fs.Bool("print-config", false, "Print the effective configuration with the source of each value and exit")
+
+	for _, f := range l.defaults.fields() {
+		fs.Var(&flagValue{}, f.path, fmt.Sprintf("Override %s (env %s)", f.path, envName(EnvPrefix, f.path)))
+	}
+}
+
+// Load assembles and validates the configuration. RegisterFlags must have
+// been called and the flag set parsed beforehand for flags to apply.
+func (l *Loader) Load() (*Config, error) {
+	config := *l.defaults
+	sources := make(map[string]string)
+
+	fields := config.fields()
+	for _, f := range fields {
+		sources[f.path] = "default"
+	}
+
+	if path := l.ConfigPath(); path != "" {
+		paths, err := decodeFile(path, &config)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			sources[p] = "file " + path
+		}
+	}
+
+	for _, f := range fields {
+		name := envName(EnvPrefix, f.path)
+		raw, ok := l.lookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := f.set(raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		sources[f.path] = "env " + name
+	}
+
+	if l.flagSet != nil {
+		byPath := make(map[string]field, len(fields))
+		for _, f := range fields {
+			byPath[f.path] = f
+		}
+
+		var flagErr error
+		l.flagSet.Visit(func(fl *flag.Flag) {
+			f, ok := byPath[fl.Name]
+			if !ok || flagErr != nil {
+				return
+			}
+			if err := f.set(fl.Value.String()); err != nil {
+				flagErr = fmt.Errorf("-%s: %w", fl.Name, err)
+				return
+			}
+			sources[f.path] = "flag -" + fl.Name
+		})
+		if flagErr != nil {
+			return nil, flagErr
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	l.config = &config
+	l.sources = sources
+	return l.config, nil
+}
+
+// ConfigPath returns the -config file, or "" when none was given
+func (l *Loader) ConfigPath() string {
+	if l.configPath == nil {
+		return ""
+	}
+	return *l.configPath
+}
+
+// PrintRequested reports whether -print-config was given
+func (l *Loader) PrintRequested() bool {
+	return l.printConfig != nil && *l.printConfig
+}
+
+// Print writes the configuration from the last Load, lists included, with
+// secrets redacted and the source of every value
+func (l *Loader) Print(w io.Writer) error {
+	if l.config == nil {
+		return errors.New("configuration has not been loaded")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, f := range l.config.printedFields() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.path, f.redacted(), l.source(f.path))
+	}
+	return tw.Flush()
+}
+
+// source returns where the value at path came from. The entries of a list
+// come from wherever the list was set, and lists only from the file.
+func (l *Loader) source(path string) string {
+	list, _, _ := strings.Cut(path, "[")
+	if source, ok := l.sources[list]; ok {
+		return source
+	}
+	return "default"
+}
+
+// decodeFile decodes a yaml file on top of config and returns the yaml paths
+// of the values it set. Unknown keys are rejected.
+func decodeFile(filename string, config *Config) ([]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	var paths []string
+	if len(document.Content) > 0 {
+		collectPaths(document.Content[0], "", &paths)
+	}
+	return paths, nil
+}
+
+// collectPaths appends the dotted path of every scalar under a mapping node
+func collectPaths(node *yaml.Node, prefix string, paths *[]string) {
+	if node.Kind != yaml.MappingNode {
+		*paths = append(*paths, prefix)
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		path := node.Content[i].Value
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		collectPaths(node.Content[i+1], path, paths)
+	}
+}
@@ -0,0 +1,319 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+	"circuit-breaker-demo/pkg/httpclient"
+	"circuit-breaker-demo/pkg/loadshed"
+	"circuit-breaker-demo/pkg/ratelimit"
+)
+
+var (
+	validLogLevels  = []string{"debug", "info", "warn", "error"}
+	validLogFormats = []string{"json", "console"}
+)
+
+// Validate checks the whole configuration and reports every invalid field
+// with its yaml path, e.g. "circuit_breaker.failure_rate_threshold must be in (0,1]"
+func (c *Config) Validate() error {
+	var errs circuitbreaker.ValidationErrors
+
+	validatePort(&errs, "server.port", c.Server.Port)
+	validatePositive(&errs, "server.read_timeout", c.Server.ReadTimeout)
+	validatePositive(&errs, "server.write_timeout", c.Server.WriteTimeout)
+	if c.Server.RequestBudget < 0 {
+		errs.Add("server.request_budget", "must not be negative")
+	}
+	if c.Server.GRPCPort != 0 {
+		validatePort(&errs, "server.grpc_port", c.Server.GRPCPort)
+		if c.Server.GRPCPort == c.Server.Port {
+			errs.Add("server.grpc_port", "must differ from server.port")
+		}
+	}
+
+	validateLoadShedding(&errs, "load_shedding", c.LoadShedding)
+	validateRateLimiting(&errs, "rate_limiting", c.RateLimiting)
+	validateAuth(&errs, "auth", c.Auth)
+	if c.Idempotency.Enabled {
+		validatePositive(&errs, "idempotency.ttl", c.Idempotency.TTL)
+	}
+
+	validatePositive(&errs, "client.timeout", c.Client.Timeout)
+	validateRetry(&errs, "client.retry", c.Client.Retry)
+	validateHealthCheck(&errs, "client.health_check", c.Client.HealthCheck)
+	validateOutlierDetection(&errs, "client.outlier_detection", c.Client.OutlierDetection)
+	errs.Merge("circuit_breaker", c.CircuitBreaker.Breaker("default").Validate())
+
+	for _, entry := range c.Services.entries() {
+		field := "services." + entry.key
+		resolved := c.Resolve(ServiceName(entry.key), *entry.service)
+
+		if len(entry.service.Endpoints) == 0 {
+			validateURL(&errs, field+".url", resolved.URL)
+		}
+		seen := make(map[string]int, len(entry.service.Endpoints))
+		for i, endpoint := range entry.service.Endpoints {
+			endpointField := fmt.Sprintf("%s.endpoints[%d]", field, i)
+			validateURL(&errs, endpointField+".url", endpoint.URL)
+			if first, exists := seen[endpoint.URL]; exists {
+				errs.Add(endpointField+".url", fmt.Sprintf("duplicates endpoints[%d]", first))
+			}
+			seen[endpoint.URL] = i
+			if endpoint.Weight < 0 {
+				errs.Add(endpointField+".weight", "must not be negative")
+			}
+		}
+		validateOneOf(&errs, field+".balancer", resolved.Balancer, httpclient.Strategies)
+		validateHedge(&errs, field+".hedge", entry.service.Hedge)
+		if entry.service.Cache.TTL < 0 {
+			errs.Add(field+".cache.ttl", "must not be negative")
+		}
+		if entry.service.Cache.MaxEntries < 0 {
+			errs.Add(field+".cache.max_entries", "must not be negative")
+		}
+		if entry.service.Batch.MaxWait < 0 {
+			errs.Add(field+".batch.max_wait", "must not be negative")
+		}
+		if entry.service.Batch.MaxSize < 0 {
+			errs.Add(field+".batch.max_size", "must not be negative")
+		}
+		validatePositive(&errs, field+".timeout", resolved.Timeout)
+		validateRetry(&errs, field+".retry", entry.service.Retry.inherit(c.Client.Retry))
+		errs.Merge(field+".circuit_breaker", resolved.CircuitBreaker.Validate())
+	}
+
+	validateOneOf(&errs, "logging.level", c.Logging.Level, validLogLevels)
+	validateOneOf(&errs, "logging.format", c.Logging.Format, validLogFormats)
+
+	if c.Logging.Sampling.Initial < 0 {
+		errs.Add("logging.sampling.initial", "must not be negative")
+	}
+	if c.Logging.Sampling.Initial > 0 && c.Logging.Sampling.Thereafter < 1 {
+		errs.Add("logging.sampling.thereafter", "must be at least 1 when sampling is enabled")
+	}
+
+	if c.Metrics.Enabled {
+		if c.Metrics.Port != 0 {
+			validatePort(&errs, "metrics.port", c.Metrics.Port)
+		}
+		if !strings.HasPrefix(c.Metrics.Path, "/") {
+			errs.Add("metrics.path", "must start with \"/\"")
+		}
+	}
+
+	return errs.Err()
+}
+
+func validatePort(errs *circuitbreaker.ValidationErrors, field string, port int) {
+	if port < 1 || port > 65535 {
+		errs.Add(field, "must be in [1,65535]")
+	}
+}
+
+func validatePositive(errs *circuitbreaker.ValidationErrors, field string, d time.Duration) {
+	if d <= 0 {
+		errs.Add(field, "must be greater than 0")
+	}
+}
+
+func validateURL(errs *circuitbreaker.ValidationErrors, field, rawURL string) {
+	parsed, err := url.Parse(rawURL)
+	switch {
+	case rawURL == "":
+		errs.Add(field, "is required")
+	case err != nil:
+		errs.Add(field, "is not a valid URL")
+	case parsed.Scheme != "http" && parsed.Scheme != "https":
+		errs.Add(field, "must use the http or https scheme")
+	case parsed.Host == "":
+		errs.Add(field, "must include a host")
+	}
+}
+
+func validateRetry(errs *circuitbreaker.ValidationErrors, field string, retry RetryConfig) {
+	if retry.MaxAttempts < 1 {
+		errs.Add(field+".max_attempts", "must be at least 1")
+	}
+	if retry.InitialBackoff < 0 {
+		errs.Add(field+".initial_backoff", "must not be negative")
+	}
+	if retry.MaxBackoff < retry.InitialBackoff {
+		errs.Add(field+".max_backoff", "must not be less than initial_backoff")
+	}
+	if retry.Multiplier < 1 {
+		errs.Add(field+".multiplier", "must be at least 1")
+	}
+}
+
+func validateHedge(errs *circuitbreaker.ValidationErrors, field string, hedge HedgeConfig) {
+	if hedge.Delay < 0 {
+		errs.Add(field+".delay", "must not be negative")
+	}
+	if hedge.Budget < 0 || hedge.Budget > 1 {
+		errs.Add(field+".budget", "must be in [0,1]")
+	} else if hedge.Delay > 0 && hedge.Budget == 0 {
+		errs.Add(field+".budget", "must be greater than 0 when hedging is enabled")
+	}
+}
+
+func validateHealthCheck(errs *circuitbreaker.ValidationErrors, field string, check HealthCheckConfig) {
+	if check.Interval < 0 {
+		errs.Add(field+".interval", "must not be negative")
+	}
+	if check.Interval == 0 {
+		return
+	}
+	if !strings.HasPrefix(check.Path, "/") {
+		errs.Add(field+".path", "must start with \"/\"")
+	}
+	validatePositive(errs, field+".timeout", check.Timeout)
+	if check.Timeout > check.Interval {
+		errs.Add(field+".timeout", "must not exceed interval")
+	}
+	if check.UnhealthyThreshold < 1 {
+		errs.Add(field+".unhealthy_threshold", "must be at least 1")
+	}
+	if check.HealthyThreshold < 1 {
+		errs.Add(field+".healthy_threshold", "must be at least 1")
+	}
+}
+
+func validateOutlierDetection(errs *circuitbreaker.ValidationErrors, field string, outlier OutlierConfig) {
+	if outlier.ConsecutiveErrors < 0 {
+		errs.Add(field+".consecutive_errors", "must not be negative")
+	}
+	if outlier.ConsecutiveErrors == 0 {
+		return
+	}
+	validatePositive(errs, field+".base_ejection_time", outlier.BaseEjectionTime)
+	if outlier.MaxEjectionTime < outlier.BaseEjectionTime {
+		errs.Add(field+".max_ejection_time", "must not be less than base_ejection_time")
+	}
+}
+
+func validateLoadShedding(errs *circuitbreaker.ValidationErrors, field string, shedding LoadSheddingConfig) {
+	if !shedding.Enabled {
+		return
+	}
+	if shedding.MaxInFlight < 0 {
+		errs.Add(field+".max_in_flight", "must not be negative")
+	}
+	if shedding.MaxQueueTime < 0 {
+		errs.Add(field+".max_queue_time", "must not be negative")
+	}
+	if shedding.MaxCPU < 0 || shedding.MaxCPU > 1 {
+		errs.Add(field+".max_cpu", "must be in [0,1]")
+	}
+	if shedding.RetryAfter < 0 {
+		errs.Add(field+".retry_after", "must not be negative")
+	}
+	validateOneOf(errs, field+".default_priority", shedding.DefaultPriority, loadshed.Priorities)
+
+	seen := make(map[string]string, len(shedding.Routes))
+	for i, route := range shedding.Routes {
+		routeField := fmt.Sprintf("%s.routes[%d]", field, i)
+		validateRoute(errs, routeField+".route", route.Route, fmt.Sprintf("routes[%d]", i), seen)
+		validateOneOf(errs, routeField+".priority", route.Priority, loadshed.Priorities)
+	}
+}
+
+func validateRateLimiting(errs *circuitbreaker.ValidationErrors, field string, limiting RateLimitingConfig) {
+	if !limiting.Enabled {
+		return
+	}
+
+	seen := make(map[string]string, len(limiting.Rules))
+	for i, rule := range limiting.Rules {
+		ruleField := fmt.Sprintf("%s.rules[%d]", field, i)
+		validateRoute(errs, ruleField+".route", rule.Route, fmt.Sprintf("rules[%d]", i), seen)
+		validateOneOf(errs, ruleField+".algorithm", rule.Algorithm, ratelimit.Algorithms)
+		if rule.Rate < 1 {
+			errs.Add(ruleField+".rate", "must be at least 1")
+		}
+		validatePositive(errs, ruleField+".period", rule.Period)
+		if rule.Rate > 0 && rule.Period > 0 && rule.Period < time.Duration(rule.Rate) {
+			errs.Add(ruleField+".period", "is too short for rate")
+		}
+		if rule.Burst < 0 {
+			errs.Add(ruleField+".burst", "must not be negative")
+		} else if rule.Burst > 0 && rule.Algorithm != ratelimit.TokenBucket {
+			errs.Add(ruleField+".burst", "only applies to the token_bucket algorithm")
+		}
+		if len(rule.Keys) == 0 {
+			errs.Add(ruleField+".keys", "must list at least one key source")
+		}
+		for j, key := range rule.Keys {
+			validateOneOf(errs, fmt.Sprintf("%s.keys[%d]", ruleField, j), key, ratelimit.KeySources)
+		}
+	}
+}
+
+// minAPIKeySecretLength is the shortest accepted auth.api_key_secret
+const minAPIKeySecretLength = 32
+
+// demoAPIKeySecret was once committed with the demo configuration. Anyone
+// can sign API keys with it, so it is refused.
+const demoAPIKeySecret = "demo-only-api-key-secret-change-me-0123456789"
+
+func validateAuth(errs *circuitbreaker.ValidationErrors, field string, a AuthConfig) {
+	if !a.Enabled {
+		return
+	}
+	switch {
+	case a.APIKeySecret == "":
+		errs.Add(field+".api_key_secret", "is required when enabled; set "+envName(EnvPrefix, field+".api_key_secret"))
+	case len(a.APIKeySecret) < minAPIKeySecretLength:
+		errs.Add(field+".api_key_secret", fmt.Sprintf("must be at least %d characters", minAPIKeySecretLength))
+	case a.APIKeySecret == demoAPIKeySecret:
+		errs.Add(field+".api_key_secret", "is the published demo secret; generate a new one")
+	}
+	if len(a.APIKeys) == 0 && a.JWKSFile == "" {
+		errs.Add(field, "must list api_keys or set jwks_file when enabled")
+	}
+	if a.ClockSkew < 0 {
+		errs.Add(field+".clock_skew", "must not be negative")
+	}
+
+	seen := make(map[string]int, len(a.APIKeys))
+	for i, key := range a.APIKeys {
+		keyField := fmt.Sprintf("%s.api_keys[%d]", field, i)
+		if key.ID == "" {
+			errs.Add(keyField+".id", "is required")
+		}
+		if first, exists := seen[key.ID]; exists {
+			errs.Add(keyField+".id", fmt.Sprintf("duplicates api_keys[%d]", first))
+		}
+		seen[key.ID] = i
+		if key.Subject == "" {
+			errs.Add(keyField+".subject", "is required")
+		}
+	}
+}
+
+// validateRoute checks a route such as "POST /api/v1/trades" and that no
+// earlier entry in seen has the same route; entry names this one in seen
+func validateRoute(errs *circuitbreaker.ValidationErrors, field, route, entry string, seen map[string]string) {
+	method, path, ok := splitRoute(route)
+	if !ok {
+		errs.Add(field, "must be a method and a path, e.g. \"POST /api/v1/trades\"")
+		return
+	}
+	key := strings.ToUpper(method) + " " + path
+	if first, exists := seen[key]; exists {
+		errs.Add(field, "duplicates "+first)
+	}
+	seen[key] = entry
+}
+
+func validateOneOf(errs *circuitbreaker.ValidationErrors, field, value string, allowed []string) {
+	for _, candidate := range allowed {
+		if value == candidate {
+			return
+		}
+	}
+	errs.Add(field, fmt.Sprintf("must be one of %s", strings.Join(allowed, ", ")))
+}
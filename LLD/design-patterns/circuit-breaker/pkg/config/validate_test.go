@@ -0,0 +1,188 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+)
+
+// invalidFields returns the fields Validate reports for c
+func invalidFields(t *testing.T, c *Config) []string {
+	t.Helper()
+
+	err := c.Validate()
+	if err == nil {
+		return nil
+	}
+	var errs circuitbreaker.ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Validate() = %v, want ValidationErrors", err)
+	}
+	fields := make([]string, len(errs))
+	for i, fieldErr := range errs {
+		fields[i] = fieldErr.Field
+	}
+	return fields
+}
+
+// checkInvalidFields fails unless Validate reports exactly want for c
+func checkInvalidFields(t *testing.T, c *Config, want ...string) {
+	t.Helper()
+
+	got := invalidFields(t, c)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Invalid fields = %v, want %v", got, want)
+	}
+}
+
+func TestValidateAuthSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		want   []string
+	}{
+		{name: "missing", secret: "", want: []string{"auth.api_key_secret"}},
+		{name: "too short", secret: strings.Repeat("s", minAPIKeySecretLength-1), want: []string{"auth.api_key_secret"}},
+		{name: "published demo secret", secret: demoAPIKeySecret, want: []string{"auth.api_key_secret"}},
+		{name: "long enough", secret: strings.Repeat("s", minAPIKeySecretLength)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultConfig()
+			c.Auth.Enabled = true
+			c.Auth.APIKeySecret = tt.secret
+			c.Auth.APIKeys = []APIKeyConfig{{ID: "user123-key", Subject: "user123", Roles: []string{"trader"}}}
+
+			checkInvalidFields(t, c, tt.want...)
+		})
+	}
+}
+
+func TestValidateAuthDisabledNeedsNoSecret(t *testing.T) {
+	c := DefaultConfig()
+	c.Auth.Enabled = false
+	c.Auth.APIKeySecret = ""
+
+	checkInvalidFields(t, c)
+}
+
+func TestValidateAuthSecretMessageNamesVariable(t *testing.T) {
+	c := DefaultConfig()
+	c.Auth.Enabled = true
+	c.Auth.APIKeys = []APIKeyConfig{{ID: "user123-key", Subject: "user123"}}
+
+	err := c.Validate()
+	if err == nil || !strings.Contains(err.Error(), "TRADING_AUTH_API_KEY_SECRET") {
+		t.Errorf("Validate() = %v, want it to name TRADING_AUTH_API_KEY_SECRET", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{"default", func(c *Config) {}, nil},
+		{"port out of range", func(c *Config) { c.Server.Port = 70000 }, []string{"server.port"}},
+		{"grpc on the http port", func(c *Config) { c.Server.GRPCPort = c.Server.Port }, []string{"server.grpc_port"}},
+		{"negative request budget", func(c *Config) { c.Server.RequestBudget = -time.Second }, []string{"server.request_budget"}},
+		{"idempotency without ttl", func(c *Config) { c.Idempotency.TTL = 0 }, []string{"idempotency.ttl"}},
+		{"disabled idempotency without ttl", func(c *Config) {
+			c.Idempotency.Enabled = false
+			c.Idempotency.TTL = 0
+		}, nil},
+		{"max backoff below initial", func(c *Config) { c.Client.Retry.MaxBackoff = time.Millisecond }, []string{
+			"client.retry.max_backoff",
+			"services.market_data.retry.max_backoff",
+			"services.portfolio.retry.max_backoff",
+			"services.risk_management.retry.max_backoff",
+			"services.notification.retry.max_backoff",
+			"services.audit.retry.max_backoff",
+		}},
+		{"health check slower than its interval", func(c *Config) { c.Client.HealthCheck.Timeout = time.Minute }, []string{"client.health_check.timeout"}},
+		{"health checks disabled", func(c *Config) {
+			c.Client.HealthCheck.Interval = 0
+			c.Client.HealthCheck.Path = ""
+		}, nil},
+		{"max ejection below base", func(c *Config) { c.Client.OutlierDetection.MaxEjectionTime = time.Second }, []string{"client.outlier_detection.max_ejection_time"}},
+		// Only portfolio inherits the default failure rate
+		{"invalid default breaker", func(c *Config) { c.CircuitBreaker.FailureRateThreshold = 2 }, []string{
+			"circuit_breaker.failure_rate_threshold",
+			"services.portfolio.circuit_breaker.failure_rate_threshold",
+		}},
+		{"invalid service breaker", func(c *Config) { c.Services.Audit.CircuitBreaker.SuccessThreshold = 100 }, []string{"services.audit.circuit_breaker.success_threshold"}},
+		{"service url without scheme", func(c *Config) { c.Services.Portfolio.URL = "localhost:8081" }, []string{"services.portfolio.url"}},
+		{"endpoints", func(c *Config) {
+			c.Services.MarketData.URL = ""
+			c.Services.MarketData.Endpoints = []EndpointConfig{
+				{URL: "http://md-1:8082"},
+				{URL: "ftp://md-2:8082"},
+				{URL: "http://md-1:8082", Weight: -1},
+			}
+		}, []string{
+			"services.market_data.endpoints[1].url",
+			"services.market_data.endpoints[2].url",
+			"services.market_data.endpoints[2].weight",
+		}},
+		{"unknown balancer", func(c *Config) { c.Services.MarketData.Balancer = "random" }, []string{"services.market_data.balancer"}},
+		{"hedge without budget", func(c *Config) { c.Services.Portfolio.Hedge.Delay = 50 * time.Millisecond }, []string{"services.portfolio.hedge.budget"}},
+		{"negative cache and batch", func(c *Config) {
+			c.Services.MarketData.Cache.TTL = -time.Second
+			c.Services.MarketData.Batch.MaxSize = -1
+		}, []string{"services.market_data.cache.ttl", "services.market_data.batch.max_size"}},
+		{"shedding priorities", func(c *Config) {
+			c.LoadShedding.DefaultPriority = "urgent"
+			c.LoadShedding.Routes = []RoutePriorityConfig{
+				{Route: "POST /api/v1/trades", Priority: "critical"},
+				{Route: "post /api/v1/trades", Priority: "low"},
+				{Route: "/api/v1/health", Priority: "critical"},
+			}
+		}, []string{
+			"load_shedding.default_priority",
+			"load_shedding.routes[1].route",
+			"load_shedding.routes[2].route",
+		}},
+		{"rate limit rule", func(c *Config) {
+			c.RateLimiting.Enabled = true
+			c.RateLimiting.Rules = []RateLimitRuleConfig{{
+				Route:     "GET /api/v1/portfolio/:userId",
+				Algorithm: "sliding_window",
+				Rate:      10,
+				Period:    time.Nanosecond,
+				Burst:     20,
+				Keys:      []string{"user", "cookie"},
+			}}
+		}, []string{
+			"rate_limiting.rules[0].period",
+			"rate_limiting.rules[0].burst",
+			"rate_limiting.rules[0].keys[1]",
+		}},
+		{"rate limit rule without keys", func(c *Config) {
+			c.RateLimiting.Enabled = true
+			c.RateLimiting.Rules = []RateLimitRuleConfig{{Route: "POST /api/v1/trades", Algorithm: "token_bucket", Rate: 5, Period: time.Second}}
+		}, []string{"rate_limiting.rules[0].keys"}},
+		{"log level", func(c *Config) { c.Logging.Level = "verbose" }, []string{"logging.level"}},
+		{"sampling without thereafter", func(c *Config) {
+			c.Logging.Sampling.Initial = 100
+			c.Logging.Sampling.Thereafter = 0
+		}, []string{"logging.sampling.thereafter"}},
+		{"metrics path", func(c *Config) {
+			c.Metrics.Enabled = true
+			c.Metrics.Path = "metrics"
+		}, []string{"metrics.path"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultConfig()
+			tt.change(c)
+
+			checkInvalidFields(t, c, tt.want...)
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Change describes a configuration reload that passed validation and is now active
+type Change struct {
+	Version  uint64
+	Hash     string
+	Old      *Config
+	New      *Config
+	Services []ServiceChange // Only services whose resolved settings differ
+	Logging  *LoggingConfig  // Non-nil when the logging section changed
+
+	LoadShedding *LoadSheddingConfig // Non-nil when the load_shedding section changed
+	RateLimiting *RateLimitingConfig // Non-nil when the rate_limiting section changed
+	Auth         *AuthConfig         // Non-nil when the auth section changed
+	Idempotency  *IdempotencyConfig  // Non-nil when the idempotency section changed
+}
+
+// ServiceChange describes the old and new resolved settings of one upstream
+type ServiceChange struct {
+	Key string // yaml key, e.g. "market_data"
+	Old ResolvedService
+	New ResolvedService
+}
+
+// Watcher reloads the configuration when its file changes or the process
+// receives SIGHUP. Invalid configurations are logged and rejected, so the
+// last good configuration stays active.
+type Watcher struct {
+	loader   *Loader
+	interval time.Duration
+	logger   *zap.Logger
+
+	mutex       sync.RWMutex
+	current     *Config
+	version     uint64
+	hash        string
+	modTime     time.Time
+	size        int64
+	subscribers []func(Change)
+}
+
+// NewWatcher creates a watcher whose active configuration is initial, as
+// returned by loader.Load
+func NewWatcher(loader *Loader, initial *Config, interval time.Duration, logger *zap.Logger) *Watcher {
+	w := &Watcher{
+		loader:   loader,
+		interval: interval,
+		logger:   logger,
+		current:  initial,
+		version:  1,
+		hash:     hashConfig(initial),
+	}
+
+	if info, err := os.Stat(loader.ConfigPath()); err == nil {
+		w.modTime = info.ModTime()
+		w.size = info.Size()
+	}
+
+	return w
+}
+
+// Subscribe registers fn to be called after every applied reload.
+// Subscribers run in registration order on the watcher goroutine.
+func (w *Watcher) Subscribe(fn func(Change)) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.subscribers = append(w.subscribers, fn)
+}
+
+// Current returns the active configuration
+func (w *Watcher) Current() *Config {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	return w.current
+}
+
+// Version returns the version and hash of the active configuration. The
+// version starts at 1 and increases with every applied reload.
+func (w *Watcher) Version() (uint64, string) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	return w.version, w.hash
+}
+
+// Start watches the file and SIGHUP until the context is cancelled
+func (w *Watcher) Start(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			w.logger.Info("Received SIGHUP, reloading configuration")
+			w.Reload()
+		case <-ticker.C:
+			if w.fileChanged() {
+				w.logger.Info("Configuration file changed, reloading",
+					zap.String("path", w.loader.ConfigPath()),
+				)
+				w.Reload()
+			}
+		}
+	}
+}
+
+// Reload loads and validates the configuration and, if it differs from the
+// active one, makes it active and notifies subscribers
+func (w *Watcher) Reload() error {
+	next, err := w.loader.Load()
+	if err != nil {
+		w.logger.Error("Rejected configuration reload, keeping last good configuration",
+			zap.String("path", w.loader.ConfigPath()),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	hash := hashConfig(next)
+
+	w.mutex.Lock()
+	if hash == w.hash {
+		w.mutex.Unlock()
+		return nil
+	}
+
+	change := diff(w.current, next)
+	w.version++
+	change.Version = w.version
+	change.Hash = hash
+	w.current = next
+	w.hash = hash
+	subscribers := append([]func(Change){}, w.subscribers...)
+	w.mutex.Unlock()
+
+	w.logger.Info("Configuration reloaded",
+		zap.Uint64("version", change.Version),
+		zap.String("hash", change.Hash),
+		zap.Int("servicesChanged", len(change.Services)),
+		zap.Bool("loggingChanged", change.Logging != nil),
+		zap.Bool("loadSheddingChanged", change.LoadShedding != nil),
+		zap.Bool("rateLimitingChanged", change.RateLimiting != nil),
+		zap.Bool("authChanged", change.Auth != nil),
+		zap.Bool("idempotencyChanged", change.Idempotency != nil),
+	)
+
+	for _, fn := range subscribers {
+		fn(change)
+	}
+
+	return nil
+}
+
+// fileChanged reports whether the file was modified since it was last seen
+func (w *Watcher) fileChanged() bool {
+	path := w.loader.ConfigPath()
+	if path == "" {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		w.logger.Warn("Failed to stat configuration file", zap.String("path", path), zap.Error(err))
+		return false
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return false
+	}
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+	return true
+}
+
+// diff compares two configurations section by section
+func diff(old, next *Config) Change {
+	change := Change{Old: old, New: next}
+
+	oldEntries := old.Services.entries()
+	for i, entry := range next.Services.entries() {
+		name := ServiceName(entry.key)
+		before := old.Resolve(name, *oldEntries[i].service)
+		after := next.Resolve(name, *entry.service)
+		if !reflect.DeepEqual(before, after) {
+			change.Services = append(change.Services, ServiceChange{Key: entry.key, Old: before, New: after})
+		}
+	}
+
+	if old.Logging != next.Logging {
+		logging := next.Logging
+		change.Logging = &logging
+	}
+
+	if !reflect.DeepEqual(old.LoadShedding, next.LoadShedding) {
+		shedding := next.LoadShedding
+		change.LoadShedding = &shedding
+	}
+	if !reflect.DeepEqual(old.RateLimiting, next.RateLimiting) {
+		limiting := next.RateLimiting
+		change.RateLimiting = &limiting
+	}
+	if !reflect.DeepEqual(old.Auth, next.Auth) {
+		authConfig := next.Auth
+		change.Auth = &authConfig
+	}
+	if old.Idempotency != next.Idempotency {
+		idempotencyConfig := next.Idempotency
+		change.Idempotency = &idempotencyConfig
+	}
+
+	return change
+}
+
+// hashConfig returns a short fingerprint of the effective configuration
+func hashConfig(config *Config) string {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
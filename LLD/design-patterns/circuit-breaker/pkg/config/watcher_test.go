@@ -0,0 +1,192 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestLoader returns a loader whose flag set was parsed from args and
+// whose environment is env
+func newTestLoader(t *testing.T, env map[string]string, args ...string) *Loader {
+	t.Helper()
+
+	loader := NewLoader(DefaultConfig())
+	loader.lookupEnv = func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	loader.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return loader
+}
+
+// writeConfig writes a configuration file into a temporary directory and
+// returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestWatcher returns a watcher of the file at path, loaded once, and
+// the changes it reports
+func newTestWatcher(t *testing.T, path string) (*Watcher, *[]Change) {
+	t.Helper()
+
+	loader := newTestLoader(t, nil, "-config", path)
+	initial, err := loader.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := NewWatcher(loader, initial, time.Second, zap.NewNop())
+	var changes []Change
+	watcher.Subscribe(func(change Change) {
+		changes = append(changes, change)
+	})
+	return watcher, &changes
+}
+
+// rewrite replaces the content of the file at path
+func rewrite(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcherReportsChangedSections(t *testing.T) {
+	path := writeConfig(t, "logging:\n  level: info\n")
+	watcher, changes := newTestWatcher(t, path)
+
+	rewrite(t, path, `
+logging:
+  level: debug
+services:
+  risk_management:
+    circuit_breaker:
+      failure_threshold: 3
+`)
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*changes) != 1 {
+		t.Fatalf("Subscribers got %d changes, want 1", len(*changes))
+	}
+	change := (*changes)[0]
+	if change.Version != 2 {
+		t.Errorf("Version = %d, want 2", change.Version)
+	}
+	if version, hash := watcher.Version(); version != 2 || hash != change.Hash {
+		t.Errorf("Watcher version = %d %s, want that of the change", version, hash)
+	}
+	if change.Logging == nil || change.Logging.Level != "debug" {
+		t.Errorf("Logging = %+v, want the new level", change.Logging)
+	}
+	if change.LoadShedding != nil || change.RateLimiting != nil || change.Auth != nil || change.Idempotency != nil {
+		t.Error("Change reports sections that were not modified")
+	}
+
+	if len(change.Services) != 1 {
+		t.Fatalf("Services = %+v, want only risk management", change.Services)
+	}
+	service := change.Services[0]
+	if service.Key != "risk_management" || service.New.Name != "risk-management-service" {
+		t.Errorf("Service = %s %s, want risk_management", service.Key, service.New.Name)
+	}
+	if service.Old.CircuitBreaker.FailureThreshold == 3 || service.New.CircuitBreaker.FailureThreshold != 3 {
+		t.Errorf("Failure threshold went from %d to %d, want 3", service.Old.CircuitBreaker.FailureThreshold, service.New.CircuitBreaker.FailureThreshold)
+	}
+	if watcher.Current() != change.New {
+		t.Error("Current() is not the new configuration")
+	}
+}
+
+func TestWatcherReportsInheritedServiceChanges(t *testing.T) {
+	path := writeConfig(t, "")
+	watcher, changes := newTestWatcher(t, path)
+
+	// Every service inherits the client user agent
+	rewrite(t, path, "client:\n  user_agent: \"test-agent/1.0\"\n")
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*changes) != 1 || len((*changes)[0].Services) != 5 {
+		t.Fatalf("Changes = %+v, want all five services", *changes)
+	}
+	for _, service := range (*changes)[0].Services {
+		if service.New.UserAgent != "test-agent/1.0" {
+			t.Errorf("%s user agent = %q, want test-agent/1.0", service.Key, service.New.UserAgent)
+		}
+	}
+}
+
+func TestWatcherIgnoresUnchangedReloads(t *testing.T) {
+	path := writeConfig(t, "logging:\n  level: info\n")
+	watcher, changes := newTestWatcher(t, path)
+
+	// Only the formatting differs
+	rewrite(t, path, "logging:\n    level: \"info\"\n")
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*changes) != 0 {
+		t.Errorf("Subscribers got %d changes, want none", len(*changes))
+	}
+	if version, _ := watcher.Version(); version != 1 {
+		t.Errorf("Version = %d, want 1", version)
+	}
+}
+
+func TestWatcherKeepsLastGoodConfiguration(t *testing.T) {
+	path := writeConfig(t, "logging:\n  level: info\n")
+	watcher, changes := newTestWatcher(t, path)
+	initial := watcher.Current()
+
+	for _, content := range []string{
+		"logging:\n  level: verbose\n",
+		"logging:\n  colour: true\n",
+		"logging: [\n",
+	} {
+		rewrite(t, path, content)
+		if err := watcher.Reload(); err == nil {
+			t.Errorf("Reload() of %q succeeded, want an error", content)
+		}
+	}
+
+	if len(*changes) != 0 || watcher.Current() != initial {
+		t.Error("Rejected reload replaced the configuration")
+	}
+}
+
+func TestWatcherFileChanged(t *testing.T) {
+	path := writeConfig(t, "logging:\n  level: info\n")
+	watcher, _ := newTestWatcher(t, path)
+
+	if watcher.fileChanged() {
+		t.Error("fileChanged() = true before any write")
+	}
+	rewrite(t, path, "logging:\n  level: debug\n")
+	if !watcher.fileChanged() {
+		t.Error("fileChanged() = false after a write")
+	}
+	if watcher.fileChanged() {
+		t.Error("fileChanged() = true twice for one write")
+	}
+}
@@ -0,0 +1,189 @@
+package grpcclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Classifier decides whether the error of a call counts as a failure for
+// the circuit breaker
+type Classifier func(err error) bool
+
+// DefaultClassifier treats UNAVAILABLE, DEADLINE_EXCEEDED, RESOURCE_EXHAUSTED,
+// INTERNAL, UNKNOWN and DATA_LOSS as failures: the upstream is down, slow,
+// overloaded or broken. Other codes such as INVALID_ARGUMENT or NOT_FOUND are
+// the caller's fault and do not say anything about the health of the upstream.
+func DefaultClassifier(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted,
+		codes.Internal, codes.Unknown, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+// BreakerSelector returns the circuit breaker guarding a call to method on cc
+type BreakerSelector func(method string, cc *grpc.ClientConn) *circuitbreaker.CircuitBreaker
+
+// PerTarget selects a breaker per connection target from the registry
+func PerTarget(registry *circuitbreaker.Registry) BreakerSelector {
+	return func(_ string, cc *grpc.ClientConn) *circuitbreaker.CircuitBreaker {
+		return registry.Get(cc.Target())
+	}
+}
+
+// SingleBreaker guards every call with the same breaker
+func SingleBreaker(cb *circuitbreaker.CircuitBreaker) BreakerSelector {
+	return func(string, *grpc.ClientConn) *circuitbreaker.CircuitBreaker {
+		return cb
+	}
+}
+
+// rejectedError is returned for a call rejected by an open circuit. It
+// matches circuitbreaker.ErrOpenState with errors.Is and has status
+// UNAVAILABLE, so callers can handle it either way.
+type rejectedError struct{}
+
+func (rejectedError) Error() string { return circuitbreaker.ErrOpenState.Error() }
+
+func (rejectedError) Unwrap() error { return circuitbreaker.ErrOpenState }
+
+// GRPCStatus implements the interface used by status.FromError
+func (rejectedError) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, circuitbreaker.ErrOpenState.Error())
+}
+
+// Interceptor runs gRPC calls through a circuit breaker, the gRPC
+// counterpart of httpclient.Transport. Register both forms on a connection:
+//
+//	grpc.Dial(target,
+//		grpc.WithUnaryInterceptor(interceptor.Unary()),
+//		grpc.WithStreamInterceptor(interceptor.Stream()))
+//
+// Errors are returned to the caller unchanged; the classifier only decides
+// whether they count against the breaker. Calls the caller canceled are not
+// recorded.
+type Interceptor struct {
+	breakers BreakerSelector
+	classify Classifier
+	logger   *zap.Logger
+}
+
+// NewInterceptor creates a breaker interceptor
+func NewInterceptor(breakers BreakerSelector, logger *zap.Logger) *Interceptor {
+	return &Interceptor{
+		breakers: breakers,
+		classify: DefaultClassifier,
+		logger:   logger,
+	}
+}
+
+// SetClassifier replaces the default failure classification. It must be
+// called before the interceptor is in use.
+func (i *Interceptor) SetClassifier(classify Classifier) {
+	i.classify = classify
+}
+
+// outcome turns the result of a call into the outcome recorded by the
+// breaker: nil for success or an error the classifier accepts,
+// context.Canceled when the caller gave up, and the error otherwise
+func (i *Interceptor) outcome(ctx context.Context, err error) error {
+	switch {
+	case err == nil || err == io.EOF:
+		return nil
+	case errors.Is(ctx.Err(), context.Canceled):
+		return context.Canceled
+	case i.classify(err):
+		return err
+	}
+	return nil
+}
+
+// Unary returns the interceptor for unary calls
+func (i *Interceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		cb := i.breakers(method, cc)
+		start := time.Now()
+
+		done, err := cb.Allow()
+		if err != nil {
+			i.logger.Debug("gRPC call rejected", zap.String("method", method), zap.String("breaker", cb.GetConfig().Name))
+			return rejectedError{}
+		}
+
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		done(i.outcome(ctx, err))
+
+		i.logger.Debug("gRPC call",
+			zap.String("method", method),
+			zap.String("breaker", cb.GetConfig().Name),
+			zap.String("code", status.Code(err).String()),
+			zap.Duration("duration", time.Since(start)),
+		)
+		return err
+	}
+}
+
+// Stream returns the interceptor for streaming calls. The breaker records
+// the outcome when the stream ends: success when the server closed it with
+// OK, failure when it ended with a status the classifier rejects, including
+// a deadline that passed mid-stream. A stream the caller canceled is not
+// recorded.
+func (i *Interceptor) Stream() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cb := i.breakers(method, cc)
+
+		done, err := cb.Allow()
+		if err != nil {
+			i.logger.Debug("gRPC stream rejected", zap.String("method", method), zap.String("breaker", cb.GetConfig().Name))
+			return nil, rejectedError{}
+		}
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			done(i.outcome(ctx, err))
+			return nil, err
+		}
+
+		// A stream abandoned without being read to the end still reports
+		// its outcome once its context ends
+		stop := context.AfterFunc(ctx, func() {
+			done(i.outcome(ctx, status.FromContextError(ctx.Err()).Err()))
+		})
+
+		return &breakerStream{
+			ClientStream:  stream,
+			serverStreams: desc.ServerStreams,
+			finish: func(err error) {
+				stop()
+				done(i.outcome(ctx, err))
+			},
+		}, nil
+	}
+}
+
+// breakerStream reports the outcome of a stream to the breaker when it ends
+type breakerStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	finish        func(err error)
+}
+
+// RecvMsg implements grpc.ClientStream. A stream ends with io.EOF or an
+// error, or, when the server sends a single response, with that response.
+func (s *breakerStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.finish(err)
+	}
+	return err
+}
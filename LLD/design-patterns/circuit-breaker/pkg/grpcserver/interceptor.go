@@ -0,0 +1,71 @@
+// Package grpcserver holds the server side of the gRPC breaker integration:
+// interceptors returning the status codes grpcclient classifies.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+	"circuit-breaker-demo/pkg/grpcclient"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StatusError converts an error returned by a handler into a gRPC status
+// error, so the caller's breaker can classify it: an open circuit further
+// down becomes UNAVAILABLE, and context errors become DEADLINE_EXCEEDED or
+// CANCELED. Errors that already carry a status, and nil, are returned as is.
+func StatusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, circuitbreaker.ErrOpenState):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// logServerError logs calls that failed because of the server, which are
+// the ones grpcclient.DefaultClassifier holds against it
+func logServerError(logger *zap.Logger, method string, err error) {
+	if grpcclient.DefaultClassifier(err) {
+		logger.Error("gRPC call failed",
+			zap.String("method", method),
+			zap.String("code", status.Code(err).String()),
+			zap.Error(err),
+		)
+	}
+}
+
+// UnaryServerInterceptor returns handler errors as status errors (see
+// StatusError) and logs the ones that count against this server
+func UnaryServerInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		err = StatusError(err)
+		logServerError(logger, info.FullMethod, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls
+func StreamServerInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := StatusError(handler(srv, stream))
+		logServerError(logger, info.FullMethod, err)
+		return err
+	}
+}
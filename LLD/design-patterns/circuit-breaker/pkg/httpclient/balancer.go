@@ -0,0 +1,231 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+)
+
+// Balancing strategies accepted by NewBalancer
+const (
+	StrategyRoundRobin        = "round_robin"
+	StrategyWeighted          = "weighted"
+	StrategyLeastOutstanding  = "least_outstanding"
+	StrategyPowerOfTwoChoices = "p2c"
+)
+
+// Strategies lists every balancing strategy accepted by NewBalancer
+var Strategies = []string{StrategyRoundRobin, StrategyWeighted, StrategyLeastOutstanding, StrategyPowerOfTwoChoices}
+
+// Endpoint is one instance of an upstream service. Each endpoint has its own
+// circuit breaker, so a failing instance is taken out of rotation while the
+// others keep serving. Health checks and outlier detection can take it out
+// of rotation as well.
+type Endpoint struct {
+	URL    string
+	Weight int // Relative share for the weighted strategy; values below 1 count as 1
+
+	breaker     *circuitbreaker.CircuitBreaker
+	outstanding int64
+	health      endpointHealth
+}
+
+// NewEndpoint creates an endpoint guarded by cb
+func NewEndpoint(url string, weight int, cb *circuitbreaker.CircuitBreaker) *Endpoint {
+	if weight < 1 {
+		weight = 1
+	}
+
+	return &Endpoint{
+		URL:     strings.TrimSuffix(url, "/"),
+		Weight:  weight,
+		breaker: cb,
+	}
+}
+
+// Breaker returns the circuit breaker guarding this endpoint
+func (e *Endpoint) Breaker() *circuitbreaker.CircuitBreaker {
+	return e.breaker
+}
+
+// Outstanding returns the number of requests whose response body has not
+// been closed yet
+func (e *Endpoint) Outstanding() int64 {
+	return atomic.LoadInt64(&e.outstanding)
+}
+
+// available reports whether the endpoint should receive requests: its
+// breaker is not open, it passes health checks and it is not ejected
+func (e *Endpoint) available() bool {
+	return e.breaker.GetState() != circuitbreaker.StateOpen && e.Healthy()
+}
+
+// acquire counts a request against the endpoint and returns the function
+// that releases it; calling release more than once has no effect
+func (e *Endpoint) acquire() (release func()) {
+	atomic.AddInt64(&e.outstanding, 1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(&e.outstanding, -1)
+		})
+	}
+}
+
+// Balancer chooses the endpoint for the next request. Pick is only called
+// with a non-empty slice and must be safe for concurrent use.
+type Balancer interface {
+	Pick(endpoints []*Endpoint) *Endpoint
+}
+
+// NewBalancer returns the balancer for a strategy name; "" selects round robin
+func NewBalancer(strategy string) (Balancer, error) {
+	switch strategy {
+	case "", StrategyRoundRobin:
+		return &RoundRobin{}, nil
+	case StrategyWeighted:
+		return &WeightedRoundRobin{}, nil
+	case StrategyLeastOutstanding:
+		return &LeastOutstanding{}, nil
+	case StrategyPowerOfTwoChoices:
+		return &PowerOfTwoChoices{}, nil
+	default:
+		return nil, fmt.Errorf("unknown balancing strategy %q (want one of %s)", strategy, strings.Join(Strategies, ", "))
+	}
+}
+
+// RoundRobin cycles through the endpoints in order
+type RoundRobin struct {
+	next uint64
+}
+
+// Pick implements Balancer
+func (b *RoundRobin) Pick(endpoints []*Endpoint) *Endpoint {
+	n := atomic.AddUint64(&b.next, 1) - 1
+	return endpoints[n%uint64(len(endpoints))]
+}
+
+// WeightedRoundRobin is the smooth weighted round robin from the scheduling
+// notes: every pick adds each endpoint's weight to its current weight, the
+// highest current weight wins and is reduced by the total. Endpoints with
+// weights 5, 1, 1 are picked a, a, b, a, c, a, a rather than in a burst.
+type WeightedRoundRobin struct {
+	mutex   sync.Mutex
+	current map[*Endpoint]int
+}
+
+// Pick implements Balancer
+func (b *WeightedRoundRobin) Pick(endpoints []*Endpoint) *Endpoint {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.current == nil {
+		b.current = make(map[*Endpoint]int)
+	}
+
+	total := 0
+	var selected *Endpoint
+	for _, endpoint := range endpoints {
+		total += endpoint.Weight
+		b.current[endpoint] += endpoint.Weight
+		if selected == nil || b.current[endpoint] > b.current[selected] {
+			selected = endpoint
+		}
+	}
+
+	b.current[selected] -= total
+	return selected
+}
+
+// LeastOutstanding picks the endpoint with the fewest requests in flight.
+// Ties are broken round robin so idle endpoints share the load.
+type LeastOutstanding struct {
+	next uint64
+}
+
+// Pick implements Balancer
+func (b *LeastOutstanding) Pick(endpoints []*Endpoint) *Endpoint {
+	start := int((atomic.AddUint64(&b.next, 1) - 1) % uint64(len(endpoints)))
+
+	var selected *Endpoint
+	for i := range endpoints {
+		endpoint := endpoints[(start+i)%len(endpoints)]
+		if selected == nil || endpoint.Outstanding() < selected.Outstanding() {
+			selected = endpoint
+		}
+	}
+	return selected
+}
+
+// PowerOfTwoChoices samples two endpoints at random and picks the one with
+// fewer requests in flight. It avoids the herding of LeastOutstanding when
+// many clients share stale load information, at O(1) cost per pick.
+type PowerOfTwoChoices struct {
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+// Pick implements Balancer
+func (b *PowerOfTwoChoices) Pick(endpoints []*Endpoint) *Endpoint {
+	if len(endpoints) == 1 {
+		return endpoints[0]
+	}
+
+	b.mutex.Lock()
+	if b.rand == nil {
+		b.rand = rand.New(rand.NewSource(rand.Int63()))
+	}
+	i := b.rand.Intn(len(endpoints))
+	j := b.rand.Intn(len(endpoints) - 1)
+	b.mutex.Unlock()
+
+	if j >= i {
+		j++
+	}
+
+	first, second := endpoints[i], endpoints[j]
+	if second.Outstanding() < first.Outstanding() {
+		return second
+	}
+	return first
+}
+
+// endpointKey is the context key carrying the endpoint chosen for a request
+type endpointKey struct{}
+
+// withEndpoint returns a context that routes the request's breaker to endpoint
+func withEndpoint(ctx context.Context, endpoint *Endpoint) context.Context {
+	return context.WithValue(ctx, endpointKey{}, endpoint)
+}
+
+// endpointFrom returns the endpoint stored by withEndpoint, or nil
+func endpointFrom(ctx context.Context) *Endpoint {
+	endpoint, _ := ctx.Value(endpointKey{}).(*Endpoint)
+	return endpoint
+}
+
+// endpointBreaker selects the breaker of the endpoint HTTPClient chose for the request
+func endpointBreaker(req *http.Request) *circuitbreaker.CircuitBreaker {
+	return endpointFrom(req.Context()).breaker
+}
+
+// trackedBody runs release when the body is closed, e.g. to free the
+// endpoint's outstanding slot or cancel the request context
+type trackedBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,185 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+
+	"go.uber.org/zap"
+)
+
+// newTestEndpoints returns an endpoint per host, named after it, with the
+// given weights and a breaker that opens after two failures
+func newTestEndpoints(hosts []string, weights ...int) []*Endpoint {
+	endpoints := make([]*Endpoint, len(hosts))
+	for i, host := range hosts {
+		weight := 1
+		if i < len(weights) {
+			weight = weights[i]
+		}
+		config := circuitbreaker.DefaultConfig(host)
+		config.FailureThreshold = 2
+		cb := circuitbreaker.NewCircuitBreaker(config, zap.NewNop())
+		endpoints[i] = NewEndpoint("http://"+host, weight, cb)
+	}
+	return endpoints
+}
+
+// picks returns the hosts of n endpoints picked by b
+func picks(b Balancer, endpoints []*Endpoint, n int) string {
+	hosts := make([]string, n)
+	for i := range hosts {
+		hosts[i] = strings.TrimPrefix(b.Pick(endpoints).URL, "http://")
+	}
+	return strings.Join(hosts, ",")
+}
+
+func TestNewBalancer(t *testing.T) {
+	for _, strategy := range append([]string{""}, Strategies...) {
+		if _, err := NewBalancer(strategy); err != nil {
+			t.Errorf("NewBalancer(%q) = %v", strategy, err)
+		}
+	}
+	if _, err := NewBalancer("random"); err == nil {
+		t.Error("NewBalancer(\"random\") succeeded, want an error")
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	endpoints := newTestEndpoints([]string{"a", "b", "c"})
+
+	if got := picks(&RoundRobin{}, endpoints, 7); got != "a,b,c,a,b,c,a" {
+		t.Errorf("Picks = %s, want a,b,c,a,b,c,a", got)
+	}
+}
+
+func TestWeightedRoundRobinIsSmooth(t *testing.T) {
+	endpoints := newTestEndpoints([]string{"a", "b", "c"}, 5, 1, 1)
+
+	if got := picks(&WeightedRoundRobin{}, endpoints, 14); got != "a,a,b,a,c,a,a,a,a,b,a,c,a,a" {
+		t.Errorf("Picks = %s, want a,a,b,a,c,a,a twice", got)
+	}
+}
+
+func TestLeastOutstanding(t *testing.T) {
+	endpoints := newTestEndpoints([]string{"a", "b", "c"})
+	balancer := &LeastOutstanding{}
+
+	// Idle endpoints share the load
+	if got := picks(balancer, endpoints, 3); got != "a,b,c" {
+		t.Errorf("Picks of idle endpoints = %s, want a,b,c", got)
+	}
+
+	releaseA := endpoints[0].acquire()
+	endpoints[1].acquire()
+	endpoints[1].acquire()
+	if got := picks(balancer, endpoints, 3); got != "c,c,c" {
+		t.Errorf("Picks = %s, want the idle c", got)
+	}
+
+	releaseA()
+	releaseA()
+	if got := endpoints[0].Outstanding(); got != 0 {
+		t.Errorf("Outstanding after releasing twice = %d, want 0", got)
+	}
+}
+
+func TestPowerOfTwoChoicesAvoidsBusiestEndpoint(t *testing.T) {
+	endpoints := newTestEndpoints([]string{"a", "b", "c"})
+	for i := 0; i < 10; i++ {
+		endpoints[1].acquire()
+	}
+
+	balancer := &PowerOfTwoChoices{}
+	seen := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		seen[balancer.Pick(endpoints).URL]++
+	}
+	if seen["http://b"] != 0 {
+		t.Errorf("Busy endpoint picked %d times, want never", seen["http://b"])
+	}
+	if seen["http://a"] == 0 || seen["http://c"] == 0 {
+		t.Errorf("Picks = %v, want both idle endpoints", seen)
+	}
+
+	if got := balancer.Pick(endpoints[:1]); got != endpoints[0] {
+		t.Errorf("Pick of one endpoint = %s, want it", got.URL)
+	}
+}
+
+// hostTransport answers with the status and after the latency set for the
+// request's host and counts the requests each host received
+type hostTransport struct {
+	mutex     sync.Mutex
+	statuses  map[string]int
+	latencies map[string]time.Duration
+	calls     map[string]int
+}
+
+func (h *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h.mutex.Lock()
+	h.calls[req.URL.Host]++
+	step := Respond(h.statuses[req.URL.Host], nil).After(h.latencies[req.URL.Host])
+	h.mutex.Unlock()
+
+	return step.respond(req)
+}
+
+// callsTo returns how many requests host received
+func (h *hostTransport) callsTo(host string) int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.calls[host]
+}
+
+func TestBalancedClientSkipsEndpointWithOpenBreaker(t *testing.T) {
+	endpoints := newTestEndpoints([]string{"md-1", "md-2"})
+	hosts := &hostTransport{
+		statuses: map[string]int{"md-1": http.StatusInternalServerError, "md-2": http.StatusOK},
+		calls:    make(map[string]int),
+	}
+	client := NewBalancedHTTPClient(endpoints, &RoundRobin{}, time.Second, zap.NewNop())
+	client.Transport().SetBase(hosts)
+
+	for i := 0; i < 8; i++ {
+		resp, err := client.Get(context.Background(), "/api/v1/prices/AAPL")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	if state := endpoints[0].Breaker().GetState(); state != circuitbreaker.StateOpen {
+		t.Errorf("md-1 breaker = %v, want open", state)
+	}
+	if state := endpoints[1].Breaker().GetState(); state != circuitbreaker.StateClosed {
+		t.Errorf("md-2 breaker = %v, want closed", state)
+	}
+	if hosts.calls["md-1"] != 2 || hosts.calls["md-2"] != 6 {
+		t.Errorf("Calls = %v, want md-1 skipped once its breaker opened", hosts.calls)
+	}
+	for _, endpoint := range endpoints {
+		if outstanding := endpoint.Outstanding(); outstanding != 0 {
+			t.Errorf("%s outstanding = %d, want 0 after closing every body", endpoint.URL, outstanding)
+		}
+	}
+}
+
+func TestBalancedClientWithEveryBreakerOpen(t *testing.T) {
+	endpoints := newTestEndpoints([]string{"md-1", "md-2"})
+	for _, endpoint := range endpoints {
+		endpoint.Breaker().OpenUntil(time.Now().Add(time.Minute))
+	}
+	client := NewBalancedHTTPClient(endpoints, &RoundRobin{}, time.Second, zap.NewNop())
+	client.Transport().SetBase(NewFakeTransport())
+
+	if _, err := client.Get(context.Background(), "/api/v1/prices/AAPL"); !errors.Is(err, circuitbreaker.ErrOpenState) {
+		t.Errorf("Get() = %v, want ErrOpenState", err)
+	}
+}
@@ -0,0 +1,253 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// ErrNotInBatch is returned to a caller whose key is missing from a batch
+// response that reported neither a result nor an error for it
+var ErrNotInBatch = errors.New("key missing from batch response")
+
+// BatchFunc loads many keys with one upstream call. It returns a result or
+// an error per key, or err when the whole batch failed.
+type BatchFunc func(ctx context.Context, keys []string) (results map[string]interface{}, keyErrors map[string]error, err error)
+
+// BatchPolicy configures when a batch is sent
+type BatchPolicy struct {
+	MaxWait time.Duration // Send a batch this long after its first key arrived
+	MaxSize int           // Send a batch as soon as it holds this many distinct keys
+}
+
+// Batcher collects individual lookups for a short time and sends them as
+// one batch request. Callers asking for the same key in the same batch
+// share its result.
+//
+// A batch runs until the latest deadline of its callers, so it is not cut
+// short by the first one to give up, and never longer than the batcher's
+// timeout. It carries the request ID of the caller that started it; the IDs
+// of the others are logged with the batch.
+type Batcher struct {
+	name    string
+	load    BatchFunc
+	timeout time.Duration
+	logger  *zap.Logger
+	metrics *batchMetrics
+
+	mutex   sync.Mutex
+	policy  BatchPolicy
+	pending map[string][]chan batchResult
+	keys    []string
+	callers batchCallers
+	timer   *time.Timer
+	batch   uint64 // Increases with every flush so a late timer cannot flush the next batch
+}
+
+// batchCallers is what a batch takes from the contexts of its callers
+type batchCallers struct {
+	requestIDs []string
+	deadline   time.Time // Latest caller deadline
+	unbounded  bool      // Some caller has no deadline
+}
+
+// add records the context of a caller joining the batch
+func (b *batchCallers) add(ctx context.Context) {
+	if id := RequestIDFrom(ctx); id != "" {
+		b.requestIDs = append(b.requestIDs, id)
+	}
+	deadline, ok := ctx.Deadline()
+	switch {
+	case !ok:
+		b.unbounded = true
+	case deadline.After(b.deadline):
+		b.deadline = deadline
+	}
+}
+
+// context returns the context a batch runs with: the first caller's
+// request ID, and the latest caller deadline within timeout
+func (b batchCallers) context(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if len(b.requestIDs) > 0 {
+		ctx = WithRequestID(ctx, b.requestIDs[0])
+	}
+
+	deadline := time.Now().Add(timeout)
+	if !b.unbounded && b.deadline.Before(deadline) {
+		deadline = b.deadline
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+// batchResult is delivered to each caller waiting on a key
+type batchResult struct {
+	value interface{}
+	err   error
+}
+
+// batchMetrics holds Prometheus metrics for batching
+type batchMetrics struct {
+	batchesTotal *prometheus.CounterVec
+	batchSize    *prometheus.HistogramVec
+}
+
+var (
+	batchMetricsOnce   sync.Once
+	globalBatchMetrics *batchMetrics
+)
+
+func getBatchMetrics() *batchMetrics {
+	batchMetricsOnce.Do(func() {
+		globalBatchMetrics = &batchMetrics{
+			batchesTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "http_client_batches_total",
+					Help: "Total number of batch requests by trigger (size or wait) and result",
+				},
+				[]string{"batcher", "trigger", "result"},
+			),
+			batchSize: promauto.NewHistogramVec(
+				prometheus.HistogramOpts{
+					Name:    "http_client_batch_size",
+					Help:    "Number of distinct keys per batch request",
+					Buckets: []float64{1, 2, 5, 10, 20, 50, 100},
+				},
+				[]string{"batcher"},
+			),
+		}
+	})
+	return globalBatchMetrics
+}
+
+// NewBatcher creates a batcher. Each batch runs with its own context
+// limited by timeout, since it serves callers with different contexts.
+func NewBatcher(name string, load BatchFunc, policy BatchPolicy, timeout time.Duration, logger *zap.Logger) *Batcher {
+	return &Batcher{
+		name:    name,
+		load:    load,
+		timeout: timeout,
+		logger:  logger,
+		metrics: getBatchMetrics(),
+		policy:  policy,
+		pending: make(map[string][]chan batchResult),
+	}
+}
+
+// SetPolicy changes the policy for batches started from now on
+func (b *Batcher) SetPolicy(policy BatchPolicy) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.policy = policy
+}
+
+// Load adds key to the current batch and waits for its result
+func (b *Batcher) Load(ctx context.Context, key string) (interface{}, error) {
+	result := make(chan batchResult, 1)
+
+	b.mutex.Lock()
+	if _, exists := b.pending[key]; !exists {
+		b.keys = append(b.keys, key)
+	}
+	b.pending[key] = append(b.pending[key], result)
+	b.callers.add(ctx)
+
+	switch {
+	case b.policy.MaxSize > 0 && len(b.keys) >= b.policy.MaxSize:
+		b.flushLocked("size")
+	case b.timer == nil:
+		batch := b.batch
+		b.timer = time.AfterFunc(b.policy.MaxWait, func() { b.flushOnTimer(batch) })
+	}
+	b.mutex.Unlock()
+
+	select {
+	case r := <-result:
+		return r.value, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// flushOnTimer sends the batch when its wait time is up, unless it was
+// already sent because it filled up
+func (b *Batcher) flushOnTimer(batch uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if batch == b.batch {
+		b.flushLocked("wait")
+	}
+}
+
+// flushLocked takes the pending batch and sends it in the background.
+// b.mutex must be held.
+func (b *Batcher) flushLocked(trigger string) {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.keys) == 0 {
+		return
+	}
+
+	keys, waiters, callers := b.keys, b.pending, b.callers
+	b.batch++
+	b.keys = nil
+	b.pending = make(map[string][]chan batchResult)
+	b.callers = batchCallers{}
+
+	go b.send(trigger, keys, waiters, callers)
+}
+
+// send runs one batch and fans the results out to the waiting callers
+func (b *Batcher) send(trigger string, keys []string, waiters map[string][]chan batchResult, callers batchCallers) {
+	ctx, cancel := callers.context(b.timeout)
+	defer cancel()
+
+	b.metrics.batchSize.WithLabelValues(b.name).Observe(float64(len(keys)))
+	b.logger.Debug("Sending batch",
+		zap.String("batcher", b.name),
+		zap.Int("keys", len(keys)),
+		zap.Strings("requestIds", callers.requestIDs),
+	)
+
+	results, keyErrors, err := b.load(ctx, keys)
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+		b.logger.Error("Batch request failed",
+			zap.String("batcher", b.name),
+			zap.Int("keys", len(keys)),
+			zap.Strings("requestIds", callers.requestIDs),
+			zap.Error(err),
+		)
+	}
+	b.metrics.batchesTotal.WithLabelValues(b.name, trigger, outcome).Inc()
+
+	for key, channels := range waiters {
+		var r batchResult
+		switch {
+		case err != nil:
+			r.err = err
+		case keyErrors[key] != nil:
+			r.err = keyErrors[key]
+		default:
+			value, exists := results[key]
+			if !exists {
+				r.err = ErrNotInBatch
+			}
+			r.value = value
+		}
+
+		for _, ch := range channels {
+			ch <- r
+		}
+	}
+}
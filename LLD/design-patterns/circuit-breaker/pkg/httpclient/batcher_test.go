@@ -0,0 +1,204 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// quoteLoader is a BatchFunc serving prices for known symbols and
+// recording each batch it was asked for
+type quoteLoader struct {
+	prices  map[string]float64
+	latency time.Duration
+	err     error
+
+	mutex   sync.Mutex
+	batches [][]string
+	ctxs    []context.Context
+}
+
+func (l *quoteLoader) load(ctx context.Context, symbols []string) (map[string]interface{}, map[string]error, error) {
+	l.mutex.Lock()
+	l.batches = append(l.batches, symbols)
+	l.ctxs = append(l.ctxs, ctx)
+	l.mutex.Unlock()
+
+	select {
+	case <-time.After(l.latency):
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	if l.err != nil {
+		return nil, nil, l.err
+	}
+
+	results := make(map[string]interface{})
+	keyErrors := make(map[string]error)
+	for _, symbol := range symbols {
+		if price, exists := l.prices[symbol]; exists {
+			results[symbol] = price
+		} else if symbol != "GONE" {
+			keyErrors[symbol] = errors.New("symbol not found")
+		}
+	}
+	return results, keyErrors, nil
+}
+
+func (l *quoteLoader) recorded() ([][]string, []context.Context) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.batches, l.ctxs
+}
+
+// loadAll looks up the symbols concurrently, each with its own context
+func loadAll(b *Batcher, ctxs []context.Context, symbols []string) ([]interface{}, []error) {
+	values := make([]interface{}, len(symbols))
+	errs := make([]error, len(symbols))
+
+	var wg sync.WaitGroup
+	for i := range symbols {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = b.Load(ctxs[i], symbols[i])
+		}(i)
+		time.Sleep(time.Millisecond) // Keep the order of keys in the batch
+	}
+	wg.Wait()
+	return values, errs
+}
+
+func backgrounds(n int) []context.Context {
+	ctxs := make([]context.Context, n)
+	for i := range ctxs {
+		ctxs[i] = context.Background()
+	}
+	return ctxs
+}
+
+func TestBatcherMergesConcurrentLoads(t *testing.T) {
+	loader := &quoteLoader{prices: map[string]float64{"AAPL": 150, "MSFT": 380}}
+	b := NewBatcher("quotes", loader.load, BatchPolicy{MaxWait: 30 * time.Millisecond, MaxSize: 10}, time.Second, zap.NewNop())
+
+	symbols := []string{"AAPL", "MSFT", "AAPL", "NOPE", "GONE"}
+	values, errs := loadAll(b, backgrounds(len(symbols)), symbols)
+
+	batches, _ := loader.recorded()
+	if want := [][]string{{"AAPL", "MSFT", "NOPE", "GONE"}}; !reflect.DeepEqual(batches, want) {
+		t.Fatalf("Batches = %v, want %v", batches, want)
+	}
+
+	tests := []struct {
+		value   interface{}
+		wantErr bool
+	}{
+		{150.0, false}, {380.0, false}, {150.0, false}, {nil, true}, {nil, true},
+	}
+	for i, tt := range tests {
+		if values[i] != tt.value || (errs[i] != nil) != tt.wantErr {
+			t.Errorf("%s = %v, %v, want %v", symbols[i], values[i], errs[i], tt.value)
+		}
+	}
+	if !errors.Is(errs[4], ErrNotInBatch) {
+		t.Errorf("Missing key = %v, want ErrNotInBatch", errs[4])
+	}
+}
+
+func TestBatcherSendsFullBatchAtOnce(t *testing.T) {
+	loader := &quoteLoader{prices: map[string]float64{"AAPL": 150, "MSFT": 380, "TSLA": 240}}
+	b := NewBatcher("quotes", loader.load, BatchPolicy{MaxWait: time.Minute, MaxSize: 2}, time.Second, zap.NewNop())
+
+	start := time.Now()
+	_, errs := loadAll(b, backgrounds(2), []string{"AAPL", "MSFT"})
+	if errs[0] != nil || errs[1] != nil || time.Since(start) > time.Second {
+		t.Fatalf("Full batch = %v after %v, want it sent without waiting", errs, time.Since(start))
+	}
+
+	// The next key starts a new batch
+	b.SetPolicy(BatchPolicy{MaxWait: 10 * time.Millisecond, MaxSize: 2})
+	if _, err := b.Load(context.Background(), "TSLA"); err != nil {
+		t.Fatal(err)
+	}
+	if batches, _ := loader.recorded(); len(batches) != 2 {
+		t.Errorf("Batches = %v, want 2", batches)
+	}
+}
+
+func TestBatcherFailureReachesEveryCaller(t *testing.T) {
+	down := errors.New("market data unavailable")
+	loader := &quoteLoader{err: down}
+	b := NewBatcher("quotes", loader.load, BatchPolicy{MaxWait: 10 * time.Millisecond}, time.Second, zap.NewNop())
+
+	_, errs := loadAll(b, backgrounds(3), []string{"AAPL", "MSFT", "AAPL"})
+	for i, err := range errs {
+		if !errors.Is(err, down) {
+			t.Errorf("Caller %d = %v, want the batch error", i, err)
+		}
+	}
+}
+
+func TestBatchRunsUntilLatestCallerDeadline(t *testing.T) {
+	loader := &quoteLoader{prices: map[string]float64{"AAPL": 150, "MSFT": 380}, latency: 60 * time.Millisecond}
+	b := NewBatcher("quotes", loader.load, BatchPolicy{MaxWait: 10 * time.Millisecond}, time.Second, zap.NewNop())
+
+	first, cancelFirst := context.WithTimeout(WithRequestID(context.Background(), "first-request"), 30*time.Millisecond)
+	defer cancelFirst()
+	second, cancelSecond := context.WithTimeout(WithRequestID(context.Background(), "second-request"), 500*time.Millisecond)
+	defer cancelSecond()
+
+	values, errs := loadAll(b, []context.Context{first, second}, []string{"AAPL", "MSFT"})
+	if !errors.Is(errs[0], context.DeadlineExceeded) {
+		t.Errorf("First caller = %v, want its own deadline exceeded", errs[0])
+	}
+	if errs[1] != nil || values[1] != 380.0 {
+		t.Errorf("Second caller = %v, %v, want the price the first caller gave up on", values[1], errs[1])
+	}
+
+	_, ctxs := loader.recorded()
+	deadline, _ := ctxs[0].Deadline()
+	if want, _ := second.Deadline(); !deadline.Equal(want) {
+		t.Errorf("Batch deadline = %v, want the second caller's %v", deadline, want)
+	}
+	if id := RequestIDFrom(ctxs[0]); id != "first-request" {
+		t.Errorf("Batch request ID = %q, want the first caller's", id)
+	}
+}
+
+func TestBatchDeadlineIsBoundedByTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline time.Duration // 0 for a caller without a deadline
+	}{
+		{"no caller deadline", 0},
+		{"caller deadline past the timeout", time.Minute},
+	}
+
+	for _, tt := range tests {
+		loader := &quoteLoader{prices: map[string]float64{"AAPL": 150}}
+		b := NewBatcher("quotes", loader.load, BatchPolicy{MaxWait: time.Millisecond}, 200*time.Millisecond, zap.NewNop())
+
+		ctx := context.Background()
+		if tt.deadline > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+			defer cancel()
+		}
+
+		start := time.Now()
+		if _, err := b.Load(ctx, "AAPL"); err != nil {
+			t.Fatal(err)
+		}
+		_, ctxs := loader.recorded()
+		deadline, ok := ctxs[0].Deadline()
+		if in := deadline.Sub(start); !ok || in < 200*time.Millisecond || in > 250*time.Millisecond {
+			t.Errorf("%s: batch deadline in %v, want the 200ms timeout", tt.name, deadline.Sub(start))
+		}
+	}
+}
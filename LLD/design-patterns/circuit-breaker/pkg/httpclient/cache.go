@@ -0,0 +1,318 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// defaultCacheEntries caps the cache when CachePolicy.MaxEntries is 0
+const defaultCacheEntries = 1000
+
+// CachePolicy configures coalescing and caching of GET requests. Only
+// requests without extra headers take part, keyed by path.
+type CachePolicy struct {
+	Coalesce   bool          // Concurrent GETs for the same path share one upstream request
+	TTL        time.Duration // Keep 200 responses this long; 0 disables caching
+	MaxEntries int           // Defaults to 1000
+}
+
+// enabled reports whether the policy does anything
+func (p CachePolicy) enabled() bool {
+	return p.Coalesce || p.TTL > 0
+}
+
+// cacheEntry is a fully read response
+type cacheEntry struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	etag       string
+	expires    time.Time
+}
+
+// response returns a new *http.Response carrying the entry
+func (e *cacheEntry) response() *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.statusCode, http.StatusText(e.statusCode)),
+		StatusCode:    e.statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+	}
+}
+
+// freshness returns how long a response may be cached under the policy TTL
+// and its Cache-Control header, and whether it may be stored at all. The
+// gateway serves many users, so it behaves as a shared cache: private and
+// no-store responses are not kept and s-maxage wins over max-age.
+func freshness(header http.Header, ttl time.Duration) (time.Duration, bool) {
+	maxAge := -1
+	sharedMaxAge := -1
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(strings.ToLower(directive)), "=")
+		switch name {
+		case "no-store", "private":
+			return 0, false
+		case "no-cache":
+			maxAge = 0
+		case "max-age":
+			if seconds, err := strconv.Atoi(value); err == nil && maxAge != 0 {
+				maxAge = seconds
+			}
+		case "s-maxage":
+			if seconds, err := strconv.Atoi(value); err == nil {
+				sharedMaxAge = seconds
+			}
+		}
+	}
+	if sharedMaxAge >= 0 && maxAge != 0 {
+		maxAge = sharedMaxAge
+	}
+
+	if maxAge >= 0 && time.Duration(maxAge)*time.Second < ttl {
+		return time.Duration(maxAge) * time.Second, true
+	}
+	return ttl, true
+}
+
+// responseCache is a small TTL cache of GET responses. Expired entries are
+// kept while they have an ETag so they can be revalidated. Entries are never
+// modified once stored.
+type responseCache struct {
+	mutex   sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// get returns the entry for key, fresh or not
+func (c *responseCache) get(key string) *cacheEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.entries[key]
+}
+
+// put stores an entry, evicting expired entries and then the entry closest
+// to expiry when the cache is full
+func (c *responseCache) put(key string, entry *cacheEntry, maxEntries int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*cacheEntry)
+	}
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheEntries
+	}
+
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= maxEntries {
+		now := time.Now()
+		var oldestKey string
+		var oldest *cacheEntry
+		for k, e := range c.entries {
+			if now.After(e.expires) && e.etag == "" {
+				delete(c.entries, k)
+				continue
+			}
+			if oldest == nil || e.expires.Before(oldest.expires) {
+				oldestKey, oldest = k, e
+			}
+		}
+		if len(c.entries) >= maxEntries {
+			delete(c.entries, oldestKey)
+		}
+	}
+
+	c.entries[key] = entry
+}
+
+// flightGroup lets concurrent callers with the same key share one call
+type flightGroup struct {
+	mutex sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is a call in progress or completed
+type flightCall struct {
+	done  chan struct{}
+	entry *cacheEntry
+	err   error
+}
+
+// do runs fn once per key at a time. Callers arriving while it runs share
+// its result and report shared. fn runs in its own goroutine, so it must not
+// depend on the context of any caller; a caller whose context ends stops
+// waiting without affecting the others. A panic in fn fails the call for
+// every caller instead of leaving them waiting.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (*cacheEntry, error)) (entry *cacheEntry, shared bool, err error) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	call, shared := g.calls[key]
+	if !shared {
+		call = &flightCall{done: make(chan struct{})}
+		g.calls[key] = call
+		go g.run(key, call, fn)
+	}
+	g.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.entry, shared, call.err
+	case <-ctx.Done():
+		return nil, shared, ctx.Err()
+	}
+}
+
+// run calls fn and hands its result to the callers of the key
+func (g *flightGroup) run(key string, call *flightCall, fn func() (*cacheEntry, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			call.entry, call.err = nil, fmt.Errorf("coalesced request panicked: %v", r)
+		}
+		g.mutex.Lock()
+		delete(g.calls, key)
+		g.mutex.Unlock()
+		close(call.done)
+	}()
+
+	call.entry, call.err = fn()
+}
+
+// sharedContext returns the context of a request made on behalf of several
+// callers. It keeps the values of ctx but none of what belongs to its
+// caller alone: cancellation, deadline, request ID and idempotency key. The
+// request is bounded by timeout instead.
+func sharedContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx = context.WithoutCancel(ctx)
+	ctx = WithRequestID(ctx, "")
+	ctx = WithIdempotencyKey(ctx, "")
+	return context.WithTimeout(ctx, timeout)
+}
+
+// cacheMetrics holds Prometheus metrics for coalescing and caching
+type cacheMetrics struct {
+	requestsTotal *prometheus.CounterVec
+}
+
+var (
+	cacheMetricsOnce   sync.Once
+	globalCacheMetrics *cacheMetrics
+)
+
+func getCacheMetrics() *cacheMetrics {
+	cacheMetricsOnce.Do(func() {
+		globalCacheMetrics = &cacheMetrics{
+			requestsTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "http_client_cache_requests_total",
+					Help: "GET requests by cache result (hit, revalidated, coalesced, miss)",
+				},
+				[]string{"result"},
+			),
+		}
+	})
+	return globalCacheMetrics
+}
+
+// doCached serves a GET from the cache, joins an identical request in
+// flight or fetches it. Every caller gets its own copy of the response.
+func (c *HTTPClient) doCached(ctx context.Context, path string, policy CachePolicy) (*http.Response, error) {
+	metrics := getCacheMetrics()
+
+	if policy.TTL > 0 {
+		if entry := c.cache.get(path); entry != nil && time.Now().Before(entry.expires) {
+			metrics.requestsTotal.WithLabelValues("hit").Inc()
+			return entry.response(), nil
+		}
+	}
+
+	if !policy.Coalesce {
+		entry, err := c.fetch(ctx, path, policy)
+		if err != nil {
+			return nil, err
+		}
+		return entry.response(), nil
+	}
+
+	// The fetch serves everyone who joins it, so it neither ends with the
+	// caller that started it nor carries that caller's request ID or deadline
+	entry, shared, err := c.flight.do(ctx, path, func() (*cacheEntry, error) {
+		fetchCtx, cancel := sharedContext(ctx, c.GetTimeout())
+		defer cancel()
+		return c.fetch(fetchCtx, path, policy)
+	})
+	if shared {
+		metrics.requestsTotal.WithLabelValues("coalesced").Inc()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry.response(), nil
+}
+
+// fetch sends the GET upstream, revalidating a stale entry with
+// If-None-Match when it has an ETag, and caches the result
+func (c *HTTPClient) fetch(ctx context.Context, path string, policy CachePolicy) (*cacheEntry, error) {
+	metrics := getCacheMetrics()
+
+	var headers map[string]string
+	stale := c.cache.get(path)
+	if policy.TTL > 0 && stale != nil && stale.etag != "" {
+		headers = map[string]string{"If-None-Match": stale.etag}
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, path, nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && headers != nil {
+		ttl, _ := freshness(resp.Header, policy.TTL)
+		revalidated := *stale
+		revalidated.expires = time.Now().Add(ttl)
+		c.cache.put(path, &revalidated, policy.MaxEntries)
+		metrics.requestsTotal.WithLabelValues("revalidated").Inc()
+		return &revalidated, nil
+	}
+	metrics.requestsTotal.WithLabelValues("miss").Inc()
+
+	maxSize := c.getMaxResponseSize()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if int64(len(body)) > maxSize {
+		return nil, fmt.Errorf("%w: limit is %d bytes", ErrResponseTooLarge, maxSize)
+	}
+
+	entry := &cacheEntry{
+		statusCode: resp.StatusCode,
+		header:     resp.Header,
+		body:       body,
+		etag:       resp.Header.Get("ETag"),
+	}
+
+	if policy.TTL > 0 && resp.StatusCode == http.StatusOK {
+		if ttl, storable := freshness(resp.Header, policy.TTL); storable && (ttl > 0 || entry.etag != "") {
+			entry.expires = time.Now().Add(ttl)
+			c.cache.put(path, entry, policy.MaxEntries)
+		}
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,229 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const pricePath = "/api/v1/prices/AAPL"
+
+func TestCacheCoalescesConcurrentGets(t *testing.T) {
+	fake := NewFakeTransport().On(http.MethodGet, pricePath,
+		Respond(http.StatusOK, map[string]float64{"price": 150}).After(50*time.Millisecond))
+	client := newTestClient(t, fake)
+	client.SetCachePolicy(CachePolicy{Coalesce: true})
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	errs := make([]error, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i], errs[i] = getBody(context.Background(), client, pricePath)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range bodies {
+		if errs[i] != nil || bodies[i] != `{"price":150}` {
+			t.Errorf("Caller %d = %q, %v, want the shared response", i, bodies[i], errs[i])
+		}
+	}
+	if calls := fake.Calls(http.MethodGet, pricePath); calls != 1 {
+		t.Errorf("Upstream calls = %d, want 1", calls)
+	}
+
+	// Without a TTL nothing is kept once the call completes
+	if _, err := getBody(context.Background(), client, pricePath); err != nil {
+		t.Fatal(err)
+	}
+	if calls := fake.Calls(http.MethodGet, pricePath); calls != 2 {
+		t.Errorf("Upstream calls after the coalesced one = %d, want 2", calls)
+	}
+}
+
+func TestCoalescedFetchOutlivesFirstCaller(t *testing.T) {
+	fake := NewFakeTransport().On(http.MethodGet, pricePath,
+		Respond(http.StatusOK, map[string]float64{"price": 150}).After(100*time.Millisecond))
+	client := newTestClient(t, fake)
+	client.SetCachePolicy(CachePolicy{Coalesce: true})
+
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := getBody(first, client, pricePath)
+		firstErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := getBody(context.Background(), client, pricePath)
+		second <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("First caller = %v, want context.Canceled", err)
+	}
+	if err := <-second; err != nil {
+		t.Errorf("Second caller = %v, want the response the first caller gave up on", err)
+	}
+	if calls := fake.Calls(http.MethodGet, pricePath); calls != 1 {
+		t.Errorf("Upstream calls = %d, want 1", calls)
+	}
+}
+
+func TestCoalescedFetchCarriesNoCallerContext(t *testing.T) {
+	headers := &headerLog{next: NewFakeTransport().On(http.MethodGet, pricePath, Respond(http.StatusOK, nil))}
+	client := newTestClient(t, headers)
+	client.SetCachePolicy(CachePolicy{Coalesce: true})
+
+	ctx, cancel := context.WithTimeout(WithRequestID(context.Background(), "caller-request"), 200*time.Millisecond)
+	defer cancel()
+	if _, err := getBody(ctx, client, pricePath); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := headers.get(0)
+	if id := sent.Get(RequestIDHeader); id == "" || id == "caller-request" {
+		t.Errorf("%s = %q, want an ID of its own", RequestIDHeader, id)
+	}
+	budget, ok := ParseBudget(sent.Get(DeadlineHeader))
+	if !ok || budget <= 200*time.Millisecond || budget > client.GetTimeout() {
+		t.Errorf("%s = %q, want the client timeout rather than the caller's deadline", DeadlineHeader, sent.Get(DeadlineHeader))
+	}
+}
+
+func TestFlightGroupPanicFailsEveryCaller(t *testing.T) {
+	var group flightGroup
+	release := make(chan struct{})
+
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, _, err := group.do(context.Background(), "key", func() (*cacheEntry, error) {
+				<-release
+				panic("boom")
+			})
+			results <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-results:
+			if err == nil || !strings.Contains(err.Error(), "boom") {
+				t.Errorf("Caller error = %v, want the panic", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Caller still waiting after the call panicked")
+		}
+	}
+
+	entry, shared, err := group.do(context.Background(), "key", func() (*cacheEntry, error) {
+		return &cacheEntry{statusCode: http.StatusOK}, nil
+	})
+	if err != nil || shared || entry.statusCode != http.StatusOK {
+		t.Errorf("Call after the panic = %v, %v, %v, want a new call", entry, shared, err)
+	}
+}
+
+func TestCacheServesWithinTTL(t *testing.T) {
+	fake := NewFakeTransport().On(http.MethodGet, pricePath,
+		Respond(http.StatusOK, map[string]float64{"price": 150}),
+		Respond(http.StatusOK, map[string]float64{"price": 151}))
+	client := newTestClient(t, fake)
+	client.SetCachePolicy(CachePolicy{TTL: 50 * time.Millisecond})
+
+	for i := 0; i < 3; i++ {
+		if body, err := getBody(context.Background(), client, pricePath); err != nil || body != `{"price":150}` {
+			t.Fatalf("Request %d = %q, %v, want the cached price", i+1, body, err)
+		}
+	}
+	if calls := fake.Calls(http.MethodGet, pricePath); calls != 1 {
+		t.Errorf("Upstream calls within the TTL = %d, want 1", calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if body, err := getBody(context.Background(), client, pricePath); err != nil || body != `{"price":151}` {
+		t.Errorf("Request after the TTL = %q, %v, want a fresh price", body, err)
+	}
+}
+
+func TestCacheRespectsCacheControl(t *testing.T) {
+	fake := NewFakeTransport().On(http.MethodGet, pricePath,
+		Respond(http.StatusOK, nil).WithHeader("Cache-Control", "no-store"))
+	client := newTestClient(t, fake)
+	client.SetCachePolicy(CachePolicy{TTL: time.Minute})
+
+	getBody(context.Background(), client, pricePath)
+	getBody(context.Background(), client, pricePath)
+	if calls := fake.Calls(http.MethodGet, pricePath); calls != 2 {
+		t.Errorf("Upstream calls for a no-store response = %d, want 2", calls)
+	}
+}
+
+func TestCacheRevalidatesWithETag(t *testing.T) {
+	fake := NewFakeTransport().On(http.MethodGet, pricePath,
+		Respond(http.StatusOK, map[string]float64{"price": 150}).WithHeader("ETag", `"v1"`),
+		Respond(http.StatusNotModified, nil))
+	headers := &headerLog{next: fake}
+	client := newTestClient(t, headers)
+	client.SetCachePolicy(CachePolicy{TTL: 50 * time.Millisecond})
+
+	if _, err := getBody(context.Background(), client, pricePath); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+
+	body, err := getBody(context.Background(), client, pricePath)
+	if err != nil || body != `{"price":150}` {
+		t.Fatalf("Revalidated request = %q, %v, want the stored body", body, err)
+	}
+	if got := headers.get(1).Get("If-None-Match"); got != `"v1"` {
+		t.Errorf("If-None-Match = %q, want the stored ETag", got)
+	}
+
+	// A 304 makes the entry fresh again
+	getBody(context.Background(), client, pricePath)
+	if calls := fake.Calls(http.MethodGet, pricePath); calls != 2 {
+		t.Errorf("Upstream calls = %d, want 2", calls)
+	}
+}
+
+func TestFreshness(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		want         time.Duration
+		storable     bool
+	}{
+		{"", time.Minute, true},
+		{"max-age=10", 10 * time.Second, true},
+		{"max-age=600", time.Minute, true},
+		{"max-age=10, s-maxage=20", 20 * time.Second, true},
+		{"no-cache", 0, true},
+		{"no-cache, s-maxage=20", 0, true},
+		{"private, max-age=10", 0, false},
+		{"no-store", 0, false},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.cacheControl != "" {
+			header.Set("Cache-Control", tt.cacheControl)
+		}
+		if got, storable := freshness(header, time.Minute); got != tt.want || storable != tt.storable {
+			t.Errorf("freshness(%q) = %v, %v, want %v, %v", tt.cacheControl, got, storable, tt.want, tt.storable)
+		}
+	}
+}
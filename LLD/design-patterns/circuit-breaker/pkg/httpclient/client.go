@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"circuit-breaker-demo/pkg/circuitbreaker"
@@ -14,26 +16,179 @@ import (
 	"go.uber.org/zap"
 )
 
-// HTTPClient wraps http.Client with circuit breaker functionality
+// HTTPClient wraps http.Client with circuit breaker functionality. The
+// breaker is applied by a Transport, so classification and metrics are the
+// same as for any other http.Client using that transport. Requests are
+// spread over one or more endpoints, each with its own breaker.
 type HTTPClient struct {
-	client         *http.Client
-	circuitBreaker *circuitbreaker.CircuitBreaker
-	logger         *zap.Logger
-	baseURL        string
+	endpoints   []*Endpoint
+	balancer    Balancer
+	transport   *Transport
+	logger      *zap.Logger
+	hedgeBudget hedgeBudget
+	cache       responseCache
+	flight      flightGroup
+
+	// client and the policies below can be replaced while requests are in flight
+	mutex            sync.RWMutex
+	client           *http.Client
+	retryPolicy      RetryPolicy
+	hedgePolicy      HedgePolicy
+	cachePolicy      CachePolicy
+	maxResponseSize  int64
+	outlierDetection OutlierDetection
+	interceptors     []Interceptor
 }
 
 // NewHTTPClient creates a new HTTP client with circuit breaker
 func NewHTTPClient(baseURL string, timeout time.Duration, cb *circuitbreaker.CircuitBreaker, logger *zap.Logger) *HTTPClient {
+	return NewBalancedHTTPClient([]*Endpoint{NewEndpoint(baseURL, 1, cb)}, &RoundRobin{}, timeout, logger)
+}
+
+// NewBalancedHTTPClient creates a client that spreads requests over several
+// instances of the same service. Endpoints whose breaker is open, that fail
+// health checks or that are ejected by outlier detection are skipped.
+func NewBalancedHTTPClient(endpoints []*Endpoint, balancer Balancer, timeout time.Duration, logger *zap.Logger) *HTTPClient {
+	transport := NewTransport(http.DefaultTransport, endpointBreaker, logger)
+
 	return &HTTPClient{
 		client: &http.Client{
-			Timeout: timeout,
+			Timeout:   timeout,
+			Transport: transport,
 		},
-		circuitBreaker: cb,
-		logger:         logger,
-		baseURL:        baseURL,
+		endpoints:       endpoints,
+		balancer:        balancer,
+		transport:       transport,
+		logger:          logger,
+		retryPolicy:     NoRetry(),
+		maxResponseSize: DefaultMaxResponseSize,
+	}
+}
+
+// SetRetryPolicy sets the retry policy used for idempotent requests
+func (c *HTTPClient) SetRetryPolicy(policy RetryPolicy) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.retryPolicy = policy
+}
+
+// SetTimeout changes the timeout for subsequent requests; requests already
+// in flight keep the timeout they started with
+func (c *HTTPClient) SetTimeout(timeout time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.client = &http.Client{
+		Timeout:   timeout,
+		Transport: c.transport,
 	}
 }
 
+// SetHedgePolicy sets the hedging policy for GET requests. Hedging needs at
+// least two endpoints; with one endpoint the policy has no effect.
+func (c *HTTPClient) SetHedgePolicy(policy HedgePolicy) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.hedgePolicy = policy
+}
+
+// SetCachePolicy sets the coalescing and caching policy for GET requests
+func (c *HTTPClient) SetCachePolicy(policy CachePolicy) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.cachePolicy = policy
+}
+
+// SetOutlierDetection sets the policy for ejecting endpoints that keep failing
+func (c *HTTPClient) SetOutlierDetection(detection OutlierDetection) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.outlierDetection = detection
+}
+
+// Use appends interceptors to the chain every request attempt passes
+// through. They run in the order added, the first one outermost.
+func (c *HTTPClient) Use(interceptors ...Interceptor) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.interceptors = append(append([]Interceptor(nil), c.interceptors...), interceptors...)
+}
+
+// SetMaxResponseSize limits the size of JSON bodies decoded by GetJSON,
+// PostJSON and PutJSON
+func (c *HTTPClient) SetMaxResponseSize(size int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.maxResponseSize = size
+}
+
+// GetTimeout returns the current request timeout
+func (c *HTTPClient) GetTimeout() time.Duration {
+	return c.httpClient().Timeout
+}
+
+// httpClient returns the current underlying client
+func (c *HTTPClient) httpClient() *http.Client {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.client
+}
+
+// getHedgePolicy returns the current hedging policy
+func (c *HTTPClient) getHedgePolicy() HedgePolicy {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.hedgePolicy
+}
+
+// getCachePolicy returns the current coalescing and caching policy
+func (c *HTTPClient) getCachePolicy() CachePolicy {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.cachePolicy
+}
+
+// getOutlierDetection returns the current outlier detection policy
+func (c *HTTPClient) getOutlierDetection() OutlierDetection {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.outlierDetection
+}
+
+// getMaxResponseSize returns the current response size limit
+func (c *HTTPClient) getMaxResponseSize() int64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.maxResponseSize
+}
+
+// getInterceptors returns the current interceptor chain
+func (c *HTTPClient) getInterceptors() []Interceptor {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.interceptors
+}
+
+// getRetryPolicy returns the current retry policy
+func (c *HTTPClient) getRetryPolicy() RetryPolicy {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.retryPolicy
+}
+
 // Get performs a GET request with circuit breaker protection
 func (c *HTTPClient) Get(ctx context.Context, path string) (*http.Response, error) {
 	return c.Do(ctx, "GET", path, nil, nil)
@@ -58,27 +213,106 @@ func (c *HTTPClient) Delete(ctx context.Context, path string) (*http.Response, e
 	return c.Do(ctx, "DELETE", path, nil, nil)
 }
 
-// Do performs an HTTP request with circuit breaker protection
+// Transport returns the breaker-aware transport used by this client
+func (c *HTTPClient) Transport() *Transport {
+	return c.transport
+}
+
+// Endpoints returns the endpoints this client balances over
+func (c *HTTPClient) Endpoints() []*Endpoint {
+	return c.endpoints
+}
+
+// pickEndpoint chooses among the available endpoints. When none is available
+// one is picked anyway: an open breaker then rejects the request so the
+// rejection shows up in the metrics, and an unhealthy or ejected endpoint
+// still gets a chance rather than failing every request outright.
+func (c *HTTPClient) pickEndpoint() *Endpoint {
+	available := make([]*Endpoint, 0, len(c.endpoints))
+	for _, endpoint := range c.endpoints {
+		if endpoint.available() {
+			available = append(available, endpoint)
+		}
+	}
+	if len(available) == 0 {
+		available = c.endpoints
+	}
+
+	return c.balancer.Pick(available)
+}
+
+// pickEndpointExcept chooses among the available endpoints other than
+// exclude, or returns nil when there is none
+func (c *HTTPClient) pickEndpointExcept(exclude *Endpoint) *Endpoint {
+	available := make([]*Endpoint, 0, len(c.endpoints))
+	for _, endpoint := range c.endpoints {
+		if endpoint != exclude && endpoint.available() {
+			available = append(available, endpoint)
+		}
+	}
+	if len(available) == 0 {
+		return nil
+	}
+
+	return c.balancer.Pick(available)
+}
+
+// Do performs an HTTP request with circuit breaker protection. Idempotent
+// requests are retried according to the retry policy and GET requests may be
+// hedged, coalesced or served from cache; every attempt goes through the
+// circuit breaker.
 func (c *HTTPClient) Do(ctx context.Context, method, path string, body interface{}, headers map[string]string) (*http.Response, error) {
-	result, err := c.circuitBreaker.Execute(ctx, func() (interface{}, error) {
-		return c.doRequest(ctx, method, path, body, headers)
-	})
+	if cachePolicy := c.getCachePolicy(); cachePolicy.enabled() && method == http.MethodGet && body == nil && len(headers) == 0 {
+		return c.doCached(ctx, path, cachePolicy)
+	}
 
-	if err != nil {
-		return nil, err
+	return c.do(ctx, method, path, body, headers)
+}
+
+// do runs the retry loop for one request
+func (c *HTTPClient) do(ctx context.Context, method, path string, body interface{}, headers map[string]string) (*http.Response, error) {
+	retryPolicy := c.getRetryPolicy()
+	attempts := retryPolicy.attemptsFor(method)
+
+	hedgePolicy := c.getHedgePolicy()
+	hedge := method == http.MethodGet && hedgePolicy.Delay > 0 && len(c.endpoints) > 1
+
+	var response *http.Response
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			wait := retryPolicy.backoff(attempt - 1)
+			c.logger.Debug("Retrying HTTP request",
+				zap.String("method", method),
+				zap.String("path", path),
+				zap.Int("attempt", attempt),
+				zap.Duration("backoff", wait),
+			)
+			if sleepErr := sleep(ctx, wait); sleepErr != nil {
+				break
+			}
+		}
+
+		if hedge {
+			response, err = c.doHedged(ctx, method, path, headers, hedgePolicy)
+		} else {
+			response, err = c.doRequestTo(ctx, c.pickEndpoint(), method, path, body, headers)
+		}
+		if err == nil || !shouldRetry(ctx, err) {
+			break
+		}
 	}
 
-	response, ok := result.(*http.Response)
-	if !ok {
-		return nil, fmt.Errorf("unexpected response type")
+	if err != nil {
+		return nil, err
 	}
 
 	return response, nil
 }
 
-// doRequest performs the actual HTTP request
-func (c *HTTPClient) doRequest(ctx context.Context, method, path string, body interface{}, headers map[string]string) (*http.Response, error) {
-	url := c.baseURL + path
+// doRequestTo performs the actual HTTP request against one endpoint
+func (c *HTTPClient) doRequestTo(ctx context.Context, endpoint *Endpoint, method, path string, body interface{}, headers map[string]string) (*http.Response, error) {
+	url := endpoint.URL + path
 
 	var reqBody io.Reader
 	if body != nil {
@@ -89,7 +323,7 @@ func (c *HTTPClient) doRequest(ctx context.Context, method, path string, body in
 		reqBody = bytes.NewBuffer(jsonBody)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	req, err := http.NewRequestWithContext(withEndpoint(ctx, endpoint), method, url, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -99,18 +333,24 @@ func (c *HTTPClient) doRequest(ctx context.Context, method, path string, body in
 		req.Header.Set(key, value)
 	}
 
-	// Add default headers
-	req.Header.Set("User-Agent", "trading-gateway/1.0")
 	req.Header.Set("Accept", "application/json")
 
 	c.logger.Debug("Making HTTP request",
 		zap.String("method", method),
 		zap.String("url", url),
-		zap.Any("headers", headers),
 	)
 
-	resp, err := c.client.Do(req)
+	// Streams end with their context rather than the client timeout
+	client := c.httpClient()
+	if isStreaming(ctx) {
+		client = &http.Client{Transport: client.Transport}
+	}
+
+	release := endpoint.acquire()
+	resp, err := chain(c.getInterceptors(), client.Do)(req)
+	c.observe(ctx, endpoint, resp, err)
 	if err != nil {
+		release()
 		c.logger.Error("HTTP request failed",
 			zap.String("method", method),
 			zap.String("url", url),
@@ -121,17 +361,17 @@ func (c *HTTPClient) doRequest(ctx context.Context, method, path string, body in
 
 	// Check for HTTP error status codes
 	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
+		httpErr := newHTTPError(req, resp)
+		release()
 
 		c.logger.Error("HTTP request returned error status",
 			zap.String("method", method),
 			zap.String("url", url),
 			zap.Int("status_code", resp.StatusCode),
-			zap.String("response_body", string(body)),
+			zap.ByteString("response_body", httpErr.Body),
 		)
 
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+		return nil, httpErr
 	}
 
 	c.logger.Debug("HTTP request successful",
@@ -140,18 +380,37 @@ func (c *HTTPClient) doRequest(ctx context.Context, method, path string, body in
 		zap.Int("status_code", resp.StatusCode),
 	)
 
+	resp.Body = &trackedBody{ReadCloser: resp.Body, release: release}
 	return resp, nil
 }
 
-// GetJSON performs a GET request and unmarshals JSON response
+// observe feeds the outcome of a request into outlier detection. Requests
+// rejected by the breaker, not sent for lack of time or abandoned by the
+// caller say nothing about the endpoint and are ignored.
+func (c *HTTPClient) observe(ctx context.Context, endpoint *Endpoint, resp *http.Response, err error) {
+	if errors.Is(err, circuitbreaker.ErrOpenState) || errors.Is(err, ErrDeadlineTooClose) || ctx.Err() != nil {
+		return
+	}
+
+	failed := err != nil || resp.StatusCode >= 500
+	if ejection := endpoint.recordOutcome(failed, c.getOutlierDetection()); ejection > 0 {
+		getHealthMetrics().ejectionsTotal.WithLabelValues(endpoint.URL).Inc()
+		c.logger.Warn("Ejected endpoint after consecutive errors",
+			zap.String("endpoint", endpoint.URL),
+			zap.Duration("ejectionTime", ejection),
+		)
+	}
+}
+
+// GetJSON performs a GET request and unmarshals JSON response. A nil target
+// discards the body; 204 responses leave target untouched.
 func (c *HTTPClient) GetJSON(ctx context.Context, path string, target interface{}) error {
 	resp, err := c.Get(ctx, path)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	return json.NewDecoder(resp.Body).Decode(target)
+	return decodeJSON(resp, target, c.getMaxResponseSize())
 }
 
 // PostJSON performs a POST request and unmarshals JSON response
@@ -160,9 +419,8 @@ func (c *HTTPClient) PostJSON(ctx context.Context, path string, body interface{}
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	return json.NewDecoder(resp.Body).Decode(target)
+	return decodeJSON(resp, target, c.getMaxResponseSize())
 }
 
 // PutJSON performs a PUT request and unmarshals JSON response
@@ -171,17 +429,54 @@ func (c *HTTPClient) PutJSON(ctx context.Context, path string, body interface{},
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	return json.NewDecoder(resp.Body).Decode(target)
+	return decodeJSON(resp, target, c.getMaxResponseSize())
+}
+
+// EndpointStatuses returns the health of every endpoint
+func (c *HTTPClient) EndpointStatuses() []EndpointStatus {
+	statuses := make([]EndpointStatus, len(c.endpoints))
+	for i, endpoint := range c.endpoints {
+		statuses[i] = endpoint.Status()
+	}
+	return statuses
 }
 
-// GetCircuitBreakerStats returns circuit breaker statistics
+// GetCircuitBreakerStats returns circuit breaker statistics. With several
+// endpoints the stats of each endpoint's breaker are listed under "endpoints".
 func (c *HTTPClient) GetCircuitBreakerStats() map[string]interface{} {
-	return c.circuitBreaker.GetStats()
+	if len(c.endpoints) == 1 {
+		return c.endpoints[0].breaker.GetStats()
+	}
+
+	endpoints := make([]map[string]interface{}, len(c.endpoints))
+	for i, endpoint := range c.endpoints {
+		stats := endpoint.breaker.GetStats()
+		stats["url"] = endpoint.URL
+		stats["weight"] = endpoint.Weight
+		stats["outstanding"] = endpoint.Outstanding()
+		stats["healthy"] = endpoint.Healthy()
+		endpoints[i] = stats
+	}
+
+	return map[string]interface{}{
+		"state":     c.GetCircuitBreakerState().String(),
+		"endpoints": endpoints,
+	}
 }
 
-// GetCircuitBreakerState returns the current circuit breaker state
+// GetCircuitBreakerState returns the current circuit breaker state. With
+// several endpoints it is the best state among them: the client is CLOSED
+// while any endpoint is closed and OPEN only when all of them are.
 func (c *HTTPClient) GetCircuitBreakerState() circuitbreaker.State {
-	return c.circuitBreaker.GetState()
+	state := circuitbreaker.StateOpen
+	for _, endpoint := range c.endpoints {
+		switch endpoint.breaker.GetState() {
+		case circuitbreaker.StateClosed:
+			return circuitbreaker.StateClosed
+		case circuitbreaker.StateHalfOpen:
+			state = circuitbreaker.StateHalfOpen
+		}
+	}
+	return state
 }
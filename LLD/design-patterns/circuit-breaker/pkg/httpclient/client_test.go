@@ -0,0 +1,117 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+
+	"go.uber.org/zap"
+)
+
+const testBaseURL = "http://upstream.test"
+
+// newTestClient returns a client of one endpoint whose requests go to base,
+// with the request ID and deadline interceptors the services install
+func newTestClient(t *testing.T, base http.RoundTripper) *HTTPClient {
+	t.Helper()
+
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.DefaultConfig("test"), zap.NewNop())
+	client := NewHTTPClient(testBaseURL, time.Second, cb, zap.NewNop())
+	client.Transport().SetBase(base)
+	client.Use(RequestID(), PropagateDeadline())
+	return client
+}
+
+// headerLog records the headers of every request passed to the next
+// round tripper
+type headerLog struct {
+	next http.RoundTripper
+
+	mutex   sync.Mutex
+	headers []http.Header
+}
+
+func (l *headerLog) RoundTrip(req *http.Request) (*http.Response, error) {
+	l.mutex.Lock()
+	l.headers = append(l.headers, req.Header.Clone())
+	l.mutex.Unlock()
+	return l.next.RoundTrip(req)
+}
+
+// get returns the headers of the i-th request
+func (l *headerLog) get(i int) http.Header {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if i >= len(l.headers) {
+		return http.Header{}
+	}
+	return l.headers[i]
+}
+
+// getBody sends a GET and returns the response body
+func getBody(ctx context.Context, client *HTTPClient, path string) (string, error) {
+	resp, err := client.Get(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+// lateContext has a deadline that has already passed but, unlike a context
+// from context.WithDeadline, is never done
+type lateContext struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c lateContext) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func TestDeadlineTooCloseIsNotCountedAgainstEndpoint(t *testing.T) {
+	fake := NewFakeTransport().On(http.MethodGet, pricePath, Respond(http.StatusOK, nil))
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.DefaultConfig("test"), zap.NewNop())
+	client := NewHTTPClient(testBaseURL, time.Second, cb, zap.NewNop())
+	client.Transport().SetBase(fake)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 1})
+	client.SetOutlierDetection(OutlierDetection{ConsecutiveErrors: 1, BaseEjectionTime: time.Minute, MaxEjectionTime: time.Minute})
+
+	var attempts atomic.Int32
+	client.Use(func(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+		attempts.Add(1)
+		return next(req)
+	}, PropagateDeadline())
+
+	ctx := lateContext{Context: context.Background(), deadline: time.Now().Add(500 * time.Microsecond)}
+	_, err := client.Get(ctx, pricePath)
+	if !errors.Is(err, ErrDeadlineTooClose) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get() = %v, want ErrDeadlineTooClose", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("Context error = %v, want none", ctx.Err())
+	}
+
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("Attempts = %d, want 1", got)
+	}
+	if calls := fake.Calls(http.MethodGet, pricePath); calls != 0 {
+		t.Errorf("Upstream calls = %d, want 0", calls)
+	}
+	if status := client.Endpoints()[0].Status(); status.Ejected {
+		t.Errorf("Endpoint = %+v, want it kept in rotation", status)
+	}
+	if stats := cb.GetStats(); stats["failures"] != uint32(0) {
+		t.Errorf("Breaker stats = %v, want no failures", stats)
+	}
+}
@@ -0,0 +1,135 @@
+package httpclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"circuit-breaker-demo/pkg/models"
+)
+
+const (
+	// maxErrorBodySize caps how much of an error response body is kept
+	maxErrorBodySize = 4 << 10
+
+	// DefaultMaxResponseSize caps JSON response bodies decoded by the client
+	DefaultMaxResponseSize = 10 << 20
+)
+
+var (
+	// ErrUnexpectedContentType is returned when a response that should be
+	// decoded is not JSON
+	ErrUnexpectedContentType = errors.New("unexpected content type")
+
+	// ErrResponseTooLarge is returned when a response body exceeds the size limit
+	ErrResponseTooLarge = errors.New("response body too large")
+)
+
+// HTTPError is returned for responses with a status code of 400 or above
+type HTTPError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Header     http.Header
+	Body       []byte                // Raw body, at most 4 KiB
+	Truncated  bool                  // True when Body was cut at the limit
+	Response   *models.ErrorResponse // Decoded body when the upstream sent a JSON error
+}
+
+func (e *HTTPError) Error() string {
+	message := string(e.Body)
+	if e.Response != nil {
+		message = e.Response.Error
+		if e.Response.Message != "" {
+			message += ": " + e.Response.Message
+		}
+	}
+	return fmt.Sprintf("HTTP %d from %s %s: %s", e.StatusCode, e.Method, e.URL, message)
+}
+
+// Retryable reports whether repeating the request may succeed
+func (e *HTTPError) Retryable() bool {
+	if e.DeadlineExceeded() {
+		return false
+	}
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// DeadlineExceeded reports whether the upstream gave up because the
+// X-Request-Deadline it was sent left too little time
+func (e *HTTPError) DeadlineExceeded() bool {
+	return e.StatusCode == http.StatusGatewayTimeout && e.Header.Get(DeadlineHeader) == "0"
+}
+
+// RetryAfter returns the wait the upstream asked for with Retry-After
+func (e *HTTPError) RetryAfter() (time.Duration, bool) {
+	return ParseRetryAfter(e.Header.Get("Retry-After"), time.Now())
+}
+
+// newHTTPError reads a capped amount of the body and closes it
+func newHTTPError(req *http.Request, resp *http.Response) *HTTPError {
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize+1))
+	httpErr := &HTTPError{
+		Method:     req.Method,
+		URL:        req.URL.Redacted(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}
+	if len(body) > maxErrorBodySize {
+		httpErr.Body = body[:maxErrorBodySize]
+		httpErr.Truncated = true
+	}
+
+	if isJSON(resp.Header) && !httpErr.Truncated {
+		var errorResponse models.ErrorResponse
+		if json.Unmarshal(body, &errorResponse) == nil && errorResponse.Error != "" {
+			httpErr.Response = &errorResponse
+		}
+	}
+
+	return httpErr
+}
+
+// isJSON reports whether the Content-Type is application/json or a +json type
+func isJSON(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || len(mediaType) > 5 && mediaType[len(mediaType)-5:] == "+json"
+}
+
+// decodeJSON decodes the response body into target and closes it. A nil
+// target or a response without content only drains the body.
+func decodeJSON(resp *http.Response, target interface{}, maxSize int64) error {
+	defer resp.Body.Close()
+
+	if target == nil || resp.StatusCode == http.StatusNoContent || resp.ContentLength == 0 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxSize))
+		return nil
+	}
+
+	if !isJSON(resp.Header) {
+		return fmt.Errorf("%w: %q", ErrUnexpectedContentType, resp.Header.Get("Content-Type"))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if int64(len(body)) > maxSize {
+		return fmt.Errorf("%w: limit is %d bytes", ErrResponseTooLarge, maxSize)
+	}
+
+	if err := json.Unmarshal(body, target); err != nil {
+		return fmt.Errorf("failed to decode response body: %w", err)
+	}
+	return nil
+}
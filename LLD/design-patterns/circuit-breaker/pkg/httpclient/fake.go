@@ -0,0 +1,177 @@
+package httpclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrFakeConnectionRefused is a transport error a FakeTransport can script
+var ErrFakeConnectionRefused = errors.New("connect: connection refused")
+
+// Step is one scripted outcome of a fake round trip: a response or an
+// error, delivered after Latency
+type Step struct {
+	Status  int
+	Header  http.Header
+	Body    []byte
+	Err     error
+	Latency time.Duration
+}
+
+// Respond returns a step answering with status and body encoded as JSON
+func Respond(status int, body interface{}) Step {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return Fail(fmt.Errorf("failed to encode scripted body: %w", err))
+	}
+
+	return Step{
+		Status: status,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   data,
+	}
+}
+
+// Fail returns a step failing with a transport error
+func Fail(err error) Step {
+	return Step{Err: err}
+}
+
+// Refuse returns a step failing as if nothing listened on the endpoint
+func Refuse() Step {
+	return Fail(ErrFakeConnectionRefused)
+}
+
+// Unavailable returns a 503 step carrying Retry-After
+func Unavailable(retryAfter time.Duration) Step {
+	step := Respond(http.StatusServiceUnavailable, map[string]string{"error": "Service unavailable"})
+	return step.WithHeader("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+}
+
+// Hang returns a step that never answers; the request ends when its
+// context is canceled or times out
+func Hang() Step {
+	return Step{Latency: -1}
+}
+
+// After returns the step delivered after latency
+func (s Step) After(latency time.Duration) Step {
+	s.Latency = latency
+	return s
+}
+
+// WithHeader returns the step with a response header added
+func (s Step) WithHeader(key, value string) Step {
+	s.Header = s.Header.Clone()
+	if s.Header == nil {
+		s.Header = make(http.Header)
+	}
+	s.Header.Set(key, value)
+	return s
+}
+
+// respond waits out the latency and builds the response for req
+func (s Step) respond(req *http.Request) (*http.Response, error) {
+	if s.Latency != 0 {
+		var wait <-chan time.Time
+		if s.Latency > 0 {
+			timer := time.NewTimer(s.Latency)
+			defer timer.Stop()
+			wait = timer.C
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-wait:
+		}
+	}
+
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	header := s.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", s.Status, http.StatusText(s.Status)),
+		StatusCode:    s.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(s.Body)),
+		ContentLength: int64(len(s.Body)),
+		Request:       req,
+	}, nil
+}
+
+// FakeTransport is an http.RoundTripper answering from scripts instead of
+// the network. Each script is a sequence of steps for one method and path;
+// requests take the steps in order and the last step repeats, so "fail
+// twice, then succeed" is three steps.
+type FakeTransport struct {
+	mutex   sync.Mutex
+	scripts map[string][]Step
+	calls   map[string]int
+}
+
+// NewFakeTransport creates a fake transport without scripts
+func NewFakeTransport() *FakeTransport {
+	return &FakeTransport{
+		scripts: make(map[string][]Step),
+		calls:   make(map[string]int),
+	}
+}
+
+// On scripts the answers for a method and path, replacing an earlier
+// script and its call count
+func (f *FakeTransport) On(method, path string, steps ...Step) *FakeTransport {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	key := method + " " + path
+	f.scripts[key] = steps
+	f.calls[key] = 0
+	return f
+}
+
+// Calls returns how many requests reached the script for method and path
+func (f *FakeTransport) Calls(method, path string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.calls[method+" "+path]
+}
+
+// RoundTrip implements http.RoundTripper
+func (f *FakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	key := req.Method + " " + req.URL.Path
+	f.mutex.Lock()
+	steps := f.scripts[key]
+	if len(steps) == 0 {
+		f.mutex.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrNoInteraction, key)
+	}
+	step := steps[len(steps)-1]
+	if call := f.calls[key]; call < len(steps) {
+		step = steps[call]
+	}
+	f.calls[key]++
+	f.mutex.Unlock()
+
+	return step.respond(req)
+}
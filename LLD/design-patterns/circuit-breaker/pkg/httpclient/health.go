@@ -0,0 +1,284 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// HealthCheck configures active health checking of endpoints
+type HealthCheck struct {
+	Path               string        // e.g. "/api/v1/health"; any 2xx response passes
+	Interval           time.Duration // 0 disables active checks
+	Timeout            time.Duration
+	UnhealthyThreshold int // Consecutive failed checks before an endpoint is marked unhealthy
+	HealthyThreshold   int // Consecutive passed checks before it is marked healthy again
+}
+
+// OutlierDetection configures passive ejection of endpoints that keep
+// failing real requests. The n-th ejection of an endpoint lasts n times
+// BaseEjectionTime, capped at MaxEjectionTime; an endpoint that stays out of
+// trouble for MaxEjectionTime starts again from BaseEjectionTime.
+type OutlierDetection struct {
+	ConsecutiveErrors int // 5xx responses or transport errors in a row; 0 disables
+	BaseEjectionTime  time.Duration
+	MaxEjectionTime   time.Duration
+}
+
+// EndpointStatus is a snapshot of an endpoint's health
+type EndpointStatus struct {
+	URL          string
+	Healthy      bool      // Result of active health checks
+	Ejected      bool      // Ejected by outlier detection
+	EjectedUntil time.Time // Zero unless Ejected
+	Ejections    int       // Ejections counted towards the next ejection time
+	Outstanding  int64
+	BreakerState string
+}
+
+// endpointHealth holds the active and passive health state of an endpoint
+type endpointHealth struct {
+	mutex             sync.Mutex
+	unhealthy         bool
+	checkFailures     int
+	checkPasses       int
+	consecutiveErrors int
+	ejections         int
+	ejectedUntil      time.Time
+}
+
+// Healthy reports whether the endpoint passes health checks and is not ejected
+func (e *Endpoint) Healthy() bool {
+	e.health.mutex.Lock()
+	defer e.health.mutex.Unlock()
+
+	return !e.health.unhealthy && !time.Now().Before(e.health.ejectedUntil)
+}
+
+// Status returns a snapshot of the endpoint's health
+func (e *Endpoint) Status() EndpointStatus {
+	e.health.mutex.Lock()
+	defer e.health.mutex.Unlock()
+
+	status := EndpointStatus{
+		URL:          e.URL,
+		Healthy:      !e.health.unhealthy,
+		Ejections:    e.health.ejections,
+		Outstanding:  e.Outstanding(),
+		BreakerState: e.breaker.GetState().String(),
+	}
+	if time.Now().Before(e.health.ejectedUntil) {
+		status.Ejected = true
+		status.EjectedUntil = e.health.ejectedUntil
+	}
+	return status
+}
+
+// recordCheck applies the result of an active health check and reports
+// whether the endpoint changed between healthy and unhealthy
+func (e *Endpoint) recordCheck(passed bool, check HealthCheck) (changed bool) {
+	e.health.mutex.Lock()
+	defer e.health.mutex.Unlock()
+
+	if passed {
+		e.health.checkFailures = 0
+		e.health.checkPasses++
+		if e.health.unhealthy && e.health.checkPasses >= check.HealthyThreshold {
+			e.health.unhealthy = false
+			return true
+		}
+		return false
+	}
+
+	e.health.checkPasses = 0
+	e.health.checkFailures++
+	if !e.health.unhealthy && e.health.checkFailures >= check.UnhealthyThreshold {
+		e.health.unhealthy = true
+		return true
+	}
+	return false
+}
+
+// recordOutcome feeds the result of a real request into outlier detection
+// and returns how long the endpoint was ejected for, or 0
+func (e *Endpoint) recordOutcome(failed bool, detection OutlierDetection) time.Duration {
+	if detection.ConsecutiveErrors <= 0 {
+		return 0
+	}
+
+	e.health.mutex.Lock()
+	defer e.health.mutex.Unlock()
+
+	now := time.Now()
+	if !failed {
+		e.health.consecutiveErrors = 0
+		if e.health.ejections > 0 && now.Sub(e.health.ejectedUntil) > detection.MaxEjectionTime {
+			e.health.ejections = 0
+		}
+		return 0
+	}
+
+	e.health.consecutiveErrors++
+	if e.health.consecutiveErrors < detection.ConsecutiveErrors || now.Before(e.health.ejectedUntil) {
+		return 0
+	}
+
+	e.health.consecutiveErrors = 0
+	e.health.ejections++
+	ejection := time.Duration(e.health.ejections) * detection.BaseEjectionTime
+	if detection.MaxEjectionTime > 0 && ejection > detection.MaxEjectionTime {
+		ejection = detection.MaxEjectionTime
+	}
+	e.health.ejectedUntil = now.Add(ejection)
+	return ejection
+}
+
+// HealthChecker polls the health route of every endpoint of a client.
+// Checks bypass the circuit breakers, so probing a struggling instance does
+// not count against it.
+type HealthChecker struct {
+	endpoints []*Endpoint
+	check     HealthCheck
+	client    *http.Client
+	logger    *zap.Logger
+	metrics   *healthMetrics
+}
+
+// healthMetrics holds Prometheus metrics for endpoint health
+type healthMetrics struct {
+	endpointHealthy *prometheus.GaugeVec
+	ejectionsTotal  *prometheus.CounterVec
+}
+
+var (
+	healthMetricsOnce   sync.Once
+	globalHealthMetrics *healthMetrics
+)
+
+func getHealthMetrics() *healthMetrics {
+	healthMetricsOnce.Do(func() {
+		globalHealthMetrics = &healthMetrics{
+			endpointHealthy: promauto.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: "http_client_endpoint_healthy",
+					Help: "Result of active health checks (1 = healthy, 0 = unhealthy)",
+				},
+				[]string{"endpoint"},
+			),
+			ejectionsTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "http_client_endpoint_ejections_total",
+					Help: "Total number of endpoint ejections by outlier detection",
+				},
+				[]string{"endpoint"},
+			),
+		}
+	})
+	return globalHealthMetrics
+}
+
+// NewHealthChecker creates a health checker for the given endpoints
+func NewHealthChecker(endpoints []*Endpoint, check HealthCheck, logger *zap.Logger) *HealthChecker {
+	if check.UnhealthyThreshold < 1 {
+		check.UnhealthyThreshold = 1
+	}
+	if check.HealthyThreshold < 1 {
+		check.HealthyThreshold = 1
+	}
+
+	return &HealthChecker{
+		endpoints: endpoints,
+		check:     check,
+		client:    &http.Client{Timeout: check.Timeout},
+		logger:    logger,
+		metrics:   getHealthMetrics(),
+	}
+}
+
+// Start checks every endpoint immediately and then every interval until
+// the context is cancelled. It returns at once when checks are disabled.
+func (h *HealthChecker) Start(ctx context.Context) {
+	if h.check.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(h.check.Interval)
+	defer ticker.Stop()
+
+	for {
+		h.CheckAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll checks every endpoint concurrently and waits for the results
+func (h *HealthChecker) CheckAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, endpoint := range h.endpoints {
+		wg.Add(1)
+		go func(endpoint *Endpoint) {
+			defer wg.Done()
+			h.checkEndpoint(ctx, endpoint)
+		}(endpoint)
+	}
+	wg.Wait()
+}
+
+// checkEndpoint runs one health check and records the result
+func (h *HealthChecker) checkEndpoint(ctx context.Context, endpoint *Endpoint) {
+	passed, detail := h.probe(ctx, endpoint.URL+h.check.Path)
+	if ctx.Err() != nil {
+		return
+	}
+
+	if endpoint.recordCheck(passed, h.check) {
+		if passed {
+			h.logger.Info("Endpoint passed health checks, returning it to rotation",
+				zap.String("endpoint", endpoint.URL),
+			)
+		} else {
+			h.logger.Warn("Endpoint failed health checks, removing it from rotation",
+				zap.String("endpoint", endpoint.URL),
+				zap.String("reason", detail),
+			)
+		}
+	}
+
+	healthy := 0.0
+	if endpoint.Status().Healthy {
+		healthy = 1
+	}
+	h.metrics.endpointHealthy.WithLabelValues(endpoint.URL).Set(healthy)
+}
+
+// probe sends one health check request and reports whether it passed
+func (h *HealthChecker) probe(ctx context.Context, url string) (bool, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, err.Error()
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return false, err.Error()
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBodySize))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return false, resp.Status
+	}
+	return true, ""
+}
@@ -0,0 +1,177 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// setStatus changes the status a host answers with
+func (h *hostTransport) setStatus(host string, status int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.statuses[host] = status
+}
+
+func TestHealthCheckerThresholds(t *testing.T) {
+	endpoints := newTestEndpoints([]string{"md-1", "md-2"})
+	hosts := &hostTransport{
+		statuses: map[string]int{"md-1": http.StatusServiceUnavailable, "md-2": http.StatusOK},
+		calls:    make(map[string]int),
+	}
+	checker := NewHealthChecker(endpoints, HealthCheck{
+		Path:               "/api/v1/health",
+		Interval:           time.Second,
+		Timeout:            time.Second,
+		UnhealthyThreshold: 2,
+		HealthyThreshold:   2,
+	}, zap.NewNop())
+	checker.client.Transport = hosts
+
+	steps := []struct {
+		status  int // md-1's health check response
+		healthy bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusServiceUnavailable, false},
+		{http.StatusOK, false},
+		{http.StatusOK, true},
+	}
+	for i, step := range steps {
+		hosts.setStatus("md-1", step.status)
+		checker.CheckAll(context.Background())
+
+		if got := endpoints[0].Status().Healthy; got != step.healthy {
+			t.Errorf("Check %d: md-1 healthy = %v, want %v", i+1, got, step.healthy)
+		}
+		if !endpoints[1].Healthy() {
+			t.Errorf("Check %d: md-2 unhealthy, want healthy", i+1)
+		}
+	}
+
+	// Checks bypass the breakers
+	if stats := endpoints[0].Breaker().GetStats(); stats["requests"] != uint32(0) {
+		t.Errorf("md-1 breaker stats = %v, want no requests", stats)
+	}
+}
+
+func TestOutlierEjectionTime(t *testing.T) {
+	endpoint := newTestEndpoints([]string{"md-1"})[0]
+	detection := OutlierDetection{ConsecutiveErrors: 2, BaseEjectionTime: 100 * time.Millisecond, MaxEjectionTime: 250 * time.Millisecond}
+
+	// expire ends the current ejection as if its time had passed
+	expire := func() {
+		endpoint.health.mutex.Lock()
+		endpoint.health.ejectedUntil = time.Now().Add(-time.Millisecond)
+		endpoint.health.mutex.Unlock()
+	}
+
+	for i, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 250 * time.Millisecond} {
+		if got := endpoint.recordOutcome(true, detection); got != 0 {
+			t.Fatalf("Ejection %d after one error = %v, want none yet", i+1, got)
+		}
+		if got := endpoint.recordOutcome(true, detection); got != want {
+			t.Errorf("Ejection %d = %v, want %v", i+1, got, want)
+		}
+		if status := endpoint.Status(); !status.Ejected || endpoint.Healthy() {
+			t.Errorf("Ejection %d: status = %+v, want ejected", i+1, status)
+		}
+
+		// Errors while ejected do not extend the ejection
+		endpoint.recordOutcome(true, detection)
+		if got := endpoint.recordOutcome(true, detection); got != 0 {
+			t.Errorf("Ejection %d extended by %v", i+1, got)
+		}
+		expire()
+		// Those errors would count towards the next ejection otherwise
+		endpoint.recordOutcome(false, detection)
+	}
+
+	// A success breaks the run of errors
+	endpoint.recordOutcome(true, detection)
+	endpoint.recordOutcome(false, detection)
+	if got := endpoint.recordOutcome(true, detection); got != 0 {
+		t.Errorf("Ejection after a success = %v, want none", got)
+	}
+
+	// Staying out of trouble for MaxEjectionTime starts again from the base
+	endpoint.health.mutex.Lock()
+	endpoint.health.ejectedUntil = time.Now().Add(-detection.MaxEjectionTime - time.Millisecond)
+	endpoint.health.mutex.Unlock()
+	endpoint.recordOutcome(false, detection)
+	endpoint.recordOutcome(true, detection)
+	if got := endpoint.recordOutcome(true, detection); got != detection.BaseEjectionTime {
+		t.Errorf("Ejection after a quiet period = %v, want %v", got, detection.BaseEjectionTime)
+	}
+}
+
+func TestOutlierDetectionDisabled(t *testing.T) {
+	endpoint := newTestEndpoints([]string{"md-1"})[0]
+
+	for i := 0; i < 10; i++ {
+		if got := endpoint.recordOutcome(true, OutlierDetection{}); got != 0 {
+			t.Fatalf("Ejection = %v, want none while disabled", got)
+		}
+	}
+}
+
+func TestBalancedClientEjectsFailingEndpoint(t *testing.T) {
+	endpoints := newTestEndpoints([]string{"md-1", "md-2"})
+	for _, endpoint := range endpoints {
+		// Keep the breakers closed so only outlier detection acts
+		config := endpoint.Breaker().GetConfig()
+		config.FailureThreshold = 100
+		if err := endpoint.Breaker().UpdateConfig(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hosts := &hostTransport{
+		statuses: map[string]int{"md-1": http.StatusBadGateway, "md-2": http.StatusOK},
+		calls:    make(map[string]int),
+	}
+	client := NewBalancedHTTPClient(endpoints, &RoundRobin{}, time.Second, zap.NewNop())
+	client.Transport().SetBase(hosts)
+	client.SetOutlierDetection(OutlierDetection{ConsecutiveErrors: 2, BaseEjectionTime: time.Minute, MaxEjectionTime: time.Minute})
+
+	for i := 0; i < 8; i++ {
+		if resp, err := client.Get(context.Background(), "/api/v1/prices/AAPL"); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	if !endpoints[0].Status().Ejected {
+		t.Error("md-1 not ejected after consecutive errors")
+	}
+	if hosts.calls["md-1"] != 2 || hosts.calls["md-2"] != 6 {
+		t.Errorf("Calls = %v, want md-1 skipped once ejected", hosts.calls)
+	}
+}
+
+func TestPickEndpointWithNoneAvailable(t *testing.T) {
+	endpoints := newTestEndpoints([]string{"md-1", "md-2"})
+	check := HealthCheck{UnhealthyThreshold: 1, HealthyThreshold: 1}
+	for _, endpoint := range endpoints {
+		endpoint.recordCheck(false, check)
+	}
+	hosts := &hostTransport{
+		statuses: map[string]int{"md-1": http.StatusOK, "md-2": http.StatusOK},
+		calls:    make(map[string]int),
+	}
+	client := NewBalancedHTTPClient(endpoints, &RoundRobin{}, time.Second, zap.NewNop())
+	client.Transport().SetBase(hosts)
+
+	// Unhealthy endpoints still get a chance rather than failing every request
+	resp, err := client.Get(context.Background(), "/api/v1/prices/AAPL")
+	if err != nil {
+		t.Fatalf("Get() = %v, want a response from an unhealthy endpoint", err)
+	}
+	resp.Body.Close()
+
+	if got := client.pickEndpointExcept(endpoints[0]); got != nil {
+		t.Errorf("pickEndpointExcept() = %s, want none", got.URL)
+	}
+}
@@ -0,0 +1,179 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// hedgeBudgetBurst caps the hedges that can be saved up while traffic is fast
+const hedgeBudgetBurst = 10
+
+// hedgeBudgetEpsilon absorbs rounding, so ten deposits of 0.1 make a token
+const hedgeBudgetEpsilon = 1e-9
+
+// HedgePolicy configures request hedging for GET requests. When the first
+// request has not answered after Delay, a second one is sent to another
+// endpoint; the first success wins and the other request is canceled.
+type HedgePolicy struct {
+	Delay  time.Duration // Typically the upstream's p95 latency; 0 disables hedging
+	Budget float64       // Hedges allowed per request, e.g. 0.1 for at most 10% extra load
+}
+
+// hedgeBudget is a token bucket: every request deposits Budget tokens and
+// every hedge spends one, so hedging stops when an upstream slows down as a
+// whole instead of doubling its load
+type hedgeBudget struct {
+	mutex  sync.Mutex
+	tokens float64
+}
+
+// deposit credits one request
+func (b *hedgeBudget) deposit(ratio float64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.tokens += ratio
+	if b.tokens > hedgeBudgetBurst {
+		b.tokens = hedgeBudgetBurst
+	}
+}
+
+// spend takes one token and reports whether one was available
+func (b *hedgeBudget) spend() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.tokens < 1-hedgeBudgetEpsilon {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// hedgeMetrics holds Prometheus metrics for hedged requests
+type hedgeMetrics struct {
+	hedgesTotal *prometheus.CounterVec
+}
+
+var (
+	hedgeMetricsOnce   sync.Once
+	globalHedgeMetrics *hedgeMetrics
+)
+
+func getHedgeMetrics() *hedgeMetrics {
+	hedgeMetricsOnce.Do(func() {
+		globalHedgeMetrics = &hedgeMetrics{
+			hedgesTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "http_client_hedges_total",
+					Help: "Total number of hedged requests by result (won, lost, budget_exhausted)",
+				},
+				[]string{"endpoint", "result"},
+			),
+		}
+	})
+	return globalHedgeMetrics
+}
+
+// hedgeResult is the outcome of one leg of a hedged request
+type hedgeResult struct {
+	resp *http.Response
+	err  error
+	leg  int // 0 for the first request, 1 for the hedge
+}
+
+// doHedged sends a GET to one endpoint and, if it is still outstanding
+// after the policy delay, a second one to another endpoint. The first
+// success is returned and the other leg is canceled; a canceled leg is not
+// recorded by its breaker. When both legs fail the last error is returned.
+func (c *HTTPClient) doHedged(ctx context.Context, method, path string, headers map[string]string, policy HedgePolicy) (*http.Response, error) {
+	c.hedgeBudget.deposit(policy.Budget)
+
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+	send := func(endpoint *Endpoint) {
+		legCtx, cancel := context.WithCancel(ctx)
+		leg := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := c.doRequestTo(legCtx, endpoint, method, path, nil, headers)
+			results <- hedgeResult{resp: resp, err: err, leg: leg}
+		}()
+	}
+
+	primary := c.pickEndpoint()
+	send(primary)
+	pending := 1
+
+	timer := time.NewTimer(policy.Delay)
+	defer timer.Stop()
+
+	var hedge *Endpoint
+	for {
+		select {
+		case <-timer.C:
+			endpoint := c.pickEndpointExcept(primary)
+			if endpoint == nil {
+				continue
+			}
+			if !c.hedgeBudget.spend() {
+				getHedgeMetrics().hedgesTotal.WithLabelValues(endpoint.URL, "budget_exhausted").Inc()
+				continue
+			}
+
+			c.logger.Debug("Hedging slow request",
+				zap.String("path", path),
+				zap.String("primary", primary.URL),
+				zap.String("hedge", endpoint.URL),
+				zap.Duration("delay", policy.Delay),
+			)
+			hedge = endpoint
+			send(hedge)
+			pending++
+
+		case result := <-results:
+			pending--
+			if result.err != nil && pending > 0 {
+				continue
+			}
+
+			// Cancel every leg but the winner, whose context must stay
+			// alive until its body has been read
+			for leg, cancel := range cancels {
+				if leg != result.leg || result.err != nil {
+					cancel()
+				}
+			}
+			if result.err == nil {
+				result.resp.Body = &trackedBody{ReadCloser: result.resp.Body, release: cancels[result.leg]}
+			}
+
+			if hedge != nil && result.err == nil {
+				outcome := "lost"
+				if result.leg == 1 {
+					outcome = "won"
+				}
+				getHedgeMetrics().hedgesTotal.WithLabelValues(hedge.URL, outcome).Inc()
+			}
+			if pending > 0 {
+				go discardResults(results, pending)
+			}
+			return result.resp, result.err
+		}
+	}
+}
+
+// discardResults closes the responses of legs that lost the race
+func discardResults(results <-chan hedgeResult, pending int) {
+	for i := 0; i < pending; i++ {
+		if result := <-results; result.resp != nil {
+			result.resp.Body.Close()
+		}
+	}
+}
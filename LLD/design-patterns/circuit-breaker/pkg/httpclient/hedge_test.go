@@ -0,0 +1,125 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestHedgeBudget(t *testing.T) {
+	var budget hedgeBudget
+
+	for i := 0; i < 9; i++ {
+		budget.deposit(0.1)
+	}
+	if budget.spend() {
+		t.Error("spend() after 9 requests at 0.1 = true, want false")
+	}
+	budget.deposit(0.1)
+	if !budget.spend() {
+		t.Error("spend() after 10 requests at 0.1 = false, want true")
+	}
+	if budget.spend() {
+		t.Error("spend() twice for one token = true, want false")
+	}
+
+	// Fast traffic saves up at most hedgeBudgetBurst hedges
+	for i := 0; i < 100; i++ {
+		budget.deposit(1)
+	}
+	spent := 0
+	for budget.spend() {
+		spent++
+	}
+	if spent != hedgeBudgetBurst {
+		t.Errorf("Hedges saved up = %d, want %d", spent, hedgeBudgetBurst)
+	}
+}
+
+// firstEndpoint always picks the first endpoint it is given
+type firstEndpoint struct{}
+
+func (firstEndpoint) Pick(endpoints []*Endpoint) *Endpoint {
+	return endpoints[0]
+}
+
+// newHedgedClient returns a client over md-1, which answers after
+// primaryLatency, and md-2, which answers at once. md-1 is always picked first.
+func newHedgedClient(primaryLatency time.Duration, policy HedgePolicy) (*HTTPClient, *hostTransport, []*Endpoint) {
+	endpoints := newTestEndpoints([]string{"md-1", "md-2"})
+	hosts := &hostTransport{
+		statuses:  map[string]int{"md-1": http.StatusOK, "md-2": http.StatusOK},
+		latencies: map[string]time.Duration{"md-1": primaryLatency},
+		calls:     make(map[string]int),
+	}
+	client := NewBalancedHTTPClient(endpoints, firstEndpoint{}, time.Second, zap.NewNop())
+	client.Transport().SetBase(hosts)
+	client.SetHedgePolicy(policy)
+	return client, hosts, endpoints
+}
+
+func TestHedgeWinsOverSlowPrimary(t *testing.T) {
+	client, hosts, endpoints := newHedgedClient(300*time.Millisecond, HedgePolicy{Delay: 20 * time.Millisecond, Budget: 1})
+
+	start := time.Now()
+	resp, err := client.Get(context.Background(), pricePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if elapsed := time.Since(start); elapsed >= 300*time.Millisecond {
+		t.Errorf("Hedged request took %v, want the hedge's answer", elapsed)
+	}
+	if hosts.callsTo("md-1") != 1 || hosts.callsTo("md-2") != 1 {
+		t.Errorf("Calls = %v, want one per endpoint", hosts.calls)
+	}
+
+	// The canceled primary is not recorded as a failure
+	time.Sleep(20 * time.Millisecond)
+	if stats := endpoints[0].Breaker().GetStats(); stats["failures"] != uint32(0) {
+		t.Errorf("md-1 breaker stats = %v, want no failures", stats)
+	}
+	if outstanding := endpoints[0].Outstanding(); outstanding != 0 {
+		t.Errorf("md-1 outstanding = %d, want 0 once the primary was canceled", outstanding)
+	}
+}
+
+func TestHedgeNotSentForFastPrimary(t *testing.T) {
+	client, hosts, _ := newHedgedClient(0, HedgePolicy{Delay: 50 * time.Millisecond, Budget: 1})
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(context.Background(), pricePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	time.Sleep(60 * time.Millisecond)
+
+	if calls := hosts.callsTo("md-1") + hosts.callsTo("md-2"); calls != 3 {
+		t.Errorf("Calls = %v, want one per request", hosts.calls)
+	}
+}
+
+func TestHedgeWithinBudget(t *testing.T) {
+	client, hosts, _ := newHedgedClient(60*time.Millisecond, HedgePolicy{Delay: 10 * time.Millisecond, Budget: 0.5})
+
+	// Each request deposits half a hedge, so every second one is hedged
+	for i := 0; i < 4; i++ {
+		resp, err := client.Get(context.Background(), pricePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if hedges := hosts.callsTo("md-2"); hedges != 2 {
+		t.Errorf("Hedges = %d, want 2 for 4 requests at a budget of 0.5", hedges)
+	}
+}
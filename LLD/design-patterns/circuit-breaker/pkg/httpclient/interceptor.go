@@ -0,0 +1,316 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+const (
+	// RequestIDHeader carries the request ID between services
+	RequestIDHeader = "X-Request-ID"
+
+	// DeadlineHeader carries the time left for a request in milliseconds.
+	// A relative budget is used so clock skew between hosts does not matter.
+	DeadlineHeader = "X-Request-Deadline"
+
+	// IdempotencyKeyHeader identifies a request the server should apply at
+	// most once, however often it is sent
+	IdempotencyKeyHeader = "Idempotency-Key"
+)
+
+// ErrDeadlineTooClose is returned by PropagateDeadline for a request with
+// less than a millisecond left, which is not sent. It matches
+// context.DeadlineExceeded but, unlike an upstream timing out, does not count
+// against the endpoint.
+var ErrDeadlineTooClose = fmt.Errorf("request not sent, deadline too close: %w", context.DeadlineExceeded)
+
+// DefaultRedactedHeaders are masked by the Logging interceptor
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// RoundTripFunc sends a request and returns its response
+type RoundTripFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper
+func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Interceptor wraps every attempt of a request. It may change the request
+// before calling next and inspect the response or error afterwards.
+// Interceptors run outside the circuit breaker, once per attempt, so a
+// retried or hedged request passes through them again.
+type Interceptor func(req *http.Request, next RoundTripFunc) (*http.Response, error)
+
+// chain composes interceptors around next; the first interceptor runs first
+func chain(interceptors []Interceptor, next RoundTripFunc) RoundTripFunc {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, inner := interceptors[i], next
+		next = func(req *http.Request) (*http.Response, error) {
+			return interceptor(req, inner)
+		}
+	}
+	return next
+}
+
+// Chain wraps an http.RoundTripper with interceptors, so they can be used
+// with any http.Client, e.g. together with NewTransport
+func Chain(base http.RoundTripper, interceptors ...Interceptor) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return chain(interceptors, base.RoundTrip)
+}
+
+// requestIDKey is the context key for the request ID
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the request ID carried by the context, or ""
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// idempotencyKeyKey is the context key for the idempotency key
+type idempotencyKeyKey struct{}
+
+// WithIdempotencyKey returns a context carrying the idempotency key
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyKey{}, key)
+}
+
+// IdempotencyKeyFrom returns the idempotency key carried by the context, or ""
+func IdempotencyKeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyKey{}).(string)
+	return key
+}
+
+// NewRequestID returns a random 128-bit request ID in hex
+func NewRequestID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// UserAgent sets the User-Agent header of every request
+func UserAgent(userAgent string) Interceptor {
+	return func(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+		req.Header.Set("User-Agent", userAgent)
+		return next(req)
+	}
+}
+
+// TokenSource returns the bearer token for a request
+type TokenSource func(ctx context.Context) (string, error)
+
+// StaticToken always returns the same token
+func StaticToken(token string) TokenSource {
+	return func(context.Context) (string, error) {
+		return token, nil
+	}
+}
+
+// AuthToken sends the token from source as "Authorization: Bearer <token>"
+// unless the request already carries an Authorization header. An error from
+// source fails the request before it is sent.
+func AuthToken(source TokenSource) Interceptor {
+	return func(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+		if req.Header.Get("Authorization") == "" {
+			token, err := source(req.Context())
+			if err != nil {
+				return nil, err
+			}
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		return next(req)
+	}
+}
+
+// RequestID propagates the request ID from the context in the X-Request-ID
+// header. Requests without one get a new ID, so every upstream call can be
+// correlated.
+func RequestID() Interceptor {
+	return func(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+		if req.Header.Get(RequestIDHeader) == "" {
+			id := RequestIDFrom(req.Context())
+			if id == "" {
+				id = NewRequestID()
+			}
+			req.Header.Set(RequestIDHeader, id)
+		}
+		return next(req)
+	}
+}
+
+// IdempotencyKey propagates the idempotency key from the context in the
+// Idempotency-Key header of POST, PUT, PATCH and DELETE requests, so the
+// upstream applies a retried operation at most once. Install it only on
+// clients of upstreams whose operations belong to the keyed request.
+func IdempotencyKey() Interceptor {
+	return func(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return next(req)
+		}
+		if key := IdempotencyKeyFrom(req.Context()); key != "" && req.Header.Get(IdempotencyKeyHeader) == "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		return next(req)
+	}
+}
+
+// FormatBudget formats the time left for a request for the DeadlineHeader
+func FormatBudget(budget time.Duration) string {
+	return strconv.FormatInt(budget.Milliseconds(), 10)
+}
+
+// ParseBudget parses a DeadlineHeader value
+func ParseBudget(value string) (time.Duration, bool) {
+	millis, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(millis) * time.Millisecond, true
+}
+
+// PropagateDeadline sends the time left until the context deadline in the
+// X-Request-Deadline header, so the upstream can give up on work that
+// cannot finish in time. A request with less than a millisecond left fails
+// with ErrDeadlineTooClose without being sent.
+func PropagateDeadline() Interceptor {
+	return func(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+		deadline, ok := req.Context().Deadline()
+		if !ok {
+			return next(req)
+		}
+
+		budget := time.Until(deadline)
+		if budget < time.Millisecond {
+			return nil, ErrDeadlineTooClose
+		}
+		req.Header.Set(DeadlineHeader, FormatBudget(budget))
+		return next(req)
+	}
+}
+
+// Logging logs every attempt at debug level with its headers. Values of
+// DefaultRedactedHeaders and of the extra headers given are masked.
+func Logging(logger *zap.Logger, redact ...string) Interceptor {
+	redacted := make(map[string]bool)
+	for _, name := range append(append([]string(nil), DefaultRedactedHeaders...), redact...) {
+		redacted[http.CanonicalHeaderKey(name)] = true
+	}
+
+	return func(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+		if !logger.Core().Enabled(zap.DebugLevel) {
+			return next(req)
+		}
+
+		start := time.Now()
+		resp, err := next(req)
+
+		fields := []zap.Field{
+			zap.String("method", req.Method),
+			zap.String("url", req.URL.Redacted()),
+			zap.String("requestId", req.Header.Get(RequestIDHeader)),
+			zap.Any("requestHeaders", redactHeaders(req.Header, redacted)),
+			zap.Duration("duration", time.Since(start)),
+		}
+		if err != nil {
+			logger.Debug("HTTP call failed", append(fields, zap.Error(err))...)
+			return resp, err
+		}
+
+		logger.Debug("HTTP call completed", append(fields,
+			zap.Int("status_code", resp.StatusCode),
+			zap.Any("responseHeaders", redactHeaders(resp.Header, redacted)),
+		)...)
+		return resp, nil
+	}
+}
+
+// redactHeaders returns a copy of header with sensitive values masked
+func redactHeaders(header http.Header, redacted map[string]bool) map[string]string {
+	result := make(map[string]string, len(header))
+	for name, values := range header {
+		if redacted[http.CanonicalHeaderKey(name)] {
+			result[name] = "[REDACTED]"
+			continue
+		}
+		result[name] = strings.Join(values, ", ")
+	}
+	return result
+}
+
+// callMetrics holds Prometheus metrics recorded by the Metrics interceptor
+type callMetrics struct {
+	callsTotal   *prometheus.CounterVec
+	callDuration *prometheus.HistogramVec
+}
+
+var (
+	callMetricsOnce   sync.Once
+	globalCallMetrics *callMetrics
+)
+
+func getCallMetrics() *callMetrics {
+	callMetricsOnce.Do(func() {
+		globalCallMetrics = &callMetrics{
+			callsTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "http_client_calls_total",
+					Help: "Total number of outgoing HTTP calls per client, including calls rejected by the circuit breaker",
+				},
+				[]string{"client", "method", "code"},
+			),
+			callDuration: promauto.NewHistogramVec(
+				prometheus.HistogramOpts{
+					Name: "http_client_call_duration_seconds",
+					Help: "Outgoing HTTP call duration per client in seconds",
+				},
+				[]string{"client", "method"},
+			),
+		}
+	})
+	return globalCallMetrics
+}
+
+// Metrics counts calls and records their duration under the client name,
+// e.g. "market-data-service". Calls that got no response are counted with
+// code "error".
+func Metrics(client string) Interceptor {
+	metrics := getCallMetrics()
+
+	return func(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+		start := time.Now()
+		resp, err := next(req)
+
+		code := "error"
+		if resp != nil {
+			code = strconv.Itoa(resp.StatusCode)
+		}
+		metrics.callsTotal.WithLabelValues(client, req.Method, code).Inc()
+		metrics.callDuration.WithLabelValues(client, req.Method).Observe(time.Since(start).Seconds())
+
+		return resp, err
+	}
+}
@@ -0,0 +1,215 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// callLog records the order interceptors run in
+type callLog struct {
+	mutex sync.Mutex
+	calls []string
+}
+
+// interceptor returns an interceptor that logs entering and leaving as name
+func (l *callLog) interceptor(name string) Interceptor {
+	return func(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+		l.add(name + ">")
+		resp, err := next(req)
+		if errors.Is(err, circuitbreaker.ErrOpenState) {
+			l.add("<" + name + " rejected")
+		} else {
+			l.add("<" + name)
+		}
+		return resp, err
+	}
+}
+
+func (l *callLog) add(call string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.calls = append(l.calls, call)
+}
+
+func (l *callLog) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return strings.Join(l.calls, " ")
+}
+
+func TestInterceptorsRunInOrderOfUse(t *testing.T) {
+	fake := NewFakeTransport().On(http.MethodGet, pricePath, Respond(http.StatusOK, nil))
+	client := newTestClient(t, fake)
+	log := &callLog{}
+	client.Use(log.interceptor("a"), log.interceptor("b"))
+	client.Use(log.interceptor("c"))
+
+	if _, err := getBody(context.Background(), client, pricePath); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := log.String(), "a> b> c> <c <b <a"; got != want {
+		t.Errorf("Calls = %q, want %q", got, want)
+	}
+}
+
+func TestInterceptorsRunOncePerAttempt(t *testing.T) {
+	fake := NewFakeTransport().On(http.MethodGet, pricePath,
+		Respond(http.StatusServiceUnavailable, nil),
+		Respond(http.StatusOK, nil))
+	client := newTestClient(t, fake)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 1})
+	log := &callLog{}
+	client.Use(log.interceptor("a"))
+
+	if _, err := getBody(context.Background(), client, pricePath); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := log.String(), "a> <a a> <a"; got != want {
+		t.Errorf("Calls = %q, want %q", got, want)
+	}
+}
+
+func TestInterceptorsSeeBreakerRejections(t *testing.T) {
+	client := newTestClient(t, NewFakeTransport())
+	client.Endpoints()[0].Breaker().OpenUntil(time.Now().Add(time.Minute))
+	log := &callLog{}
+	client.Use(log.interceptor("a"))
+
+	if _, err := client.Get(context.Background(), pricePath); !errors.Is(err, circuitbreaker.ErrOpenState) {
+		t.Fatalf("Get() = %v, want ErrOpenState", err)
+	}
+	if got, want := log.String(), "a> <a rejected"; got != want {
+		t.Errorf("Calls = %q, want %q", got, want)
+	}
+}
+
+func TestChain(t *testing.T) {
+	headers := &headerLog{next: NewFakeTransport().On(http.MethodGet, pricePath, Respond(http.StatusOK, nil))}
+	client := &http.Client{Transport: Chain(headers, UserAgent("test-agent/1.0"), AuthToken(StaticToken("secret")))}
+
+	resp, err := client.Get(testBaseURL + pricePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	sent := headers.get(0)
+	if sent.Get("User-Agent") != "test-agent/1.0" || sent.Get("Authorization") != "Bearer secret" {
+		t.Errorf("Headers = %v, want the user agent and bearer token", sent)
+	}
+}
+
+func TestAuthToken(t *testing.T) {
+	t.Run("keeps an existing Authorization header", func(t *testing.T) {
+		headers := &headerLog{next: NewFakeTransport().On(http.MethodGet, pricePath, Respond(http.StatusOK, nil))}
+		client := newTestClient(t, headers)
+		client.Use(AuthToken(StaticToken("service-token")))
+
+		resp, err := client.Do(context.Background(), http.MethodGet, pricePath, nil, map[string]string{"Authorization": "Bearer caller"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := headers.get(0).Get("Authorization"); got != "Bearer caller" {
+			t.Errorf("Authorization = %q, want the caller's", got)
+		}
+	})
+
+	t.Run("fails before sending when the token is unavailable", func(t *testing.T) {
+		fake := NewFakeTransport().On(http.MethodGet, pricePath, Respond(http.StatusOK, nil))
+		client := newTestClient(t, fake)
+		errNoToken := errors.New("no token")
+		client.Use(AuthToken(func(context.Context) (string, error) { return "", errNoToken }))
+
+		if _, err := client.Get(context.Background(), pricePath); !errors.Is(err, errNoToken) {
+			t.Errorf("Get() = %v, want the token source's error", err)
+		}
+		if calls := fake.Calls(http.MethodGet, pricePath); calls != 0 {
+			t.Errorf("Upstream calls = %d, want 0", calls)
+		}
+	})
+}
+
+func TestRequestID(t *testing.T) {
+	headers := &headerLog{next: NewFakeTransport().On(http.MethodGet, pricePath, Respond(http.StatusOK, nil))}
+	client := newTestClient(t, headers)
+
+	getBody(WithRequestID(context.Background(), "caller-request"), client, pricePath)
+	getBody(context.Background(), client, pricePath)
+
+	if got := headers.get(0).Get(RequestIDHeader); got != "caller-request" {
+		t.Errorf("%s = %q, want the caller's ID", RequestIDHeader, got)
+	}
+	if got := headers.get(1).Get(RequestIDHeader); !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(got) {
+		t.Errorf("%s = %q, want a new 128-bit ID", RequestIDHeader, got)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	fake := NewFakeTransport().
+		On(http.MethodGet, pricePath, Respond(http.StatusOK, nil)).
+		On(http.MethodPost, "/api/v1/trades", Respond(http.StatusCreated, nil))
+	headers := &headerLog{next: fake}
+	client := newTestClient(t, headers)
+	client.Use(IdempotencyKey())
+
+	ctx := WithIdempotencyKey(context.Background(), "trade-1")
+	getBody(ctx, client, pricePath)
+	resp, err := client.Post(ctx, "/api/v1/trades", map[string]string{"symbol": "AAPL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := headers.get(0).Get(IdempotencyKeyHeader); got != "" {
+		t.Errorf("GET %s = %q, want none", IdempotencyKeyHeader, got)
+	}
+	if got := headers.get(1).Get(IdempotencyKeyHeader); got != "trade-1" {
+		t.Errorf("POST %s = %q, want the caller's key", IdempotencyKeyHeader, got)
+	}
+}
+
+func TestLoggingRedactsHeaders(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	fake := NewFakeTransport().On(http.MethodGet, pricePath,
+		Respond(http.StatusOK, nil).WithHeader("Set-Cookie", "session=abc"))
+	client := newTestClient(t, fake)
+	client.Use(AuthToken(StaticToken("secret")), Logging(zap.New(core), "X-Account"))
+
+	resp, err := client.Do(context.Background(), http.MethodGet, pricePath, nil, map[string]string{"X-Account": "12345"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	entries := logs.FilterMessage("HTTP call completed").All()
+	if len(entries) != 1 {
+		t.Fatalf("Logged %d calls, want 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	requestHeaders, _ := fields["requestHeaders"].(map[string]string)
+	responseHeaders, _ := fields["responseHeaders"].(map[string]string)
+	if requestHeaders["Authorization"] != "[REDACTED]" || requestHeaders["X-Account"] != "[REDACTED]" {
+		t.Errorf("Request headers = %v, want Authorization and X-Account redacted", requestHeaders)
+	}
+	if requestHeaders["Accept"] != "application/json" {
+		t.Errorf("Request headers = %v, want Accept logged", requestHeaders)
+	}
+	if responseHeaders["Set-Cookie"] != "[REDACTED]" {
+		t.Errorf("Response headers = %v, want Set-Cookie redacted", responseHeaders)
+	}
+}
@@ -0,0 +1,238 @@
+package httpclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrNoInteraction is returned by Replayer and FakeTransport for a request
+// they have no recorded or scripted answer for
+var ErrNoInteraction = errors.New("no interaction for request")
+
+// Interaction is one recorded round trip. Either Error or the response
+// fields are set.
+type Interaction struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	RequestBody string      `json:"requestBody,omitempty"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        string      `json:"body,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	LatencyMS   int64       `json:"latencyMs"`
+}
+
+// Fixture is a sequence of recorded interactions, stored as JSON
+type Fixture struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadFixture reads a fixture file written by Recorder.Save
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture %s: %w", path, err)
+	}
+	return &fixture, nil
+}
+
+// Recorder is an http.RoundTripper that passes requests to base and records
+// every request/response pair, including latency and transport errors. Use
+// it as the base of a Transport to record what the upstreams really said.
+type Recorder struct {
+	base http.RoundTripper
+
+	mutex        sync.Mutex
+	interactions []Interaction
+}
+
+// NewRecorder creates a recorder on top of base (http.DefaultTransport when nil)
+func NewRecorder(base http.RoundTripper) *Recorder {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Recorder{base: base}
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	interaction := Interaction{
+		Method: req.Method,
+		URL:    req.URL.String(),
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		interaction.RequestBody = string(body)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	start := time.Now()
+	resp, err := r.base.RoundTrip(req)
+	if err == nil {
+		var body []byte
+		body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		interaction.Status = resp.StatusCode
+		interaction.Header = resp.Header.Clone()
+		interaction.Body = string(body)
+	}
+	interaction.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		interaction.Error = err.Error()
+	}
+
+	r.mutex.Lock()
+	r.interactions = append(r.interactions, interaction)
+	r.mutex.Unlock()
+
+	return resp, err
+}
+
+// Fixture returns everything recorded so far
+func (r *Recorder) Fixture() *Fixture {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return &Fixture{Interactions: append([]Interaction(nil), r.interactions...)}
+}
+
+// Save writes everything recorded so far to a fixture file
+func (r *Recorder) Save(path string) error {
+	data, err := json.MarshalIndent(r.Fixture(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode fixture: %w", err)
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Replayer is an http.RoundTripper that answers requests from a fixture.
+// Requests are matched by method, path and query, ignoring the host, and by
+// body when the interaction has one. JSON bodies match when they hold the
+// same values, whatever the key order; leave requestBody out of a fixture for
+// bodies that change from run to run, like IDs and timestamps. Each recorded
+// interaction is used once, the first match in recording order. Recorded
+// latency is waited out again, so timeouts and hedging behave as they did
+// when recording.
+type Replayer struct {
+	mutex   sync.Mutex
+	pending map[string][]Interaction
+}
+
+// NewReplayer creates a replayer for the fixture
+func NewReplayer(fixture *Fixture) (*Replayer, error) {
+	r := &Replayer{pending: make(map[string][]Interaction)}
+	for _, interaction := range fixture.Interactions {
+		req, err := http.NewRequest(interaction.Method, interaction.URL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interaction %s %s: %w", interaction.Method, interaction.URL, err)
+		}
+		key := replayKey(req)
+		r.pending[key] = append(r.pending[key], interaction)
+	}
+	return r, nil
+}
+
+// replayKey identifies requests that share recorded interactions
+func replayKey(req *http.Request) string {
+	return req.Method + " " + req.URL.RequestURI()
+}
+
+// Remaining returns how many recorded interactions have not been replayed
+func (r *Replayer) Remaining() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	remaining := 0
+	for _, interactions := range r.pending {
+		remaining += len(interactions)
+	}
+	return remaining
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+
+	key := replayKey(req)
+	r.mutex.Lock()
+	interactions := r.pending[key]
+	match := -1
+	for i, interaction := range interactions {
+		if sameBody(interaction.RequestBody, body) {
+			match = i
+			break
+		}
+	}
+	if match < 0 {
+		r.mutex.Unlock()
+		if len(interactions) > 0 {
+			return nil, fmt.Errorf("%w: %s with body %s", ErrNoInteraction, key, body)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNoInteraction, key)
+	}
+	interaction := interactions[match]
+	r.pending[key] = append(interactions[:match:match], interactions[match+1:]...)
+	r.mutex.Unlock()
+
+	step := Step{
+		Status:  interaction.Status,
+		Header:  interaction.Header,
+		Body:    []byte(interaction.Body),
+		Latency: time.Duration(interaction.LatencyMS) * time.Millisecond,
+	}
+	if interaction.Error != "" {
+		step.Err = errors.New(interaction.Error)
+	}
+	return step.respond(req)
+}
+
+// sameBody reports whether a request body matches the recorded one. An
+// interaction without a recorded body matches any body.
+func sameBody(recorded string, body []byte) bool {
+	if recorded == "" || recorded == string(body) {
+		return true
+	}
+
+	want, err := canonicalJSON([]byte(recorded))
+	if err != nil {
+		return false
+	}
+	got, err := canonicalJSON(body)
+	return err == nil && bytes.Equal(want, got)
+}
+
+// canonicalJSON re-encodes a JSON document with sorted keys and no spacing
+func canonicalJSON(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
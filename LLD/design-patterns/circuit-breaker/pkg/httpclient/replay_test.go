@@ -0,0 +1,164 @@
+package httpclient
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// send sends a request through rt and returns the status and body of the
+// response
+func send(rt http.RoundTripper, method, url, body string) (int, string, error) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	if body == "" {
+		req.Body = http.NoBody
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data), err
+}
+
+func TestRecorderSaveAndLoad(t *testing.T) {
+	fake := NewFakeTransport().
+		On(http.MethodGet, pricePath, Respond(http.StatusOK, map[string]float64{"price": 150}).After(20*time.Millisecond)).
+		On(http.MethodPost, "/api/v1/risk/check", Refuse())
+	recorder := NewRecorder(fake)
+
+	if _, body, err := send(recorder, http.MethodGet, "http://md-1:8082"+pricePath, ""); err != nil || body != `{"price":150}` {
+		t.Fatalf("Recorded GET = %q, %v, want the fake's response", body, err)
+	}
+	if _, _, err := send(recorder, http.MethodPost, "http://risk:8083/api/v1/risk/check", `{"symbol":"AAPL"}`); !errors.Is(err, ErrFakeConnectionRefused) {
+		t.Fatalf("Recorded POST = %v, want the fake's error", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := recorder.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	fixture, err := LoadFixture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fixture, recorder.Fixture()) {
+		t.Errorf("Loaded fixture = %+v, want %+v", fixture, recorder.Fixture())
+	}
+
+	if got := len(fixture.Interactions); got != 2 {
+		t.Fatalf("Interactions = %d, want 2", got)
+	}
+	get, post := fixture.Interactions[0], fixture.Interactions[1]
+	if get.Status != http.StatusOK || get.Body != `{"price":150}` || get.LatencyMS < 20 {
+		t.Errorf("GET interaction = %+v, want 200 with the body after 20ms", get)
+	}
+	if post.RequestBody != `{"symbol":"AAPL"}` || post.Error != ErrFakeConnectionRefused.Error() {
+		t.Errorf("POST interaction = %+v, want the request body and the error", post)
+	}
+}
+
+func TestLoadFixtureErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadFixture(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadFixture() of a missing file succeeded, want an error")
+	}
+
+	path := filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(path, []byte(`{"interactions": [`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFixture(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("LoadFixture() of broken JSON = %v, want an error naming the file", err)
+	}
+}
+
+func TestReplayerReplaysErrorsAndLatency(t *testing.T) {
+	replayer, err := NewReplayer(&Fixture{Interactions: []Interaction{
+		{Method: http.MethodGet, URL: "http://md-1:8082" + pricePath, Status: http.StatusOK, Body: `{"price":150}`, LatencyMS: 30},
+		{Method: http.MethodGet, URL: "http://md-1:8082" + pricePath, Error: "connect: connection refused"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The host is ignored
+	start := time.Now()
+	status, body, err := send(replayer, http.MethodGet, "http://localhost:8082"+pricePath, "")
+	if err != nil || status != http.StatusOK || body != `{"price":150}` {
+		t.Errorf("First replay = %d %q %v, want the recorded response", status, body, err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("First replay took %v, want the recorded 30ms", elapsed)
+	}
+
+	if _, _, err := send(replayer, http.MethodGet, "http://localhost:8082"+pricePath, ""); err == nil || err.Error() != "connect: connection refused" {
+		t.Errorf("Second replay = %v, want the recorded error", err)
+	}
+
+	// Every interaction is used once
+	if _, _, err := send(replayer, http.MethodGet, "http://localhost:8082"+pricePath, ""); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("Third replay = %v, want ErrNoInteraction", err)
+	}
+	if remaining := replayer.Remaining(); remaining != 0 {
+		t.Errorf("Remaining = %d, want 0", remaining)
+	}
+}
+
+func TestReplayerMatchesBodies(t *testing.T) {
+	const riskURL = "http://risk:8083/api/v1/risk/check"
+	replayer, err := NewReplayer(&Fixture{Interactions: []Interaction{
+		{Method: http.MethodPost, URL: riskURL, RequestBody: `{"symbol":"AAPL","quantity":10}`, Status: http.StatusOK, Body: "aapl"},
+		{Method: http.MethodPost, URL: riskURL, RequestBody: `{"symbol":"MSFT","quantity":5}`, Status: http.StatusOK, Body: "msft"},
+		{Method: http.MethodPost, URL: "http://audit:8085/api/v1/audit", Status: http.StatusOK, Body: "audited"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		body string
+		want string // Response body, or "" for ErrNoInteraction
+	}{
+		{"other path", "http://risk:8083/api/v1/risk/limits", `{"symbol":"AAPL","quantity":10}`, ""},
+		{"different body", riskURL, `{"symbol":"AAPL","quantity":11}`, ""},
+		{"not JSON", riskURL, `symbol=AAPL`, ""},
+		{"out of order with other key order", riskURL, `{ "quantity": 5, "symbol": "MSFT" }`, "msft"},
+		{"same body", riskURL, `{"symbol":"AAPL","quantity":10}`, "aapl"},
+		{"already replayed", riskURL, `{"symbol":"AAPL","quantity":10}`, ""},
+		{"no recorded body", "http://audit:8085/api/v1/audit", `{"eventId":"AUDIT_1"}`, "audited"},
+	}
+	for _, tt := range tests {
+		_, body, err := send(replayer, http.MethodPost, tt.url, tt.body)
+		if tt.want == "" {
+			if !errors.Is(err, ErrNoInteraction) {
+				t.Errorf("%s: replay = %q %v, want ErrNoInteraction", tt.name, body, err)
+			}
+			continue
+		}
+		if err != nil || body != tt.want {
+			t.Errorf("%s: replay = %q %v, want %q", tt.name, body, err, tt.want)
+		}
+	}
+}
+
+func TestNewReplayerRejectsInvalidURL(t *testing.T) {
+	_, err := NewReplayer(&Fixture{Interactions: []Interaction{{Method: http.MethodGet, URL: "://md-1"}}})
+	if err == nil {
+		t.Error("NewReplayer() with an invalid URL succeeded, want an error")
+	}
+}
@@ -0,0 +1,104 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+)
+
+// RetryPolicy controls how failed idempotent requests are retried
+type RetryPolicy struct {
+	MaxAttempts    int           // Total attempts including the first one; 1 disables retries
+	InitialBackoff time.Duration // Wait before the first retry
+	MaxBackoff     time.Duration // Upper bound for the wait between retries
+	Multiplier     float64       // Growth factor applied to the wait after each retry
+}
+
+// NoRetry returns a policy that sends every request exactly once
+func NoRetry() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 1}
+}
+
+// backoff returns the wait before the given retry (1 for the first retry)
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	wait := float64(p.InitialBackoff)
+	for i := 1; i < retry; i++ {
+		wait *= p.Multiplier
+	}
+
+	if p.MaxBackoff > 0 && time.Duration(wait) > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return time.Duration(wait)
+}
+
+// attemptsFor returns how many attempts are allowed for the method. Only
+// idempotent methods are retried, so a POST is never sent twice.
+func (p RetryPolicy) attemptsFor(method string) int {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		if p.MaxAttempts > 1 {
+			return p.MaxAttempts
+		}
+	}
+	return 1
+}
+
+// shouldRetry reports whether another attempt may help. Requests rejected by
+// an open circuit, not sent for lack of time, abandoned by the caller or
+// refused with a client error status are not retried.
+func shouldRetry(ctx context.Context, err error) bool {
+	if errors.Is(err, circuitbreaker.ErrOpenState) || errors.Is(err, ErrDeadlineTooClose) {
+		return false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && !httpErr.Retryable() {
+		return false
+	}
+
+	return ctx.Err() == nil
+}
+
+// ParseRetryAfter parses a Retry-After header value given either as a
+// number of seconds or as an HTTP-date. A date in the past yields 0.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := date.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// sleep waits for d or until the context is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
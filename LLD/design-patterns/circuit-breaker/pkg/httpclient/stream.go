@@ -0,0 +1,165 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+)
+
+// ErrStreamStalled is returned when a streamed response delivers no data
+// for the idle timeout
+var ErrStreamStalled = errors.New("stream stalled")
+
+// streamingKey marks a request context whose response body is streamed
+type streamingKey struct{}
+
+// withStreaming marks ctx so the Transport defers the breaker outcome
+// until the response body has been consumed
+func withStreaming(ctx context.Context) context.Context {
+	return context.WithValue(ctx, streamingKey{}, true)
+}
+
+// isStreaming reports whether ctx was marked by withStreaming
+func isStreaming(ctx context.Context) bool {
+	streaming, _ := ctx.Value(streamingKey{}).(bool)
+	return streaming
+}
+
+// streamOutcome turns the result of a streamed request into the outcome
+// recorded by the breaker. A request whose context ended reports the cause,
+// so a stall counts as a failure while a caller giving up does not.
+func streamOutcome(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return err
+}
+
+// breakerBody reports the outcome of a streamed response to the breaker:
+// success when the body is read to the end or closed early, failure when a
+// read fails
+type breakerBody struct {
+	io.ReadCloser
+	ctx  context.Context
+	done func(err error)
+}
+
+// Read implements io.Reader
+func (b *breakerBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	switch {
+	case err == io.EOF:
+		b.done(nil)
+	case err != nil:
+		b.done(streamOutcome(b.ctx, err))
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (b *breakerBody) Close() error {
+	b.done(nil)
+	return b.ReadCloser.Close()
+}
+
+// roundTripStream sends a streamed request. The breaker outcome is decided
+// at once for transport errors and responses classified as failures, and
+// otherwise when the body is consumed.
+func (t *Transport) roundTripStream(cb *circuitbreaker.CircuitBreaker, req *http.Request) (*http.Response, error) {
+	done, err := cb.Allow()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if t.classify(resp, err) {
+		outcome := err
+		if outcome == nil {
+			outcome = errClassifiedFailure
+		}
+		done(streamOutcome(req.Context(), outcome))
+		return resp, err
+	}
+
+	resp.Body = &breakerBody{ReadCloser: resp.Body, ctx: req.Context(), done: done}
+	return resp, nil
+}
+
+// idleBody fails the stream with ErrStreamStalled when no data arrives for
+// the idle timeout, and releases the request context on Close
+type idleBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	timer   *time.Timer // nil without an idle timeout
+	timeout time.Duration
+	cancel  context.CancelCauseFunc
+	once    sync.Once
+}
+
+// Read implements io.Reader
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.timer != nil {
+		b.timer.Reset(b.timeout)
+	}
+	if err != nil && errors.Is(context.Cause(b.ctx), ErrStreamStalled) {
+		return n, ErrStreamStalled
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (b *idleBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		b.cancel(context.Canceled)
+	})
+	return err
+}
+
+// Stream sends a request whose response body is read incrementally, such
+// as a large batch response or an event feed. Unlike Do, the circuit
+// breaker records the outcome only when the body has been read to the end
+// or closed (success) or a read failed (failure), so a body that stalls or
+// is cut off counts against the upstream. There is no overall timeout: the
+// request lasts as long as ctx, but fails with ErrStreamStalled when no data
+// arrives for the client timeout. Streams are not retried, hedged or
+// cached. The caller must close the body.
+func (c *HTTPClient) Stream(ctx context.Context, method, path string, body interface{}, headers map[string]string) (*http.Response, error) {
+	timeout := c.GetTimeout()
+	ctx, cancel := context.WithCancelCause(withStreaming(ctx))
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() { cancel(ErrStreamStalled) })
+	}
+
+	resp, err := c.doRequestTo(ctx, c.pickEndpoint(), method, path, body, headers)
+	if err != nil {
+		if timer != nil {
+			timer.Stop()
+		}
+		stalled := errors.Is(context.Cause(ctx), ErrStreamStalled)
+		cancel(context.Canceled)
+		if stalled {
+			return nil, ErrStreamStalled
+		}
+		return nil, err
+	}
+
+	resp.Body = &idleBody{
+		ReadCloser: resp.Body,
+		ctx:        ctx,
+		timer:      timer,
+		timeout:    timeout,
+		cancel:     cancel,
+	}
+	return resp, nil
+}
@@ -0,0 +1,188 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+)
+
+// pipeTransport answers every request with the given status and a body the
+// test writes through the pipe sent on writers. Like a body read from the
+// network, it fails once the request context ends.
+type pipeTransport struct {
+	status  int
+	writers chan *io.PipeWriter
+}
+
+func newPipeTransport(status int) *pipeTransport {
+	return &pipeTransport{status: status, writers: make(chan *io.PipeWriter, 1)}
+}
+
+func (p *pipeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, writer := io.Pipe()
+	go func() {
+		<-req.Context().Done()
+		writer.CloseWithError(req.Context().Err())
+	}()
+	p.writers <- writer
+
+	return &http.Response{
+		StatusCode: p.status,
+		Header:     make(http.Header),
+		Body:       body,
+		Request:    req,
+	}, nil
+}
+
+// newStreamClient returns a test client over a pipe transport whose breaker
+// opens on the second failure and already counts one, so a success shows
+// up as the count going back to 0
+func newStreamClient(t *testing.T, status int) (*HTTPClient, *pipeTransport, *circuitbreaker.CircuitBreaker) {
+	t.Helper()
+
+	pipe := newPipeTransport(status)
+	client := newTestClient(t, pipe)
+	breaker := client.Endpoints()[0].Breaker()
+	config := breaker.GetConfig()
+	config.FailureThreshold = 2
+	config.MinimumRequests = 100
+	if err := breaker.UpdateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := breaker.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(errors.New("earlier failure"))
+	return client, pipe, breaker
+}
+
+// checkFailures fails unless the breaker counts want failures
+func checkFailures(t *testing.T, breaker *circuitbreaker.CircuitBreaker, want uint32) {
+	t.Helper()
+
+	if got := breaker.GetStats()["failures"]; got != want {
+		t.Errorf("Breaker failures = %v, want %d", got, want)
+	}
+}
+
+func TestStreamOutcomeDeferredUntilBodyIsRead(t *testing.T) {
+	client, pipe, breaker := newStreamClient(t, http.StatusOK)
+
+	resp, err := client.Stream(context.Background(), http.MethodGet, pricePath, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	writer := <-pipe.writers
+
+	go func() {
+		writer.Write([]byte(`{"symbol":"AAPL"}`))
+		writer.Close()
+	}()
+	buf := make([]byte, 64)
+	if _, err := resp.Body.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	checkFailures(t, breaker, 1)
+
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	checkFailures(t, breaker, 0)
+}
+
+func TestStreamOutcomes(t *testing.T) {
+	tests := []struct {
+		name string
+		// finish ends the stream and returns the error of reading it
+		finish       func(body io.ReadCloser, writer *io.PipeWriter, cancel context.CancelFunc) error
+		wantErr      error
+		wantFailures uint32
+		wantState    circuitbreaker.State
+	}{
+		{
+			name: "closed early",
+			finish: func(body io.ReadCloser, writer *io.PipeWriter, cancel context.CancelFunc) error {
+				return body.Close()
+			},
+			wantFailures: 0,
+			wantState:    circuitbreaker.StateClosed,
+		},
+		{
+			name: "cut off",
+			finish: func(body io.ReadCloser, writer *io.PipeWriter, cancel context.CancelFunc) error {
+				writer.CloseWithError(io.ErrUnexpectedEOF)
+				_, err := io.ReadAll(body)
+				return err
+			},
+			wantErr:      io.ErrUnexpectedEOF,
+			wantFailures: 2,
+			wantState:    circuitbreaker.StateOpen,
+		},
+		{
+			name: "stalled",
+			finish: func(body io.ReadCloser, writer *io.PipeWriter, cancel context.CancelFunc) error {
+				_, err := io.ReadAll(body)
+				return err
+			},
+			wantErr:      ErrStreamStalled,
+			wantFailures: 2,
+			wantState:    circuitbreaker.StateOpen,
+		},
+		{
+			name: "abandoned by the caller",
+			finish: func(body io.ReadCloser, writer *io.PipeWriter, cancel context.CancelFunc) error {
+				cancel()
+				_, err := io.ReadAll(body)
+				return err
+			},
+			wantErr:      context.Canceled,
+			wantFailures: 1,
+			wantState:    circuitbreaker.StateClosed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, pipe, breaker := newStreamClient(t, http.StatusOK)
+			client.SetTimeout(50 * time.Millisecond)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			resp, err := client.Stream(ctx, http.MethodGet, pricePath, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if err := tt.finish(resp.Body, <-pipe.writers, cancel); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Stream error = %v, want %v", err, tt.wantErr)
+			}
+			checkFailures(t, breaker, tt.wantFailures)
+			if state := breaker.GetState(); state != tt.wantState {
+				t.Errorf("State = %v, want %v", state, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestStreamFailureStatusRecordedAtOnce(t *testing.T) {
+	client, pipe, breaker := newStreamClient(t, http.StatusInternalServerError)
+	go func() {
+		(<-pipe.writers).Close()
+	}()
+
+	_, err := client.Stream(context.Background(), http.MethodGet, pricePath, nil, nil)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Stream() = %v, want a 500 HTTPError", err)
+	}
+	checkFailures(t, breaker, 2)
+}
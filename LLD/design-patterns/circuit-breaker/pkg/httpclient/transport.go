@@ -0,0 +1,234 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Classifier decides whether the outcome of a round trip counts as a
+// failure for the circuit breaker
+type Classifier func(resp *http.Response, err error) bool
+
+// DefaultClassifier treats transport errors, 5xx and 429 responses as
+// failures. Other 4xx responses are the caller's fault and do not say
+// anything about the health of the upstream, and neither does a 504 the
+// upstream answered because the caller's X-Request-Deadline left too little
+// time, marked by echoing the header as 0.
+func DefaultClassifier(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	if resp.StatusCode == http.StatusGatewayTimeout && resp.Header.Get(DeadlineHeader) == "0" {
+		return false
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// BreakerSelector returns the circuit breaker guarding a request
+type BreakerSelector func(req *http.Request) *circuitbreaker.CircuitBreaker
+
+// PerHost selects a breaker per request host (host:port) from the registry
+func PerHost(registry *circuitbreaker.Registry) BreakerSelector {
+	return func(req *http.Request) *circuitbreaker.CircuitBreaker {
+		return registry.Get(req.URL.Host)
+	}
+}
+
+// SingleBreaker guards every request with the same breaker
+func SingleBreaker(cb *circuitbreaker.CircuitBreaker) BreakerSelector {
+	return func(*http.Request) *circuitbreaker.CircuitBreaker {
+		return cb
+	}
+}
+
+// DefaultMaxRetryAfter caps how long a Retry-After hint may hold a circuit open
+const DefaultMaxRetryAfter = 5 * time.Minute
+
+// Transport is an http.RoundTripper that runs every request through a
+// circuit breaker, so any http.Client (including third-party SDKs) can be
+// protected. Responses classified as failures are still returned to the
+// caller; they only count against the breaker. A 429 or 503 response with
+// Retry-After holds the breaker open for the requested time.
+type Transport struct {
+	base          http.RoundTripper
+	breakers      BreakerSelector
+	classify      Classifier
+	maxRetryAfter time.Duration
+	logger        *zap.Logger
+	metrics       *transportMetrics
+}
+
+// errClassifiedFailure marks a response the classifier rejected so the
+// breaker records a failure while the response is still handed back
+var errClassifiedFailure = errors.New("response classified as failure")
+
+// transportMetrics holds Prometheus metrics for round trips
+type transportMetrics struct {
+	requestsTotal   *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	retryAfterTotal *prometheus.CounterVec
+}
+
+var (
+	transportMetricsOnce   sync.Once
+	globalTransportMetrics *transportMetrics
+)
+
+// NewTransport creates a breaker-aware transport on top of base
+// (http.DefaultTransport when nil)
+func NewTransport(base http.RoundTripper, breakers BreakerSelector, logger *zap.Logger) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &Transport{
+		base:          base,
+		breakers:      breakers,
+		classify:      DefaultClassifier,
+		maxRetryAfter: DefaultMaxRetryAfter,
+		logger:        logger,
+		metrics:       getTransportMetrics(),
+	}
+}
+
+// SetBase replaces the transport that sends requests, e.g. with a Recorder,
+// Replayer or FakeTransport. Call it before the transport is used.
+func (t *Transport) SetBase(base http.RoundTripper) {
+	t.base = base
+}
+
+// SetClassifier replaces the failure classifier
+func (t *Transport) SetClassifier(classify Classifier) {
+	t.classify = classify
+}
+
+// SetMaxRetryAfter caps how long a Retry-After hint may hold a breaker
+// open; 0 ignores hints
+func (t *Transport) SetMaxRetryAfter(max time.Duration) {
+	t.maxRetryAfter = max
+}
+
+func getTransportMetrics() *transportMetrics {
+	transportMetricsOnce.Do(func() {
+		globalTransportMetrics = &transportMetrics{
+			requestsTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "http_client_requests_total",
+					Help: "Total number of outgoing HTTP requests",
+				},
+				[]string{"host", "method", "code"},
+			),
+			requestDuration: promauto.NewHistogramVec(
+				prometheus.HistogramOpts{
+					Name: "http_client_request_duration_seconds",
+					Help: "Outgoing HTTP request duration in seconds",
+				},
+				[]string{"host", "method"},
+			),
+			retryAfterTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "http_client_retry_after_total",
+					Help: "Total number of Retry-After hints that held a circuit breaker open",
+				},
+				[]string{"host", "code"},
+			),
+		}
+	})
+	return globalTransportMetrics
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cb := t.breakers(req)
+	start := time.Now()
+
+	var resp *http.Response
+	var err error
+	if isStreaming(req.Context()) {
+		resp, err = t.roundTripStream(cb, req)
+	} else {
+		var result interface{}
+		result, err = cb.Execute(req.Context(), func() (interface{}, error) {
+			resp, err := t.base.RoundTrip(req)
+			if t.classify(resp, err) {
+				if err == nil {
+					err = errClassifiedFailure
+				}
+			}
+			return resp, err
+		})
+
+		resp, _ = result.(*http.Response)
+		if errors.Is(err, errClassifiedFailure) {
+			err = nil
+		}
+	}
+	if resp != nil {
+		t.honourRetryAfter(cb, req, resp)
+	}
+
+	code := "error"
+	switch {
+	case errors.Is(err, circuitbreaker.ErrOpenState):
+		code = "rejected"
+	case err != nil && errors.Is(req.Context().Err(), context.Canceled):
+		code = "canceled"
+	case resp != nil:
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	t.metrics.requestsTotal.WithLabelValues(req.URL.Host, req.Method, code).Inc()
+	t.metrics.requestDuration.WithLabelValues(req.URL.Host, req.Method).Observe(time.Since(start).Seconds())
+
+	t.logger.Debug("HTTP round trip",
+		zap.String("method", req.Method),
+		zap.String("url", req.URL.Redacted()),
+		zap.String("breaker", cb.GetConfig().Name),
+		zap.String("code", code),
+		zap.Duration("duration", time.Since(start)),
+		zap.Error(err),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// honourRetryAfter holds the breaker open when an overloaded upstream asked
+// for a pause with a 429 or 503 response carrying Retry-After
+func (t *Transport) honourRetryAfter(cb *circuitbreaker.CircuitBreaker, req *http.Request, resp *http.Response) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	if t.maxRetryAfter <= 0 {
+		return
+	}
+
+	wait, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok || wait <= 0 {
+		return
+	}
+	if wait > t.maxRetryAfter {
+		wait = t.maxRetryAfter
+	}
+
+	cb.OpenUntil(time.Now().Add(wait))
+	t.metrics.retryAfterTotal.WithLabelValues(req.URL.Host, strconv.Itoa(resp.StatusCode)).Inc()
+
+	t.logger.Warn("Upstream asked to back off, holding circuit open",
+		zap.String("url", req.URL.Redacted()),
+		zap.String("breaker", cb.GetConfig().Name),
+		zap.Int("status_code", resp.StatusCode),
+		zap.Duration("retryAfter", wait),
+	)
+}
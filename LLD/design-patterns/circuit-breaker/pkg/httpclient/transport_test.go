@@ -0,0 +1,146 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+
+	"go.uber.org/zap"
+)
+
+// hostStatuses answers every request with the status set for its host
+type hostStatuses map[string]int
+
+func (h hostStatuses) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: h[req.URL.Host], Body: http.NoBody, Request: req}, nil
+}
+
+// sendTo sends n GET requests to host and returns the last error
+func sendTo(client *http.Client, host string, n int) error {
+	var err error
+	for i := 0; i < n; i++ {
+		var resp *http.Response
+		if resp, err = client.Get("http://" + host + "/api/v1/health"); err == nil {
+			resp.Body.Close()
+		}
+	}
+	return err
+}
+
+func TestPerHostBreakers(t *testing.T) {
+	template := circuitbreaker.DefaultConfig("")
+	template.FailureThreshold = 2
+	registry := circuitbreaker.NewRegistry(template, zap.NewNop())
+
+	hosts := hostStatuses{"md-1:8082": http.StatusInternalServerError, "md-2:8082": http.StatusOK}
+	client := &http.Client{Transport: NewTransport(hosts, PerHost(registry), zap.NewNop())}
+
+	if err := sendTo(client, "md-1:8082", 3); !errors.Is(err, circuitbreaker.ErrOpenState) {
+		t.Errorf("Third request to md-1 = %v, want ErrOpenState", err)
+	}
+	if err := sendTo(client, "md-2:8082", 3); err != nil {
+		t.Errorf("Request to md-2 = %v, want it sent", err)
+	}
+
+	if state := registry.Get("md-1:8082").GetState(); state != circuitbreaker.StateOpen {
+		t.Errorf("md-1 breaker = %v, want open", state)
+	}
+	if state := registry.Get("md-2:8082").GetState(); state != circuitbreaker.StateClosed {
+		t.Errorf("md-2 breaker = %v, want closed", state)
+	}
+	if breakers := registry.All(); len(breakers) != 2 {
+		t.Errorf("Breakers = %d, want one per host", len(breakers))
+	}
+}
+
+func TestSingleBreakerGuardsEveryHost(t *testing.T) {
+	config := circuitbreaker.DefaultConfig("upstream")
+	config.FailureThreshold = 2
+	cb := circuitbreaker.NewCircuitBreaker(config, zap.NewNop())
+
+	hosts := hostStatuses{"md-1:8082": http.StatusInternalServerError, "md-2:8082": http.StatusOK}
+	client := &http.Client{Transport: NewTransport(hosts, SingleBreaker(cb), zap.NewNop())}
+
+	sendTo(client, "md-1:8082", 2)
+	if err := sendTo(client, "md-2:8082", 1); !errors.Is(err, circuitbreaker.ErrOpenState) {
+		t.Errorf("Request to md-2 = %v, want ErrOpenState from the shared breaker", err)
+	}
+}
+
+// newRetryAfterClient returns a test client whose breaker times out after
+// 20ms and whose Retry-After hints are capped at 100ms
+func newRetryAfterClient(t *testing.T, fake *FakeTransport) *HTTPClient {
+	t.Helper()
+
+	client := newTestClient(t, fake)
+	config := client.Endpoints()[0].Breaker().GetConfig()
+	config.Timeout = 20 * time.Millisecond
+	if err := client.Endpoints()[0].Breaker().UpdateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	client.Transport().SetMaxRetryAfter(100 * time.Millisecond)
+	return client
+}
+
+func TestRetryAfterHoldsBreakerOpen(t *testing.T) {
+	for _, first := range []Step{
+		Unavailable(time.Second),
+		Respond(http.StatusTooManyRequests, nil).WithHeader("Retry-After", "1"),
+	} {
+		t.Run(http.StatusText(first.Status), func(t *testing.T) {
+			fake := NewFakeTransport().On(http.MethodGet, pricePath, first, Respond(http.StatusOK, nil))
+			client := newRetryAfterClient(t, fake)
+			breaker := client.Endpoints()[0].Breaker()
+
+			if _, err := client.Get(context.Background(), pricePath); err == nil {
+				t.Fatal("Get() succeeded, want the upstream's error")
+			}
+			if state := breaker.GetState(); state != circuitbreaker.StateOpen {
+				t.Fatalf("State = %v, want open", state)
+			}
+
+			// Held open past the breaker timeout, up to the capped hint
+			time.Sleep(50 * time.Millisecond)
+			if _, err := client.Get(context.Background(), pricePath); !errors.Is(err, circuitbreaker.ErrOpenState) {
+				t.Errorf("Get() while held open = %v, want ErrOpenState", err)
+			}
+
+			time.Sleep(70 * time.Millisecond)
+			if _, err := getBody(context.Background(), client, pricePath); err != nil {
+				t.Errorf("Get() after the hint = %v, want a trial request", err)
+			}
+			if calls := fake.Calls(http.MethodGet, pricePath); calls != 2 {
+				t.Errorf("Upstream calls = %d, want 2", calls)
+			}
+		})
+	}
+}
+
+func TestRetryAfterIgnored(t *testing.T) {
+	tests := []struct {
+		name          string
+		step          Step
+		maxRetryAfter time.Duration
+	}{
+		{"500 with Retry-After", Respond(http.StatusInternalServerError, nil).WithHeader("Retry-After", "1"), 100 * time.Millisecond},
+		{"503 without Retry-After", Respond(http.StatusServiceUnavailable, nil), 100 * time.Millisecond},
+		{"503 with an invalid Retry-After", Respond(http.StatusServiceUnavailable, nil).WithHeader("Retry-After", "soon"), 100 * time.Millisecond},
+		{"hints disabled", Unavailable(time.Second), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newRetryAfterClient(t, NewFakeTransport().On(http.MethodGet, pricePath, tt.step))
+			client.Transport().SetMaxRetryAfter(tt.maxRetryAfter)
+
+			client.Get(context.Background(), pricePath)
+			if state := client.Endpoints()[0].Breaker().GetState(); state != circuitbreaker.StateClosed {
+				t.Errorf("State = %v, want closed", state)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often MemoryStore drops expired records
+const sweepInterval = time.Minute
+
+// MemoryStore keeps records in process memory. Each instance then only
+// recognises keys it has seen itself, and records are lost on restart.
+type MemoryStore struct {
+	mutex     sync.Mutex
+	records   map[string]entry
+	lastSweep time.Time
+}
+
+// entry is a record with its expiry
+type entry struct {
+	record  Record
+	expires time.Time
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]entry)}
+}
+
+// Claim implements Store
+func (s *MemoryStore) Claim(_ context.Context, key string, record Record, ttl time.Duration, now time.Time) (Record, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweepLocked(now)
+	}
+
+	if existing, exists := s.records[key]; exists && now.Before(existing.expires) {
+		return existing.record, false, nil
+	}
+	s.records[key] = entry{record: record, expires: now.Add(ttl)}
+	return Record{}, true, nil
+}
+
+// Complete implements Store
+func (s *MemoryStore) Complete(_ context.Context, key string, record Record, ttl time.Duration, now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.records[key] = entry{record: record, expires: now.Add(ttl)}
+	return nil
+}
+
+// Release implements Store
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// sweepLocked drops expired records
+func (s *MemoryStore) sweepLocked(now time.Time) {
+	for key, e := range s.records {
+		if !now.Before(e.expires) {
+			delete(s.records, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+// Len returns the number of keys with a record in the store
+func (s *MemoryStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.records)
+}
@@ -0,0 +1,31 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreExpiresRecords(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	now := time.Now()
+
+	if _, claimed, _ := store.Claim(ctx, "trade-1", Record{Fingerprint: "a"}, time.Minute, now); !claimed {
+		t.Fatal("First claim failed")
+	}
+	existing, claimed, _ := store.Claim(ctx, "trade-1", Record{Fingerprint: "b"}, time.Minute, now.Add(30*time.Second))
+	if claimed || existing.Fingerprint != "a" || existing.Completed {
+		t.Errorf("Claim within the TTL = %+v, %v, want the in-progress record", existing, claimed)
+	}
+	if _, claimed, _ := store.Claim(ctx, "trade-1", Record{}, time.Minute, now.Add(time.Minute)); !claimed {
+		t.Error("Claim after the TTL failed, want the expired record replaced")
+	}
+
+	// Expired records of other keys are swept once a minute
+	store.Claim(ctx, "trade-2", Record{}, time.Second, now.Add(time.Minute))
+	store.Claim(ctx, "trade-3", Record{}, time.Second, now.Add(3*time.Minute))
+	if n := store.Len(); n != 1 {
+		t.Errorf("Len() = %d after a sweep, want only the new record", n)
+	}
+}
@@ -0,0 +1,287 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"circuit-breaker-demo/pkg/auth"
+	"circuit-breaker-demo/pkg/httpclient"
+	"circuit-breaker-demo/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// ReplayedHeader marks a response replayed from the store
+const ReplayedHeader = "Idempotent-Replayed"
+
+// maxKeyLength caps the length of an Idempotency-Key
+const maxKeyLength = 255
+
+// maxRecordedBody caps the response body kept for replays; larger responses
+// are not recorded and the key is released
+const maxRecordedBody = 1 << 20
+
+// Policy configures idempotency. A disabled policy ignores keys.
+type Policy struct {
+	Enabled bool
+	TTL     time.Duration // How long a key is held and its response replayed
+}
+
+// Guard is gin middleware that applies each keyed request at most once
+type Guard struct {
+	store   Store
+	logger  *zap.Logger
+	metrics *idempotencyMetrics
+
+	mutex  sync.RWMutex
+	policy Policy
+}
+
+// idempotencyMetrics holds Prometheus metrics for idempotent requests
+type idempotencyMetrics struct {
+	requestsTotal *prometheus.CounterVec
+}
+
+var (
+	idempotencyMetricsOnce   sync.Once
+	globalIdempotencyMetrics *idempotencyMetrics
+)
+
+func getIdempotencyMetrics() *idempotencyMetrics {
+	idempotencyMetricsOnce.Do(func() {
+		globalIdempotencyMetrics = &idempotencyMetrics{
+			requestsTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "idempotency_requests_total",
+					Help: "Total number of requests with an Idempotency-Key by route and result",
+				},
+				[]string{"route", "result"},
+			),
+		}
+	})
+	return globalIdempotencyMetrics
+}
+
+// NewGuard creates a guard. Store errors are answered 503: without its
+// record a request could be applied twice.
+func NewGuard(store Store, policy Policy, logger *zap.Logger) *Guard {
+	return &Guard{
+		store:   store,
+		logger:  logger,
+		metrics: getIdempotencyMetrics(),
+		policy:  policy,
+	}
+}
+
+// SetPolicy changes the policy for requests from now on
+func (g *Guard) SetPolicy(policy Policy) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.policy = policy
+}
+
+// Policy returns the active policy
+func (g *Guard) Policy() Policy {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	return g.policy
+}
+
+// Middleware makes a route idempotent for requests with an Idempotency-Key.
+// Keys are scoped to the caller and the request path. The first request
+// with a key runs the handler; its response is kept for the policy TTL
+// unless it is a server error or the client went away, which release the
+// key for a retry. Duplicates get 409 while the first request runs and its
+// response replayed afterwards, marked with Idempotent-Replayed: true.
+//
+// With matchBody a key reused with a different body is answered 422.
+// Without it the key alone identifies the operation, for callers that may
+// retry it with refreshed values.
+func (g *Guard) Middleware(matchBody bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(httpclient.IdempotencyKeyHeader)
+		policy := g.Policy()
+		if key == "" || !policy.Enabled {
+			c.Next()
+			return
+		}
+
+		route := c.FullPath()
+		if len(key) > maxKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:     "Invalid idempotency key",
+				Message:   "Idempotency-Key must be at most 255 characters",
+				Code:      "INVALID_IDEMPOTENCY_KEY",
+				Timestamp: time.Now(),
+			})
+			return
+		}
+
+		var fingerprint string
+		if matchBody {
+			var err error
+			if fingerprint, err = fingerprintBody(c); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, models.ErrorResponse{
+					Error:     "Invalid request format",
+					Message:   err.Error(),
+					Code:      "INVALID_REQUEST",
+					Timestamp: time.Now(),
+				})
+				return
+			}
+		}
+
+		// Store calls outlive a client that goes away, so a claim is never left behind
+		ctx := context.WithoutCancel(c.Request.Context())
+		storeKey := scope(c) + "|" + key
+
+		existing, claimed, err := g.store.Claim(ctx, storeKey, Record{Fingerprint: fingerprint}, policy.TTL, time.Now())
+		if err != nil {
+			g.logger.Error("Failed to claim idempotency key", zap.String("route", route), zap.Error(err))
+			g.metrics.requestsTotal.WithLabelValues(route, "error").Inc()
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, models.ErrorResponse{
+				Error:     "Service unavailable",
+				Message:   "Idempotency-Key could not be checked, retry later",
+				Code:      "IDEMPOTENCY_UNAVAILABLE",
+				Timestamp: time.Now(),
+			})
+			return
+		}
+		if !claimed {
+			g.reject(c, route, existing, fingerprint)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Request = c.Request.WithContext(httpclient.WithIdempotencyKey(c.Request.Context(), key))
+
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			// Also reached when the handler panics
+			if err := g.store.Release(ctx, storeKey); err != nil {
+				g.logger.Error("Failed to release idempotency key", zap.String("route", route), zap.Error(err))
+			}
+			g.metrics.requestsTotal.WithLabelValues(route, "released").Inc()
+		}()
+
+		c.Next()
+
+		status := recorder.Status()
+		if !recorder.Written() || status >= http.StatusInternalServerError || recorder.overflow {
+			return
+		}
+		record := Record{
+			Fingerprint: fingerprint,
+			Completed:   true,
+			Status:      status,
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		}
+		if err := g.store.Complete(ctx, storeKey, record, policy.TTL, time.Now()); err != nil {
+			g.logger.Error("Failed to record idempotent response", zap.String("route", route), zap.Error(err))
+			return
+		}
+		completed = true
+		g.metrics.requestsTotal.WithLabelValues(route, "completed").Inc()
+	}
+}
+
+// reject answers a request whose key is already claimed: 422 for a
+// different request, 409 while the first one runs, else its response
+func (g *Guard) reject(c *gin.Context, route string, existing Record, fingerprint string) {
+	switch {
+	case existing.Fingerprint != fingerprint:
+		g.metrics.requestsTotal.WithLabelValues(route, "mismatch").Inc()
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, models.ErrorResponse{
+			Error:     "Idempotency key reused",
+			Message:   "Idempotency-Key was already used for a different request",
+			Code:      "IDEMPOTENCY_KEY_REUSED",
+			Timestamp: time.Now(),
+		})
+	case !existing.Completed:
+		g.metrics.requestsTotal.WithLabelValues(route, "conflict").Inc()
+		c.Header("Retry-After", "1")
+		c.AbortWithStatusJSON(http.StatusConflict, models.ErrorResponse{
+			Error:     "Request in progress",
+			Message:   "A request with this Idempotency-Key is still being processed",
+			Code:      "IDEMPOTENCY_CONFLICT",
+			Timestamp: time.Now(),
+		})
+	default:
+		g.metrics.requestsTotal.WithLabelValues(route, "replayed").Inc()
+		c.Header(ReplayedHeader, "true")
+		if existing.ContentType != "" {
+			c.Header("Content-Type", existing.ContentType)
+		}
+		c.Status(existing.Status)
+		c.Writer.Write(existing.Body)
+		c.Abort()
+	}
+}
+
+// scope returns what a key is scoped to: the authenticated user, if any,
+// and the request, so keys of different callers or paths never collide
+func scope(c *gin.Context) string {
+	subject := ""
+	if principal, ok := auth.PrincipalFromContext(c.Request.Context()); ok {
+		subject = principal.Subject
+	}
+	return subject + "|" + c.Request.Method + " " + c.Request.URL.Path
+}
+
+// fingerprintBody returns a hash of the request body, which is left in
+// place for the handler
+func fingerprintBody(c *gin.Context) (string, error) {
+	if c.Request.Body == nil {
+		return "", nil
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return "", err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// responseRecorder keeps a copy of the response body for replays
+type responseRecorder struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.record(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.record([]byte(s))
+	return r.ResponseWriter.WriteString(s)
+}
+
+// record appends data to the copy unless it would grow too large
+func (r *responseRecorder) record(data []byte) {
+	if r.overflow || r.body.Len()+len(data) > maxRecordedBody {
+		r.overflow = true
+		return
+	}
+	r.body.Write(data)
+}
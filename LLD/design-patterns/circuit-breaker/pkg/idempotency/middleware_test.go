@@ -0,0 +1,203 @@
+package idempotency
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"circuit-breaker-demo/pkg/httpclient"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const tradeBody = `{"symbol":"AAPL","quantity":10}`
+
+// tradeRoute is a POST /trades handler answering with the outcomes it is
+// given in turn, the last one repeating
+type tradeRoute struct {
+	outcomes []func(c *gin.Context)
+	calls    atomic.Int32
+}
+
+func (r *tradeRoute) handle(c *gin.Context) {
+	call := int(r.calls.Add(1)) - 1
+	if call >= len(r.outcomes) {
+		call = len(r.outcomes) - 1
+	}
+	r.outcomes[call](c)
+}
+
+func created(c *gin.Context) {
+	body, _ := io.ReadAll(c.Request.Body)
+	c.Data(http.StatusCreated, "application/json", body)
+}
+
+// newTestRouter returns a router guarding the route, with the guard
+// matching bodies when matchBody is set
+func newTestRouter(route *tradeRoute, matchBody bool) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	guard := NewGuard(NewMemoryStore(), Policy{Enabled: true, TTL: time.Hour}, zap.NewNop())
+	router := gin.New()
+	router.Use(gin.RecoveryWithWriter(io.Discard))
+	router.POST("/trades", guard.Middleware(matchBody), route.handle)
+	return router
+}
+
+func postTrade(router *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/trades", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(httpclient.IdempotencyKeyHeader, key)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestDuplicateGetsReplayedResponse(t *testing.T) {
+	route := &tradeRoute{outcomes: []func(*gin.Context){created}}
+	router := newTestRouter(route, true)
+
+	first := postTrade(router, "trade-1", tradeBody)
+	if first.Code != http.StatusCreated || first.Header().Get(ReplayedHeader) != "" {
+		t.Fatalf("First request = %d %q, want 201 without %s", first.Code, first.Header().Get(ReplayedHeader), ReplayedHeader)
+	}
+
+	replay := postTrade(router, "trade-1", tradeBody)
+	if replay.Code != http.StatusCreated || replay.Body.String() != tradeBody {
+		t.Errorf("Replay = %d %q, want the first response", replay.Code, replay.Body.String())
+	}
+	if replay.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("%s = %q, want true", ReplayedHeader, replay.Header().Get(ReplayedHeader))
+	}
+	if got := replay.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want the recorded one", got)
+	}
+	if calls := route.calls.Load(); calls != 1 {
+		t.Errorf("Handler ran %d times, want once", calls)
+	}
+
+	// Requests without a key or with another key are not affected
+	postTrade(router, "", tradeBody)
+	postTrade(router, "trade-2", tradeBody)
+	if calls := route.calls.Load(); calls != 3 {
+		t.Errorf("Handler ran %d times, want 3", calls)
+	}
+}
+
+func TestDuplicateWhileInProgressConflicts(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	route := &tradeRoute{outcomes: []func(*gin.Context){func(c *gin.Context) {
+		close(started)
+		<-finish
+		created(c)
+	}}}
+	router := newTestRouter(route, true)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		postTrade(router, "trade-1", tradeBody)
+	}()
+	<-started
+
+	conflict := postTrade(router, "trade-1", tradeBody)
+	close(finish)
+	wg.Wait()
+
+	if conflict.Code != http.StatusConflict || conflict.Header().Get("Retry-After") != "1" {
+		t.Errorf("Duplicate in progress = %d, Retry-After %q, want 409 with Retry-After 1", conflict.Code, conflict.Header().Get("Retry-After"))
+	}
+	if replay := postTrade(router, "trade-1", tradeBody); replay.Code != http.StatusCreated {
+		t.Errorf("Duplicate after completion = %d, want the replayed 201", replay.Code)
+	}
+}
+
+func TestKeyReusedForAnotherBody(t *testing.T) {
+	other := `{"symbol":"MSFT","quantity":10}`
+
+	tests := []struct {
+		name      string
+		matchBody bool
+		want      int
+	}{
+		{"bodies compared", true, http.StatusUnprocessableEntity},
+		{"key alone", false, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		route := &tradeRoute{outcomes: []func(*gin.Context){created}}
+		router := newTestRouter(route, tt.matchBody)
+
+		postTrade(router, "trade-1", tradeBody)
+		got := postTrade(router, "trade-1", other)
+		if got.Code != tt.want {
+			t.Errorf("%s: reused key = %d, want %d", tt.name, got.Code, tt.want)
+		}
+		if calls := route.calls.Load(); calls != 1 {
+			t.Errorf("%s: handler ran %d times, want once", tt.name, calls)
+		}
+	}
+}
+
+func TestFailedRequestReleasesKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		fail   func(c *gin.Context)
+		status int
+	}{
+		{"server error", func(c *gin.Context) { c.JSON(http.StatusServiceUnavailable, gin.H{"error": "risk service down"}) }, http.StatusServiceUnavailable},
+		{"panic", func(c *gin.Context) { panic("risk check crashed") }, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		route := &tradeRoute{outcomes: []func(*gin.Context){tt.fail, created}}
+		router := newTestRouter(route, true)
+
+		if got := postTrade(router, "trade-1", tradeBody); got.Code != tt.status {
+			t.Fatalf("%s: first request = %d, want %d", tt.name, got.Code, tt.status)
+		}
+		retry := postTrade(router, "trade-1", tradeBody)
+		if retry.Code != http.StatusCreated || retry.Header().Get(ReplayedHeader) != "" {
+			t.Errorf("%s: retry = %d, want the handler run again", tt.name, retry.Code)
+		}
+		if calls := route.calls.Load(); calls != 2 {
+			t.Errorf("%s: handler ran %d times, want twice", tt.name, calls)
+		}
+	}
+}
+
+func TestClientErrorIsReplayed(t *testing.T) {
+	route := &tradeRoute{outcomes: []func(*gin.Context){
+		func(c *gin.Context) { c.JSON(http.StatusBadRequest, gin.H{"error": "insufficient funds"}) },
+		created,
+	}}
+	router := newTestRouter(route, true)
+
+	postTrade(router, "trade-1", tradeBody)
+	if got := postTrade(router, "trade-1", tradeBody); got.Code != http.StatusBadRequest {
+		t.Errorf("Retry of a rejected trade = %d, want the replayed 400", got.Code)
+	}
+}
+
+func TestOverlongKeyRejected(t *testing.T) {
+	route := &tradeRoute{outcomes: []func(*gin.Context){created}}
+	router := newTestRouter(route, true)
+
+	if got := postTrade(router, strings.Repeat("k", maxKeyLength+1), tradeBody); got.Code != http.StatusBadRequest {
+		t.Errorf("Overlong key = %d, want 400", got.Code)
+	}
+	if calls := route.calls.Load(); calls != 0 {
+		t.Errorf("Handler ran %d times, want never", calls)
+	}
+}
@@ -0,0 +1,34 @@
+// Package idempotency lets clients retry unsafe requests with an
+// Idempotency-Key header. The first request with a key claims it; until it
+// completes, duplicates are answered 409, and afterwards they get its
+// response replayed. Records are kept by a Store: MemoryStore for a single
+// instance, or a shared implementation for several.
+package idempotency
+
+import (
+	"context"
+	"time"
+)
+
+// Record is the state of one key
+type Record struct {
+	Fingerprint string // Hash of the request that claimed the key; empty when not compared
+	Completed   bool   // False while the claiming request is in progress
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// Store keeps a record per key until it expires. A shared implementation
+// must make Claim atomic across instances, e.g. with Redis SET NX.
+type Store interface {
+	// Claim records key as in progress unless it has a record, which is
+	// then returned with claimed false
+	Claim(ctx context.Context, key string, record Record, ttl time.Duration, now time.Time) (existing Record, claimed bool, err error)
+
+	// Complete replaces the record of a claimed key with its response
+	Complete(ctx context.Context, key string, record Record, ttl time.Duration, now time.Time) error
+
+	// Release drops the record of a claimed key, so the request may be tried again
+	Release(ctx context.Context, key string) error
+}
@@ -0,0 +1,19 @@
+package loadshed
+
+import (
+	"runtime"
+	"time"
+)
+
+// cpuUtilization returns the part of the usable CPUs the process used:
+// cpu time spent over elapsed wall time, divided by GOMAXPROCS
+func cpuUtilization(cpu, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	utilization := float64(cpu) / float64(elapsed) / float64(runtime.GOMAXPROCS(0))
+	if utilization > 1 {
+		return 1
+	}
+	return utilization
+}
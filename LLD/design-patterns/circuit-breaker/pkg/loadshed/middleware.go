@@ -0,0 +1,62 @@
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"circuit-breaker-demo/pkg/httpclient"
+	"circuit-breaker-demo/pkg/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware admits each request through the shedder by the priority of
+// its route and answers shed requests 503 with Retry-After. Register it
+// after the Deadline middleware so a request gives up waiting for a slot
+// when its deadline passes.
+func (s *Shedder) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		priority := s.Priority(c.Request.Method, c.FullPath())
+
+		release, err := s.Acquire(c.Request.Context(), priority)
+		if err != nil {
+			var shed *ShedError
+			switch {
+			case errors.As(err, &shed):
+				Reject(c, shed)
+			case errors.Is(err, context.DeadlineExceeded):
+				// Tell callers the budget ran out, as bootstrap.DeadlineExceeded does
+				c.Header(httpclient.DeadlineHeader, "0")
+				c.JSON(http.StatusGatewayTimeout, models.ErrorResponse{
+					Error:     "Deadline exceeded",
+					Message:   "Request deadline expired while waiting for capacity",
+					Code:      "DEADLINE_EXCEEDED",
+					Timestamp: time.Now(),
+				})
+			}
+			c.Abort()
+			return
+		}
+		defer release()
+
+		c.Next()
+	}
+}
+
+// Reject answers a shed request 503, with Retry-After in whole seconds
+// when the policy sets one
+func Reject(c *gin.Context, shed *ShedError) {
+	if shed.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(shed.RetryAfter.Seconds()))))
+	}
+	c.JSON(http.StatusServiceUnavailable, models.ErrorResponse{
+		Error:     "Service overloaded",
+		Message:   shed.Error(),
+		Code:      "OVERLOADED",
+		Timestamp: time.Now(),
+	})
+}
@@ -0,0 +1,384 @@
+// Package loadshed protects a service from more work than it can handle.
+// Requests beyond the in-flight limit wait briefly for a slot and are shed
+// with 503 and Retry-After when none frees up in time, or at once while the
+// process is short of CPU. Priority classes decide who is shed first.
+package loadshed
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Priority orders requests by how long they are kept when the service is
+// overloaded
+type Priority int
+
+const (
+	PriorityLow      Priority = iota // Shed first, e.g. market data reads
+	PriorityNormal                   // Shed once the service is nearly full
+	PriorityCritical                 // Shed only at the full limits, e.g. trades
+)
+
+// Priorities lists the priority names accepted by ParsePriority
+var Priorities = []string{"low", "normal", "critical"}
+
+// String returns the priority name
+func (p Priority) String() string {
+	if p < PriorityLow || p > PriorityCritical {
+		return "unknown"
+	}
+	return Priorities[p]
+}
+
+// ParsePriority returns the priority with the given name
+func ParsePriority(name string) (Priority, error) {
+	for i, candidate := range Priorities {
+		if name == candidate {
+			return Priority(i), nil
+		}
+	}
+	return PriorityNormal, fmt.Errorf("unknown priority %q", name)
+}
+
+// share is the part of every limit a priority may use: low priority work
+// is shed at half the in-flight limit, queue time and CPU threshold, normal
+// work at 80%, and critical work only at the full limits
+func (p Priority) share() float64 {
+	switch p {
+	case PriorityLow:
+		return 0.5
+	case PriorityNormal:
+		return 0.8
+	}
+	return 1
+}
+
+// Reasons a request is shed, as reported by ShedError and the metrics
+const (
+	ReasonInFlight  = "in_flight"  // The in-flight limit was reached and queueing is disabled
+	ReasonQueueTime = "queue_time" // No slot freed up within the queue time
+	ReasonCPU       = "cpu"        // Process CPU utilization is above the threshold
+)
+
+// ShedError is returned for a request that was shed
+type ShedError struct {
+	Priority   Priority
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *ShedError) Error() string {
+	switch e.Reason {
+	case ReasonCPU:
+		return fmt.Sprintf("%s priority request shed: CPU utilization above threshold", e.Priority)
+	case ReasonQueueTime:
+		return fmt.Sprintf("%s priority request shed: no capacity within the queue time", e.Priority)
+	}
+	return fmt.Sprintf("%s priority request shed: too many requests in flight", e.Priority)
+}
+
+// Policy configures when requests are shed. Zero fields disable their
+// check, so a zero Policy sheds nothing.
+type Policy struct {
+	MaxInFlight  int           // Requests handled at once
+	MaxQueueTime time.Duration // How long a request may wait for a slot; 0 sheds at once
+	MaxCPU       float64       // Process CPU utilization in (0,1] of the usable CPUs
+	RetryAfter   time.Duration // Sent to shed callers; rounded up to whole seconds
+
+	DefaultPriority Priority
+	Routes          map[string]Priority // By method and path pattern, e.g. "POST /api/v1/trades"
+}
+
+// inFlightLimit returns how many requests may be in flight when one of
+// the priority is admitted, or 0 when in-flight requests are unlimited
+func (p Policy) inFlightLimit(priority Priority) int {
+	if p.MaxInFlight <= 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(p.MaxInFlight) * priority.share()))
+}
+
+// queueTime returns how long a request of the priority may wait for a slot
+func (p Policy) queueTime(priority Priority) time.Duration {
+	return time.Duration(float64(p.MaxQueueTime) * priority.share())
+}
+
+// RouteKey returns the Routes key for a method and path pattern
+func RouteKey(method, path string) string {
+	return strings.ToUpper(method) + " " + path
+}
+
+// cpuSampleInterval is how often CPU utilization is measured
+const cpuSampleInterval = time.Second
+
+// Shedder admits or sheds requests according to its policy
+type Shedder struct {
+	logger  *zap.Logger
+	metrics *shedMetrics
+	cpu     atomic.Uint64 // math.Float64bits of the last CPU utilization sample
+
+	mutex    sync.Mutex
+	policy   Policy
+	inFlight int
+	queues   [PriorityCritical + 1][]*waiter // Waiting requests by priority, oldest first
+}
+
+// waiter is a request queued for a slot; ready is closed once it has one
+type waiter struct {
+	ready chan struct{}
+}
+
+// shedMetrics holds Prometheus metrics for load shedding
+type shedMetrics struct {
+	requestsTotal *prometheus.CounterVec
+	queueWait     *prometheus.HistogramVec
+	inFlight      prometheus.Gauge
+	cpu           prometheus.Gauge
+}
+
+var (
+	shedMetricsOnce   sync.Once
+	globalShedMetrics *shedMetrics
+)
+
+func getShedMetrics() *shedMetrics {
+	shedMetricsOnce.Do(func() {
+		globalShedMetrics = &shedMetrics{
+			requestsTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "load_shed_requests_total",
+					Help: "Total number of requests by priority and result (admitted or the reason they were shed)",
+				},
+				[]string{"priority", "result"},
+			),
+			queueWait: promauto.NewHistogramVec(
+				prometheus.HistogramOpts{
+					Name:    "load_shed_queue_wait_seconds",
+					Help:    "Time admitted requests waited for a slot",
+					Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1},
+				},
+				[]string{"priority"},
+			),
+			inFlight: promauto.NewGauge(
+				prometheus.GaugeOpts{
+					Name: "load_shed_in_flight_requests",
+					Help: "Number of requests being handled",
+				},
+			),
+			cpu: promauto.NewGauge(
+				prometheus.GaugeOpts{
+					Name: "load_shed_cpu_utilization",
+					Help: "Process CPU utilization of the usable CPUs (0-1), as last sampled",
+				},
+			),
+		}
+	})
+	return globalShedMetrics
+}
+
+// NewShedder creates a shedder. CPU utilization is only measured while
+// Start runs.
+func NewShedder(policy Policy, logger *zap.Logger) *Shedder {
+	return &Shedder{
+		logger:  logger,
+		metrics: getShedMetrics(),
+		policy:  policy,
+	}
+}
+
+// SetPolicy changes the policy. Raised limits admit queued requests right
+// away; lowered ones only affect requests arriving from now on.
+func (s *Shedder) SetPolicy(policy Policy) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.policy = policy
+	s.grantLocked()
+}
+
+// Policy returns the current policy
+func (s *Shedder) Policy() Policy {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.policy
+}
+
+// Priority returns the priority of requests to a route
+func (s *Shedder) Priority(method, path string) Priority {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if priority, exists := s.policy.Routes[RouteKey(method, path)]; exists {
+		return priority
+	}
+	return s.policy.DefaultPriority
+}
+
+// InFlight returns the number of admitted requests not yet released
+func (s *Shedder) InFlight() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.inFlight
+}
+
+// CPU returns the last sampled CPU utilization, or 0 before the first sample
+func (s *Shedder) CPU() float64 {
+	return math.Float64frombits(s.cpu.Load())
+}
+
+// Acquire admits a request of the given priority, waiting up to the queue
+// time for a slot, and returns the function that releases its slot. A shed
+// request gets a *ShedError, one whose context ends while it waits gets
+// the context error.
+func (s *Shedder) Acquire(ctx context.Context, priority Priority) (func(), error) {
+	s.mutex.Lock()
+	policy := s.policy
+
+	if policy.MaxCPU > 0 && s.CPU() > policy.MaxCPU*priority.share() {
+		s.mutex.Unlock()
+		return nil, s.shed(priority, ReasonCPU, policy)
+	}
+
+	if limit := policy.inFlightLimit(priority); limit == 0 || s.inFlight < limit {
+		s.inFlight++
+		s.metrics.inFlight.Set(float64(s.inFlight))
+		s.mutex.Unlock()
+		return s.admit(priority, 0), nil
+	}
+
+	wait := policy.queueTime(priority)
+	if wait <= 0 {
+		s.mutex.Unlock()
+		return nil, s.shed(priority, ReasonInFlight, policy)
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	s.queues[priority] = append(s.queues[priority], w)
+	s.mutex.Unlock()
+
+	start := time.Now()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-w.ready:
+		return s.admit(priority, time.Since(start)), nil
+	case <-timer.C:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.mutex.Lock()
+	queued := s.dequeueLocked(priority, w)
+	s.mutex.Unlock()
+
+	if !queued {
+		// Granted a slot while giving up on it: take it after all
+		return s.admit(priority, time.Since(start)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, s.shed(priority, ReasonQueueTime, policy)
+}
+
+// admit records an admitted request and returns its release function
+func (s *Shedder) admit(priority Priority, waited time.Duration) func() {
+	s.metrics.requestsTotal.WithLabelValues(priority.String(), "admitted").Inc()
+	s.metrics.queueWait.WithLabelValues(priority.String()).Observe(waited.Seconds())
+
+	var once sync.Once
+	return func() {
+		once.Do(s.release)
+	}
+}
+
+// shed records a shed request and returns its error
+func (s *Shedder) shed(priority Priority, reason string, policy Policy) error {
+	s.metrics.requestsTotal.WithLabelValues(priority.String(), reason).Inc()
+	s.logger.Debug("Request shed",
+		zap.String("priority", priority.String()),
+		zap.String("reason", reason),
+	)
+	return &ShedError{Priority: priority, Reason: reason, RetryAfter: policy.RetryAfter}
+}
+
+// release frees a slot and hands it to the highest priority waiter
+func (s *Shedder) release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.inFlight--
+	s.grantLocked()
+	s.metrics.inFlight.Set(float64(s.inFlight))
+}
+
+// grantLocked gives free slots to queued requests, highest priority first
+func (s *Shedder) grantLocked() {
+	for priority := PriorityCritical; priority >= PriorityLow; priority-- {
+		limit := s.policy.inFlightLimit(priority)
+		for len(s.queues[priority]) > 0 && (limit == 0 || s.inFlight < limit) {
+			w := s.queues[priority][0]
+			s.queues[priority] = s.queues[priority][1:]
+			s.inFlight++
+			close(w.ready)
+		}
+	}
+}
+
+// dequeueLocked removes a waiter from its queue and reports whether it was
+// still queued, i.e. had not been granted a slot
+func (s *Shedder) dequeueLocked(priority Priority, w *waiter) bool {
+	queue := s.queues[priority]
+	for i, candidate := range queue {
+		if candidate == w {
+			s.queues[priority] = append(queue[:i:i], queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Start samples CPU utilization until the context is cancelled. It returns
+// at once where process CPU time cannot be measured, which disables CPU
+// shedding.
+func (s *Shedder) Start(ctx context.Context) {
+	last, ok := processCPUTime()
+	if !ok {
+		s.logger.Warn("Process CPU time unavailable, CPU load shedding disabled")
+		return
+	}
+	lastSample := time.Now()
+
+	ticker := time.NewTicker(cpuSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			current, ok := processCPUTime()
+			if !ok {
+				continue
+			}
+			utilization := cpuUtilization(current-last, now.Sub(lastSample))
+			last, lastSample = current, now
+
+			s.cpu.Store(math.Float64bits(utilization))
+			s.metrics.cpu.Set(utilization)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// grant is what a queued request got from Acquire
+type grant struct {
+	priority Priority
+	release  func()
+	err      error
+}
+
+// acquireAsync queues a request of the priority and waits until it is in
+// the queue, so requests queue in the order they are made
+func acquireAsync(t *testing.T, s *Shedder, ctx context.Context, priority Priority, grants chan<- grant) {
+	t.Helper()
+
+	queued := s.queued()
+	go func() {
+		release, err := s.Acquire(ctx, priority)
+		grants <- grant{priority: priority, release: release, err: err}
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for s.queued() == queued {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s priority request never queued", priority)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// queued returns the number of requests waiting for a slot
+func (s *Shedder) queued() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	n := 0
+	for _, queue := range s.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+func TestAcquireLimitsByPriority(t *testing.T) {
+	// Low priority work gets 5 of 10 slots, normal work 8 and critical work all
+	tests := []struct {
+		inFlight int
+		priority Priority
+		admitted bool
+	}{
+		{4, PriorityLow, true},
+		{5, PriorityLow, false},
+		{7, PriorityNormal, true},
+		{8, PriorityNormal, false},
+		{9, PriorityCritical, true},
+		{10, PriorityCritical, false},
+	}
+
+	for _, tt := range tests {
+		s := NewShedder(Policy{MaxInFlight: 10, RetryAfter: time.Second}, zap.NewNop())
+		for i := 0; i < tt.inFlight; i++ {
+			if _, err := s.Acquire(context.Background(), PriorityCritical); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, err := s.Acquire(context.Background(), tt.priority)
+		if tt.admitted {
+			if err != nil {
+				t.Errorf("%s priority with %d in flight = %v, want admitted", tt.priority, tt.inFlight, err)
+			}
+			continue
+		}
+
+		var shed *ShedError
+		if !errors.As(err, &shed) || shed.Reason != ReasonInFlight || shed.RetryAfter != time.Second {
+			t.Errorf("%s priority with %d in flight = %v, want shed for in_flight", tt.priority, tt.inFlight, err)
+		}
+	}
+}
+
+func TestReleaseGrantsHighestPriorityFirst(t *testing.T) {
+	s := NewShedder(Policy{MaxInFlight: 1, MaxQueueTime: 2 * time.Second}, zap.NewNop())
+	release, err := s.Acquire(context.Background(), PriorityCritical)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grants := make(chan grant, 3)
+	for _, priority := range []Priority{PriorityLow, PriorityNormal, PriorityCritical} {
+		acquireAsync(t, s, context.Background(), priority, grants)
+	}
+
+	for _, want := range []Priority{PriorityCritical, PriorityNormal, PriorityLow} {
+		release()
+		got := <-grants
+		if got.err != nil || got.priority != want {
+			t.Fatalf("Granted %s (%v), want %s", got.priority, got.err, want)
+		}
+		if n := s.InFlight(); n != 1 {
+			t.Fatalf("InFlight = %d after a grant, want 1", n)
+		}
+		release = got.release
+	}
+
+	release()
+	if n := s.InFlight(); n != 0 {
+		t.Errorf("InFlight = %d after every release, want 0", n)
+	}
+}
+
+func TestQueuedRequestShedAfterQueueTime(t *testing.T) {
+	s := NewShedder(Policy{MaxInFlight: 1, MaxQueueTime: 80 * time.Millisecond}, zap.NewNop())
+	if _, err := s.Acquire(context.Background(), PriorityCritical); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		priority Priority
+		wait     time.Duration // The queue time of the priority
+	}{
+		{PriorityLow, 40 * time.Millisecond},
+		{PriorityCritical, 80 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		start := time.Now()
+		_, err := s.Acquire(context.Background(), tt.priority)
+		waited := time.Since(start)
+
+		var shed *ShedError
+		if !errors.As(err, &shed) || shed.Reason != ReasonQueueTime {
+			t.Errorf("%s priority = %v, want shed for queue_time", tt.priority, err)
+		}
+		if waited < tt.wait || waited > tt.wait+time.Second {
+			t.Errorf("%s priority waited %v, want about %v", tt.priority, waited, tt.wait)
+		}
+	}
+	if n := s.queued(); n != 0 {
+		t.Errorf("%d requests still queued after being shed", n)
+	}
+}
+
+func TestQueuedRequestEndsWithItsContext(t *testing.T) {
+	s := NewShedder(Policy{MaxInFlight: 1, MaxQueueTime: time.Second}, zap.NewNop())
+	release, err := s.Acquire(context.Background(), PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	grants := make(chan grant, 1)
+	acquireAsync(t, s, ctx, PriorityNormal, grants)
+	cancel()
+
+	if got := <-grants; !errors.Is(got.err, context.Canceled) {
+		t.Fatalf("Acquire = %v, want context.Canceled", got.err)
+	}
+	if n := s.queued(); n != 0 {
+		t.Fatalf("%d requests still queued after giving up", n)
+	}
+
+	// The slot freed later is not handed to the request that left
+	release()
+	if n := s.InFlight(); n != 0 {
+		t.Errorf("InFlight = %d, want 0", n)
+	}
+}
+
+func TestCanceledWaiterGrantedASlotKeepsIt(t *testing.T) {
+	// The grant and the cancellation race; either way no slot may leak
+	for i := 0; i < 50; i++ {
+		s := NewShedder(Policy{MaxInFlight: 1, MaxQueueTime: time.Second}, zap.NewNop())
+		release, err := s.Acquire(context.Background(), PriorityNormal)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		grants := make(chan grant, 1)
+		acquireAsync(t, s, ctx, PriorityNormal, grants)
+
+		go cancel()
+		release()
+
+		got := <-grants
+		switch {
+		case got.err == nil:
+			if n := s.InFlight(); n != 1 {
+				t.Fatalf("InFlight = %d while the granted request holds its slot, want 1", n)
+			}
+			got.release()
+		case !errors.Is(got.err, context.Canceled):
+			t.Fatalf("Acquire = %v, want a slot or context.Canceled", got.err)
+		}
+
+		if n := s.InFlight(); n != 0 {
+			t.Fatalf("InFlight = %d after the race, want 0", n)
+		}
+	}
+}
+
+func TestSetPolicyGrantsQueuedRequests(t *testing.T) {
+	s := NewShedder(Policy{MaxInFlight: 1, MaxQueueTime: time.Second}, zap.NewNop())
+	if _, err := s.Acquire(context.Background(), PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+
+	grants := make(chan grant, 1)
+	acquireAsync(t, s, context.Background(), PriorityNormal, grants)
+	s.SetPolicy(Policy{MaxInFlight: 2, MaxQueueTime: time.Second})
+
+	select {
+	case got := <-grants:
+		if got.err != nil {
+			t.Errorf("Acquire = %v, want a slot", got.err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Error("Queued request still waiting after the limit was raised")
+	}
+}
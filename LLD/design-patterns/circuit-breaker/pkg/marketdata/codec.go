@@ -0,0 +1,40 @@
+package marketdata
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc"
+)
+
+// codecName is the content subtype the price API is sent with
+const codecName = "json"
+
+// jsonCodec encodes messages as JSON, so the API can be declared with plain
+// Go structs instead of generated protobuf code. It is passed explicitly to
+// servers and calls rather than registered globally.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return codecName
+}
+
+// ServerCodec returns the server option decoding and encoding the price API.
+// It applies to every service of the server, so serve the price API on a
+// server of its own.
+func ServerCodec() grpc.ServerOption {
+	return grpc.ForceServerCodec(jsonCodec{})
+}
+
+// callCodec is prepended to the options of every PriceClient call, so the
+// client works on any connection
+func callCodec(opts []grpc.CallOption) []grpc.CallOption {
+	return append([]grpc.CallOption{grpc.ForceCodec(jsonCodec{})}, opts...)
+}
@@ -0,0 +1,158 @@
+// Package marketdata declares the gRPC price API of market-data-service.
+// Messages are plain Go structs sent as JSON, so no generated protobuf code
+// is needed; servers take the codec with ServerCodec.
+package marketdata
+
+import (
+	"context"
+
+	"circuit-breaker-demo/pkg/models"
+
+	"google.golang.org/grpc"
+)
+
+// PriceServiceName is the full gRPC service name
+const PriceServiceName = "marketdata.v1.PriceService"
+
+// Full method names, as seen by interceptors
+const (
+	GetPriceMethod     = "/" + PriceServiceName + "/GetPrice"
+	StreamPricesMethod = "/" + PriceServiceName + "/StreamPrices"
+)
+
+// PriceRequest asks for the market data of one symbol
+type PriceRequest struct {
+	Symbol string `json:"symbol"`
+}
+
+// PricesRequest asks for the market data of several symbols
+type PricesRequest struct {
+	Symbols []string `json:"symbols"`
+}
+
+// PriceServer is implemented by the market data service
+type PriceServer interface {
+	// GetPrice returns the market data of one symbol: INVALID_ARGUMENT
+	// without a symbol, NOT_FOUND for an unknown one
+	GetPrice(ctx context.Context, req *PriceRequest) (*models.MarketData, error)
+
+	// StreamPrices sends the market data of each symbol in order. An unknown
+	// symbol ends the stream with NOT_FOUND.
+	StreamPrices(req *PricesRequest, stream PriceStreamServer) error
+}
+
+// PriceStreamServer is the server side of StreamPrices
+type PriceStreamServer interface {
+	Send(data *models.MarketData) error
+	grpc.ServerStream
+}
+
+type priceStreamServer struct {
+	grpc.ServerStream
+}
+
+func (s *priceStreamServer) Send(data *models.MarketData) error {
+	return s.ServerStream.SendMsg(data)
+}
+
+// priceServiceDesc describes the service to grpc.Server
+var priceServiceDesc = grpc.ServiceDesc{
+	ServiceName: PriceServiceName,
+	HandlerType: (*PriceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetPrice",
+			Handler:    getPriceHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamPrices",
+			Handler:       streamPricesHandler,
+			ServerStreams: true,
+		},
+	},
+}
+
+// RegisterPriceServer registers srv with a gRPC server
+func RegisterPriceServer(s grpc.ServiceRegistrar, srv PriceServer) {
+	s.RegisterService(&priceServiceDesc, srv)
+}
+
+func getPriceHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(PriceRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PriceServer).GetPrice(ctx, req)
+	}
+
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: GetPriceMethod}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PriceServer).GetPrice(ctx, req.(*PriceRequest))
+	}
+	return interceptor(ctx, req, info, handler)
+}
+
+func streamPricesHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := new(PricesRequest)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(PriceServer).StreamPrices(req, &priceStreamServer{stream})
+}
+
+// PriceClient calls the price API
+type PriceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewPriceClient creates a client on a connection, typically one dialed
+// with the grpcclient breaker interceptors
+func NewPriceClient(cc grpc.ClientConnInterface) *PriceClient {
+	return &PriceClient{cc: cc}
+}
+
+// GetPrice returns the market data of one symbol
+func (c *PriceClient) GetPrice(ctx context.Context, symbol string, opts ...grpc.CallOption) (*models.MarketData, error) {
+	opts = callCodec(opts)
+
+	data := new(models.MarketData)
+	if err := c.cc.Invoke(ctx, GetPriceMethod, &PriceRequest{Symbol: symbol}, data, opts...); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// StreamPrices opens a stream of market data for the symbols. Read it with
+// Recv until io.EOF, or cancel ctx to stop early.
+func (c *PriceClient) StreamPrices(ctx context.Context, symbols []string, opts ...grpc.CallOption) (*PriceStream, error) {
+	opts = callCodec(opts)
+
+	stream, err := c.cc.NewStream(ctx, &priceServiceDesc.Streams[0], StreamPricesMethod, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(&PricesRequest{Symbols: symbols}); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &PriceStream{stream: stream}, nil
+}
+
+// PriceStream is the client side of StreamPrices
+type PriceStream struct {
+	stream grpc.ClientStream
+}
+
+// Recv returns the next market data, or io.EOF when the stream is done
+func (s *PriceStream) Recv() (*models.MarketData, error) {
+	data := new(models.MarketData)
+	if err := s.stream.RecvMsg(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -124,11 +124,25 @@ type AuditEvent struct {
 
 // HealthResponse represents a health check response
 type HealthResponse struct {
-	Status    string            `json:"status"`
-	Service   string            `json:"service"`
-	Version   string            `json:"version"`
-	Timestamp time.Time         `json:"timestamp"`
-	Checks    map[string]string `json:"checks,omitempty"`
+	Status        string            `json:"status"`
+	Service       string            `json:"service"`
+	Version       string            `json:"version"`
+	ConfigVersion uint64            `json:"configVersion,omitempty"`
+	ConfigHash    string            `json:"configHash,omitempty"`
+	Timestamp     time.Time         `json:"timestamp"`
+	Checks        map[string]string `json:"checks,omitempty"`
+
+	Upstreams map[string][]EndpointHealth `json:"upstreams,omitempty"`
+}
+
+// EndpointHealth represents the health of one upstream endpoint as seen by the gateway
+type EndpointHealth struct {
+	URL          string     `json:"url"`
+	Healthy      bool       `json:"healthy"`
+	Ejected      bool       `json:"ejected"`
+	EjectedUntil *time.Time `json:"ejectedUntil,omitempty"`
+	CircuitState string     `json:"circuitState"`
+	Outstanding  int64      `json:"outstanding"`
 }
 
 // CircuitBreakerStatus represents circuit breaker status
@@ -144,6 +158,18 @@ type CircuitBreakerStatus struct {
 	Configuration    map[string]interface{} `json:"configuration"`
 }
 
+// CircuitBreakerConfigPatch represents a partial circuit breaker configuration
+// update; fields left out of the request keep their current value
+type CircuitBreakerConfigPatch struct {
+	MaxRequests          *uint32  `json:"maxRequests,omitempty"`
+	Interval             *string  `json:"interval,omitempty"` // Go duration, e.g. "60s"
+	Timeout              *string  `json:"timeout,omitempty"`  // Go duration, e.g. "30s"
+	FailureThreshold     *uint32  `json:"failureThreshold,omitempty"`
+	SuccessThreshold     *uint32  `json:"successThreshold,omitempty"`
+	FailureRateThreshold *float64 `json:"failureRateThreshold,omitempty"`
+	MinimumRequests      *uint32  `json:"minimumRequests,omitempty"`
+}
+
 // ErrorResponse represents an error response
 type ErrorResponse struct {
 	Error     string                 `json:"error"`
@@ -158,6 +184,9 @@ type BatchMarketDataRequest struct {
 	Symbols []string `json:"symbols" binding:"required"`
 }
 
+// MaxBatchSymbols limits the number of symbols in one quote request
+const MaxBatchSymbols = 50
+
 // BatchMarketDataResponse represents a batch market data response
 type BatchMarketDataResponse struct {
 	Data      map[string]MarketData `json:"data"`
@@ -0,0 +1,80 @@
+// Package ratelimit limits how often a client may call a route. Limits are
+// enforced by a Store, which keeps the state of every client: MemoryStore
+// for a single instance, or a shared implementation so several gateway
+// instances enforce one limit together.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Algorithms
+const (
+	// TokenBucket allows Burst requests at once and refills Rate tokens per
+	// Period, so clients may save up for short bursts
+	TokenBucket = "token_bucket"
+
+	// SlidingWindow allows at most Rate requests in any Period, keeping the
+	// time of every request in the window
+	SlidingWindow = "sliding_window"
+)
+
+// Algorithms lists the supported algorithms
+var Algorithms = []string{TokenBucket, SlidingWindow}
+
+// Limit is the rate allowed to one client
+type Limit struct {
+	Algorithm string
+	Rate      int           // Requests per Period
+	Period    time.Duration // e.g. time.Second or time.Minute
+	Burst     int           // Token bucket capacity; 0 means Rate
+}
+
+// capacity returns the most requests a client may make at once
+func (l Limit) capacity() int {
+	if l.Algorithm == TokenBucket && l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// interval returns the time one token takes to refill
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Rate)
+}
+
+// String describes the limit in the format of the RateLimit-Policy header,
+// e.g. "10;w=1;burst=20"
+func (l Limit) String() string {
+	window := int(math.Ceil(l.Period.Seconds()))
+	if l.Algorithm == TokenBucket && l.Burst > 0 && l.Burst != l.Rate {
+		return fmt.Sprintf("%d;w=%d;burst=%d", l.Rate, window, l.Burst)
+	}
+	return fmt.Sprintf("%d;w=%d", l.Rate, window)
+}
+
+// Result is the outcome of one request against a limit
+type Result struct {
+	Allowed    bool
+	Limit      int           // Requests a client may make at once
+	Remaining  int           // Requests left right now
+	Reset      time.Duration // Until the full limit is available again
+	RetryAfter time.Duration // Until the next request is allowed; 0 when allowed
+}
+
+// Store keeps the state of every client and decides each request. A shared
+// implementation must make Take atomic across instances, e.g. with a Redis
+// script.
+type Store interface {
+	// Take counts one request by key against limit at now
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// RouteKey returns the key of a rule for a method and path pattern
+func RouteKey(method, path string) string {
+	return strings.ToUpper(method) + " " + path
+}
@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often MemoryStore drops the state of idle clients
+const sweepInterval = time.Minute
+
+// MemoryStore keeps limits in process memory. Each gateway instance then
+// enforces its own limits.
+type MemoryStore struct {
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+// bucket is the token bucket of one client
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	expires time.Time // The bucket is full again from here on
+}
+
+// window is the sliding window log of one client, oldest request first
+type window struct {
+	requests []time.Time
+	expires  time.Time // Every request has left the window from here on
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		windows: make(map[string]*window),
+	}
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweepLocked(now)
+	}
+
+	switch limit.Algorithm {
+	case TokenBucket:
+		return s.takeToken(key, limit, now), nil
+	case SlidingWindow:
+		return s.takeWindow(key, limit, now), nil
+	}
+	return Result{}, fmt.Errorf("unknown rate limit algorithm %q", limit.Algorithm)
+}
+
+// takeToken refills the client's bucket for the time passed and takes a token
+func (s *MemoryStore) takeToken(key string, limit Limit, now time.Time) Result {
+	capacity := float64(limit.capacity())
+	perToken := limit.interval()
+
+	b, exists := s.buckets[key]
+	if !exists {
+		b = &bucket{tokens: capacity, updated: now}
+		s.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+float64(elapsed)/float64(perToken))
+		b.updated = now
+	}
+
+	result := Result{Limit: limit.capacity()}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) * float64(perToken))
+	}
+
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((capacity - b.tokens) * float64(perToken))
+	b.expires = now.Add(result.Reset)
+	return result
+}
+
+// takeWindow drops requests that left the client's window and records this
+// one if the window has room
+func (s *MemoryStore) takeWindow(key string, limit Limit, now time.Time) Result {
+	w, exists := s.windows[key]
+	if !exists {
+		w = &window{}
+		s.windows[key] = w
+	}
+
+	start := now.Add(-limit.Period)
+	kept := 0
+	for kept < len(w.requests) && !w.requests[kept].After(start) {
+		kept++
+	}
+	w.requests = w.requests[kept:]
+
+	result := Result{Limit: limit.Rate}
+	if len(w.requests) < limit.Rate {
+		w.requests = append(w.requests, now)
+		result.Allowed = true
+	} else {
+		result.RetryAfter = w.requests[0].Add(limit.Period).Sub(now)
+	}
+
+	result.Remaining = limit.Rate - len(w.requests)
+	if len(w.requests) > 0 {
+		w.expires = w.requests[len(w.requests)-1].Add(limit.Period)
+		result.Reset = w.expires.Sub(now)
+	}
+	return result
+}
+
+// sweepLocked drops clients whose state is back to a fresh one
+func (s *MemoryStore) sweepLocked(now time.Time) {
+	for key, b := range s.buckets {
+		if !now.Before(b.expires) {
+			delete(s.buckets, key)
+		}
+	}
+	for key, w := range s.windows {
+		if !now.Before(w.expires) {
+			delete(s.windows, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+// Len returns the number of clients with state in the store
+func (s *MemoryStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.buckets) + len(s.windows)
+}
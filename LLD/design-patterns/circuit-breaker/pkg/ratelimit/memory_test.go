@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// take counts a request by key at now and fails the test on error
+func take(t *testing.T, store *MemoryStore, key string, limit Limit, now time.Time) Result {
+	t.Helper()
+
+	result, err := store.Take(context.Background(), key, limit, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestTokenBucketBurstAndRefill(t *testing.T) {
+	limit := Limit{Algorithm: TokenBucket, Rate: 5, Period: time.Second, Burst: 10}
+	store := NewMemoryStore()
+	start := time.Unix(1700000000, 0)
+
+	for i := 0; i < 10; i++ {
+		if result := take(t, store, "client", limit, start); !result.Allowed || result.Remaining != 9-i {
+			t.Fatalf("Burst request %d = %+v, want allowed with %d remaining", i+1, result, 9-i)
+		}
+	}
+	result := take(t, store, "client", limit, start)
+	if result.Allowed || result.RetryAfter != 200*time.Millisecond {
+		t.Fatalf("Request over the burst = %+v, want denied for 200ms", result)
+	}
+	if result.Reset != 2*time.Second {
+		t.Errorf("Reset = %v, want the 2s the bucket takes to refill", result.Reset)
+	}
+
+	if result := take(t, store, "client", limit, start.Add(199*time.Millisecond)); result.Allowed {
+		t.Errorf("Request before a token refilled = %+v, want denied", result)
+	}
+	if result := take(t, store, "client", limit, start.Add(200*time.Millisecond)); !result.Allowed {
+		t.Errorf("Request once a token refilled = %+v, want allowed", result)
+	}
+
+	// A second refills Rate tokens, never more than the burst
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if take(t, store, "client", limit, start.Add(1200*time.Millisecond)).Allowed {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("Allowed %d requests after 1s, want 5", allowed)
+	}
+	if result := take(t, store, "other", limit, start); !result.Allowed || result.Remaining != 9 {
+		t.Errorf("Another client = %+v, want its own full bucket", result)
+	}
+}
+
+func TestTokenBucketWithoutBurst(t *testing.T) {
+	limit := Limit{Algorithm: TokenBucket, Rate: 2, Period: time.Second}
+	store := NewMemoryStore()
+	start := time.Unix(1700000000, 0)
+
+	take(t, store, "client", limit, start)
+	take(t, store, "client", limit, start)
+	if result := take(t, store, "client", limit, start); result.Allowed || result.Limit != 2 {
+		t.Errorf("Third request = %+v, want denied with a capacity of Rate", result)
+	}
+}
+
+func TestSlidingWindowEviction(t *testing.T) {
+	limit := Limit{Algorithm: SlidingWindow, Rate: 3, Period: time.Minute}
+	store := NewMemoryStore()
+	start := time.Unix(1700000000, 0)
+
+	for i := 0; i < 3; i++ {
+		if result := take(t, store, "client", limit, start.Add(time.Duration(i)*10*time.Second)); !result.Allowed {
+			t.Fatalf("Request %d = %+v, want allowed", i+1, result)
+		}
+	}
+
+	result := take(t, store, "client", limit, start.Add(30*time.Second))
+	if result.Allowed || result.RetryAfter != 30*time.Second {
+		t.Fatalf("Fourth request = %+v, want denied until the first leaves the window in 30s", result)
+	}
+	if result.Reset != 50*time.Second {
+		t.Errorf("Reset = %v, want 50s until the last request leaves the window", result.Reset)
+	}
+
+	if result := take(t, store, "client", limit, start.Add(59*time.Second)); result.Allowed {
+		t.Errorf("Request before the first left the window = %+v, want denied", result)
+	}
+	result = take(t, store, "client", limit, start.Add(time.Minute))
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("Request once the first left the window = %+v, want allowed with none remaining", result)
+	}
+	if result := take(t, store, "client", limit, start.Add(time.Minute)); result.Allowed {
+		t.Errorf("Request while the window is full again = %+v, want denied", result)
+	}
+}
+
+func TestMemoryStoreSweepsIdleClients(t *testing.T) {
+	bucket := Limit{Algorithm: TokenBucket, Rate: 1, Period: time.Second}
+	window := Limit{Algorithm: SlidingWindow, Rate: 1, Period: time.Second}
+	store := NewMemoryStore()
+	start := time.Unix(1700000000, 0)
+
+	take(t, store, "bucket", bucket, start)
+	take(t, store, "window", window, start)
+	if store.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", store.Len())
+	}
+
+	take(t, store, "bucket", bucket, start.Add(sweepInterval))
+	if store.Len() != 1 {
+		t.Errorf("Len() after a sweep = %d, want only the client that just made a request", store.Len())
+	}
+}
+
+func TestMemoryStoreRejectsUnknownAlgorithm(t *testing.T) {
+	_, err := NewMemoryStore().Take(context.Background(), "client", Limit{Algorithm: "leaky_bucket", Rate: 1, Period: time.Second}, time.Now())
+	if err == nil {
+		t.Error("Take() with an unknown algorithm succeeded, want an error")
+	}
+}
+
+func TestLimitString(t *testing.T) {
+	tests := []struct {
+		limit Limit
+		want  string
+	}{
+		{Limit{Algorithm: TokenBucket, Rate: 5, Period: time.Second, Burst: 10}, "5;w=1;burst=10"},
+		{Limit{Algorithm: TokenBucket, Rate: 5, Period: time.Second, Burst: 5}, "5;w=1"},
+		{Limit{Algorithm: SlidingWindow, Rate: 60, Period: time.Minute}, "60;w=60"},
+		{Limit{Algorithm: SlidingWindow, Rate: 1, Period: 1500 * time.Millisecond}, "1;w=2"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.limit.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"circuit-breaker-demo/pkg/auth"
+	"circuit-breaker-demo/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Key sources, tried in the order a rule lists them
+const (
+	KeyAPIKey = "api_key" // The ID of a verified API key
+	KeyUser   = "user"    // The authenticated user
+	KeyIP     = "ip"      // The client IP
+)
+
+// KeySources lists the supported key sources
+var KeySources = []string{KeyAPIKey, KeyUser, KeyIP}
+
+// Rule limits one route. The limiter must run after auth.Authenticator for
+// the api_key and user sources to apply; without a principal, as when
+// authentication is disabled, clients are told apart by IP.
+type Rule struct {
+	Limit
+	Keys []string // Key sources tried in order; the first one verified identifies the client
+}
+
+// Policy holds the rules by RouteKey. Routes without a rule are not limited.
+type Policy struct {
+	Rules map[string]Rule
+}
+
+// Limiter is gin middleware that enforces the policy through a store
+type Limiter struct {
+	store   Store
+	logger  *zap.Logger
+	metrics *limitMetrics
+
+	mutex  sync.RWMutex
+	policy Policy
+}
+
+// limitMetrics holds Prometheus metrics for rate limiting
+type limitMetrics struct {
+	requestsTotal *prometheus.CounterVec
+}
+
+var (
+	limitMetricsOnce   sync.Once
+	globalLimitMetrics *limitMetrics
+)
+
+func getLimitMetrics() *limitMetrics {
+	limitMetricsOnce.Do(func() {
+		globalLimitMetrics = &limitMetrics{
+			requestsTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "rate_limit_requests_total",
+					Help: "Total number of rate limited requests by route, key source and result",
+				},
+				[]string{"route", "key", "result"},
+			),
+		}
+	})
+	return globalLimitMetrics
+}
+
+// NewLimiter creates a limiter. Store errors are logged and the request is
+// let through, so an unreachable shared store does not take the API down.
+func NewLimiter(store Store, policy Policy, logger *zap.Logger) *Limiter {
+	return &Limiter{
+		store:   store,
+		logger:  logger,
+		metrics: getLimitMetrics(),
+		policy:  policy,
+	}
+}
+
+// SetPolicy changes the rules for requests from now on
+func (l *Limiter) SetPolicy(policy Policy) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.policy = policy
+}
+
+// rule returns the rule of a route
+func (l *Limiter) rule(route string) (Rule, bool) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	rule, exists := l.policy.Rules[route]
+	return rule, exists
+}
+
+// Middleware counts each request to a limited route against its client and
+// answers 429 with Retry-After once the client is over the limit. Every
+// response of a limited route carries the RateLimit-* headers.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := RouteKey(c.Request.Method, c.FullPath())
+		rule, exists := l.rule(route)
+		if !exists {
+			c.Next()
+			return
+		}
+
+		source, client := clientKey(c, rule.Keys)
+		result, err := l.store.Take(c.Request.Context(), route+"|"+source+":"+client, rule.Limit, time.Now())
+		if err != nil {
+			l.logger.Error("Failed to check rate limit, allowing request",
+				zap.String("route", route),
+				zap.Error(err),
+			)
+			l.metrics.requestsTotal.WithLabelValues(route, source, "error").Inc()
+			c.Next()
+			return
+		}
+
+		setHeaders(c, rule.Limit, result)
+		if !result.Allowed {
+			l.metrics.requestsTotal.WithLabelValues(route, source, "limited").Inc()
+			c.Header("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, models.ErrorResponse{
+				Error:     "Rate limit exceeded",
+				Message:   "Too many requests, retry in " + strconv.Itoa(seconds(result.RetryAfter)) + "s",
+				Code:      "RATE_LIMITED",
+				Timestamp: time.Now(),
+			})
+			return
+		}
+
+		l.metrics.requestsTotal.WithLabelValues(route, source, "allowed").Inc()
+		c.Next()
+	}
+}
+
+// setHeaders sets the RateLimit-* headers of the IETF httpapi draft
+func setHeaders(c *gin.Context, limit Limit, result Result) {
+	c.Header("RateLimit-Policy", limit.String())
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+}
+
+// seconds rounds d up to whole seconds, as the headers require
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// clientKey returns the first key source with a verified value for the
+// request and that value, falling back to the client IP. Only credentials
+// checked by auth.Authenticator count: an unverified header, path or body
+// would let callers pick their key, dodging their own limit or draining
+// another user's.
+func clientKey(c *gin.Context, sources []string) (string, string) {
+	principal, authenticated := auth.PrincipalFromContext(c.Request.Context())
+	for _, source := range sources {
+		switch source {
+		case KeyAPIKey:
+			if authenticated && principal.Method == auth.MethodAPIKey {
+				return source, principal.KeyID
+			}
+		case KeyUser:
+			if authenticated {
+				return source, principal.Subject
+			}
+		case KeyIP:
+			return source, c.ClientIP()
+		}
+	}
+	return KeyIP, c.ClientIP()
+}
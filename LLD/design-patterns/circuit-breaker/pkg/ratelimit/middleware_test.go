@@ -0,0 +1,196 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"circuit-breaker-demo/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+var testSecret = []byte("rate-limit-test-secret-0123456789abcdef")
+
+// newTestRouter returns a router that authenticates API keys for user123
+// and user456 and limits each route to one request a minute
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	authenticator, err := auth.NewAuthenticator(auth.Policy{
+		Enabled:      true,
+		APIKeySecret: testSecret,
+		APIKeys: map[string]auth.APIKey{
+			"user123-key":    {ID: "user123-key", Subject: "user123"},
+			"user123-mobile": {ID: "user123-mobile", Subject: "user123"},
+			"user456-key":    {ID: "user456-key", Subject: "user456"},
+		},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limit := Limit{Algorithm: TokenBucket, Rate: 1, Period: time.Minute}
+	limiter := NewLimiter(NewMemoryStore(), Policy{Rules: map[string]Rule{
+		RouteKey(http.MethodPost, "/trades"):           {Limit: limit, Keys: []string{KeyAPIKey, KeyUser, KeyIP}},
+		RouteKey(http.MethodGet, "/portfolio/:userId"): {Limit: limit, Keys: []string{KeyUser, KeyIP}},
+	}}, zap.NewNop())
+
+	router := gin.New()
+	router.Use(authenticator.Authenticate(), limiter.Middleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.POST("/trades", ok)
+	router.GET("/portfolio/:userId", ok)
+	router.GET("/health", ok)
+	return router
+}
+
+// request is one request to the test router
+type request struct {
+	method string
+	path   string
+	ip     string
+	keyID  string // Sends a valid API key for the ID
+	header string // Sends X-API-Key as is, without a key ID
+	body   string
+}
+
+func (r request) send(router *gin.Engine) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(r.method, r.path, strings.NewReader(r.body))
+	req.RemoteAddr = r.ip + ":40000"
+	req.Header.Set("Content-Type", "application/json")
+	switch {
+	case r.keyID != "":
+		req.Header.Set(auth.APIKeyHeader, auth.IssueAPIKey(testSecret, r.keyID))
+	case r.header != "":
+		req.Header.Set(auth.APIKeyHeader, r.header)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestMiddlewareHeaders(t *testing.T) {
+	router := newTestRouter(t)
+	trade := request{method: http.MethodPost, path: "/trades", ip: "10.0.0.1", keyID: "user123-key"}
+
+	allowed := trade.send(router)
+	if allowed.Code != http.StatusOK {
+		t.Fatalf("First request = %d, want 200", allowed.Code)
+	}
+	for header, want := range map[string]string{
+		"RateLimit-Policy":    "1;w=60",
+		"RateLimit-Limit":     "1",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "60",
+	} {
+		if got := allowed.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	if allowed.Header().Get("Retry-After") != "" {
+		t.Error("Allowed request has Retry-After")
+	}
+
+	limited := trade.send(router)
+	if limited.Code != http.StatusTooManyRequests {
+		t.Fatalf("Second request = %d, want 429", limited.Code)
+	}
+	if got := limited.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want \"60\"", got)
+	}
+	if !strings.Contains(limited.Body.String(), "RATE_LIMITED") {
+		t.Errorf("Body = %s, want code RATE_LIMITED", limited.Body.String())
+	}
+
+	if health := (request{method: http.MethodGet, path: "/health", ip: "10.0.0.1"}).send(router); health.Header().Get("RateLimit-Limit") != "" {
+		t.Error("Route without a rule has RateLimit headers")
+	}
+}
+
+func TestMiddlewareKeysOnVerifiedIdentity(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  request
+		second request
+		want   int // Status of the second request
+	}{
+		{
+			name:   "API keys are counted by key ID",
+			first:  request{method: http.MethodPost, path: "/trades", ip: "10.0.0.1", keyID: "user123-key"},
+			second: request{method: http.MethodPost, path: "/trades", ip: "10.0.0.2", keyID: "user123-key"},
+			want:   http.StatusTooManyRequests,
+		},
+		{
+			name:   "each key of a user has its own limit",
+			first:  request{method: http.MethodPost, path: "/trades", ip: "10.0.0.1", keyID: "user123-key"},
+			second: request{method: http.MethodPost, path: "/trades", ip: "10.0.0.1", keyID: "user123-mobile"},
+			want:   http.StatusOK,
+		},
+		{
+			name:   "authenticated users are counted by subject",
+			first:  request{method: http.MethodGet, path: "/portfolio/user123", ip: "10.0.0.1", keyID: "user123-key"},
+			second: request{method: http.MethodGet, path: "/portfolio/user123", ip: "10.0.0.2", keyID: "user123-mobile"},
+			want:   http.StatusTooManyRequests,
+		},
+		{
+			name:   "anonymous callers cannot spend a user's limit through the path",
+			first:  request{method: http.MethodGet, path: "/portfolio/user123", ip: "10.0.0.9"},
+			second: request{method: http.MethodGet, path: "/portfolio/user123", ip: "10.0.0.1", keyID: "user123-key"},
+			want:   http.StatusOK,
+		},
+		{
+			name:   "anonymous callers cannot spend a user's limit through the body",
+			first:  request{method: http.MethodPost, path: "/trades", ip: "10.0.0.9", body: `{"userId":"user123"}`},
+			second: request{method: http.MethodPost, path: "/trades", ip: "10.0.0.1", keyID: "user123-key"},
+			want:   http.StatusOK,
+		},
+		{
+			name:   "anonymous callers are counted by IP whatever user they name",
+			first:  request{method: http.MethodGet, path: "/portfolio/user123", ip: "10.0.0.9"},
+			second: request{method: http.MethodGet, path: "/portfolio/user456", ip: "10.0.0.9"},
+			want:   http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t)
+
+			if first := tt.first.send(router); first.Code != http.StatusOK {
+				t.Fatalf("First request = %d, want 200", first.Code)
+			}
+			if second := tt.second.send(router); second.Code != tt.want {
+				t.Errorf("Second request = %d, want %d", second.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareIgnoresUnverifiedAPIKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Without authentication any X-API-Key is unverified, so a new header
+	// per request must not earn a new limit
+	limiter := NewLimiter(NewMemoryStore(), Policy{Rules: map[string]Rule{
+		RouteKey(http.MethodPost, "/trades"): {
+			Limit: Limit{Algorithm: SlidingWindow, Rate: 1, Period: time.Minute},
+			Keys:  []string{KeyAPIKey, KeyUser, KeyIP},
+		},
+	}}, zap.NewNop())
+	router := gin.New()
+	router.Use(limiter.Middleware())
+	router.POST("/trades", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	if first := (request{method: http.MethodPost, path: "/trades", ip: "10.0.0.1", header: "made-up-1"}).send(router); first.Code != http.StatusOK {
+		t.Fatalf("First request = %d, want 200", first.Code)
+	}
+	if second := (request{method: http.MethodPost, path: "/trades", ip: "10.0.0.1", header: "made-up-2"}).send(router); second.Code != http.StatusTooManyRequests {
+		t.Errorf("Request with another made-up key = %d, want 429", second.Code)
+	}
+}
@@ -0,0 +1,424 @@
+// Package sqlclient protects database/sql connections with a circuit
+// breaker by wrapping the driver underneath *sql.DB.
+package sqlclient
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+)
+
+// Classifier decides whether the error of a database call counts as a
+// failure for the circuit breaker
+type Classifier func(err error) bool
+
+// DefaultClassifier treats connection errors and timeouts as failures:
+// driver.ErrBadConn, network errors, a connection cut off mid-response and
+// deadlines that passed, as well as errors whose SQLSTATE (reported by a
+// SQLState() string method, as pgx and lib/pq errors do) is in class 08
+// (connection exception), 53 (insufficient resources), 57 (operator
+// intervention, e.g. a statement timeout) or 58 (system error). Everything
+// else, such as constraint violations (class 23) or syntax errors, is the
+// caller's fault and says nothing about the health of the database.
+func DefaultClassifier(err error) bool {
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		state := stateErr.SQLState()
+		if len(state) < 2 {
+			return false
+		}
+		switch state[:2] {
+		case "08", "53", "57", "58":
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.As(err, &netErr)
+}
+
+// guard runs database calls through the breaker
+type guard struct {
+	cb       *circuitbreaker.CircuitBreaker
+	classify Classifier
+}
+
+// run calls fn unless the circuit is open, in which case it returns
+// circuitbreaker.ErrOpenState, and records the outcome
+func (g *guard) run(ctx context.Context, fn func() error) error {
+	done, err := g.cb.Allow()
+	if err != nil {
+		return err
+	}
+
+	err = fn()
+	done(g.outcome(ctx, err))
+	return err
+}
+
+// outcome turns the error of a call into the outcome recorded by the
+// breaker: nil for success or an error the classifier accepts, the error for
+// a failure, and context.Canceled, which is not recorded, when the caller
+// gave up or the driver returned driver.ErrSkip (database/sql then retries
+// through a prepared statement, which is recorded instead)
+func (g *guard) outcome(ctx context.Context, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(ctx.Err(), context.Canceled), errors.Is(err, driver.ErrSkip):
+		return context.Canceled
+	case g.classify(err):
+		return err
+	}
+	return nil
+}
+
+// Driver wraps a database/sql driver so that connecting, queries,
+// statements and transactions go through a circuit breaker. Register it
+// under a new name or open a connector from it:
+//
+//	d := sqlclient.Wrap(&pq.Driver{}, cb)
+//	connector, err := d.OpenConnector(dsn)
+//	db := sql.OpenDB(connector)
+//
+// Connect, Ping, Begin, Exec and Query (directly or on a prepared statement)
+// are rejected with circuitbreaker.ErrOpenState while the circuit is open;
+// Prepare, Commit and Rollback always reach the database, so a transaction
+// already begun can finish. Errors are returned unchanged; the classifier
+// only decides whether they count against the breaker.
+type Driver struct {
+	base  driver.Driver
+	guard *guard
+}
+
+// Wrap guards the connections of base with cb
+func Wrap(base driver.Driver, cb *circuitbreaker.CircuitBreaker) *Driver {
+	return &Driver{
+		base:  base,
+		guard: &guard{cb: cb, classify: DefaultClassifier},
+	}
+}
+
+// SetClassifier replaces the default failure classification. It must be
+// called before the driver is in use, and applies to connectors opened from
+// it as well.
+func (d *Driver) SetClassifier(classify Classifier) {
+	d.guard.classify = classify
+}
+
+// Open implements driver.Driver
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	connector, err := d.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(context.Background())
+}
+
+// OpenConnector implements driver.DriverContext
+func (d *Driver) OpenConnector(name string) (driver.Connector, error) {
+	if driverContext, ok := d.base.(driver.DriverContext); ok {
+		base, err := driverContext.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return &Connector{base: base, driver: d}, nil
+	}
+	return &Connector{base: dsnConnector{name: name, driver: d.base}, driver: d}, nil
+}
+
+// dsnConnector connects through a driver without a connector of its own
+type dsnConnector struct {
+	name   string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.name)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Connector is a driver.Connector whose connections go through a circuit
+// breaker. Pass it to sql.OpenDB.
+type Connector struct {
+	base   driver.Connector
+	driver *Driver
+}
+
+// WrapConnector guards the connections of base with cb, for drivers
+// configured through a connector rather than a DSN
+func WrapConnector(base driver.Connector, cb *circuitbreaker.CircuitBreaker) *Connector {
+	return &Connector{base: base, driver: Wrap(base.Driver(), cb)}
+}
+
+// SetClassifier replaces the default failure classification, see
+// Driver.SetClassifier
+func (c *Connector) SetClassifier(classify Classifier) {
+	c.driver.SetClassifier(classify)
+}
+
+// Connect implements driver.Connector
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	var base driver.Conn
+	err := c.driver.guard.run(ctx, func() (err error) {
+		base, err = c.base.Connect(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &conn{base: base, guard: c.driver.guard}, nil
+}
+
+// Driver implements driver.Connector
+func (c *Connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close closes the wrapped connector if it holds resources; sql.DB.Close
+// calls it
+func (c *Connector) Close() error {
+	if closer, ok := c.base.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// conn is a connection whose calls go through the breaker. It implements
+// the optional driver interfaces and falls back the way database/sql does
+// when the wrapped connection lacks them.
+type conn struct {
+	base  driver.Conn
+	guard *guard
+}
+
+// Prepare implements driver.Conn
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext implements driver.ConnPrepareContext
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var base driver.Stmt
+	var err error
+	if preparer, ok := c.base.(driver.ConnPrepareContext); ok {
+		base, err = preparer.PrepareContext(ctx, query)
+	} else {
+		base, err = c.base.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{base: base, conn: c.base, guard: c.guard}, nil
+}
+
+// Close implements driver.Conn
+func (c *conn) Close() error {
+	return c.base.Close()
+}
+
+// Begin implements driver.Conn
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx implements driver.ConnBeginTx
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	err := c.guard.run(ctx, func() (err error) {
+		if beginner, ok := c.base.(driver.ConnBeginTx); ok {
+			tx, err = beginner.BeginTx(ctx, opts)
+			return err
+		}
+		if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) || opts.ReadOnly {
+			return errors.New("sqlclient: driver does not support transaction options")
+		}
+		tx, err = c.base.Begin()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// ExecContext implements driver.ExecerContext
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.base.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	var result driver.Result
+	err := c.guard.run(ctx, func() (err error) {
+		result, err = execer.ExecContext(ctx, query, args)
+		return err
+	})
+	return result, err
+}
+
+// QueryContext implements driver.QueryerContext
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.base.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	var rows driver.Rows
+	err := c.guard.run(ctx, func() (err error) {
+		rows, err = queryer.QueryContext(ctx, query, args)
+		return err
+	})
+	return rows, err
+}
+
+// Ping implements driver.Pinger
+func (c *conn) Ping(ctx context.Context) error {
+	pinger, ok := c.base.(driver.Pinger)
+	if !ok {
+		return nil
+	}
+	return c.guard.run(ctx, func() error {
+		return pinger.Ping(ctx)
+	})
+}
+
+// ResetSession implements driver.SessionResetter
+func (c *conn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.base.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+// IsValid implements driver.Validator
+func (c *conn) IsValid() bool {
+	if validator, ok := c.base.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// CheckNamedValue implements driver.NamedValueChecker
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.base.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// stmt is a prepared statement whose executions go through the breaker
+type stmt struct {
+	base  driver.Stmt
+	conn  driver.Conn
+	guard *guard
+}
+
+// Close implements driver.Stmt
+func (s *stmt) Close() error {
+	return s.base.Close()
+}
+
+// NumInput implements driver.Stmt
+func (s *stmt) NumInput() int {
+	return s.base.NumInput()
+}
+
+// Exec implements driver.Stmt
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+// Query implements driver.Stmt
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+// ExecContext implements driver.StmtExecContext
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	var result driver.Result
+	err := s.guard.run(ctx, func() (err error) {
+		if execer, ok := s.base.(driver.StmtExecContext); ok {
+			result, err = execer.ExecContext(ctx, args)
+			return err
+		}
+		values, err := plainValues(args)
+		if err != nil {
+			return err
+		}
+		result, err = s.base.Exec(values)
+		return err
+	})
+	return result, err
+}
+
+// QueryContext implements driver.StmtQueryContext
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	var rows driver.Rows
+	err := s.guard.run(ctx, func() (err error) {
+		if queryer, ok := s.base.(driver.StmtQueryContext); ok {
+			rows, err = queryer.QueryContext(ctx, args)
+			return err
+		}
+		values, err := plainValues(args)
+		if err != nil {
+			return err
+		}
+		rows, err = s.base.Query(values)
+		return err
+	})
+	return rows, err
+}
+
+// CheckNamedValue implements driver.NamedValueChecker, consulting the
+// statement and then its connection, as database/sql would for the
+// unwrapped statement. The deprecated driver.ColumnConverter is not
+// consulted; arguments it would convert get the default conversion.
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.base.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	if checker, ok := s.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// namedValues numbers positional arguments
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, value := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: value}
+	}
+	return named
+}
+
+// plainValues strips ordinals for drivers that only take positional
+// arguments, rejecting named ones
+func plainValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("sqlclient: driver does not support named arguments")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
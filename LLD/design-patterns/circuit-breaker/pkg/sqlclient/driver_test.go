@@ -0,0 +1,234 @@
+package sqlclient
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"circuit-breaker-demo/pkg/circuitbreaker"
+	"circuit-breaker-demo/pkg/sqlclient/sqlclienttest"
+
+	"go.uber.org/zap"
+)
+
+const (
+	insertTrade = "INSERT INTO trades (id, symbol) VALUES (?, ?)"
+	selectTrade = "SELECT id, symbol FROM trades WHERE id = ?"
+)
+
+// tradesDB is a trades database on a scripted driver, reached through a
+// breaker that opens on the second statement or connection held against
+// the database
+type tradesDB struct {
+	*sql.DB
+	script  *sqlclienttest.Driver
+	breaker *circuitbreaker.CircuitBreaker
+}
+
+func openTradesDB(t *testing.T) *tradesDB {
+	t.Helper()
+
+	config := circuitbreaker.DefaultConfig("trades-db")
+	config.FailureThreshold = 2
+	breaker := circuitbreaker.NewCircuitBreaker(config, zap.NewNop())
+
+	script := sqlclienttest.NewDriver()
+	connector, err := Wrap(script, breaker).OpenConnector("trades")
+	if err != nil {
+		t.Fatalf("Failed to open connector: %v", err)
+	}
+
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() { db.Close() })
+	return &tradesDB{DB: db, script: script, breaker: breaker}
+}
+
+func (d *tradesDB) checkState(t *testing.T, want circuitbreaker.State) {
+	t.Helper()
+
+	if state := d.breaker.GetState(); state != want {
+		t.Fatalf("state = %s, want %s", state, want)
+	}
+}
+
+func TestExecAndQuery(t *testing.T) {
+	db := openTradesDB(t)
+	db.script.On(insertTrade, sqlclienttest.Affected(1))
+	db.script.On(selectTrade, sqlclienttest.Rows([]string{"id", "symbol"}, []driver.Value{"T1", "AAPL"}))
+
+	result, err := db.Exec(insertTrade, "T1", "AAPL")
+	if err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		t.Fatalf("rows affected = %d, want 1", n)
+	}
+
+	var id, symbol string
+	if err := db.QueryRow(selectTrade, "T1").Scan(&id, &symbol); err != nil {
+		t.Fatalf("QueryRow failed: %v", err)
+	}
+	if id != "T1" || symbol != "AAPL" {
+		t.Fatalf("got (%s, %s), want (T1, AAPL)", id, symbol)
+	}
+	db.checkState(t, circuitbreaker.StateClosed)
+}
+
+func TestConstraintViolationsAreIgnored(t *testing.T) {
+	db := openTradesDB(t)
+	db.script.On(insertTrade, sqlclienttest.Fail(sqlclienttest.ErrUniqueViolation))
+
+	for i := 0; i < 5; i++ {
+		_, err := db.Exec(insertTrade, "T1", "AAPL")
+		if !errors.Is(err, sqlclienttest.ErrUniqueViolation) {
+			t.Fatalf("got %v, want the constraint violation", err)
+		}
+	}
+	db.checkState(t, circuitbreaker.StateClosed)
+}
+
+func TestConnectionErrorsOpenCircuit(t *testing.T) {
+	db := openTradesDB(t)
+	db.script.On(insertTrade, sqlclienttest.Affected(1))
+	db.script.FailConnect(sqlclienttest.ErrConnectionRefused)
+
+	for i := 0; i < 2; i++ {
+		if _, err := db.Exec(insertTrade, "T1", "AAPL"); !errors.Is(err, sqlclienttest.ErrConnectionRefused) {
+			t.Fatalf("call %d: got %v, want connection refused", i, err)
+		}
+	}
+	db.checkState(t, circuitbreaker.StateOpen)
+
+	// Rejected without trying to connect, although the database is back
+	db.script.FailConnect(nil)
+	connects := db.script.Connects()
+	if _, err := db.Exec(insertTrade, "T1", "AAPL"); !errors.Is(err, circuitbreaker.ErrOpenState) {
+		t.Fatalf("got %v, want ErrOpenState", err)
+	}
+	if db.script.Connects() != connects {
+		t.Fatal("connection attempted while the circuit is open")
+	}
+}
+
+func TestStatementTimeoutsOpenCircuit(t *testing.T) {
+	db := openTradesDB(t)
+	db.script.On(selectTrade, sqlclienttest.Hang())
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err := db.QueryContext(ctx, selectTrade, "T1")
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("query %d: got %v, want context.DeadlineExceeded", i, err)
+		}
+	}
+	db.checkState(t, circuitbreaker.StateOpen)
+}
+
+func TestCanceledQueriesAreNotRecorded(t *testing.T) {
+	db := openTradesDB(t)
+	db.script.On(selectTrade, sqlclienttest.Fail(sqlclienttest.ErrConnectionRefused), sqlclienttest.Hang())
+
+	if _, err := db.Query(selectTrade, "T1"); err == nil {
+		t.Fatal("expected the scripted failure")
+	}
+
+	// A second failure would open the circuit
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := db.QueryContext(ctx, selectTrade, "T1"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	db.checkState(t, circuitbreaker.StateClosed)
+}
+
+func TestBeginGoesThroughBreaker(t *testing.T) {
+	db := openTradesDB(t)
+	db.script.On(sqlclienttest.Begin, sqlclienttest.Fail(sqlclienttest.ErrConnectionRefused))
+
+	for i := 0; i < 2; i++ {
+		if _, err := db.Begin(); !errors.Is(err, sqlclienttest.ErrConnectionRefused) {
+			t.Fatalf("call %d: got %v, want connection refused", i, err)
+		}
+	}
+	db.checkState(t, circuitbreaker.StateOpen)
+	if _, err := db.Begin(); !errors.Is(err, circuitbreaker.ErrOpenState) {
+		t.Fatalf("got %v, want ErrOpenState", err)
+	}
+}
+
+func TestPreparedStatementsGoThroughBreaker(t *testing.T) {
+	db := openTradesDB(t)
+	db.script.On(insertTrade, sqlclienttest.Fail(sqlclienttest.ErrConnectionRefused))
+
+	stmt, err := db.Prepare(insertTrade)
+	if err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+	defer stmt.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := stmt.Exec("T1", "AAPL"); !errors.Is(err, sqlclienttest.ErrConnectionRefused) {
+			t.Fatalf("call %d: got %v, want connection refused", i, err)
+		}
+	}
+	db.checkState(t, circuitbreaker.StateOpen)
+
+	calls := db.script.Calls(insertTrade)
+	if _, err := stmt.Exec("T1", "AAPL"); !errors.Is(err, circuitbreaker.ErrOpenState) {
+		t.Fatalf("got %v, want ErrOpenState", err)
+	}
+	if db.script.Calls(insertTrade) != calls {
+		t.Fatal("statement executed while the circuit is open")
+	}
+}
+
+func TestTransactionCommits(t *testing.T) {
+	db := openTradesDB(t)
+	db.script.On(insertTrade, sqlclienttest.Affected(1))
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if _, err := tx.Exec(insertTrade, "T1", "AAPL"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if got := db.script.Calls(insertTrade); got != 1 {
+		t.Fatalf("calls = %d, want 1", got)
+	}
+}
+
+func TestDefaultClassifier(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection failure", &sqlclienttest.SQLError{State: "08006"}, true},
+		{"too many connections", &sqlclienttest.SQLError{State: "53300"}, true},
+		{"statement timeout", &sqlclienttest.SQLError{State: "57014"}, true},
+		{"io error", &sqlclienttest.SQLError{State: "58030"}, true},
+		{"unique violation", sqlclienttest.ErrUniqueViolation, false},
+		{"syntax error", &sqlclienttest.SQLError{State: "42601"}, false},
+		{"malformed state", &sqlclienttest.SQLError{State: "0"}, false},
+		{"bad connection", driver.ErrBadConn, true},
+		{"connection reset", fmt.Errorf("read tcp: %w", syscall.ECONNRESET), true},
+		{"deadline", context.DeadlineExceeded, true},
+		{"no rows", sql.ErrNoRows, false},
+	}
+
+	for _, tt := range tests {
+		if got := DefaultClassifier(tt.err); got != tt.want {
+			t.Errorf("DefaultClassifier(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
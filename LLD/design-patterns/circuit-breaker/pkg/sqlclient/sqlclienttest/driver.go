@@ -0,0 +1,320 @@
+// Package sqlclienttest provides a scripted in-memory database/sql driver
+// for testing code that uses sqlclient, or any database/sql code.
+package sqlclienttest
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrNoScript is returned by Driver for a statement it has no script for
+var ErrNoScript = errors.New("no script for statement")
+
+// ErrConnectionRefused is a connection error a Driver can script
+var ErrConnectionRefused = fmt.Errorf("dial tcp 127.0.0.1:5432: %w", syscall.ECONNREFUSED)
+
+// SQLError is a database error carrying a SQLSTATE code, the way
+// driver errors report them
+type SQLError struct {
+	State   string
+	Message string
+}
+
+func (e *SQLError) Error() string {
+	return fmt.Sprintf("%s (SQLSTATE %s)", e.Message, e.State)
+}
+
+// SQLState returns the SQLSTATE code
+func (e *SQLError) SQLState() string {
+	return e.State
+}
+
+// ErrUniqueViolation is a constraint violation a Driver can script
+var ErrUniqueViolation = &SQLError{State: "23505", Message: "duplicate key value violates unique constraint"}
+
+// Statement keys for transaction control, which Driver accepts unless
+// they are scripted
+const (
+	Begin    = "BEGIN"
+	Commit   = "COMMIT"
+	Rollback = "ROLLBACK"
+)
+
+// Step is one scripted outcome of a statement: rows, a result or an
+// error, delivered after Latency
+type Step struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+	Err          error
+	Latency      time.Duration
+}
+
+// Affected returns a step for a statement changing n rows
+func Affected(n int64) Step {
+	return Step{RowsAffected: n}
+}
+
+// Rows returns a step for a query returning rows
+func Rows(columns []string, rows ...[]driver.Value) Step {
+	return Step{Columns: columns, Rows: rows}
+}
+
+// Fail returns a step failing with err
+func Fail(err error) Step {
+	return Step{Err: err}
+}
+
+// Hang returns a step that never answers; the statement ends when its
+// context is canceled or times out
+func Hang() Step {
+	return Step{Latency: -1}
+}
+
+// After returns the step delivered after latency
+func (s Step) After(latency time.Duration) Step {
+	s.Latency = latency
+	return s
+}
+
+// wait waits out the latency and returns the scripted error
+func (s Step) wait(ctx context.Context) error {
+	if s.Latency != 0 {
+		var wait <-chan time.Time
+		if s.Latency > 0 {
+			timer := time.NewTimer(s.Latency)
+			defer timer.Stop()
+			wait = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wait:
+		}
+	}
+	return s.Err
+}
+
+// Driver is an in-memory database/sql driver answering statements from
+// scripts, the database counterpart of httpclient.FakeTransport. Each script
+// is a sequence of steps for one statement text; executions take the steps
+// in order and the last step repeats.
+type Driver struct {
+	mutex      sync.Mutex
+	scripts    map[string][]Step
+	calls      map[string]int
+	connectErr error
+	connects   int
+}
+
+// NewDriver creates a driver without scripts
+func NewDriver() *Driver {
+	return &Driver{
+		scripts: make(map[string][]Step),
+		calls:   make(map[string]int),
+	}
+}
+
+// On scripts the outcomes of a statement, replacing an earlier script and
+// its call count
+func (d *Driver) On(query string, steps ...Step) *Driver {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.scripts[query] = steps
+	d.calls[query] = 0
+	return d
+}
+
+// FailConnect makes new connections fail with err, or succeed again when
+// err is nil
+func (d *Driver) FailConnect(err error) *Driver {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.connectErr = err
+	return d
+}
+
+// Calls returns how many executions reached the script for query
+func (d *Driver) Calls(query string) int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.calls[query]
+}
+
+// Connects returns how many connections were attempted
+func (d *Driver) Connects() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.connects
+}
+
+// Open implements driver.Driver
+func (d *Driver) Open(string) (driver.Conn, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.connects++
+	if d.connectErr != nil {
+		return nil, d.connectErr
+	}
+	return &conn{driver: d}, nil
+}
+
+// step takes the next step scripted for query
+func (d *Driver) step(query string) (Step, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	steps := d.scripts[query]
+	if len(steps) == 0 {
+		switch query {
+		case Begin, Commit, Rollback:
+			return Step{}, nil
+		}
+		return Step{}, fmt.Errorf("%w: %s", ErrNoScript, query)
+	}
+
+	step := steps[len(steps)-1]
+	if call := d.calls[query]; call < len(steps) {
+		step = steps[call]
+	}
+	d.calls[query]++
+	return step, nil
+}
+
+// run takes the next step for query and waits it out
+func (d *Driver) run(ctx context.Context, query string) (Step, error) {
+	step, err := d.step(query)
+	if err != nil {
+		return step, err
+	}
+	return step, step.wait(ctx)
+}
+
+// conn is a connection of a Driver
+type conn struct {
+	driver *Driver
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	if _, err := c.driver.run(ctx, Begin); err != nil {
+		return nil, err
+	}
+	return &tx{conn: c}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	step, err := c.driver.run(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(step.RowsAffected), nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	step, err := c.driver.run(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{columns: step.Columns, rows: step.Rows}, nil
+}
+
+// stmt is a prepared statement of a conn
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+// tx is a transaction of a conn
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) Commit() error {
+	_, err := t.conn.driver.run(context.Background(), Commit)
+	return err
+}
+
+func (t *tx) Rollback() error {
+	_, err := t.conn.driver.run(context.Background(), Rollback)
+	return err
+}
+
+// rows returns scripted rows
+type rows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// namedValues numbers positional arguments
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, value := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: value}
+	}
+	return named
+}
@@ -4,12 +4,25 @@ echo "🎯 Circuit Breaker Demo - Financial Trading Platform"
 echo "=================================================="
 echo
 
+# API keys for the key IDs in config/*.yaml are signed with the secret the
+# services run with (see "Authentication" in README.md)
+if [ -z "$TRADING_AUTH_API_KEY_SECRET" ]; then
+    echo "Set TRADING_AUTH_API_KEY_SECRET to the secret the services were started with"
+    exit 1
+fi
+issue_key() {
+    go run ./cmd/trading-gateway -config config/config.yaml -issue-api-key "$1"
+}
+TRADER_KEY=$(issue_key demo_user-key) || exit 1
+ADMIN_KEY=$(issue_key admin-key) || exit 1
+
 # 1. Show normal operation
 echo "�� 1. Normal Operation - Executing successful trades"
 echo "---------------------------------------------------"
 for i in {1..3}; do
     echo "Trade $i:"
     curl -s -X POST http://localhost:8080/api/v1/trades \
+        -H "X-API-Key: $TRADER_KEY" \
         -H "Content-Type: application/json" \
         -d "{\"userId\":\"demo_user\",\"symbol\":\"AAPL\",\"quantity\":$i,\"orderType\":\"BUY\",\"price\":150.00}" \
         | grep -o '"status":"[^"]*"' | sed 's/"status":/Status: /'
@@ -29,6 +42,7 @@ echo
 echo "⚠️  3. Introducing Market Data Service Failures (70% failure rate)"
 echo "-----------------------------------------------------------------"
 curl -s -X POST http://localhost:8082/api/v1/simulate/failure \
+    -H "X-API-Key: $ADMIN_KEY" \
     -H "Content-Type: application/json" \
     -d '{"failure_rate": 0.7, "is_healthy": true}' > /dev/null
 echo "Market data service configured to fail 70% of requests"
@@ -40,6 +54,7 @@ echo "----------------------------------------------"
 for i in {1..5}; do
     echo "Trade $i:"
     curl -s -X POST http://localhost:8080/api/v1/trades \
+        -H "X-API-Key: $TRADER_KEY" \
         -H "Content-Type: application/json" \
         -d "{\"userId\":\"demo_user\",\"symbol\":\"TSLA\",\"quantity\":1,\"orderType\":\"BUY\",\"price\":800.00}" \
         | grep -o '"status":"[^"]*"' | sed 's/"status":/Status: /' || echo "Status: FAILED"
@@ -51,6 +66,7 @@ echo
 echo "💥 5. Making Market Data Service Completely Unavailable"
 echo "------------------------------------------------------"
 curl -s -X POST http://localhost:8082/api/v1/simulate/failure \
+    -H "X-API-Key: $ADMIN_KEY" \
     -H "Content-Type: application/json" \
     -d '{"failure_rate": 1.0, "is_healthy": false}' > /dev/null
 echo "Market data service is now completely down"
@@ -63,6 +79,7 @@ echo "Measuring response time for trades when service is down:"
 for i in {1..3}; do
     echo -n "Trade $i: "
     time_output=$(time (curl -s -X POST http://localhost:8080/api/v1/trades \
+        -H "X-API-Key: $TRADER_KEY" \
         -H "Content-Type: application/json" \
         -d "{\"userId\":\"demo_user\",\"symbol\":\"MSFT\",\"quantity\":1,\"orderType\":\"BUY\",\"price\":300.00}" \
         | grep -o '"status":"[^"]*"' | sed 's/"status":/Status: /') 2>&1)
@@ -78,6 +95,7 @@ echo
 echo "🛠️  7. Restoring Market Data Service"
 echo "-----------------------------------"
 curl -s -X POST http://localhost:8082/api/v1/simulate/failure \
+    -H "X-API-Key: $ADMIN_KEY" \
     -H "Content-Type: application/json" \
     -d '{"failure_rate": 0.0, "is_healthy": true}' > /dev/null
 echo "Market data service restored to normal operation"
@@ -102,4 +120,4 @@ echo
 echo "🔗 API Endpoints for further testing:"
 echo "  Trading Gateway:     http://localhost:8080"
 echo "  Circuit Breaker:     http://localhost:8080/api/v1/circuit-breaker/status"
-echo "  Prometheus Metrics:  http://localhost:8080/metrics"
+echo "  Prometheus Metrics:  http://localhost:9090/metrics"
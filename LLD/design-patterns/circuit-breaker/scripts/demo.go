@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"sync"
 	"time"
+
+	"circuit-breaker-demo/pkg/auth"
 )
 
 // TradeRequest represents a trading request
@@ -19,6 +22,17 @@ type TradeRequest struct {
 	Price     float64 `json:"price"`
 }
 
+// apiKey returns the API key for a key ID listed in config/config.yaml,
+// signed with the secret the services run with. Without the secret the
+// services are assumed to run with auth disabled and no key is sent.
+func apiKey(id string) string {
+	secret := os.Getenv("TRADING_AUTH_API_KEY_SECRET")
+	if secret == "" {
+		return ""
+	}
+	return auth.IssueAPIKey([]byte(secret), id)
+}
+
 // CircuitBreakerDemo demonstrates circuit breaker functionality
 type CircuitBreakerDemo struct {
 	gatewayURL    string
@@ -72,7 +86,7 @@ func (d *CircuitBreakerDemo) Run() {
 	fmt.Println("------------------------------------------")
 	d.fullRecovery()
 
-	fmt.Println("\n🎉 Demo completed! Check the metrics at http://localhost:8080/metrics")
+	fmt.Println("\n🎉 Demo completed! Check the metrics at http://localhost:9090/metrics")
 }
 
 // waitForServices waits for all services to be available
@@ -305,12 +319,7 @@ func (d *CircuitBreakerDemo) fullRecovery() {
 func (d *CircuitBreakerDemo) executeTrade(trade TradeRequest) bool {
 	jsonData, _ := json.Marshal(trade)
 
-	resp, err := d.client.Post(
-		d.gatewayURL+"/api/v1/trades",
-		"application/json",
-		bytes.NewBuffer(jsonData),
-	)
-
+	resp, err := d.post(d.gatewayURL+"/api/v1/trades", apiKey(trade.UserID+"-key"), jsonData)
 	if err != nil {
 		return false
 	}
@@ -323,12 +332,25 @@ func (d *CircuitBreakerDemo) executeTrade(trade TradeRequest) bool {
 func (d *CircuitBreakerDemo) configureServiceFailures(url string, config map[string]interface{}) {
 	jsonData, _ := json.Marshal(config)
 
-	resp, err := d.client.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := d.post(url, apiKey("admin-key"), jsonData)
 	if err == nil {
 		resp.Body.Close()
 	}
 }
 
+// post sends a JSON body authenticated with an API key
+func (d *CircuitBreakerDemo) post(url, key string, jsonData []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(auth.APIKeyHeader, key)
+	}
+	return d.client.Do(req)
+}
+
 // showCircuitBreakerStatus displays current circuit breaker status
 func (d *CircuitBreakerDemo) showCircuitBreakerStatus() {
 	resp, err := d.client.Get(d.gatewayURL + "/api/v1/circuit-breaker/status")
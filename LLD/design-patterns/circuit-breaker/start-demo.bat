@@ -69,7 +69,7 @@ echo 📊 Available Endpoints:
 echo    Trading Gateway:    http://localhost:8080
 echo    Portfolio Service:  http://localhost:8081
 echo    Market Data:        http://localhost:8082
-echo    Metrics:            http://localhost:8080/metrics
+echo    Metrics:            http://localhost:9090/metrics
 echo.
 echo 🧪 Circuit Breaker Status: http://localhost:8080/api/v1/circuit-breaker/status
 echo.